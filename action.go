@@ -138,6 +138,25 @@ func (h *Holochain) ValidateAction(a ValidatingAction, entryType string, pkg *Pa
 		if err != nil {
 			return
 		}
+		// proofs and checkpoints must be of the chain of the agent whose action we are validating
+		var agent peer.ID
+		agent, err = vpkg.signer()
+		if err != nil {
+			return
+		}
+		if agent != "" {
+			var found bool
+			for _, s := range sources {
+				if s == agent {
+					found = true
+					break
+				}
+			}
+			if !found {
+				err = ErrPackageSignerNotSource
+				return
+			}
+		}
 
 		// run the action's app level validations
 		var n Ribosome
@@ -157,6 +176,12 @@ func (h *Holochain) ValidateAction(a ValidatingAction, entryType string, pkg *Pa
 // GetValidationResponse check the validation request and builds the validation package based
 // on the app's requirements
 func (h *Holochain) GetValidationResponse(a ValidatingAction, hash Hash) (resp ValidateResponse, err error) {
+	return h.getValidationResponse(a, hash, true)
+}
+
+// getValidationResponse builds the validation response for a validator whose
+// packages may only start from a checkpoint if fromCheckpoint is set
+func (h *Holochain) getValidationResponse(a ValidatingAction, hash Hash, fromCheckpoint bool) (resp ValidateResponse, err error) {
 	var entry Entry
 	entry, resp.Type, err = h.chain.GetEntry(hash)
 	if err == ErrHashNotFound {
@@ -197,10 +222,12 @@ func (h *Holochain) GetValidationResponse(a ValidatingAction, hash Hash) (resp V
 		// if agent, the package to return is the entry-type chain
 		// so that sys validation can confirm this agent entry in the chain
 		req := PackagingReq{PkgReqChain: int64(PkgReqChainOptFull), PkgReqEntryTypes: []string{AgentEntryType}}
-		resp.Package, err = MakePackage(h, req)
+		resp.Package, err = makePackage(h, req, fromCheckpoint)
 	case MigrateEntryType:
 		// if migrate entry there no extra info to return in the package so do nothing
 		// TODO: later this might not be true, could return whole chain?
+	case CheckpointEntryType:
+		// a checkpoint is signed so there's no extra info to return in the package
 	default:
 		// app defined entry types
 		var def *EntryDef
@@ -226,7 +253,21 @@ func (h *Holochain) GetValidationResponse(a ValidatingAction, hash Hash) (resp V
 		if err != nil {
 			h.Debugf("Ribosome GetValidationPackage(%T) err:%v\n", a, err)
 		}
-		resp.Package, err = MakePackage(h, req)
+		resp.Package, err = makePackage(h, req, fromCheckpoint)
+		if err != nil {
+			return
+		}
+	}
+	if err == nil && resp.Type != KeyEntryType {
+		// the header of the entry being validated is always proved, ahead of
+		// whatever the app asked for, so that validators can remember it to
+		// detect forks and can warrant the entry if it's invalid
+		var proof *ChainProof
+		proof, err = h.chain.Prove(hash, h.agent.PrivKey())
+		if err != nil {
+			return
+		}
+		resp.Package.Proofs = append([]ChainProof{*proof}, resp.Package.Proofs...)
 	}
 	return
 }
@@ -287,8 +328,10 @@ func argErr(typeName string, index int, arg Arg) error {
 	return fmt.Errorf("argument %d (%s) should be %s", index, arg.Name, typeName)
 }
 
-// doCommit adds an entry to the local chain after validating the action it's part of
-func (h *Holochain) doCommit(a CommittingAction, change Hash) (d *EntryDef, err error) {
+// doCommit adds an entry to the local chain after validating the action it's part of.
+// If a bundle is started the entry is added to it, along with the action to share
+// when the bundle closes, and the bundle is returned.
+func (h *Holochain) doCommit(a CommittingAction, change Hash) (d *EntryDef, bundle *Bundle, err error) {
 
 	entryType := a.EntryType()
 	entry := a.Entry()
@@ -298,7 +341,7 @@ func (h *Holochain) doCommit(a CommittingAction, change Hash) (d *EntryDef, err
 	var added bool
 
 	chain := h.Chain()
-	bundle := chain.BundleStarted()
+	bundle = chain.BundleStarted()
 	if bundle != nil {
 		chain = bundle.chain
 	}
@@ -306,7 +349,7 @@ func (h *Holochain) doCommit(a CommittingAction, change Hash) (d *EntryDef, err
 	// retry loop incase someone sneaks a new commit in between prepareHeader and addEntry
 	for !added {
 		chain.lk.RLock()
-		count := len(chain.Headers)
+		count := chain.length()
 		l, hash, header, err = chain.prepareHeader(time.Now(), entryType, entry, h.agent.PrivKey(), change)
 		chain.lk.RUnlock()
 		if err != nil {
@@ -320,10 +363,16 @@ func (h *Holochain) doCommit(a CommittingAction, change Hash) (d *EntryDef, err
 		}
 
 		chain.lk.Lock()
-		if count == len(chain.Headers) {
+		if bundle != nil && bundle.closed {
+			// the bundle timed out or was closed while we were validating
+			err = ErrBundleClosed
+		} else if count == chain.length() {
 			err = chain.addEntry(l, hash, header, entry)
 			if err == nil {
 				added = true
+				if bundle != nil {
+					bundle.sharing = append(bundle.sharing, a)
+				}
 			}
 		}
 		chain.lk.Unlock()
@@ -336,16 +385,16 @@ func (h *Holochain) doCommit(a CommittingAction, change Hash) (d *EntryDef, err
 
 func (h *Holochain) commitAndShare(a CommittingAction, change Hash) (response Hash, err error) {
 	var def *EntryDef
-	def, err = h.doCommit(a, change)
+	var bundle *Bundle
+	def, bundle, err = h.doCommit(a, change)
 	if err != nil {
 		return
 	}
 
-	bundle := h.Chain().BundleStarted()
 	if bundle == nil {
 		err = a.Share(h, def)
 	} else {
-		bundle.sharing = append(bundle.sharing, a)
+		err = h.saveBundles()
 	}
 	if err != nil {
 		return
@@ -380,7 +429,17 @@ func RunValidationPhase(h *Holochain, source peer.ID, msgType MsgType, query Has
 	}
 	switch resp := r.(type) {
 	case ValidateResponse:
-		err = handler(resp)
+		err = checkPackageProof(query, &resp)
+		if err == nil {
+			err = handler(resp)
+		}
+		if err == nil {
+			h.dht.checkForks(&resp)
+		}
+		if err != nil && (IsValidationFailedErr(err) || err == ErrPackageSignerNotSource || err == ErrPackageProofMismatch) {
+			// the source served us data that doesn't validate
+			h.dht.updateReputation(source, Reputation{InvalidData: 1})
+		}
 	default:
 		err = fmt.Errorf("expected ValidateResponse from validator got %T", r)
 	}
@@ -2,6 +2,7 @@ package holochain
 
 import (
 	"fmt"
+	. "github.com/holochain/holochain-proto/hash"
 	peer "github.com/libp2p/go-libp2p-peer"
 )
 
@@ -45,15 +46,40 @@ func (a *ActionListAdd) Receive(dht *DHT, msg *Message) (response interface{}, e
 		return
 	}
 
+	// only a fork warrant can put a peer on the forked list
+	if a.list.Type == ForkedList && w.Type() != ForkType {
+		err = fmt.Errorf("%s: forked list requires a fork warrant", prefix)
+		return
+	}
+
 	err = w.Verify(dht.h)
 	if err != nil {
 		err = fmt.Errorf("%s: %v", prefix, err)
 		return
 	}
 
-	// TODO verify that the warrant, if valid, is sufficient to allow list addition #300
+	// a warrant can only put its own parties on a list
+	var parties []Hash
+	parties, err = w.Parties()
+	if err != nil {
+		err = fmt.Errorf("%s: %v", prefix, err)
+		return
+	}
+	for _, r := range a.list.Records {
+		found := false
+		for _, p := range parties {
+			if p.String() == peer.IDB58Encode(r.ID) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			err = fmt.Errorf("%s: %v is not a party to the warrant", prefix, r.ID.Pretty())
+			return
+		}
+	}
 
-	err = dht.addToList(msg, a.list)
+	err = dht.AddToList(msg, a.list)
 	if err != nil {
 		return
 	}
@@ -65,6 +91,13 @@ func (a *ActionListAdd) Receive(dht *DHT, msg *Message) (response interface{}, e
 			dht.DeleteGossiper(node.ID) // ignore error
 		}
 	}
+
+	// and to mark forked agents in the world model
+	if a.list.Type == ForkedList && dht.h.Config.EnableWorldModel {
+		for _, node := range a.list.Records {
+			dht.h.world.SetForked(node.ID)
+		}
+	}
 	response = DHTChangeOK
 	return
 }
@@ -87,3 +87,61 @@ func TestActionBundle(t *testing.T) {
 		So(h.chain.BundleStarted(), ShouldNotBeNil)
 	})
 }
+
+func TestActionNestedBundle(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	var outerHash, innerHash Hash
+	Convey("a nested bundle should commit to the innermost bundle", t, func() {
+		_, err := NewStartBundleAction(0, "outer").Call(h)
+		So(err, ShouldBeNil)
+		outerHash = commit(h, "oddNumbers", "11")
+		_, err = NewStartBundleAction(0, "inner").Call(h)
+		So(err, ShouldBeNil)
+		innerHash = commit(h, "oddNumbers", "13")
+		So(h.chain.BundleDepth(), ShouldEqual, 2)
+		So(h.chain.bundle.chain.Length(), ShouldEqual, 1)
+		So(h.chain.BundleStarted().chain.Length(), ShouldEqual, 1)
+
+		results, err := h.Query(&QueryOptions{Bundle: true})
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 1)
+		So(results[0].Entry.Content(), ShouldEqual, "13")
+	})
+
+	Convey("committing a nested bundle should leave its entries unshared until the outer bundle is committed", t, func() {
+		_, err := (&APIFnCloseBundle{commit: true}).Call(h)
+		So(err, ShouldBeNil)
+		So(h.chain.BundleDepth(), ShouldEqual, 1)
+		So(h.chain.BundleStarted().chain.Length(), ShouldEqual, 2)
+		So(len(h.chain.BundleStarted().sharing), ShouldEqual, 2)
+		_, _, _, _, err = h.dht.Get(innerHash, StatusDefault, GetMaskDefault)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("canceling a nested bundle should only roll back the nested bundle", t, func() {
+		_, err := NewStartBundleAction(0, "debugit").Call(h)
+		So(err, ShouldBeNil)
+		commit(h, "oddNumbers", "15")
+		ShouldLog(h.nucleus.alog, func() {
+			_, err = (&APIFnCloseBundle{commit: false}).Call(h)
+			So(err, ShouldBeNil)
+		}, `debug message during bundleCanceled with reason: userCancel`)
+		So(h.chain.BundleDepth(), ShouldEqual, 1)
+		So(h.chain.BundleStarted().chain.Length(), ShouldEqual, 2)
+		So(len(h.chain.BundleStarted().sharing), ShouldEqual, 2)
+	})
+
+	Convey("committing the outer bundle should add and share all the entries", t, func() {
+		l := h.chain.Length()
+		_, err := (&APIFnCloseBundle{commit: true}).Call(h)
+		So(err, ShouldBeNil)
+		So(h.chain.BundleStarted(), ShouldBeNil)
+		So(h.chain.Length(), ShouldEqual, l+2)
+		for _, hash := range []Hash{outerHash, innerHash} {
+			_, _, _, _, err = h.dht.Get(hash, StatusDefault, GetMaskDefault)
+			So(err, ShouldBeNil)
+		}
+	})
+}
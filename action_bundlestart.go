@@ -1,5 +1,9 @@
 package holochain
 
+import (
+	"time"
+)
+
 //------------------------------------------------------------
 // StartBundle
 
@@ -29,6 +33,9 @@ func (a *APIFnStartBundle) Args() []Arg {
 }
 
 func (a *APIFnStartBundle) Call(h *Holochain) (response interface{}, err error) {
-	err = h.Chain().StartBundle(a.userParam)
+	err = h.Chain().StartBundleWithTimeout(a.userParam, time.Duration(a.timeout)*time.Millisecond)
+	if err == nil {
+		err = h.saveBundles()
+	}
 	return
 }
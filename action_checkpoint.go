@@ -0,0 +1,125 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+package holochain
+
+import (
+	"errors"
+
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+//------------------------------------------------------------
+// Checkpoint Action
+
+type ActionCheckpoint struct {
+	entry  CheckpointEntry
+	header *Header
+}
+
+func (a *ActionCheckpoint) Name() string {
+	return "checkpoint"
+}
+
+func (a *ActionCheckpoint) Entry() Entry {
+	j, err := a.entry.ToJSON()
+	if err != nil {
+		panic(err)
+	}
+	return &GobEntry{C: j}
+}
+
+func (a *ActionCheckpoint) EntryType() string {
+	return CheckpointEntryType
+}
+
+func (a *ActionCheckpoint) SetHeader(header *Header) {
+	a.header = header
+}
+
+func (a *ActionCheckpoint) GetHeader() (header *Header) {
+	return a.header
+}
+
+func (a *ActionCheckpoint) Share(h *Holochain, def *EntryDef) (err error) {
+	err = h.dht.Change(a.header.EntryLink, PUT_REQUEST, HoldReq{EntryHash: a.header.EntryLink})
+	return
+}
+
+func (a *ActionCheckpoint) SysValidation(h *Holochain, def *EntryDef, pkg *Package, sources []peer.ID) (err error) {
+	if def != CheckpointEntryDef {
+		err = ErrEntryDefInvalid
+		return
+	}
+	if a.header == nil {
+		err = ErrActionMissingHeader
+		return
+	}
+	err = sysValidateEntry(h, def, a.Entry(), pkg)
+	if err != nil {
+		return
+	}
+	// the checkpoint must be of the chain it's being added to, and by its agent
+	if !a.entry.Top.Equal(a.header.HeaderLink) {
+		err = ValidationFailed("checkpoint isn't of the headers before it")
+		return
+	}
+	var pubKey string
+	pubKey, err = h.agent.EncodePubKey()
+	if err != nil {
+		return
+	}
+	if pubKey != a.entry.PubKey {
+		err = ValidationFailed("checkpoint isn't signed by the chain's agent")
+	}
+	return
+}
+
+func (a *ActionCheckpoint) CheckValidationRequest(def *EntryDef) (err error) {
+	return
+}
+
+func (a *ActionCheckpoint) Receive(dht *DHT, msg *Message) (response interface{}, err error) {
+	// there is no action message for checkpoints, they are shared as puts
+	err = ErrActionReceiveInvalid
+	return
+}
+
+// Checkpoint commits a checkpoint of the chain so far, after which validation
+// packages for the chain start from the checkpoint instead of from genesis
+func (h *Holochain) Checkpoint() (hash Hash, err error) {
+	if h.chain.BundleStarted() != nil {
+		err = errors.New("can't checkpoint the chain while a bundle is in progress")
+		return
+	}
+	var entry CheckpointEntry
+	h.chain.lk.RLock()
+	entry, err = h.chain.newCheckpointEntry(h.chain.length(), h.agent.PrivKey())
+	h.chain.lk.RUnlock()
+	if err != nil {
+		return
+	}
+	hash, err = h.commitAndShare(&ActionCheckpoint{entry: entry}, NullHash())
+	return
+}
+
+//------------------------------------------------------------
+// Checkpoint API fn
+
+type APIFnCheckpoint struct {
+}
+
+func (fn *APIFnCheckpoint) Name() string {
+	return "checkpoint"
+}
+
+func (fn *APIFnCheckpoint) Args() []Arg {
+	return []Arg{}
+}
+
+func (fn *APIFnCheckpoint) Call(h *Holochain) (response interface{}, err error) {
+	response, err = h.Checkpoint()
+	return
+}
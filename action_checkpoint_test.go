@@ -0,0 +1,108 @@
+package holochain
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckpoint(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+	commit(h, "evenNumbers", "2")
+	commit(h, "oddNumbers", "3")
+
+	var cpHash Hash
+	Convey("it should commit a checkpoint of the chain so far", t, func() {
+		l := h.chain.Length()
+		var err error
+		cpHash, err = h.Checkpoint()
+		So(err, ShouldBeNil)
+		So(h.chain.Length(), ShouldEqual, l+1)
+		top := h.chain.Top()
+		So(top.Type, ShouldEqual, CheckpointEntryType)
+		So(top.EntryLink.String(), ShouldEqual, cpHash.String())
+		So(h.chain.Validate(false), ShouldBeNil)
+	})
+
+	Convey("it should be shared to the DHT", t, func() {
+		_, _, _, _, err := h.dht.Get(cpHash, StatusDefault, GetMaskDefault)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("sys validation should refuse a checkpoint that isn't of the headers before it", t, func() {
+		h.chain.lk.RLock()
+		entry, _ := h.chain.newCheckpointEntry(h.chain.Length()-1, h.agent.PrivKey())
+		h.chain.lk.RUnlock()
+		a := &ActionCheckpoint{entry: entry}
+		_, err := h.commitAndShare(a, NullHash())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "Validation Failed: checkpoint isn't of the headers before it")
+	})
+
+	Convey("it should not checkpoint while a bundle is in progress", t, func() {
+		So(h.chain.StartBundle("myBundle"), ShouldBeNil)
+		_, err := h.Checkpoint()
+		So(err, ShouldNotBeNil)
+		So(h.chain.CloseBundle(false), ShouldBeNil)
+	})
+
+	Convey("validating a chain should trust the headers before a verified checkpoint", t, func() {
+		commit(h, "evenNumbers", "4")
+		var b bytes.Buffer
+		So(h.chain.MarshalChain(&b, ChainMarshalFlagsNone, nil, nil), ShouldBeNil)
+		_, c, err := UnmarshalChain(h.hashSpec, &b)
+		So(err, ShouldBeNil)
+		So(c.Validate(false), ShouldBeNil)
+
+		c.Entries[2] = &GobEntry{C: "changed"}
+		So(c.Validate(false), ShouldBeNil)
+
+		cp := c.TypeTops[CheckpointEntryType]
+		c.Entries[cp] = &GobEntry{C: `{"Length":4}`}
+		So(c.Validate(false), ShouldNotBeNil)
+	})
+
+	Convey("packages of the chain should start from the latest checkpoint", t, func() {
+		pkg, err := MakePackage(h, PackagingReq{PkgReqChain: int64(PkgReqChainOptFull)})
+		So(err, ShouldBeNil)
+		vpkg, err := MakeValidationPackage(h, &pkg)
+		So(err, ShouldBeNil)
+		So(vpkg.Chain.Length(), ShouldEqual, 2)
+		So(vpkg.Chain.Headers[0].Type, ShouldEqual, CheckpointEntryType)
+		So(vpkg.Chain.Entries[1].Content(), ShouldEqual, "4")
+
+		signer, err := vpkg.signer()
+		So(err, ShouldBeNil)
+		So(signer, ShouldEqual, h.nodeID)
+
+		// a package that was tampered with should fail
+		pkg.Chain = bytes.Replace(pkg.Chain, []byte(`"Length":4`), []byte(`"Length":5`), 1)
+		_, err = MakeValidationPackage(h, &pkg)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("packages for peers that don't know checkpoints should start from genesis", t, func() {
+		So((&Message{version: "0.0.0"}).speaks(CheckpointPackageVersion), ShouldBeFalse)
+		So((&Message{version: ProtocolVersion}).speaks(CheckpointPackageVersion), ShouldBeTrue)
+		So((&Message{}).speaks(CheckpointPackageVersion), ShouldBeTrue)
+
+		pkg, err := makePackage(h, PackagingReq{PkgReqChain: int64(PkgReqChainOptFull)}, false)
+		So(err, ShouldBeNil)
+		vpkg, err := MakeValidationPackage(h, &pkg)
+		So(err, ShouldBeNil)
+		So(vpkg.Chain.Length(), ShouldEqual, h.chain.Length())
+		So(vpkg.Chain.Headers[0].Type, ShouldEqual, DNAEntryType)
+	})
+
+	Convey("a package signed by someone other than the source should fail validation", t, func() {
+		pkg, _ := MakePackage(h, PackagingReq{PkgReqChain: int64(PkgReqChainOptFull)})
+		a := NewPutAction("evenNumbers", &GobEntry{C: "6"}, nil)
+		other, _ := peer.IDB58Decode("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		_, err := h.ValidateAction(a, "evenNumbers", &pkg, []peer.ID{other})
+		So(err, ShouldEqual, ErrPackageSignerNotSource)
+	})
+}
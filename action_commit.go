@@ -83,7 +83,7 @@ func (a *ActionCommit) Share(h *Holochain, def *EntryDef) (err error) {
 	}
 	if def.isSharingPublic() {
 		// otherwise we check to see if it's a public entry and if so send the DHT put message
-		err = h.dht.Change(a.header.EntryLink, PUT_REQUEST, HoldReq{EntryHash: a.header.EntryLink})
+		err = h.dht.Change(a.header.EntryLink, PUT_REQUEST, putReq(a.header.EntryLink, a.entry))
 		if err == ErrEmptyRoutingTable {
 			// will still have committed locally and can gossip later
 			err = nil
@@ -6,6 +6,9 @@ import (
 	. "github.com/holochain/holochain-proto/hash"
 	peer "github.com/libp2p/go-libp2p-peer"
 	"reflect"
+	"regexp"
+	"sort"
+	"strings"
 )
 
 //------------------------------------------------------------
@@ -96,7 +99,230 @@ func (a *ActionGetLinks) Receive(dht *DHT, msg *Message) (response interface{},
 	lq := msg.Body.(LinkQuery)
 	var r LinkQueryResp
 	r.Links, err = dht.GetLinks(lq.Base, lq.T, lq.StatusMask)
+	if err == nil {
+		r.Links, err = filterLinks(dht, &lq, r.Links)
+	}
+	if err == nil {
+		sortLinks(&lq, r.Links)
+		r.Links, r.Next = pageLinks(&lq, r.Links, dht.config.MaxLinkSets)
+	}
 	response = &r
 
 	return
 }
+
+// newLinkQuery builds the query for the links on a base from the getLinks options
+func newLinkQuery(base Hash, tag string, options *GetLinksOptions) *LinkQuery {
+	return &LinkQuery{
+		Base:       base,
+		T:          tag,
+		StatusMask: options.StatusMask,
+		Page:       options.Page,
+		Count:      options.Count,
+		Cursor:     options.Cursor,
+		Order:      options.Order,
+		Descending: options.Descending,
+		Sources:    options.Sources,
+		EntryTypes: options.EntryTypes,
+		TagPrefix:  options.TagPrefix,
+		TagRegex:   options.TagRegex,
+	}
+}
+
+// stringsFromInterface converts an array of strings from a ribosome into a []string
+func stringsFromInterface(v interface{}) (strs []string, ok bool) {
+	switch t := v.(type) {
+	case []string:
+		strs, ok = t, true
+	case []interface{}:
+		for _, x := range t {
+			var str string
+			str, ok = x.(string)
+			if !ok {
+				return
+			}
+			strs = append(strs, str)
+		}
+		ok = true
+	}
+	return
+}
+
+// setQueryOptions sets the ordering and filtering options from the options
+// object passed to getLinks in a ribosome
+func (options *GetLinksOptions) setQueryOptions(opts map[string]interface{}) (err error) {
+	for _, name := range []string{"Order", "TagPrefix", "TagRegex"} {
+		v, ok := opts[name]
+		if !ok {
+			continue
+		}
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expecting string %s attribute in object, got %T", name, v)
+		}
+		switch name {
+		case "Order":
+			switch str {
+			case LinkOrderHash, LinkOrderTime, LinkOrderSource:
+			default:
+				return fmt.Errorf("unknown link order: %s", str)
+			}
+			options.Order = str
+		case "TagPrefix":
+			options.TagPrefix = str
+		case "TagRegex":
+			options.TagRegex = str
+		}
+	}
+	v, ok := opts["Descending"]
+	if ok {
+		options.Descending, ok = v.(bool)
+		if !ok {
+			return fmt.Errorf("expecting boolean Descending attribute in object, got %T", v)
+		}
+	}
+	v, ok = opts["Sources"]
+	if ok {
+		options.Sources, ok = stringsFromInterface(v)
+		if !ok {
+			return fmt.Errorf("expecting array of strings Sources attribute in object, got %T", v)
+		}
+	}
+	v, ok = opts["EntryTypes"]
+	if ok {
+		options.EntryTypes, ok = stringsFromInterface(v)
+		if !ok {
+			return fmt.Errorf("expecting array of strings EntryTypes attribute in object, got %T", v)
+		}
+	}
+	return
+}
+
+// linkTag returns the tag of a link in the results of a query
+func linkTag(lq *LinkQuery, th *TaggedHash) string {
+	if lq.T != "" {
+		return lq.T
+	}
+	return th.T
+}
+
+// localEntryType returns the entry type of a linked entry if we are holding it
+func localEntryType(dht *DHT, link string) (entryType string, err error) {
+	var hash Hash
+	hash, err = NewHash(link)
+	if err != nil {
+		return
+	}
+	_, entryType, _, _, err = dht.Get(hash, StatusAny, GetMaskEntryType)
+	return
+}
+
+// filterLinks returns only the links that match the filters of the query
+func filterLinks(dht *DHT, lq *LinkQuery, links []TaggedHash) (filtered []TaggedHash, err error) {
+	if len(lq.Sources) == 0 && len(lq.EntryTypes) == 0 && lq.TagPrefix == "" && lq.TagRegex == "" {
+		filtered = links
+		return
+	}
+	var re *regexp.Regexp
+	if lq.TagRegex != "" {
+		re, err = regexp.Compile(lq.TagRegex)
+		if err != nil {
+			return
+		}
+	}
+	filtered = make([]TaggedHash, 0)
+	for _, th := range links {
+		tag := linkTag(lq, &th)
+		if lq.TagPrefix != "" && !strings.HasPrefix(tag, lq.TagPrefix) {
+			continue
+		}
+		if re != nil && !re.MatchString(tag) {
+			continue
+		}
+		if len(lq.Sources) > 0 && !contains(lq.Sources, th.Source) {
+			continue
+		}
+		if len(lq.EntryTypes) > 0 {
+			entryType := th.linkedType
+			if entryType == "" {
+				// links recorded without the entry type are only filtered
+				// on what we hold, never with a query to other nodes
+				var e error
+				entryType, e = localEntryType(dht, th.H)
+				if e != nil {
+					dht.dlog.Logf("getLinks couldn't get entry type of %s: %v", th.H, e)
+					continue
+				}
+			}
+			if !contains(lq.EntryTypes, entryType) {
+				continue
+			}
+		}
+		filtered = append(filtered, th)
+	}
+	return
+}
+
+// linkCursor returns the position of a link in the ordering of links requested by the query
+func linkCursor(lq *LinkQuery, th *TaggedHash) (cursor string) {
+	cursor = th.H + ":" + linkTag(lq, th)
+	// the fields are separated by a space which sorts before any of
+	// the characters in a time or a source
+	switch lq.Order {
+	case LinkOrderTime:
+		cursor = fmt.Sprintf("%020d %s", th.Time.UnixNano(), cursor)
+	case LinkOrderSource:
+		cursor = th.Source + " " + cursor
+	}
+	return
+}
+
+// sortLinks orders the links as requested by the query
+func sortLinks(lq *LinkQuery, links []TaggedHash) {
+	if lq.Order == "" || lq.Order == LinkOrderHash {
+		// the HashTable returns links in hash order
+		if lq.Descending {
+			for i, j := 0, len(links)-1; i < j; i, j = i+1, j-1 {
+				links[i], links[j] = links[j], links[i]
+			}
+		}
+		return
+	}
+	sort.SliceStable(links, func(i, j int) bool {
+		if lq.Descending {
+			return linkCursor(lq, &links[i]) > linkCursor(lq, &links[j])
+		}
+		return linkCursor(lq, &links[i]) < linkCursor(lq, &links[j])
+	})
+}
+
+// pageLinks returns the page of links requested by the query, never more than max
+// links if max isn't zero, and the cursor to use to get the links after that page.
+// N.B. assumes the links have already been sorted by sortLinks
+func pageLinks(lq *LinkQuery, links []TaggedHash, max int) (page []TaggedHash, next string) {
+	count := lq.Count
+	if max > 0 && (count <= 0 || count > max) {
+		count = max
+	}
+	start := 0
+	if lq.Cursor != "" {
+		for start < len(links) {
+			c := linkCursor(lq, &links[start])
+			if (!lq.Descending && c > lq.Cursor) || (lq.Descending && c < lq.Cursor) {
+				break
+			}
+			start++
+		}
+	} else if count > 0 && lq.Page > 0 {
+		start = lq.Page * count
+		if start > len(links) {
+			start = len(links)
+		}
+	}
+	page = links[start:]
+	if count > 0 && len(page) > count {
+		page = page[:count]
+		next = linkCursor(lq, &page[count-1])
+	}
+	return
+}
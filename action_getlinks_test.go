@@ -0,0 +1,213 @@
+package holochain
+
+import (
+	"fmt"
+	. "github.com/holochain/holochain-proto/hash"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestGetLinksName(t *testing.T) {
+	Convey("getLinks action should have the right name", t, func() {
+		a := NewGetLinksAction(&LinkQuery{}, &GetLinksOptions{})
+		So(a.Name(), ShouldEqual, "getLinks")
+	})
+}
+
+func TestPageLinks(t *testing.T) {
+	var links []TaggedHash
+	for i := 0; i < 5; i++ {
+		links = append(links, TaggedHash{H: fmt.Sprintf("Qm%d", i), T: "tag"})
+	}
+
+	Convey("without a count or max all the links should be returned", t, func() {
+		page, next := pageLinks(&LinkQuery{}, links, 0)
+		So(len(page), ShouldEqual, 5)
+		So(next, ShouldEqual, "")
+	})
+
+	Convey("max should limit the number of links returned", t, func() {
+		page, next := pageLinks(&LinkQuery{}, links, 2)
+		So(len(page), ShouldEqual, 2)
+		So(page[1].H, ShouldEqual, "Qm1")
+		So(next, ShouldEqual, "Qm1:tag")
+
+		page, next = pageLinks(&LinkQuery{Count: 10}, links, 3)
+		So(len(page), ShouldEqual, 3)
+		So(next, ShouldEqual, "Qm2:tag")
+	})
+
+	Convey("the cursor should continue after the link it points to", t, func() {
+		page, next := pageLinks(&LinkQuery{Count: 2, Cursor: "Qm1:tag"}, links, 0)
+		So(len(page), ShouldEqual, 2)
+		So(page[0].H, ShouldEqual, "Qm2")
+		So(next, ShouldEqual, "Qm3:tag")
+
+		page, next = pageLinks(&LinkQuery{Count: 2, Cursor: next}, links, 0)
+		So(len(page), ShouldEqual, 1)
+		So(page[0].H, ShouldEqual, "Qm4")
+		So(next, ShouldEqual, "")
+	})
+
+	Convey("pages should be counted in Count sized chunks", t, func() {
+		page, next := pageLinks(&LinkQuery{Count: 2, Page: 1}, links, 0)
+		So(len(page), ShouldEqual, 2)
+		So(page[0].H, ShouldEqual, "Qm2")
+		So(next, ShouldEqual, "Qm3:tag")
+
+		page, next = pageLinks(&LinkQuery{Count: 2, Page: 5}, links, 0)
+		So(len(page), ShouldEqual, 0)
+		So(next, ShouldEqual, "")
+	})
+
+	Convey("the tag should come from the query if one was given", t, func() {
+		tagged := []TaggedHash{{H: "Qm0"}, {H: "Qm1"}}
+		_, next := pageLinks(&LinkQuery{T: "foo", Count: 1}, tagged, 0)
+		So(next, ShouldEqual, "Qm0:foo")
+	})
+}
+
+func TestGetLinksMaxLinkSets(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	base := commit(h, "evenNumbers", "2")
+	var linked []Hash
+	for i := 0; i < 3; i++ {
+		linked = append(linked, commit(h, "evenNumbers", fmt.Sprintf("%d", (i+2)*2)))
+	}
+	for _, l := range linked {
+		commit(h, "rating", fmt.Sprintf(`{"Links":[{"Base":"%s","Link":"%s","Tag":"4stars"}]}`, base.String(), l.String()))
+	}
+
+	Convey("the DHT should never return more than MaxLinkSets links", t, func() {
+		h.dht.config.MaxLinkSets = 2
+		defer func() { h.dht.config.MaxLinkSets = 0 }()
+		m := h.node.NewMessage(GETLINK_REQUEST, LinkQuery{Base: base, T: "4stars", Count: 10})
+		r, err := ActionReceiver(h, m)
+		So(err, ShouldBeNil)
+		results := r.(*LinkQueryResp)
+		So(len(results.Links), ShouldEqual, 2)
+		So(results.Next, ShouldNotEqual, "")
+
+		m = h.node.NewMessage(GETLINK_REQUEST, LinkQuery{Base: base, T: "4stars", Cursor: results.Next})
+		r, err = ActionReceiver(h, m)
+		So(err, ShouldBeNil)
+		results = r.(*LinkQueryResp)
+		So(len(results.Links), ShouldEqual, 1)
+		So(results.Next, ShouldEqual, "")
+	})
+}
+
+func TestSortAndFilterLinks(t *testing.T) {
+	now := time.Now()
+	links := []TaggedHash{
+		{H: "Qm0", T: "tag b", Source: "agent2", Time: now.Add(time.Second)},
+		{H: "Qm1", T: "tag a", Source: "agent1", Time: now.Add(2 * time.Second)},
+		{H: "Qm2", T: "other", Source: "agent3", Time: now},
+	}
+	hashes := func(links []TaggedHash) (h []string) {
+		for _, l := range links {
+			h = append(h, l.H)
+		}
+		return
+	}
+
+	Convey("links should be sortable by time, source and hash", t, func() {
+		l := append([]TaggedHash{}, links...)
+		sortLinks(&LinkQuery{Order: LinkOrderTime}, l)
+		So(hashes(l), ShouldResemble, []string{"Qm2", "Qm0", "Qm1"})
+		sortLinks(&LinkQuery{Order: LinkOrderSource}, l)
+		So(hashes(l), ShouldResemble, []string{"Qm1", "Qm0", "Qm2"})
+		sortLinks(&LinkQuery{Order: LinkOrderSource, Descending: true}, l)
+		So(hashes(l), ShouldResemble, []string{"Qm2", "Qm0", "Qm1"})
+
+		l = append([]TaggedHash{}, links...)
+		sortLinks(&LinkQuery{Descending: true}, l)
+		So(hashes(l), ShouldResemble, []string{"Qm2", "Qm1", "Qm0"})
+	})
+
+	Convey("the cursor should follow the ordering of the query", t, func() {
+		lq := LinkQuery{Order: LinkOrderTime, Descending: true, Count: 1}
+		l := append([]TaggedHash{}, links...)
+		sortLinks(&lq, l)
+		page, next := pageLinks(&lq, l, 0)
+		So(hashes(page), ShouldResemble, []string{"Qm1"})
+		lq.Cursor = next
+		page, next = pageLinks(&lq, l, 0)
+		So(hashes(page), ShouldResemble, []string{"Qm0"})
+		lq.Cursor = next
+		page, next = pageLinks(&lq, l, 0)
+		So(hashes(page), ShouldResemble, []string{"Qm2"})
+		So(next, ShouldEqual, "")
+	})
+
+	Convey("links should be filterable by source and tag", t, func() {
+		l, err := filterLinks(nil, &LinkQuery{Sources: []string{"agent1", "agent3"}}, links)
+		So(err, ShouldBeNil)
+		So(hashes(l), ShouldResemble, []string{"Qm1", "Qm2"})
+
+		l, err = filterLinks(nil, &LinkQuery{TagPrefix: "tag"}, links)
+		So(err, ShouldBeNil)
+		So(hashes(l), ShouldResemble, []string{"Qm0", "Qm1"})
+
+		l, err = filterLinks(nil, &LinkQuery{TagRegex: "^(other|tag a)$"}, links)
+		So(err, ShouldBeNil)
+		So(hashes(l), ShouldResemble, []string{"Qm1", "Qm2"})
+
+		_, err = filterLinks(nil, &LinkQuery{TagRegex: "("}, links)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("links should be filterable by the entry type recorded with them", t, func() {
+		typed := append([]TaggedHash{}, links...)
+		typed[0].linkedType = "profile"
+		typed[1].linkedType = "post"
+		typed[2].linkedType = "profile"
+		l, err := filterLinks(nil, &LinkQuery{EntryTypes: []string{"profile"}}, typed)
+		So(err, ShouldBeNil)
+		So(hashes(l), ShouldResemble, []string{"Qm0", "Qm2"})
+	})
+}
+
+func TestGetLinksQueryOptions(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	base := commit(h, "evenNumbers", "2")
+	even := commit(h, "evenNumbers", "4")
+	profile := commit(h, "profile", `{"firstName":"Zippy","lastName":"Pinhead"}`)
+	commit(h, "rating", fmt.Sprintf(`{"Links":[{"Base":"%s","Link":"%s","Tag":"4stars"}]}`, base.String(), profile.String()))
+	commit(h, "rating", fmt.Sprintf(`{"Links":[{"Base":"%s","Link":"%s","Tag":"4stars"}]}`, base.String(), even.String()))
+
+	Convey("the DHT should filter links by the entry type of the linked entry", t, func() {
+		m := h.node.NewMessage(GETLINK_REQUEST, LinkQuery{Base: base, T: "4stars", EntryTypes: []string{"profile"}})
+		r, err := ActionReceiver(h, m)
+		So(err, ShouldBeNil)
+		results := r.(*LinkQueryResp)
+		So(len(results.Links), ShouldEqual, 1)
+		So(results.Links[0].H, ShouldEqual, profile.String())
+	})
+
+	Convey("links to entries the DHT doesn't hold should be stored without an entry type", t, func() {
+		odd := commit(h, "oddNumbers", "7")
+		So(h.dht.Forget(odd), ShouldBeNil)
+		commit(h, "rating", fmt.Sprintf(`{"Links":[{"Base":"%s","Link":"%s","Tag":"3stars"}]}`, base.String(), odd.String()))
+		links, err := h.dht.GetLinks(base, "3stars", StatusLive)
+		So(err, ShouldBeNil)
+		So(len(links), ShouldEqual, 1)
+		So(links[0].H, ShouldEqual, odd.String())
+		So(links[0].linkedType, ShouldEqual, "")
+	})
+
+	Convey("the DHT should order links by the time they were made", t, func() {
+		m := h.node.NewMessage(GETLINK_REQUEST, LinkQuery{Base: base, T: "4stars", Order: LinkOrderTime, Descending: true})
+		r, err := ActionReceiver(h, m)
+		So(err, ShouldBeNil)
+		results := r.(*LinkQueryResp)
+		So(len(results.Links), ShouldEqual, 2)
+		So(results.Links[0].H, ShouldEqual, even.String())
+		So(results.Links[1].H, ShouldEqual, profile.String())
+	})
+}
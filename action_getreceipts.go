@@ -0,0 +1,25 @@
+package holochain
+
+import (
+	. "github.com/holochain/holochain-proto/hash"
+)
+
+//------------------------------------------------------------
+// GetReceipts
+
+type APIFnGetReceipts struct {
+	hash Hash
+}
+
+func (a *APIFnGetReceipts) Name() string {
+	return "getReceipts"
+}
+
+func (a *APIFnGetReceipts) Args() []Arg {
+	return []Arg{{Name: "hash", Type: HashArg}}
+}
+
+func (a *APIFnGetReceipts) Call(h *Holochain) (response interface{}, err error) {
+	response, err = h.GetReceipts(a.hash)
+	return
+}
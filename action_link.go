@@ -58,9 +58,16 @@ func (a *ActionLink) Receive(dht *DHT, msg *Message) (response interface{}, err
 			for _, l := range le.Links {
 				if base == l.Base {
 					if l.LinkAction == DelLinkAction {
-						err = dht.DelLink(msg, base, l.Link, l.Tag)
+						err = dht.DelLink(msg, base, l.Link, l.Tag, resp.Header.Time)
 					} else {
-						err = dht.PutLink(msg, base, l.Link, l.Tag)
+						// only a type from data we hold and have validated is stored,
+						// otherwise it's left unset to be looked up when filtering
+						entryType, e := localEntryType(dht, l.Link)
+						if e != nil {
+							dht.dlog.Logf("putLink couldn't get entry type of %s: %v", l.Link, e)
+							entryType = ""
+						}
+						err = dht.PutLink(msg, base, l.Link, l.Tag, entryType, resp.Header.Time)
 					}
 				}
 			}
@@ -42,29 +42,53 @@ func (a *ActionPut) Receive(dht *DHT, msg *Message) (response interface{}, err e
 	if err != ErrHashNotFound {
 		return
 	}
+	// don't bother fetching an entry the sender says is too large
+	if max := dht.config.MaxEntrySize; max > 0 && t.Size > max {
+		dht.dlog.Logf("Put %v of size %d refused: %v", t.EntryHash, t.Size, ErrEntryTooLarge)
+		err = ErrEntryTooLarge
+		return
+	}
 
+	// rejected records why the entry was refused because of the DNA's entry size
+	// limit or source quota, so that it can be reported back to the sender
+	var rejected error
 	err = RunValidationPhase(dht.h, msg.From, VALIDATE_PUT_REQUEST, t.EntryHash, func(resp ValidateResponse) error {
-		a := NewPutAction(resp.Type, &resp.Entry, &resp.Header)
-		_, err := dht.h.ValidateAction(a, a.entryType, &resp.Package, []peer.ID{msg.From})
+		entry := resp.Entry
+		b, err := entry.Marshal()
+		if err != nil {
+			return err
+		}
 
+		// the quota is charged to the entry's author, not to whoever passed it on
+		src := entrySource(dht.h, &resp, msg.From)
 		var status int
-		if err != nil {
-			dht.dlog.Logf("Put %v rejected: %v", t.EntryHash, err)
+		rejected = dht.checkHoldLimits(src, b)
+		if rejected != nil {
+			dht.dlog.Logf("Put %v rejected: %v", t.EntryHash, rejected)
 			status = StatusRejected
+			// remember the rejection but don't hold the data
+			b = nil
 		} else {
-			status = StatusLive
-		}
-		entry := resp.Entry
-		var b []byte
-		b, err = entry.Marshal()
-		if err == nil {
-			err = dht.Put(msg, resp.Type, t.EntryHash, msg.From, b, status)
+			a := NewPutAction(resp.Type, &resp.Entry, &resp.Header)
+			_, err = dht.h.ValidateAction(a, a.entryType, &resp.Package, []peer.ID{msg.From})
+			if err != nil {
+				dht.dlog.Logf("Put %v rejected: %v", t.EntryHash, err)
+				status = StatusRejected
+				dht.warrantInvalidData(msg.From, resp, err)
+			} else {
+				status = StatusLive
+			}
 		}
+		err = dht.Put(msg, resp.Type, t.EntryHash, src, b, status)
 		if err == nil {
 			holdResp, err = dht.MakeHoldResp(msg, status)
 		}
 		return err
 	})
+	if err == nil && rejected != nil {
+		err = rejected
+		return
+	}
 
 	r := dht.h.RedundancyFactor()
 	if r == 0 {
@@ -89,3 +113,21 @@ func (a *ActionPut) Receive(dht *DHT, msg *Message) (response interface{}, err e
 func (a *ActionPut) CheckValidationRequest(def *EntryDef) (err error) {
 	return
 }
+
+// entrySource returns the agent whose header of a validated entry is proved by the
+// validation package, or the peer the entry came from if there's no valid proof
+func entrySource(h *Holochain, resp *ValidateResponse, from peer.ID) (source peer.ID) {
+	source = from
+	if len(resp.Package.Proofs) == 0 {
+		return
+	}
+	proof := &resp.Package.Proofs[0]
+	if VerifyChainProof(h.hashSpec, proof) != nil {
+		return
+	}
+	agent, err := proof.Agent()
+	if err == nil {
+		source = agent
+	}
+	return
+}
@@ -20,6 +20,10 @@ func (a *APIFnQuery) Args() []Arg {
 }
 
 func (a *APIFnQuery) Call(h *Holochain) (response interface{}, err error) {
+	if a.options != nil && a.options.Aggregate.Fn != "" {
+		response, err = h.QueryAggregate(a.options)
+		return
+	}
 	response, err = h.Query(a.options)
 	return
 }
@@ -0,0 +1,47 @@
+package holochain
+
+import (
+	"reflect"
+)
+
+//------------------------------------------------------------
+// Subscribe
+
+type APIFnSubscribe struct {
+	zome     string
+	filter   DHTChangeFilter
+	callback string
+}
+
+func (a *APIFnSubscribe) Name() string {
+	return "subscribe"
+}
+
+func (a *APIFnSubscribe) Args() []Arg {
+	return []Arg{{Name: "filter", Type: MapArg, MapType: reflect.TypeOf(DHTChangeFilter{})}, {Name: "callback", Type: StringArg}}
+}
+
+func (a *APIFnSubscribe) Call(h *Holochain) (response interface{}, err error) {
+	response, err = h.Subscribe(a.zome, a.filter, a.callback)
+	return
+}
+
+//------------------------------------------------------------
+// Unsubscribe
+
+type APIFnUnsubscribe struct {
+	id int
+}
+
+func (a *APIFnUnsubscribe) Name() string {
+	return "unsubscribe"
+}
+
+func (a *APIFnUnsubscribe) Args() []Arg {
+	return []Arg{{Name: "id", Type: IntArg}}
+}
+
+func (a *APIFnUnsubscribe) Call(h *Holochain) (response interface{}, err error) {
+	err = h.dht.Unsubscribe(a.id)
+	return
+}
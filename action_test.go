@@ -132,6 +132,14 @@ func TestSysValidateEntry(t *testing.T) {
 		So(err.Error(), ShouldEqual, "Validation Failed: nil entry invalid")
 	})
 
+	Convey("an entry larger than the DNA's MaxEntrySize is invalid", t, func() {
+		_, def, _ := h.GetEntryDef("evenNumbers")
+		h.nucleus.dna.DHTConfig.MaxEntrySize = 4
+		defer func() { h.nucleus.dna.DHTConfig.MaxEntrySize = 0 }()
+		err := sysValidateEntry(h, def, &GobEntry{C: "123456"}, nil)
+		So(err, ShouldEqual, ErrEntryTooLarge)
+	})
+
 	Convey("validate on a schema based entry should check entry against the schema", t, func() {
 		profile := `{"firstName":"Eric"}` // missing required lastName
 		_, def, _ := h.GetEntryDef("profile")
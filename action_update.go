@@ -62,7 +62,7 @@ func (a *ActionMod) Share(h *Holochain, def *EntryDef) (err error) {
 	if def.isSharingPublic() {
 		// if it's a public entry send the DHT MOD & PUT messages
 		// TODO handle errors better!!
-		h.dht.Change(a.header.EntryLink, PUT_REQUEST, HoldReq{EntryHash: a.header.EntryLink})
+		h.dht.Change(a.header.EntryLink, PUT_REQUEST, putReq(a.header.EntryLink, a.entry))
 		h.dht.Change(a.replaces, MOD_REQUEST, HoldReq{RelatedHash: a.replaces, EntryHash: a.header.EntryLink})
 	}
 	return
@@ -133,7 +133,7 @@ func (a *ActionMod) Receive(dht *DHT, msg *Message) (response interface{}, err e
 			// how do we record an invalid Mod?
 			//@TODO store as REJECTED?
 		} else {
-			err = dht.Mod(msg, t.RelatedHash, t.EntryHash)
+			err = dht.Mod(msg, t.RelatedHash, t.EntryHash, resp.Header.Time)
 			if err == nil {
 				holdResp, err = dht.MakeHoldResp(msg, StatusLive)
 			}
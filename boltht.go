@@ -0,0 +1,893 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements a boltdb based instance of HashTable
+// unlike BuntHT the data is kept on disk and only paged in when accessed, which
+// makes it more suitable for nodes that hold large numbers of entries
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+const (
+	BoltHTType = "bolt"
+
+	// BoltOpenTimeout is how long to wait for the lock on the database file
+	// if another process has it open
+	BoltOpenTimeout = 5 * time.Second
+)
+
+var (
+	boltEntryBucket       = []byte("entry")
+	boltTypeBucket        = []byte("type")
+	boltSrcBucket         = []byte("src")
+	boltStatusBucket      = []byte("status")
+	boltReplacedByBucket  = []byte("replacedBy")
+	boltLinkBucket        = []byte("link")
+	boltIdxBucket         = []byte("idx")
+	boltFingerprintBucket = []byte("f")
+	boltPeerBucket        = []byte("peer")
+	boltListBucket        = []byte("list")
+	boltMetaBucket        = []byte("meta")
+	boltReceiptBucket     = []byte("receipt")
+	boltUsageBucket       = []byte("usage")
+	boltReputationBucket  = []byte("reputation")
+	boltHeaderBucket      = []byte("header")
+	boltHeaderOfBucket    = []byte("headerOf")
+
+	boltIdxKey = []byte("_idx")
+
+	boltBuckets = [][]byte{boltEntryBucket, boltTypeBucket, boltSrcBucket, boltStatusBucket,
+		boltReplacedByBucket, boltLinkBucket, boltIdxBucket, boltFingerprintBucket,
+		boltPeerBucket, boltListBucket, boltMetaBucket, boltReceiptBucket, boltUsageBucket,
+		boltReputationBucket, boltHeaderBucket, boltHeaderOfBucket}
+)
+
+type BoltHT struct {
+	db *bolt.DB
+}
+
+// NewBoltHT creates a BoltHT stored in the given directory
+func NewBoltHT(dbPath string) (ht HashTable, err error) {
+	b := &BoltHT{}
+	err = b.Open(filepath.Join(dbPath, DHTBoltStoreFileName))
+	if err == nil {
+		ht = b
+	}
+	return
+}
+
+// Open initializes the table
+func (ht *BoltHT) Open(options interface{}) (err error) {
+	file := options.(string)
+	var db *bolt.DB
+	db, err = bolt.Open(file, 0600, &bolt.Options{Timeout: BoltOpenTimeout})
+	if err != nil {
+		err = fmt.Errorf("unable to open %s: %v", file, err)
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range boltBuckets {
+			_, e := tx.CreateBucketIfNotExists(b)
+			if e != nil {
+				return e
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return
+	}
+	ht.db = db
+	return
+}
+
+// Close cleans up any resources used by the table
+func (ht *BoltHT) Close() {
+	ht.db.Close()
+	ht.db = nil
+}
+
+// itob converts an index into a big endian key so that keys sort in index order
+func itob(i int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(i))
+	return b
+}
+
+// btoi converts a big endian key back into an index
+func btoi(b []byte) int {
+	return int(binary.BigEndian.Uint64(b))
+}
+
+// boltGetInt returns the integer value at a given key, and assumes the value 0 if the key doesn't exist
+func boltGetInt(b *bolt.Bucket, key []byte) int {
+	v := b.Get(key)
+	if v == nil {
+		return 0
+	}
+	return btoi(v)
+}
+
+// boltIncIdx adds a new index record to dht for gossiping later
+func boltIncIdx(tx *bolt.Tx, m *Message) (err error) {
+	// if message is nil we can't record this for gossiping
+	// this should only be the case for the DNA
+	if m == nil {
+		return
+	}
+
+	meta := tx.Bucket(boltMetaBucket)
+	idx := boltGetInt(meta, boltIdxKey) + 1
+	err = meta.Put(boltIdxKey, itob(idx))
+	if err != nil {
+		return
+	}
+
+	var b []byte
+	b, err = ByteEncoder(m)
+	if err != nil {
+		return
+	}
+	err = tx.Bucket(boltIdxBucket).Put(itob(idx), b)
+	if err != nil {
+		return
+	}
+
+	var f Hash
+	f, err = m.Fingerprint()
+	if err != nil {
+		return
+	}
+	err = tx.Bucket(boltFingerprintBucket).Put([]byte(f.String()), itob(idx))
+	return
+}
+
+// Put stores a value to the DHT store
+// N.B. This call assumes that the value has already been validated
+func (ht *BoltHT) Put(m *Message, entryType string, key Hash, src peer.ID, value []byte, status int) (err error) {
+	k := []byte(key.String())
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		err := boltIncIdx(tx, m)
+		if err != nil {
+			return err
+		}
+		err = boltReleaseUsage(tx, k)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(boltEntryBucket).Put(k, value)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(boltTypeBucket).Put(k, []byte(entryType))
+		if err != nil {
+			return err
+		}
+		s := []byte(peer.IDB58Encode(src))
+		err = tx.Bucket(boltSrcBucket).Put(k, s)
+		if err != nil {
+			return err
+		}
+		err = boltAddUsage(tx, s, len(value))
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(boltStatusBucket).Put(k, []byte(fmt.Sprintf("%d", status)))
+	})
+	return
+}
+
+func boltSetStatus(tx *bolt.Tx, m *Message, key string, status int) (err error) {
+	k := []byte(key)
+	if tx.Bucket(boltEntryBucket).Get(k) == nil {
+		err = ErrHashNotFound
+		return
+	}
+
+	err = boltIncIdx(tx, m)
+	if err != nil {
+		return
+	}
+
+	err = tx.Bucket(boltStatusBucket).Put(k, []byte(fmt.Sprintf("%d", status)))
+	return
+}
+
+// Del moves the given hash to the StatusDeleted status
+// N.B. this functions assumes that the validity of this action has been confirmed
+func (ht *BoltHT) Del(m *Message, key Hash) (err error) {
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		return boltSetStatus(tx, m, key.String(), StatusDeleted)
+	})
+	return
+}
+
+// Mod moves the given hash to the StatusModified status
+// N.B. this functions assumes that the validity of this action has been confirmed
+func (ht *BoltHT) Mod(m *Message, key Hash, newkey Hash, t time.Time) (err error) {
+	k := key.String()
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		err := boltSetStatus(tx, m, k, StatusModified)
+		if err == nil {
+			link := newkey.String()
+			err = boltLink(tx, k, link, SysTagReplacedBy, m.From, StatusLive, newkey, "", t)
+			if err == nil {
+				err = tx.Bucket(boltReplacedByBucket).Put([]byte(k), []byte(link))
+			}
+		}
+		return err
+	})
+	return
+}
+
+// boltGet returns the value of an entry checking it against the status mask
+// with the same semantics as the BuntHT
+func boltGet(tx *bolt.Tx, k string, statusMask int) (val string, err error) {
+	key := []byte(k)
+	v := tx.Bucket(boltEntryBucket).Get(key)
+	if v == nil {
+		err = ErrHashNotFound
+		return
+	}
+	val = string(v)
+	statusVal := string(tx.Bucket(boltStatusBucket).Get(key))
+
+	if statusMask == StatusDefault {
+		// if the status mask is not given (i.e. Default) then
+		// we return information about the status if it's other than live
+		switch statusVal {
+		case StatusDeletedVal:
+			err = ErrHashDeleted
+		case StatusModifiedVal:
+			r := tx.Bucket(boltReplacedByBucket).Get(key)
+			if r == nil {
+				panic("missing expected replacedBy record")
+			}
+			val = string(r)
+			err = ErrHashModified
+		case StatusRejectedVal:
+			err = ErrHashRejected
+		case StatusLiveVal:
+		default:
+			panic("unknown status!")
+		}
+	} else {
+		// otherwise we return the value only if the status is in the mask
+		var status int
+		status, err = strconv.Atoi(statusVal)
+		if err == nil {
+			if (status & statusMask) == 0 {
+				err = ErrHashNotFound
+			}
+		}
+	}
+	return
+}
+
+// Exists checks for the existence of the hash in the store
+func (ht *BoltHT) Exists(key Hash, statusMask int) (err error) {
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		_, err := boltGet(tx, key.String(), statusMask)
+		return err
+	})
+	return
+}
+
+// Source returns the source node address of a given hash
+func (ht *BoltHT) Source(key Hash) (id peer.ID, err error) {
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltSrcBucket).Get([]byte(key.String()))
+		if v == nil {
+			return ErrHashNotFound
+		}
+		var e error
+		id, e = peer.IDB58Decode(string(v))
+		return e
+	})
+	return
+}
+
+// Get retrieves a value from the DHT store
+func (ht *BoltHT) Get(key Hash, statusMask int, getMask int) (data []byte, entryType string, sources []string, status int, err error) {
+	if getMask == GetMaskDefault {
+		getMask = GetMaskEntry
+	}
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		k := key.String()
+		val, err := boltGet(tx, k, statusMask)
+		data = []byte(val) // gotta do this because value is valid if ErrHashModified
+		if err != nil {
+			return err
+		}
+
+		if (getMask & GetMaskEntryType) != 0 {
+			entryType = string(tx.Bucket(boltTypeBucket).Get([]byte(k)))
+		}
+		if (getMask & GetMaskSources) != 0 {
+			v := tx.Bucket(boltSrcBucket).Get([]byte(k))
+			if v == nil {
+				return ErrHashNotFound
+			}
+			sources = append(sources, string(v))
+		}
+
+		status, err = strconv.Atoi(string(tx.Bucket(boltStatusBucket).Get([]byte(k))))
+		return err
+	})
+	return
+}
+
+// boltLinkKey builds the key used to store the linking events between a base and a link
+func boltLinkKey(base string, link string, tag string) []byte {
+	return []byte(base + ":" + link + ":" + tag)
+}
+
+// boltLink is a low level routine to add a link, also used by delLink
+// this ensure monotonic recording of linking attempts
+func boltLink(tx *bolt.Tx, base string, link string, tag string, src peer.ID, status int, linkingEntryHash Hash, entryType string, t time.Time) (err error) {
+	b := tx.Bucket(boltLinkBucket)
+	key := boltLinkKey(base, link, tag)
+	var records []linkEvent
+	val := b.Get(key)
+	if val != nil {
+		// load the previous value so we can append to it.
+		json.Unmarshal(val, &records)
+	} else if status == StatusDeleted {
+		// when deleting the key must exist
+		err = ErrLinkNotFound
+		return
+	}
+	records = appendLinkEvent(records, status, peer.IDB58Encode(src), linkingEntryHash.String(), entryType, t)
+	var v []byte
+	v, err = json.Marshal(records)
+	if err != nil {
+		return
+	}
+	err = b.Put(key, v)
+	return
+}
+
+func (ht *BoltHT) link(m *Message, base string, link string, tag string, status int, entryType string, t time.Time) (err error) {
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		_, err := boltGet(tx, base, StatusLive)
+		if err != nil {
+			return err
+		}
+		err = boltLink(tx, base, link, tag, m.From, status, m.Body.(HoldReq).EntryHash, entryType, t)
+		if err != nil {
+			return err
+		}
+		return boltIncIdx(tx, m)
+	})
+	return
+}
+
+// PutLink associates a link with a stored hash
+// N.B. this function assumes that the data associated has been properly retrieved
+// and validated from the cource chain
+func (ht *BoltHT) PutLink(m *Message, base string, link string, tag string, entryType string, t time.Time) (err error) {
+	err = ht.link(m, base, link, tag, StatusLive, entryType, t)
+	return
+}
+
+// DelLink removes a link and tag associated with a stored hash
+// N.B. this function assumes that the action has been properly validated
+func (ht *BoltHT) DelLink(m *Message, base string, link string, tag string, t time.Time) (err error) {
+	err = ht.link(m, base, link, tag, StatusDeleted, "", t)
+	return
+}
+
+// boltIterateLinks calls fn for each of the link records stored on a base
+func boltIterateLinks(tx *bolt.Tx, base string, fn func(link string, tag string, value []byte)) {
+	prefix := []byte(base + ":")
+	c := tx.Bucket(boltLinkBucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		x := strings.SplitN(string(k[len(prefix):]), ":", 2)
+		fn(x[0], x[1], v)
+	}
+}
+
+// Forget removes all the data stored for a hash, including the links on it
+func (ht *BoltHT) Forget(key Hash) (err error) {
+	k := key.String()
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltEntryBucket).Get([]byte(k)) == nil {
+			return ErrHashNotFound
+		}
+		err := boltReleaseUsage(tx, []byte(k))
+		if err != nil {
+			return err
+		}
+		for _, b := range [][]byte{boltEntryBucket, boltTypeBucket, boltSrcBucket, boltStatusBucket, boltReplacedByBucket} {
+			err := tx.Bucket(b).Delete([]byte(k))
+			if err != nil {
+				return err
+			}
+		}
+		// deleting while iterating with a cursor skips keys so collect them first
+		var links [][]byte
+		boltIterateLinks(tx, k, func(link string, tag string, value []byte) {
+			links = append(links, boltLinkKey(k, link, tag))
+		})
+		b := tx.Bucket(boltLinkBucket)
+		for _, l := range links {
+			err := b.Delete(l)
+			if err != nil {
+				return err
+			}
+		}
+		// and the proofs of the entry's headers
+		var headers [][]byte
+		prefix := []byte(k + ":")
+		c := tx.Bucket(boltHeaderOfBucket).Cursor()
+		for hk, _ := c.Seek(prefix); hk != nil && bytes.HasPrefix(hk, prefix); hk, _ = c.Next() {
+			headers = append(headers, append([]byte{}, hk...))
+		}
+		for _, hk := range headers {
+			err := tx.Bucket(boltHeaderBucket).Delete(hk[len(prefix):])
+			if err == nil {
+				err = tx.Bucket(boltHeaderOfBucket).Delete(hk)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// GetLinks retrieves meta value associated with a base
+func (ht *BoltHT) GetLinks(base Hash, tag string, statusMask int) (results []TaggedHash, err error) {
+	b := base.String()
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		_, err := boltGet(tx, b, StatusLive+StatusModified) //only get links on live and modified bases
+		if err != nil {
+			return err
+		}
+
+		if statusMask == StatusDefault {
+			statusMask = StatusLive
+		}
+
+		results = make([]TaggedHash, 0)
+		boltIterateLinks(tx, b, func(link string, t string, value []byte) {
+			if tag == "" || tag == t {
+				var records []linkEvent
+				json.Unmarshal(value, &records)
+				l := len(records)
+				//TODO: this is totally bogus currently simply
+				// looking at the last item we ever got
+				if l > 0 {
+					entry := records[l-1]
+					if (entry.Status & statusMask) > 0 {
+						th := TaggedHash{H: link, Source: entry.Source, Time: entry.Time, linkedType: entry.EntryType}
+						if tag == "" {
+							th.T = t
+						}
+						results = append(results, th)
+					}
+				}
+			}
+		})
+		return nil
+	})
+	return
+}
+
+// GetIdx returns the current index of changes to the HashTable
+func (ht *BoltHT) GetIdx() (idx int, err error) {
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		idx = boltGetInt(tx.Bucket(boltMetaBucket), boltIdxKey)
+		return nil
+	})
+	return
+}
+
+// GetIdxMessage returns the messages that causes the change at a given index
+func (ht *BoltHT) GetIdxMessage(idx int) (msg Message, err error) {
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltIdxBucket).Get(itob(idx))
+		if v == nil {
+			return ErrNoSuchIdx
+		}
+		return ByteDecoder(v, &msg)
+	})
+	return
+}
+
+// DumpIdx converts message and data of a DHT change request to a string for human consumption
+func (ht *BoltHT) dumpIdx(idx int) (str string, err error) {
+	return dumpHashTableIdx(ht, idx)
+}
+
+// String converts the table into a human readable string
+func (ht *BoltHT) String() (result string) {
+	idx, err := ht.GetIdx()
+	if err != nil {
+		return err.Error()
+	}
+	result += fmt.Sprintf("DHT changes: %d\n", idx)
+	for i := 1; i <= idx; i++ {
+		str, err := ht.dumpIdx(i)
+		if err != nil {
+			result += fmt.Sprintf("%d Error:%v\n", i, err)
+		} else {
+			result += fmt.Sprintf("%d\n%v\n", i, str)
+		}
+	}
+
+	result += fmt.Sprintf("DHT entries:\n")
+	ht.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltEntryBucket).ForEach(func(key, value []byte) error {
+			k := string(key)
+			status := statusValueToString(string(tx.Bucket(boltStatusBucket).Get(key)))
+			sources := string(tx.Bucket(boltSrcBucket).Get(key))
+			var links string
+			boltIterateLinks(tx, k, func(link string, tag string, v []byte) {
+				links += fmt.Sprintf("Linked to: %s with tag %s\n", link, tag)
+				links += string(v) + "\n"
+			})
+			result += fmt.Sprintf("Hash--%s (status %s):\nValue: %s\nSources: %s\n%s\n", k, status, string(value), sources, links)
+			return nil
+		})
+	})
+	return
+}
+
+// DumpIdxJSON converts message and data of a DHT change request to a JSON string representation.
+func (ht *BoltHT) dumpIdxJSON(idx int) (str string, err error) {
+	return dumpHashTableIdxJSON(ht, idx)
+}
+
+// JSON converts the table into a JSON string representation.
+func (ht *BoltHT) JSON() (result string, err error) {
+	var buffer, entries bytes.Buffer
+	idx, err := ht.GetIdx()
+	if err != nil {
+		return "", err
+	}
+	buffer.WriteString("{ \"dht_changes\": [")
+	for i := 1; i <= idx; i++ {
+		json, err := ht.dumpIdxJSON(i)
+		if err != nil {
+			return "", fmt.Errorf("DHT Change %d,  Error: %v", i, err)
+		}
+		buffer.WriteString(json)
+		if i < idx {
+			buffer.WriteString(",")
+		}
+	}
+	buffer.WriteString("], \"dht_entries\": [")
+	ht.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltEntryBucket).ForEach(func(key, value []byte) error {
+			k := string(key)
+			status := statusValueToString(string(tx.Bucket(boltStatusBucket).Get(key)))
+			sources := string(tx.Bucket(boltSrcBucket).Get(key))
+			var links bytes.Buffer
+			boltIterateLinks(tx, k, func(link string, tag string, v []byte) {
+				links.WriteString(fmt.Sprintf("{ \"linkTo\": \"%s\",", link))
+				links.WriteString(fmt.Sprintf("\"tag\": \"%s\",", tag))
+				links.WriteString(fmt.Sprintf("\"value\": \"%s\" },", EscapeJSONValue(string(v))))
+			})
+			entries.WriteString(fmt.Sprintf("{ \"hash\": \"%s\",", k))
+			entries.WriteString(fmt.Sprintf("\"status\": \"%s\",", status))
+			entries.WriteString(fmt.Sprintf("\"value\": \"%s\",", EscapeJSONValue(string(value))))
+			entries.WriteString(fmt.Sprintf("\"sources\": \"%s\"", sources))
+			if links.Len() > 0 {
+				entries.WriteString(fmt.Sprintf(",\"links\": [%s]", strings.TrimSuffix(links.String(), ",")))
+			}
+			entries.WriteString("},")
+			return nil
+		})
+	})
+	buffer.WriteString(strings.TrimSuffix(entries.String(), ","))
+	buffer.WriteString("]}")
+	return PrettyPrintJSON(buffer.Bytes())
+}
+
+// Iterate call fn on all the hashes in the table
+func (ht *BoltHT) Iterate(fn HashTableIterateFn) {
+	ht.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltEntryBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			hash, err := NewHash(string(k))
+			if err != nil || !fn(hash) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// GetFingerprint returns the index that of the message that made a change or -1 if we don't have it
+func (ht *BoltHT) GetFingerprint(f Hash) (index int, err error) {
+	index = -1
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltFingerprintBucket).Get([]byte(f.String()))
+		if v != nil {
+			index = btoi(v)
+		}
+		return nil
+	})
+	return
+}
+
+// GetPuts returns a list of puts after the given index
+func (ht *BoltHT) GetPuts(since int) (puts []Put, err error) {
+	puts = make([]Put, 0)
+	if since < 0 {
+		since = 0
+	}
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltIdxBucket).Cursor()
+		for k, v := c.Seek(itob(since)); k != nil; k, v = c.Next() {
+			p := Put{Idx: btoi(k)}
+			if len(v) > 0 {
+				err := ByteDecoder(v, &p.M)
+				if err != nil {
+					break
+				}
+			}
+			puts = append(puts, p)
+		}
+		return nil
+	})
+	return
+}
+
+// GetGossiper loads returns last known index of the gossiper
+func (ht *BoltHT) GetGossiper(id peer.ID) (idx int, err error) {
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		idx = boltGetInt(tx.Bucket(boltPeerBucket), []byte(peer.IDB58Encode(id)))
+		return nil
+	})
+	return
+}
+
+// GetGossipers returns all the gossipers with their last known index
+func (ht *BoltHT) GetGossipers() (gossipers []GossiperData, err error) {
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltPeerBucket).ForEach(func(k, v []byte) error {
+			id, e := peer.IDB58Decode(string(k))
+			if e != nil {
+				return e
+			}
+			gossipers = append(gossipers, GossiperData{ID: id, PutIdx: btoi(v)})
+			return nil
+		})
+	})
+	return
+}
+
+// UpdateGossiper updates a gossiper's index if it's greater than the stored one
+func (ht *BoltHT) UpdateGossiper(id peer.ID, newIdx int) (err error) {
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltPeerBucket)
+		key := []byte(peer.IDB58Encode(id))
+		if newIdx < boltGetInt(b, key) {
+			return nil
+		}
+		return b.Put(key, itob(newIdx))
+	})
+	return
+}
+
+// DeleteGossiper removes a gossiper from the database
+func (ht *BoltHT) DeleteGossiper(id peer.ID) (err error) {
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltPeerBucket).Delete([]byte(peer.IDB58Encode(id)))
+	})
+	return
+}
+
+// GetReputation returns the reputation of a peer
+func (ht *BoltHT) GetReputation(id peer.ID) (rep Reputation, err error) {
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltReputationBucket).Get([]byte(peer.IDB58Encode(id)))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &rep)
+	})
+	return
+}
+
+// UpdateReputation adds the counts in delta to the reputation of a peer
+func (ht *BoltHT) UpdateReputation(id peer.ID, delta Reputation) (err error) {
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltReputationBucket)
+		key := []byte(peer.IDB58Encode(id))
+		var rep Reputation
+		if v := b.Get(key); v != nil {
+			if err := json.Unmarshal(v, &rep); err != nil {
+				return err
+			}
+		}
+		v, err := json.Marshal(rep.update(delta, time.Now()))
+		if err != nil {
+			return err
+		}
+		return b.Put(key, v)
+	})
+	return
+}
+
+// PutHeaderProof stores the proof of one of an agent's headers under the header it follows
+func (ht *BoltHT) PutHeaderProof(agent peer.ID, proof ChainProof) (seen *ChainProof, err error) {
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltHeaderBucket)
+		key := []byte(peer.IDB58Encode(agent) + ":" + proof.Header.HeaderLink.String())
+		if v := b.Get(key); v != nil {
+			var p ChainProof
+			if err := ByteDecoder(v, &p); err != nil {
+				return err
+			}
+			seen = &p
+			return nil
+		}
+		v, err := ByteEncoder(&proof)
+		if err != nil {
+			return err
+		}
+		err = b.Put(key, v)
+		if err != nil {
+			return err
+		}
+		// index the proof by the header's entry so that Forget can remove it
+		of := append([]byte(proof.Header.EntryLink.String()+":"), key...)
+		return tx.Bucket(boltHeaderOfBucket).Put(of, []byte{})
+	})
+	return
+}
+
+// GetList returns the peer list of the given type
+func (ht *BoltHT) GetList(listType PeerListType) (result PeerList, err error) {
+	result.Type = listType
+	result.Records = make([]PeerRecord, 0)
+	prefix := []byte(string(listType) + ":")
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltListBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			pid, e := peer.IDB58Decode(string(k[len(prefix):]))
+			if e != nil {
+				return e
+			}
+			result.Records = append(result.Records, PeerRecord{ID: pid, Warrant: string(v)})
+		}
+		return nil
+	})
+	return
+}
+
+// AddToList adds the peers to a list
+func (ht *BoltHT) AddToList(m *Message, list PeerList) (err error) {
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		err := boltIncIdx(tx, m)
+		if err != nil {
+			return err
+		}
+		b := tx.Bucket(boltListBucket)
+		for _, r := range list.Records {
+			k := peer.IDB58Encode(r.ID)
+			err = b.Put([]byte(string(list.Type)+":"+k), []byte(r.Warrant))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// PutReceipt stores a receipt returned by a node for a change to a hash
+func (ht *BoltHT) PutReceipt(key Hash, receipt Receipt) (err error) {
+	var f Hash
+	f, err = receipt.Msg.Fingerprint()
+	if err != nil {
+		return
+	}
+	var b []byte
+	b, err = ByteEncoder(&receipt)
+	if err != nil {
+		return
+	}
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		k := []byte(key.String() + ":" + peer.IDB58Encode(receipt.Peer) + ":" + f.String())
+		return tx.Bucket(boltReceiptBucket).Put(k, b)
+	})
+	return
+}
+
+// GetReceipts returns a list of receipts that were generated regarding a hash
+func (ht *BoltHT) GetReceipts(key Hash) (receipts []Receipt, err error) {
+	receipts = make([]Receipt, 0)
+	prefix := []byte(key.String() + ":")
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltReceiptBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var r Receipt
+			err := ByteDecoder(v, &r)
+			if err != nil {
+				return err
+			}
+			receipts = append(receipts, r)
+		}
+		return nil
+	})
+	return
+}
+
+// boltAddUsage adjusts the number of bytes held on behalf of a source
+func boltAddUsage(tx *bolt.Tx, src []byte, delta int) (err error) {
+	var n int
+	b := tx.Bucket(boltUsageBucket)
+	if v := b.Get(src); v != nil {
+		n, err = strconv.Atoi(string(v))
+		if err != nil {
+			return
+		}
+	}
+	err = b.Put(src, []byte(fmt.Sprintf("%d", n+delta)))
+	return
+}
+
+// boltReleaseUsage removes the bytes of any entry already stored under key from its source's usage
+func boltReleaseUsage(tx *bolt.Tx, key []byte) (err error) {
+	v := tx.Bucket(boltEntryBucket).Get(key)
+	if v == nil {
+		return
+	}
+	src := tx.Bucket(boltSrcBucket).Get(key)
+	if src == nil {
+		return
+	}
+	err = boltAddUsage(tx, src, -len(v))
+	return
+}
+
+// GetUsage returns the number of bytes of entry data held on behalf of a source
+func (ht *BoltHT) GetUsage(src peer.ID) (bytes int, err error) {
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltUsageBucket).Get([]byte(peer.IDB58Encode(src)))
+		if v == nil {
+			return nil
+		}
+		var err error
+		bytes, err = strconv.Atoi(string(v))
+		return err
+	})
+	return
+}
+
+// Usage returns the number of bytes of entry data held on behalf of each source
+func (ht *BoltHT) Usage() (usage map[peer.ID]int, err error) {
+	usage = make(map[peer.ID]int)
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUsageBucket).ForEach(func(k, v []byte) error {
+			id, err := peer.IDB58Decode(string(k))
+			if err != nil {
+				return err
+			}
+			usage[id], err = strconv.Atoi(string(v))
+			return err
+		})
+	})
+	return
+}
@@ -0,0 +1,44 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltHTOpen(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+
+	Convey("It should initialize the data store", t, func() {
+		f := filepath.Join(d, DHTBoltStoreFileName)
+		So(FileExists(f), ShouldBeFalse)
+		ht := &BoltHT{}
+		err := ht.Open(f)
+		So(err, ShouldBeNil)
+		So(FileExists(f), ShouldBeTrue)
+		ht.Close()
+	})
+}
+
+func TestDHTWithBoltHT(t *testing.T) {
+	d, s := setupTestService()
+	defer CleanupTestDir(d)
+	h := setupTestChain("test", 0, s)
+	h.Config.HashTableType = BoltHTType
+	prepareTestChain(h)
+	defer h.Close()
+
+	Convey("the DHT should use the HashTable type from the config", t, func() {
+		_, ok := h.dht.ht.(*BoltHT)
+		So(ok, ShouldBeTrue)
+		So(FileExists(h.DBPath(), DHTBoltStoreFileName), ShouldBeTrue)
+	})
+
+	Convey("genesis entries should be retrievable from the bolt DHT", t, func() {
+		_, et, _, status, err := h.dht.Get(h.agentHash, StatusLive, GetMaskAll)
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, StatusLive)
+		So(et, ShouldEqual, AgentEntryType)
+	})
+}
@@ -0,0 +1,357 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements persisting bundles in progress so they survive a restart, and
+// canceling bundles whose timeout has elapsed
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/holochain/holochain-proto/hash"
+)
+
+const (
+	// BundleFileName is the file in the DB directory that holds the bundles in progress
+	BundleFileName = "bundle.dat"
+
+	DefaultBundleCheckInterval = time.Second
+)
+
+var ErrBundleDiverges = errors.New("saved bundle doesn't continue the chain it was started on")
+
+// bundleRecord is how a bundle in progress is stored in the bundle file
+type bundleRecord struct {
+	UserParam string
+	Started   time.Time
+	Timeout   time.Duration
+	Count     int
+	Pairs     []byte // the bundle's header, entry and header hash for each of its entries
+}
+
+// saveBundles writes the bundles in progress, outermost first, to the bundle
+// file or removes the file if there are none.  Entries are encrypted with the
+// holochain's data cipher just as they are on the chain.  The chain stays
+// locked until the file is written so that the last save always matches the
+// bundles as they are.
+func (h *Holochain) saveBundles() (err error) {
+	h.chain.lk.Lock()
+	defer h.chain.lk.Unlock()
+	path := filepath.Join(h.DBPath(), BundleFileName)
+	var cipher *DataCipher
+	cipher, err = h.DataCipher()
+	if err != nil {
+		return
+	}
+
+	var records []bundleRecord
+	for b := h.chain.bundle; b != nil; b = b.chain.bundle {
+		var r bundleRecord
+		r, err = b.record(cipher)
+		if err != nil {
+			return
+		}
+		records = append(records, r)
+	}
+
+	if len(records) == 0 {
+		err = os.Remove(path)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(records)
+	if err != nil {
+		return
+	}
+	// write to a temporary file first so a crash can't leave a partial bundle file
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf.Bytes(), 0600)
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp, path)
+	return
+}
+
+// record serializes the bundle for the bundle file
+func (b *Bundle) record(cipher *DataCipher) (r bundleRecord, err error) {
+	b.chain.lk.RLock()
+	defer b.chain.lk.RUnlock()
+	r = bundleRecord{
+		UserParam: b.userParam,
+		Started:   b.started,
+		Timeout:   b.timeout,
+		Count:     len(b.chain.Headers),
+	}
+	var buf bytes.Buffer
+	for i, header := range b.chain.Headers {
+		e := b.chain.Entries[i]
+		if cipher != nil {
+			e, err = cipher.SealEntry(e)
+			if err != nil {
+				return
+			}
+		}
+		err = writePair(&buf, header, e)
+		if err != nil {
+			return
+		}
+		err = b.chain.Hashes[i].MarshalHash(&buf)
+		if err != nil {
+			return
+		}
+	}
+	r.Pairs = buf.Bytes()
+	return
+}
+
+// restoreBundles restarts the bundles that were in progress when the holochain
+// was shut down, with their entries waiting to be shared as they were.  A saved
+// bundle that no longer continues the chain, along with any bundles nested in
+// it, can't be committed so the zomes' bundleCanceled routines are called with
+// BundleCancelReasonDiverged and it's discarded.
+func (h *Holochain) restoreBundles() (err error) {
+	var diverged *Bundle
+	diverged, err = h.loadBundles()
+	if err == nil && diverged != nil {
+		if h.bundleCancelOverridden(BundleCancelReasonDiverged) {
+			Infof("bundle %s diverged from the chain so it can't be committed, discarding it", diverged.userParam)
+		}
+		err = h.closeBundle(diverged, false)
+	}
+	return
+}
+
+// loadBundles restarts the bundles in the bundle file.  If one of them
+// diverges from the chain it's restarted without its entries and returned.
+func (h *Holochain) loadBundles() (diverged *Bundle, err error) {
+	if h.chain.BundleStarted() != nil {
+		return
+	}
+	var b []byte
+	b, err = ReadFile(h.DBPath(), BundleFileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	var records []bundleRecord
+	err = gob.NewDecoder(bytes.NewBuffer(b)).Decode(&records)
+	if err != nil {
+		return
+	}
+	var cipher *DataCipher
+	cipher, err = h.DataCipher()
+	if err != nil {
+		return
+	}
+
+	for _, r := range records {
+		parent := h.chain
+		if b := h.chain.BundleStarted(); b != nil {
+			parent = b.chain
+		}
+		var top Hash
+		parent.lk.RLock()
+		top, err = parent.topHash()
+		idx := parent.length() - 1
+		parent.lk.RUnlock()
+		if err != nil {
+			return
+		}
+		bundle := Bundle{
+			idx:       idx,
+			userParam: r.UserParam,
+			chain:     NewChain(h.hashSpec),
+			sharing:   make([]CommittingAction, 0),
+			started:   r.Started,
+			timeout:   r.Timeout,
+		}
+		bundle.chain.bundleOf = parent
+
+		reader := bytes.NewReader(r.Pairs)
+		for i := 0; i < r.Count; i++ {
+			var header *Header
+			var e Entry
+			var hash Hash
+			header, e, err = readPair(ChainMarshalFlagsNone, reader)
+			if err != nil {
+				return
+			}
+			hash, err = UnmarshalHash(reader)
+			if err != nil {
+				return
+			}
+			if cipher != nil {
+				e, err = cipher.OpenEntry(e)
+				if err != nil {
+					return
+				}
+			}
+			if i == 0 && !header.HeaderLink.Equal(top) {
+				Infof("saved bundle %s: %v", r.UserParam, ErrBundleDiverges)
+				diverged = &bundle
+				break
+			}
+			err = bundle.chain.addEntry(i, hash, header, e)
+			if err != nil {
+				return
+			}
+			var a CommittingAction
+			a, err = bundleSharingAction(header, e)
+			if err != nil {
+				return
+			}
+			bundle.sharing = append(bundle.sharing, a)
+		}
+		parent.lk.Lock()
+		parent.bundle = &bundle
+		parent.lk.Unlock()
+		if diverged != nil {
+			break
+		}
+		h.Debugf("restored bundle %s with %d entries", bundle.userParam, r.Count)
+	}
+	return
+}
+
+// bundleSharingAction rebuilds the action that committed an entry to a bundle
+// so that the entry can be shared when the bundle closes
+func bundleSharingAction(header *Header, e Entry) (a CommittingAction, err error) {
+	j, _ := e.Content().(string)
+	switch {
+	case header.Type == DelEntryType:
+		var entry DelEntry
+		entry, err = DelEntryFromJSON(j)
+		if err != nil {
+			return
+		}
+		a = NewDelAction(entry)
+	case header.Type == MigrateEntryType:
+		var entry MigrateEntry
+		entry, err = MigrateEntryFromJSON(j)
+		if err != nil {
+			return
+		}
+		a = &ActionMigrate{entry: entry}
+	case header.Type == CheckpointEntryType:
+		var entry CheckpointEntry
+		entry, err = CheckpointEntryFromJSON(j)
+		if err != nil {
+			return
+		}
+		a = &ActionCheckpoint{entry: entry}
+	case !header.Change.IsNullHash():
+		a = NewModAction(header.Type, e, header.Change)
+	default:
+		a = NewCommitAction(header.Type, e)
+	}
+	a.SetHeader(header)
+	return
+}
+
+// bundleCancelOverridden calls the bundleCanceled routines of all the zomes
+// for the innermost bundle and returns true if one of them asked for the
+// bundle to be committed instead
+func (h *Holochain) bundleCancelOverridden(reason string) (overridden bool) {
+	for _, zome := range h.nucleus.dna.Zomes {
+		r, _, err := h.MakeRibosome(zome.Name)
+		if err != nil {
+			continue
+		}
+		var result string
+		result, err = r.BundleCanceled(reason)
+		if err != nil {
+			Debugf("error in %s.bundleCanceled():%v", zome.Name, err)
+			continue
+		}
+		if result == BundleCancelResponseCommit {
+			Debugf("%s.bundleCanceled() overrode cancel", zome.Name)
+			overridden = true
+			return
+		}
+	}
+	return
+}
+
+// closeBundle closes the given bundle if it's still the innermost one, sharing
+// its entries if it was committed and wasn't nested, and updates the bundle file
+func (h *Holochain) closeBundle(bundle *Bundle, commit bool) (err error) {
+	err = h.Chain().closeBundleIfStarted(bundle, commit)
+	if err == ErrBundleNotStarted {
+		return
+	}
+	var sharing []CommittingAction
+	if err == nil && commit {
+		sharing = bundle.sharing
+	}
+	if e := h.saveBundles(); e != nil && err == nil {
+		err = e
+	}
+
+	// if there wasn't an error closing the bundle share all the commits, unless
+	// the bundle was nested, in which case they were handed on to the enclosing bundle
+	for _, a := range sharing {
+		_, def, err := h.GetEntryDef(a.GetHeader().Type)
+		if err != nil {
+			h.dht.dlog.Logf("Error getting entry def in close bundle:%v", err)
+			err = nil
+		} else {
+			err = a.Share(h, def)
+		}
+	}
+	return
+}
+
+// bundleExpired returns true if the innermost bundle or any of the bundles
+// enclosing it have timed out, must be called with the chain locked
+func (h *Holochain) bundleExpired(now time.Time) bool {
+	for b := h.chain.bundle; b != nil; b = b.chain.bundle {
+		if b.Expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// BundleTimeoutTask cancels the bundles whose timeout has elapsed, innermost
+// first because a bundle can't close while a bundle nested in it is open.  The
+// zomes' bundleCanceled routines are called with BundleCancelReasonTimeout and
+// if any of them asks for it the bundle is committed instead.
+func BundleTimeoutTask(h *Holochain) {
+	if h.chain == nil {
+		return
+	}
+	now := time.Now()
+	for {
+		h.chain.lk.RLock()
+		bundle := h.chain.BundleStarted()
+		expired := bundle != nil && h.bundleExpired(now)
+		h.chain.lk.RUnlock()
+		if !expired {
+			return
+		}
+		// the bundleCanceled routines run without the lock so that they can
+		// use the bundle functions, which means the bundle may be closed before
+		// we get to it, in which case we just check again
+		commit := h.bundleCancelOverridden(BundleCancelReasonTimeout)
+		err := h.closeBundle(bundle, commit)
+		if err != nil && err != ErrBundleNotStarted {
+			h.dht.dlog.Logf("error closing timed out bundle: %v", err)
+			return
+		}
+	}
+}
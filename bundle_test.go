@@ -0,0 +1,174 @@
+package holochain
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/holochain/holochain-proto/hash"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// restartTestChain closes a holochain and loads it again the way a restarted node would
+func restartTestChain(s *Service, h *Holochain) (h2 *Holochain) {
+	h.Close()
+	h2, err := s.Load("test")
+	if err != nil {
+		panic(err)
+	}
+	if err = h2.Prepare(); err != nil {
+		panic(err)
+	}
+	// no need to activate DHT protocols for these tests
+	h2.Config.PeerModeDHTNode = false
+	return
+}
+
+func TestBundlePersistence(t *testing.T) {
+	d, s, h := PrepareTestChain("test")
+	defer func() { CleanupTestChain(h, d) }()
+
+	l := h.chain.Length()
+	var outerHash, innerHash Hash
+	Convey("bundles in progress should be saved to the bundle file", t, func() {
+		So(FileExists(h.DBPath(), BundleFileName), ShouldBeFalse)
+		_, err := NewStartBundleAction(60000, "outer").Call(h)
+		So(err, ShouldBeNil)
+		So(FileExists(h.DBPath(), BundleFileName), ShouldBeTrue)
+		outerHash = commit(h, "oddNumbers", "11")
+		_, err = NewStartBundleAction(60000, "inner").Call(h)
+		So(err, ShouldBeNil)
+		innerHash = commit(h, "oddNumbers", "13")
+	})
+
+	Convey("a restarted holochain should restore its bundles when it's activated", t, func() {
+		h = restartTestChain(s, h)
+		So(h.chain.BundleStarted(), ShouldBeNil)
+		So(h.Activate(), ShouldBeNil)
+		So(h.chain.BundleDepth(), ShouldEqual, 2)
+
+		outer := h.chain.bundle
+		So(outer.userParam, ShouldEqual, `"outer"`)
+		So(outer.idx, ShouldEqual, l-1)
+		So(outer.timeout, ShouldEqual, 60*time.Second)
+		So(outer.chain.Length(), ShouldEqual, 1)
+		So(outer.sharing[0].GetHeader().EntryLink.String(), ShouldEqual, outerHash.String())
+
+		inner := h.chain.BundleStarted()
+		So(inner.userParam, ShouldEqual, `"inner"`)
+		So(inner.chain.Length(), ShouldEqual, 1)
+		So(inner.chain.Headers[0].HeaderLink.String(), ShouldEqual, outer.chain.Hashes[0].String())
+		So(len(inner.sharing), ShouldEqual, 1)
+		So(inner.sharing[0].EntryType(), ShouldEqual, "oddNumbers")
+
+		results, err := h.Query(&QueryOptions{Bundle: true})
+		So(err, ShouldBeNil)
+		So(results[0].Entry.Content(), ShouldEqual, "13")
+	})
+
+	Convey("closing the restored bundles should commit and share their entries and remove the bundle file", t, func() {
+		_, err := (&APIFnCloseBundle{commit: true}).Call(h)
+		So(err, ShouldBeNil)
+		So(FileExists(h.DBPath(), BundleFileName), ShouldBeTrue)
+		_, err = (&APIFnCloseBundle{commit: true}).Call(h)
+		So(err, ShouldBeNil)
+		So(h.chain.BundleStarted(), ShouldBeNil)
+		So(h.chain.Length(), ShouldEqual, l+2)
+		So(h.chain.Verify(ChainVerificationFull), ShouldBeNil)
+		So(FileExists(h.DBPath(), BundleFileName), ShouldBeFalse)
+		for _, hash := range []Hash{outerHash, innerHash} {
+			_, _, _, _, err = h.dht.Get(hash, StatusDefault, GetMaskDefault)
+			So(err, ShouldBeNil)
+		}
+	})
+}
+
+func TestBundleTimeout(t *testing.T) {
+	d, s, h := PrepareTestChain("test")
+	defer func() { CleanupTestChain(h, d) }()
+
+	Convey("a bundle should be canceled when its timeout elapses", t, func() {
+		l := h.chain.Length()
+		_, err := NewStartBundleAction(1, "debugit").Call(h)
+		So(err, ShouldBeNil)
+		commit(h, "oddNumbers", "7")
+		bundle := h.chain.BundleStarted()
+		time.Sleep(time.Millisecond * 5)
+		ShouldLog(h.nucleus.alog, func() {
+			BundleTimeoutTask(h)
+		}, `debug message during bundleCanceled with reason: timeout`)
+		So(h.chain.BundleStarted(), ShouldBeNil)
+		So(h.chain.Length(), ShouldEqual, l)
+		So(FileExists(h.DBPath(), BundleFileName), ShouldBeFalse)
+
+		// a bundle that was closed under a commit or close can't be used again
+		So(bundle.closed, ShouldBeTrue)
+		So(h.closeBundle(bundle, true), ShouldEqual, ErrBundleNotStarted)
+	})
+
+	Convey("a bundle should not be canceled before its timeout elapses", t, func() {
+		_, err := NewStartBundleAction(60000, "myBundle").Call(h)
+		So(err, ShouldBeNil)
+		BundleTimeoutTask(h)
+		So(h.chain.BundleStarted(), ShouldNotBeNil)
+		_, err = (&APIFnCloseBundle{commit: true}).Call(h)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("a timed out bundle should be committed if bundleCanceled asks for it", t, func() {
+		l := h.chain.Length()
+		_, err := NewStartBundleAction(1, "cancelit").Call(h)
+		So(err, ShouldBeNil)
+		commit(h, "oddNumbers", "9")
+		time.Sleep(time.Millisecond * 5)
+		BundleTimeoutTask(h)
+		So(h.chain.BundleStarted(), ShouldBeNil)
+		So(h.chain.Length(), ShouldEqual, l+1)
+	})
+
+	Convey("an outer bundle timing out should also cancel the bundles nested in it", t, func() {
+		l := h.chain.Length()
+		_, err := NewStartBundleAction(1, "outer").Call(h)
+		So(err, ShouldBeNil)
+		commit(h, "oddNumbers", "11")
+		_, err = NewStartBundleAction(60000, "debugit").Call(h)
+		So(err, ShouldBeNil)
+		time.Sleep(time.Millisecond * 5)
+		ShouldLog(h.nucleus.alog, func() {
+			BundleTimeoutTask(h)
+		}, `debug message during bundleCanceled with reason: timeout`)
+		So(h.chain.BundleStarted(), ShouldBeNil)
+		So(h.chain.Length(), ShouldEqual, l)
+	})
+
+	Convey("a bundle that timed out while the holochain was down should be canceled when it's activated", t, func() {
+		l := h.chain.Length()
+		_, err := NewStartBundleAction(50, "debugit").Call(h)
+		So(err, ShouldBeNil)
+		commit(h, "oddNumbers", "13")
+		h = restartTestChain(s, h)
+		time.Sleep(time.Millisecond * 60)
+		ShouldLog(h.nucleus.alog, func() {
+			So(h.Activate(), ShouldBeNil)
+		}, `debug message during bundleCanceled with reason: timeout`)
+		So(h.chain.BundleStarted(), ShouldBeNil)
+		So(h.chain.Length(), ShouldEqual, l)
+		So(FileExists(h.DBPath(), BundleFileName), ShouldBeFalse)
+	})
+
+	Convey("a saved bundle that no longer continues the chain should be canceled and discarded when it's activated", t, func() {
+		_, err := NewStartBundleAction(60000, "debugit").Call(h)
+		So(err, ShouldBeNil)
+		commit(h, "oddNumbers", "15")
+		h = restartTestChain(s, h)
+
+		// the chain moves on before the bundle is restored
+		commit(h, "oddNumbers", "17")
+		l := h.chain.Length()
+		ShouldLog(h.nucleus.alog, func() {
+			So(h.Activate(), ShouldBeNil)
+		}, `debug message during bundleCanceled with reason: diverged`)
+		So(h.chain.BundleStarted(), ShouldBeNil)
+		So(h.chain.Length(), ShouldEqual, l)
+		So(FileExists(h.DBPath(), BundleFileName), ShouldBeFalse)
+	})
+}
@@ -10,18 +10,35 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	. "github.com/holochain/holochain-proto/hash"
 	peer "github.com/libp2p/go-libp2p-peer"
 	"github.com/tidwall/buntdb"
 )
 
+const (
+	BuntHTType = "bunt"
+)
+
 type BuntHT struct {
 	db *buntdb.DB
 }
 
+// NewBuntHT creates a BuntHT stored in the given directory
+func NewBuntHT(dbPath string) (ht HashTable, err error) {
+	b := &BuntHT{}
+	err = b.Open(filepath.Join(dbPath, DHTStoreFileName))
+	if err == nil {
+		ht = b
+	}
+	return
+}
+
 // linkEvent represents the value stored in buntDB associated with a
 // link key for one source having stored one LinkingEntry
 // (The Link struct defined in entry.go is encoded in the key used for buntDB)
@@ -29,6 +46,18 @@ type linkEvent struct {
 	Status     int
 	Source     string
 	LinksEntry string
+	Time       time.Time // time in the header of the linking entry
+	EntryType  string    `json:",omitempty"` // entry type of the linked entry, empty if unknown
+}
+
+// appendLinkEvent adds a linking event to the records of a link, carrying the
+// linked entry type forward from the previous event when it isn't given, as
+// happens when a link is deleted
+func appendLinkEvent(records []linkEvent, status int, source string, linksEntry string, entryType string, t time.Time) []linkEvent {
+	if entryType == "" && len(records) > 0 {
+		entryType = records[len(records)-1].EntryType
+	}
+	return append(records, linkEvent{status, source, linksEntry, t, entryType})
 }
 
 func (ht *BuntHT) Open(options interface{}) (err error) {
@@ -42,6 +71,8 @@ func (ht *BuntHT) Open(options interface{}) (err error) {
 	db.CreateIndex("peer", "peer:*", buntdb.IndexString)
 	db.CreateIndex("list", "list:*", buntdb.IndexString)
 	db.CreateIndex("entry", "entry:*", buntdb.IndexString)
+	db.CreateIndex("receipt", "receipt:*", buntdb.IndexString)
+	db.CreateIndex("usage", "usage:*", buntdb.IndexString)
 
 	ht.db = db
 	return
@@ -56,6 +87,10 @@ func (ht *BuntHT) Put(m *Message, entryType string, key Hash, src peer.ID, value
 		if err != nil {
 			return err
 		}
+		err = _releaseUsage(tx, k)
+		if err != nil {
+			return err
+		}
 		_, _, err = tx.Set("entry:"+k, string(value), nil)
 		if err != nil {
 			return err
@@ -64,7 +99,12 @@ func (ht *BuntHT) Put(m *Message, entryType string, key Hash, src peer.ID, value
 		if err != nil {
 			return err
 		}
-		_, _, err = tx.Set("src:"+k, peer.IDB58Encode(src), nil)
+		s := peer.IDB58Encode(src)
+		_, _, err = tx.Set("src:"+k, s, nil)
+		if err != nil {
+			return err
+		}
+		err = _addUsage(tx, s, len(value))
 		if err != nil {
 			return err
 		}
@@ -112,13 +152,13 @@ func _setStatus(tx *buntdb.Tx, m *Message, key string, status int) (err error) {
 
 // Mod moves the given hash to the StatusModified status
 // N.B. this functions assumes that the validity of this action has been confirmed
-func (ht *BuntHT) Mod(m *Message, key Hash, newkey Hash) (err error) {
+func (ht *BuntHT) Mod(m *Message, key Hash, newkey Hash, t time.Time) (err error) {
 	k := key.String()
 	err = ht.db.Update(func(tx *buntdb.Tx) error {
 		err = _setStatus(tx, m, k, StatusModified)
 		if err == nil {
 			link := newkey.String()
-			err = _link(tx, k, link, SysTagReplacedBy, m.From, StatusLive, newkey)
+			err = _link(tx, k, link, SysTagReplacedBy, m.From, StatusLive, newkey, "", t)
 			if err == nil {
 				_, _, err = tx.Set("replacedBy:"+k, link, nil)
 				if err != nil {
@@ -246,7 +286,7 @@ func (ht *BuntHT) Get(key Hash, statusMask int, getMask int) (data []byte, entry
 
 // _link is a low level routine to add a link, also used by delLink
 // this ensure monotonic recording of linking attempts
-func _link(tx *buntdb.Tx, base string, link string, tag string, src peer.ID, status int, linkingEntryHash Hash) (err error) {
+func _link(tx *buntdb.Tx, base string, link string, tag string, src peer.ID, status int, linkingEntryHash Hash, entryType string, t time.Time) (err error) {
 	key := "link:" + base + ":" + link + ":" + tag
 	var val string
 	val, err = tx.Get(key)
@@ -283,7 +323,7 @@ func _link(tx *buntdb.Tx, base string, link string, tag string, src peer.ID, sta
 	} else {
 		return
 	}
-	records = append(records, linkEvent{status, source, lehStr})
+	records = appendLinkEvent(records, status, source, lehStr, entryType, t)
 	var b []byte
 	b, err = json.Marshal(records)
 	if err != nil {
@@ -296,13 +336,13 @@ func _link(tx *buntdb.Tx, base string, link string, tag string, src peer.ID, sta
 	return
 }
 
-func (ht *BuntHT) link(m *Message, base string, link string, tag string, status int) (err error) {
+func (ht *BuntHT) link(m *Message, base string, link string, tag string, status int, entryType string, t time.Time) (err error) {
 	err = ht.db.Update(func(tx *buntdb.Tx) error {
 		_, err := _get(tx, base, StatusLive)
 		if err != nil {
 			return err
 		}
-		err = _link(tx, base, link, tag, m.From, status, m.Body.(HoldReq).EntryHash)
+		err = _link(tx, base, link, tag, m.From, status, m.Body.(HoldReq).EntryHash, entryType, t)
 		if err != nil {
 			return err
 		}
@@ -321,15 +361,57 @@ func (ht *BuntHT) link(m *Message, base string, link string, tag string, status
 // PutLink associates a link with a stored hash
 // N.B. this function assumes that the data associated has been properly retrieved
 // and validated from the cource chain
-func (ht *BuntHT) PutLink(m *Message, base string, link string, tag string) (err error) {
-	err = ht.link(m, base, link, tag, StatusLive)
+func (ht *BuntHT) PutLink(m *Message, base string, link string, tag string, entryType string, t time.Time) (err error) {
+	err = ht.link(m, base, link, tag, StatusLive, entryType, t)
 	return
 }
 
 // DelLink removes a link and tag associated with a stored hash
 // N.B. this function assumes that the action has been properly validated
-func (ht *BuntHT) DelLink(m *Message, base string, link string, tag string) (err error) {
-	err = ht.link(m, base, link, tag, StatusDeleted)
+func (ht *BuntHT) DelLink(m *Message, base string, link string, tag string, t time.Time) (err error) {
+	err = ht.link(m, base, link, tag, StatusDeleted, "", t)
+	return
+}
+
+// Forget removes all the data stored for a hash, including the links on it
+func (ht *BuntHT) Forget(key Hash) (err error) {
+	k := key.String()
+	err = ht.db.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Get("entry:" + k)
+		if err != nil {
+			if err == buntdb.ErrNotFound {
+				err = ErrHashNotFound
+			}
+			return err
+		}
+		err = _releaseUsage(tx, k)
+		if err != nil {
+			return err
+		}
+		// keys can't be deleted while iterating so collect them first
+		keys := []string{"entry:" + k, "type:" + k, "src:" + k, "status:" + k, "replacedBy:" + k}
+		err = tx.AscendKeys("link:"+k+":*", func(key, value string) bool {
+			keys = append(keys, key)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.AscendKeys("headerOf:"+k+":*", func(key, value string) bool {
+			keys = append(keys, key, "header:"+strings.TrimPrefix(key, "headerOf:"+k+":"))
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			_, err = tx.Delete(key)
+			if err != nil && err != buntdb.ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
 	return
 }
 
@@ -359,7 +441,7 @@ func (ht *BuntHT) GetLinks(base Hash, tag string, statusMask int) (results []Tag
 				if l > 0 {
 					entry := records[l-1]
 					if err == nil && (entry.Status&statusMask) > 0 {
-						th := TaggedHash{H: string(x[2]), Source: entry.Source}
+						th := TaggedHash{H: string(x[2]), Source: entry.Source, Time: entry.Time, linkedType: entry.EntryType}
 						if tag == "" {
 							th.T = t
 						}
@@ -480,25 +562,7 @@ func (ht *BuntHT) GetIdxMessage(idx int) (msg Message, err error) {
 
 // DumpIdx converts message and data of a DHT change request to a string for human consumption
 func (ht *BuntHT) dumpIdx(idx int) (str string, err error) {
-	var msg Message
-	msg, err = ht.GetIdxMessage(idx)
-	if err != nil {
-		return
-	}
-	f, _ := msg.Fingerprint()
-	str = fmt.Sprintf("MSG (fingerprint %v):\n   %v\n", f, msg)
-	switch msg.Type {
-	case PUT_REQUEST:
-		key := msg.Body.(HoldReq).EntryHash
-		entry, entryType, _, _, e := ht.Get(key, StatusDefault, GetMaskAll)
-		if e != nil {
-			err = fmt.Errorf("couldn't get %v err:%v ", key, e)
-			return
-		} else {
-			str += fmt.Sprintf("DATA: type:%s entry: %v\n", entryType, entry)
-		}
-	}
-	return
+	return dumpHashTableIdx(ht, idx)
 }
 
 func statusValueToString(val string) string {
@@ -563,38 +627,7 @@ func (ht *BuntHT) String() (result string) {
 
 // DumpIdxJSON converts message and data of a DHT change request to a JSON string representation.
 func (ht *BuntHT) dumpIdxJSON(idx int) (str string, err error) {
-	var msg Message
-	var buffer bytes.Buffer
-	var msgField, dataField string
-	msg, err = ht.GetIdxMessage(idx)
-
-	if err != nil {
-		return "", err
-	}
-
-	f, _ := msg.Fingerprint()
-	buffer.WriteString(fmt.Sprintf("{ \"index\": %d,", idx))
-	msgField = fmt.Sprintf("\"message\": { \"fingerprint\": \"%v\", \"content\": \"%v\" },", f, msg)
-
-	switch msg.Type {
-	case PUT_REQUEST:
-		key := msg.Body.(HoldReq).EntryHash
-		entry, entryType, _, _, e := ht.Get(key, StatusAny, GetMaskAll)
-		if e != nil {
-			err = fmt.Errorf("couldn't get %v err:%v ", key, e)
-			return
-		}
-		dataField = fmt.Sprintf("\"data\": { \"type\": \"%s\", \"entry\": \"%v\" }", entryType, entry)
-	}
-
-	if len(dataField) > 0 {
-		buffer.WriteString(msgField)
-		buffer.WriteString(dataField)
-	} else {
-		buffer.WriteString(strings.TrimSuffix(msgField, ","))
-	}
-	buffer.WriteString("}")
-	return PrettyPrintJSON(buffer.Bytes())
+	return dumpHashTableIdxJSON(ht, idx)
 }
 
 // JSON converts the table into a JSON string representation.
@@ -677,3 +710,337 @@ func (ht *BuntHT) Iterate(fn HashTableIterateFn) {
 		return err
 	})
 }
+
+// GetFingerprint returns the index that of the message that made a change or -1 if we don't have it
+func (ht *BuntHT) GetFingerprint(f Hash) (index int, err error) {
+	index = -1
+	err = ht.db.View(func(tx *buntdb.Tx) error {
+		idxStr, e := tx.Get("f:" + f.String())
+		if e == buntdb.ErrNotFound {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		index, e = strconv.Atoi(idxStr)
+		if e != nil {
+			return e
+		}
+		return nil
+	})
+	return
+}
+
+// GetPuts returns a list of puts after the given index
+func (ht *BuntHT) GetPuts(since int) (puts []Put, err error) {
+	puts = make([]Put, 0)
+	err = ht.db.View(func(tx *buntdb.Tx) error {
+		err = tx.AscendGreaterOrEqual("idx", string(since), func(key, value string) bool {
+			x := strings.Split(key, ":")
+			idx, _ := strconv.Atoi(x[1])
+			if idx >= since {
+				p := Put{Idx: idx}
+				if value != "" {
+					err := ByteDecoder([]byte(value), &p.M)
+					if err != nil {
+						return false
+					}
+				}
+				puts = append(puts, p)
+			}
+			return true
+		})
+		sort.Slice(puts, func(i, j int) bool { return puts[i].Idx < puts[j].Idx })
+		return err
+	})
+	return
+}
+
+// GetGossiper loads returns last known index of the gossiper
+func (ht *BuntHT) GetGossiper(id peer.ID) (idx int, err error) {
+	key := "peer:" + peer.IDB58Encode(id)
+	err = ht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		idx, e = getIntVal(key, tx)
+		if e != nil {
+			return e
+		}
+		return nil
+	})
+	return
+}
+
+// GetGossipers returns all the gossipers with their last known index
+func (ht *BuntHT) GetGossipers() (gossipers []GossiperData, err error) {
+	err = ht.db.View(func(tx *buntdb.Tx) error {
+		err = tx.Ascend("peer", func(key, value string) bool {
+			x := strings.Split(key, ":")
+			id, e := peer.IDB58Decode(x[1])
+			if e != nil {
+				return false
+			}
+			idx, _ := strconv.Atoi(value)
+			gossipers = append(gossipers, GossiperData{ID: id, PutIdx: idx})
+			return true
+		})
+		return nil
+	})
+	return
+}
+
+// UpdateGossiper updates a gossiper's index if it's greater than the stored one
+func (ht *BuntHT) UpdateGossiper(id peer.ID, newIdx int) (err error) {
+	err = ht.db.Update(func(tx *buntdb.Tx) error {
+		key := "peer:" + peer.IDB58Encode(id)
+		idx, e := getIntVal(key, tx)
+		if e != nil {
+			return e
+		}
+		if newIdx < idx {
+			return nil
+		}
+		sidx := fmt.Sprintf("%d", newIdx)
+		_, _, err = tx.Set(key, sidx, nil)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	return
+}
+
+// DeleteGossiper removes a gossiper from the database
+func (ht *BuntHT) DeleteGossiper(id peer.ID) (err error) {
+	err = ht.db.Update(func(tx *buntdb.Tx) error {
+		key := "peer:" + peer.IDB58Encode(id)
+		_, e := tx.Delete(key)
+		return e
+	})
+	return
+}
+
+// GetReputation returns the reputation of a peer
+func (ht *BuntHT) GetReputation(id peer.ID) (rep Reputation, err error) {
+	err = ht.db.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get("reputation:" + peer.IDB58Encode(id))
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal([]byte(val), &rep)
+	})
+	return
+}
+
+// UpdateReputation adds the counts in delta to the reputation of a peer
+func (ht *BuntHT) UpdateReputation(id peer.ID, delta Reputation) (err error) {
+	err = ht.db.Update(func(tx *buntdb.Tx) error {
+		key := "reputation:" + peer.IDB58Encode(id)
+		var rep Reputation
+		val, err := tx.Get(key)
+		if err == nil {
+			err = json.Unmarshal([]byte(val), &rep)
+		} else if err == buntdb.ErrNotFound {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		var b []byte
+		b, err = json.Marshal(rep.update(delta, time.Now()))
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(key, string(b), nil)
+		return err
+	})
+	return
+}
+
+// PutHeaderProof stores the proof of one of an agent's headers under the header it follows
+func (ht *BuntHT) PutHeaderProof(agent peer.ID, proof ChainProof) (seen *ChainProof, err error) {
+	err = ht.db.Update(func(tx *buntdb.Tx) error {
+		key := "header:" + peer.IDB58Encode(agent) + ":" + proof.Header.HeaderLink.String()
+		val, err := tx.Get(key)
+		if err == nil {
+			var p ChainProof
+			err = ByteDecoder([]byte(val), &p)
+			if err == nil {
+				seen = &p
+			}
+			return err
+		}
+		if err != buntdb.ErrNotFound {
+			return err
+		}
+		var b []byte
+		b, err = ByteEncoder(&proof)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(key, string(b), nil)
+		if err != nil {
+			return err
+		}
+		// index the proof by the header's entry so that Forget can remove it
+		_, _, err = tx.Set("headerOf:"+proof.Header.EntryLink.String()+":"+strings.TrimPrefix(key, "header:"), "", nil)
+		return err
+	})
+	return
+}
+
+// GetList returns the peer list of the given type
+func (ht *BuntHT) GetList(listType PeerListType) (result PeerList, err error) {
+	result.Type = listType
+	result.Records = make([]PeerRecord, 0)
+	err = ht.db.View(func(tx *buntdb.Tx) error {
+		err = tx.Ascend("list", func(key, value string) bool {
+			x := strings.Split(key, ":")
+
+			if x[1] == string(listType) {
+				pid, e := peer.IDB58Decode(x[2])
+				if e != nil {
+					return false
+				}
+				r := PeerRecord{ID: pid, Warrant: value}
+				result.Records = append(result.Records, r)
+			}
+			return true
+		})
+		return nil
+	})
+	return
+}
+
+// AddToList adds the peers to a list
+func (ht *BuntHT) AddToList(m *Message, list PeerList) (err error) {
+	err = ht.db.Update(func(tx *buntdb.Tx) error {
+		_, err = incIdx(tx, m)
+		if err != nil {
+			return err
+		}
+		for _, r := range list.Records {
+			k := peer.IDB58Encode(r.ID)
+			_, _, err = tx.Set("list:"+string(list.Type)+":"+k, r.Warrant, nil)
+			if err != nil {
+				return err
+			}
+		}
+		return err
+	})
+	return
+}
+
+// PutReceipt stores a receipt returned by a node for a change to a hash
+func (ht *BuntHT) PutReceipt(key Hash, receipt Receipt) (err error) {
+	var f Hash
+	f, err = receipt.Msg.Fingerprint()
+	if err != nil {
+		return
+	}
+	var b []byte
+	b, err = ByteEncoder(&receipt)
+	if err != nil {
+		return
+	}
+	err = ht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set("receipt:"+key.String()+":"+peer.IDB58Encode(receipt.Peer)+":"+f.String(), string(b), nil)
+		return err
+	})
+	return
+}
+
+// GetReceipts returns a list of receipts that were generated regarding a hash
+func (ht *BuntHT) GetReceipts(key Hash) (receipts []Receipt, err error) {
+	receipts = make([]Receipt, 0)
+	err = ht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		err := tx.AscendKeys("receipt:"+key.String()+":*", func(key, value string) bool {
+			var r Receipt
+			e = ByteDecoder([]byte(value), &r)
+			if e != nil {
+				return false
+			}
+			receipts = append(receipts, r)
+			return true
+		})
+		if err == nil {
+			err = e
+		}
+		return err
+	})
+	return
+}
+
+// _addUsage adjusts the number of bytes held on behalf of a source
+func _addUsage(tx *buntdb.Tx, src string, delta int) (err error) {
+	var n int
+	val, err := tx.Get("usage:" + src)
+	if err == nil {
+		n, err = strconv.Atoi(val)
+		if err != nil {
+			return
+		}
+	} else if err != buntdb.ErrNotFound {
+		return
+	}
+	_, _, err = tx.Set("usage:"+src, fmt.Sprintf("%d", n+delta), nil)
+	return
+}
+
+// _releaseUsage removes the bytes of any entry already stored under key from its source's usage
+func _releaseUsage(tx *buntdb.Tx, key string) (err error) {
+	val, err := tx.Get("entry:" + key)
+	if err != nil {
+		if err == buntdb.ErrNotFound {
+			err = nil
+		}
+		return
+	}
+	src, err := tx.Get("src:" + key)
+	if err != nil {
+		return
+	}
+	err = _addUsage(tx, src, -len(val))
+	return
+}
+
+// GetUsage returns the number of bytes of entry data held on behalf of a source
+func (ht *BuntHT) GetUsage(src peer.ID) (bytes int, err error) {
+	err = ht.db.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get("usage:" + peer.IDB58Encode(src))
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		bytes, err = strconv.Atoi(val)
+		return err
+	})
+	return
+}
+
+// Usage returns the number of bytes of entry data held on behalf of each source
+func (ht *BuntHT) Usage() (usage map[peer.ID]int, err error) {
+	usage = make(map[peer.ID]int)
+	err = ht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		err := tx.Ascend("usage", func(key, value string) bool {
+			var id peer.ID
+			id, e = peer.IDB58Decode(strings.TrimPrefix(key, "usage:"))
+			if e != nil {
+				return false
+			}
+			usage[id], e = strconv.Atoi(value)
+			return e == nil
+		})
+		if err == nil {
+			err = e
+		}
+		return err
+	})
+	return
+}
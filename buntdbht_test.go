@@ -1,12 +1,14 @@
 package holochain
 
 import (
+	"encoding/json"
 	"fmt"
 	. "github.com/holochain/holochain-proto/hash"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/tidwall/buntdb"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestBuntHTOpen(t *testing.T) {
@@ -73,7 +75,7 @@ func TestBuntHTPutGetModDel(t *testing.T) {
 
 		m := node.NewMessage(MOD_REQUEST, HoldReq{RelatedHash: hash, EntryHash: newhash})
 
-		err := ht.Mod(m, hash, newhash)
+		err := ht.Mod(m, hash, newhash, time.Now())
 		So(err, ShouldBeNil)
 		data, entryType, _, status, err := ht.Get(hash, StatusAny, GetMaskAll)
 		So(err, ShouldBeNil)
@@ -151,7 +153,7 @@ func TestBuntHTLinking(t *testing.T) {
 	linkHash2Str := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2"
 	//linkHash2, _ := NewHash(linkHash2Str)
 	Convey("It should fail if hash doesn't exist", t, func() {
-		err := ht.PutLink(nil, baseStr, linkHash1Str, "tag foo")
+		err := ht.PutLink(nil, baseStr, linkHash1Str, "tag foo", "", time.Now())
 		So(err, ShouldEqual, ErrHashNotFound)
 
 		v, err := ht.GetLinks(base, "tag foo", StatusLive)
@@ -166,24 +168,26 @@ func TestBuntHTLinking(t *testing.T) {
 
 	// the message doesn't actually matter for this test because it only gets used later in gossiping
 	fakeMsg := node.NewMessage(LINK_REQUEST, HoldReq{RelatedHash: linkHash1, EntryHash: linkingEntryHash})
+	linkTime := fakeMsg.Time.Add(-time.Hour)
+	msgTime, _ := json.Marshal(linkTime)
 
 	Convey("Low level should add linking events to buntdb", t, func() {
-		err := ht.link(fakeMsg, baseStr, linkHash1Str, "link test", StatusLive)
+		err := ht.link(fakeMsg, baseStr, linkHash1Str, "link test", StatusLive, "someType", linkTime)
 		So(err, ShouldBeNil)
 		err = ht.db.View(func(tx *buntdb.Tx) error {
 			err = tx.Ascend("link", func(key, value string) bool {
 				So(key, ShouldEqual, fmt.Sprintf(`link:%s:%s:link test`, baseStr, linkHash1Str))
-				So(value, ShouldEqual, fmt.Sprintf(`[{"Status":%d,"Source":"%s","LinksEntry":"%s"}]`, StatusLive, id.Pretty(), linkingEntryHashStr))
+				So(value, ShouldEqual, fmt.Sprintf(`[{"Status":%d,"Source":"%s","LinksEntry":"%s","Time":%s,"EntryType":"someType"}]`, StatusLive, id.Pretty(), linkingEntryHashStr, msgTime))
 				return true
 			})
 			return nil
 		})
 
-		err = ht.link(fakeMsg, baseStr, linkHash1Str, "link test", StatusDeleted)
+		err = ht.link(fakeMsg, baseStr, linkHash1Str, "link test", StatusDeleted, "", linkTime)
 		So(err, ShouldBeNil)
 		err = ht.db.View(func(tx *buntdb.Tx) error {
 			err = tx.Ascend("link", func(key, value string) bool {
-				So(value, ShouldEqual, fmt.Sprintf(`[{"Status":%d,"Source":"%s","LinksEntry":"%s"},{"Status":%d,"Source":"%s","LinksEntry":"%s"}]`, StatusLive, id.Pretty(), linkingEntryHashStr, StatusDeleted, id.Pretty(), linkingEntryHashStr))
+				So(value, ShouldEqual, fmt.Sprintf(`[{"Status":%d,"Source":"%s","LinksEntry":"%s","Time":%s,"EntryType":"someType"},{"Status":%d,"Source":"%s","LinksEntry":"%s","Time":%s,"EntryType":"someType"}]`, StatusLive, id.Pretty(), linkingEntryHashStr, msgTime, StatusDeleted, id.Pretty(), linkingEntryHashStr, msgTime))
 				return true
 			})
 			return nil
@@ -195,13 +199,13 @@ func TestBuntHTLinking(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(len(data), ShouldEqual, 0)
 
-		err = ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag foo")
+		err = ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag foo", "", time.Now())
 		So(err, ShouldBeNil)
 
-		err = ht.PutLink(fakeMsg, baseStr, linkHash2Str, "tag foo")
+		err = ht.PutLink(fakeMsg, baseStr, linkHash2Str, "tag foo", "", time.Now())
 		So(err, ShouldBeNil)
 
-		err = ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag bar")
+		err = ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag bar", "", time.Now())
 		So(err, ShouldBeNil)
 
 		data, err = ht.GetLinks(base, "tag foo", StatusLive)
@@ -220,7 +224,7 @@ func TestBuntHTLinking(t *testing.T) {
 	})
 
 	Convey("It should store and retrieve a links source", t, func() {
-		err = ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag source")
+		err = ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag source", "", time.Now())
 		So(err, ShouldBeNil)
 
 		data, err := ht.GetLinks(base, "tag source", StatusLive)
@@ -234,35 +238,35 @@ func TestBuntHTLinking(t *testing.T) {
 	})
 
 	Convey("It should work to put a link a second time", t, func() {
-		err = ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag foo")
+		err = ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag foo", "", time.Now())
 		So(err, ShouldBeNil)
 	})
 
 	Convey("It should fail delete links non existent links bases and tags", t, func() {
 		badHashStr := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqhX"
 
-		err := ht.DelLink(fakeMsg, badHashStr, linkHash1Str, "tag foo")
+		err := ht.DelLink(fakeMsg, badHashStr, linkHash1Str, "tag foo", time.Now())
 		So(err, ShouldEqual, ErrHashNotFound)
-		err = ht.DelLink(fakeMsg, baseStr, badHashStr, "tag foo")
+		err = ht.DelLink(fakeMsg, baseStr, badHashStr, "tag foo", time.Now())
 		So(err, ShouldEqual, ErrLinkNotFound)
-		err = ht.DelLink(fakeMsg, baseStr, linkHash1Str, "tag baz")
+		err = ht.DelLink(fakeMsg, baseStr, linkHash1Str, "tag baz", time.Now())
 		So(err, ShouldEqual, ErrLinkNotFound)
 	})
 
 	Convey("It should delete links", t, func() {
-		err := ht.DelLink(fakeMsg, baseStr, linkHash1Str, "tag bar")
+		err := ht.DelLink(fakeMsg, baseStr, linkHash1Str, "tag bar", time.Now())
 		So(err, ShouldBeNil)
 		data, err := ht.GetLinks(base, "tag bar", StatusLive)
 		So(err, ShouldBeNil)
 		So(len(data), ShouldEqual, 0)
 
-		err = ht.DelLink(fakeMsg, baseStr, linkHash1Str, "tag foo")
+		err = ht.DelLink(fakeMsg, baseStr, linkHash1Str, "tag foo", time.Now())
 		So(err, ShouldBeNil)
 		data, err = ht.GetLinks(base, "tag foo", StatusLive)
 		So(err, ShouldBeNil)
 		So(len(data), ShouldEqual, 1)
 
-		err = ht.DelLink(fakeMsg, baseStr, linkHash2Str, "tag foo")
+		err = ht.DelLink(fakeMsg, baseStr, linkHash2Str, "tag foo", time.Now())
 		So(err, ShouldBeNil)
 		data, err = ht.GetLinks(base, "tag foo", StatusLive)
 		So(err, ShouldBeNil)
@@ -0,0 +1,846 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements the subset of CBOR (RFC 7049) needed to encode messages in a
+// format that isn't tied to go:
+//  - structs are maps keyed by field name, and unknown keys are skipped when decoding
+//  - hashes and peer IDs are byte strings, times are RFC 3339 strings (tag 0)
+//  - values of registered body types in interface{} fields are tagged with
+//    their registered name as generic objects (tag 27)
+// CBORSchema describes the resulting format for implementations in other languages.
+// Decoding is bounded by cborMaxMessageSize and cborMaxDepth, and allocations
+// only grow with the data actually read, as the input comes from peers.
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+const (
+	cborUint byte = iota
+	cborNegInt
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+const (
+	cborFalse     = 20
+	cborTrue      = 21
+	cborNull      = 22
+	cborUndefined = 23
+	cborFloat16   = 25
+	cborFloat32   = 26
+	cborFloat64   = 27
+
+	cborTagDateTime      = 0
+	cborTagGenericObject = 27
+
+	// cborMaxLength limits the length of strings and arrays we will decode
+	cborMaxLength = 1 << 26
+
+	// cborMaxMessageSize limits the number of bytes read to decode a message
+	cborMaxMessageSize = 1 << 26
+
+	// cborMaxDepth limits how deeply items may be nested
+	cborMaxDepth = 128
+
+	// cborMaxItems limits the number of items decoded from a message, as an
+	// item of one byte can still take many bytes to hold once decoded
+	cborMaxItems = 1 << 20
+
+	// cborMaxPrealloc limits the number of array elements allocated before
+	// they have been read
+	cborMaxPrealloc = 1024
+)
+
+var ErrCBORUnsupported = errors.New("cbor: unsupported item")
+var ErrCBORMessageTooLarge = errors.New("cbor: message too large")
+var ErrCBORTooDeep = errors.New("cbor: items nested too deeply")
+var ErrCBORTooManyItems = errors.New("cbor: too many items")
+
+var (
+	hashType   = reflect.TypeOf(Hash(""))
+	peerIDType = reflect.TypeOf(peer.ID(""))
+	timeType   = reflect.TypeOf(time.Time{})
+)
+
+// CBORMarshal encodes a value as CBOR
+func CBORMarshal(v interface{}) (data []byte, err error) {
+	var e cborEncoder
+	err = e.encode(reflect.ValueOf(v))
+	if err != nil {
+		return
+	}
+	data = e.buf.Bytes()
+	return
+}
+
+// CBORUnmarshal decodes CBOR into the value pointed to by v
+func CBORUnmarshal(data []byte, v interface{}) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		err = errors.New("cbor: can't unmarshal into a non-pointer")
+		return
+	}
+	d := cborDecoder{r: bytes.NewReader(data)}
+	err = d.decode(rv.Elem())
+	return
+}
+
+type cborEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *cborEncoder) head(major byte, n uint64) {
+	var b [9]byte
+	switch {
+	case n < 24:
+		e.buf.WriteByte(major<<5 | byte(n))
+		return
+	case n <= math.MaxUint8:
+		b[0] = major<<5 | 24
+		b[1] = byte(n)
+		e.buf.Write(b[:2])
+	case n <= math.MaxUint16:
+		b[0] = major<<5 | 25
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		e.buf.Write(b[:3])
+	case n <= math.MaxUint32:
+		b[0] = major<<5 | 26
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		e.buf.Write(b[:5])
+	default:
+		b[0] = major<<5 | 27
+		binary.BigEndian.PutUint64(b[1:], n)
+		e.buf.Write(b[:9])
+	}
+}
+
+func (e *cborEncoder) bytes(major byte, b []byte) {
+	e.head(major, uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *cborEncoder) encode(v reflect.Value) (err error) {
+	if !v.IsValid() {
+		e.head(cborSimple, cborNull)
+		return
+	}
+	switch v.Type() {
+	case hashType, peerIDType:
+		e.bytes(cborBytes, []byte(v.String()))
+		return
+	case timeType:
+		e.head(cborTag, cborTagDateTime)
+		e.bytes(cborText, []byte(v.Interface().(time.Time).Format(time.RFC3339Nano)))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.head(cborSimple, cborTrue)
+		} else {
+			e.head(cborSimple, cborFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i := v.Int(); i >= 0 {
+			e.head(cborUint, uint64(i))
+		} else {
+			e.head(cborNegInt, uint64(-1-i))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		e.head(cborUint, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		var b [9]byte
+		b[0] = cborSimple<<5 | cborFloat64
+		binary.BigEndian.PutUint64(b[1:], math.Float64bits(v.Float()))
+		e.buf.Write(b[:])
+	case reflect.String:
+		e.bytes(cborText, []byte(v.String()))
+	case reflect.Slice:
+		if v.IsNil() {
+			e.head(cborSimple, cborNull)
+			return
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.bytes(cborBytes, v.Bytes())
+			return
+		}
+		fallthrough
+	case reflect.Array:
+		e.head(cborArray, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err = e.encode(v.Index(i)); err != nil {
+				return
+			}
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			e.head(cborSimple, cborNull)
+			return
+		}
+		err = e.encode(v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			e.head(cborSimple, cborNull)
+			return
+		}
+		err = e.encodeInterface(v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		var fields []int
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" {
+				fields = append(fields, i)
+			}
+		}
+		e.head(cborMap, uint64(len(fields)))
+		for _, i := range fields {
+			e.bytes(cborText, []byte(t.Field(i).Name))
+			if err = e.encode(v.Field(i)); err != nil {
+				return
+			}
+		}
+	default:
+		err = fmt.Errorf("cbor: can't encode %v", v.Type())
+	}
+	return
+}
+
+// encodeInterface encodes the value of an interface{} field so that it can
+// be decoded without knowing its type in advance
+func (e *cborEncoder) encodeInterface(v reflect.Value) (err error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			e.head(cborSimple, cborNull)
+			return
+		}
+		v = v.Elem()
+	}
+	if name, ok := bodyTypeNames[v.Type()]; ok {
+		e.head(cborTag, cborTagGenericObject)
+		e.head(cborArray, 2)
+		e.bytes(cborText, []byte(name))
+		err = e.encode(v)
+		return
+	}
+	if v.Kind() == reflect.Struct && v.Type() != timeType {
+		err = fmt.Errorf("cbor: unregistered message body type %v", v.Type())
+		return
+	}
+	err = e.encode(v)
+	return
+}
+
+type cborReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// cborLimitReader returns ErrCBORMessageTooLarge once more than n bytes have been read
+type cborLimitReader struct {
+	r cborReader
+	n int64
+}
+
+func (l *cborLimitReader) Read(p []byte) (n int, err error) {
+	if l.n <= 0 {
+		err = ErrCBORMessageTooLarge
+		return
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err = l.r.Read(p)
+	l.n -= int64(n)
+	return
+}
+
+func (l *cborLimitReader) ReadByte() (b byte, err error) {
+	if l.n <= 0 {
+		err = ErrCBORMessageTooLarge
+		return
+	}
+	b, err = l.r.ReadByte()
+	if err == nil {
+		l.n--
+	}
+	return
+}
+
+type cborDecoder struct {
+	r     cborReader
+	depth int
+	items int
+}
+
+// enter must be called, with a deferred leave, by every function that decodes
+// an item so that the nesting of items, and so the recursion, is bounded
+func (d *cborDecoder) enter() (err error) {
+	d.depth++
+	d.items++
+	if d.depth > cborMaxDepth {
+		err = ErrCBORTooDeep
+	} else if d.items > cborMaxItems {
+		err = ErrCBORTooManyItems
+	}
+	return
+}
+
+func (d *cborDecoder) leave() {
+	d.depth--
+}
+
+// prealloc returns the capacity to allocate for an array of n elements
+func prealloc(n uint64) int {
+	if n > cborMaxPrealloc {
+		return cborMaxPrealloc
+	}
+	return int(n)
+}
+
+// head reads the initial byte of an item and its argument
+func (d *cborDecoder) head() (major byte, info byte, n uint64, err error) {
+	var b byte
+	b, err = d.r.ReadByte()
+	if err != nil {
+		return
+	}
+	major = b >> 5
+	info = b & 0x1f
+	var size int
+	switch {
+	case info < 24:
+		n = uint64(info)
+		return
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		// indefinite lengths and reserved values
+		err = ErrCBORUnsupported
+		return
+	}
+	var buf [8]byte
+	if _, err = io.ReadFull(d.r, buf[:size]); err != nil {
+		return
+	}
+	for _, x := range buf[:size] {
+		n = n<<8 | uint64(x)
+	}
+	return
+}
+
+func (d *cborDecoder) readBytes(n uint64) (b []byte, err error) {
+	if n > cborMaxLength {
+		err = fmt.Errorf("cbor: length %d too large", n)
+		return
+	}
+	if n == 0 {
+		b = []byte{}
+		return
+	}
+	// the buffer grows as the bytes arrive rather than trusting the length
+	var buf bytes.Buffer
+	var read int64
+	read, err = io.CopyN(&buf, d.r, int64(n))
+	if err == io.EOF && read > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return
+	}
+	b = buf.Bytes()
+	return
+}
+
+func (d *cborDecoder) decode(v reflect.Value) (err error) {
+	major, info, n, err := d.head()
+	if err != nil {
+		return
+	}
+	err = d.decodeItem(v, major, info, n)
+	return
+}
+
+func (d *cborDecoder) mismatch(v reflect.Value, major byte) error {
+	return fmt.Errorf("cbor: can't decode major type %d into %v", major, v.Type())
+}
+
+// decodeItem decodes the item whose head has been read into v
+func (d *cborDecoder) decodeItem(v reflect.Value, major byte, info byte, n uint64) (err error) {
+	if err = d.enter(); err != nil {
+		return
+	}
+	defer d.leave()
+	if major == cborSimple && (info == cborNull || info == cborUndefined) {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	switch v.Type() {
+	case hashType, peerIDType:
+		if major != cborBytes && major != cborText {
+			return d.mismatch(v, major)
+		}
+		var b []byte
+		if b, err = d.readBytes(n); err == nil {
+			v.SetString(string(b))
+		}
+		return
+	case timeType:
+		var x interface{}
+		if x, err = d.decodeAny(major, info, n); err != nil {
+			return
+		}
+		t, ok := x.(time.Time)
+		if !ok {
+			return d.mismatch(v, major)
+		}
+		v.Set(reflect.ValueOf(t))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if major != cborSimple || (info != cborFalse && info != cborTrue) {
+			return d.mismatch(v, major)
+		}
+		v.SetBool(info == cborTrue)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if (major != cborUint && major != cborNegInt) || n > math.MaxInt64 {
+			return d.mismatch(v, major)
+		}
+		i := int64(n)
+		if major == cborNegInt {
+			i = -1 - i
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("cbor: %d overflows %v", i, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if major != cborUint {
+			return d.mismatch(v, major)
+		}
+		if v.OverflowUint(n) {
+			return fmt.Errorf("cbor: %d overflows %v", n, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		var x interface{}
+		if x, err = d.decodeAny(major, info, n); err != nil {
+			return
+		}
+		switch f := x.(type) {
+		case float64:
+			v.SetFloat(f)
+		case int:
+			v.SetFloat(float64(f))
+		case int64:
+			v.SetFloat(float64(f))
+		case uint64:
+			v.SetFloat(float64(f))
+		default:
+			return d.mismatch(v, major)
+		}
+	case reflect.String:
+		if major != cborText {
+			return d.mismatch(v, major)
+		}
+		var b []byte
+		if b, err = d.readBytes(n); err == nil {
+			v.SetString(string(b))
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if major != cborBytes {
+				return d.mismatch(v, major)
+			}
+			var b []byte
+			if b, err = d.readBytes(n); err == nil {
+				v.SetBytes(b)
+			}
+			return
+		}
+		if major != cborArray {
+			return d.mismatch(v, major)
+		}
+		if n > cborMaxLength {
+			return fmt.Errorf("cbor: length %d too large", n)
+		}
+		s := reflect.MakeSlice(v.Type(), 0, prealloc(n))
+		zero := reflect.Zero(v.Type().Elem())
+		for i := 0; i < int(n); i++ {
+			s = reflect.Append(s, zero)
+			if err = d.decode(s.Index(i)); err != nil {
+				return
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		if major != cborArray {
+			return d.mismatch(v, major)
+		}
+		for i := uint64(0); i < n; i++ {
+			if int(i) < v.Len() {
+				err = d.decode(v.Index(int(i)))
+			} else {
+				err = d.skip()
+			}
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Ptr:
+		p := reflect.New(v.Type().Elem())
+		if err = d.decodeItem(p.Elem(), major, info, n); err == nil {
+			v.Set(p)
+		}
+	case reflect.Interface:
+		var x interface{}
+		if x, err = d.decodeAny(major, info, n); err != nil {
+			return
+		}
+		xv := reflect.ValueOf(x)
+		if !xv.Type().AssignableTo(v.Type()) {
+			return fmt.Errorf("cbor: can't assign %v to %v", xv.Type(), v.Type())
+		}
+		v.Set(xv)
+	case reflect.Struct:
+		if major != cborMap {
+			return d.mismatch(v, major)
+		}
+		for i := uint64(0); i < n; i++ {
+			var key string
+			if err = d.decode(reflect.ValueOf(&key).Elem()); err != nil {
+				return
+			}
+			// fields the struct doesn't have are skipped so that fields can be added
+			f, ok := v.Type().FieldByName(key)
+			if ok && f.PkgPath == "" && len(f.Index) == 1 {
+				err = d.decode(v.Field(f.Index[0]))
+			} else {
+				err = d.skip()
+			}
+			if err != nil {
+				return
+			}
+		}
+	default:
+		err = fmt.Errorf("cbor: can't decode into %v", v.Type())
+	}
+	return
+}
+
+func (d *cborDecoder) skip() (err error) {
+	major, info, n, err := d.head()
+	if err != nil {
+		return
+	}
+	_, err = d.decodeAny(major, info, n)
+	return
+}
+
+// decodeAny decodes an item whose head has been read without knowing its
+// type in advance
+func (d *cborDecoder) decodeAny(major byte, info byte, n uint64) (x interface{}, err error) {
+	if err = d.enter(); err != nil {
+		return
+	}
+	defer d.leave()
+	switch major {
+	case cborUint:
+		if n > math.MaxInt64 {
+			x = n
+		} else {
+			x = cborInt(int64(n))
+		}
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			err = ErrCBORUnsupported
+			return
+		}
+		x = cborInt(-1 - int64(n))
+	case cborBytes:
+		x, err = d.readBytes(n)
+	case cborText:
+		var b []byte
+		if b, err = d.readBytes(n); err == nil {
+			x = string(b)
+		}
+	case cborArray:
+		if n > cborMaxLength {
+			err = fmt.Errorf("cbor: length %d too large", n)
+			return
+		}
+		a := make([]interface{}, 0, prealloc(n))
+		for i := uint64(0); i < n; i++ {
+			var val interface{}
+			if err = d.decode(reflect.ValueOf(&val).Elem()); err != nil {
+				return
+			}
+			a = append(a, val)
+		}
+		x = a
+	case cborMap:
+		m := make(map[string]interface{})
+		for i := uint64(0); i < n; i++ {
+			var key string
+			if err = d.decode(reflect.ValueOf(&key).Elem()); err != nil {
+				return
+			}
+			var val interface{}
+			if err = d.decode(reflect.ValueOf(&val).Elem()); err != nil {
+				return
+			}
+			m[key] = val
+		}
+		x = m
+	case cborTag:
+		x, err = d.decodeTagged(n)
+	case cborSimple:
+		switch info {
+		case cborFalse:
+			x = false
+		case cborTrue:
+			x = true
+		case cborNull, cborUndefined:
+		case cborFloat16:
+			x = float16ToFloat64(uint16(n))
+		case cborFloat32:
+			x = float64(math.Float32frombits(uint32(n)))
+		case cborFloat64:
+			x = math.Float64frombits(n)
+		default:
+			err = ErrCBORUnsupported
+		}
+	}
+	return
+}
+
+func (d *cborDecoder) decodeTagged(tag uint64) (x interface{}, err error) {
+	major, info, n, err := d.head()
+	if err != nil {
+		return
+	}
+	switch {
+	case tag == cborTagDateTime && major == cborText:
+		var b []byte
+		if b, err = d.readBytes(n); err != nil {
+			return
+		}
+		var t time.Time
+		if t, err = time.Parse(time.RFC3339Nano, string(b)); err != nil {
+			return
+		}
+		// like gob, give times in the local time zone's offset the local location
+		if _, offset := t.Zone(); !t.IsZero() && offset == localOffset(t) {
+			t = t.In(time.Local)
+		}
+		x = t
+	case tag == cborTagGenericObject && major == cborArray && n == 2:
+		var name string
+		if err = d.decode(reflect.ValueOf(&name).Elem()); err != nil {
+			return
+		}
+		t, ok := bodyTypes[name]
+		if !ok {
+			// a type we don't know, from a newer node, so decode it generically
+			var val interface{}
+			err = d.decode(reflect.ValueOf(&val).Elem())
+			x = val
+			return
+		}
+		v := reflect.New(t).Elem()
+		if err = d.decode(v); err == nil {
+			x = v.Interface()
+		}
+	default:
+		// we don't know the tag so just return the item it tags
+		x, err = d.decodeAny(major, info, n)
+	}
+	return
+}
+
+func localOffset(t time.Time) int {
+	_, offset := t.In(time.Local).Zone()
+	return offset
+}
+
+// cborInt returns integers decoded into interface{} fields as ints when they
+// fit, like the untyped constants they usually come from
+func cborInt(i int64) interface{} {
+	if int64(int(i)) == i {
+		return int(i)
+	}
+	return i
+}
+
+func float16ToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
+
+// CBORSchema returns a CDDL (RFC 8610) description of messages as the cbor
+// codec encodes them, with a rule for each registered body type, so that
+// implementations in other languages don't need to know the go types.  Every
+// map key may be missing, in which case the field has its zero value, and keys
+// that aren't described should be ignored.
+func CBORSchema() string {
+	s := cborSchema{rules: make(map[string]string)}
+	s.typeOf(reflect.TypeOf(Message{}))
+
+	var names []string
+	for name := range bodyTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var bodies []string
+	for _, name := range names {
+		s.typeOf(bodyTypes[name])
+		bodies = append(bodies, fmt.Sprintf(`#6.%d(["%s", %s])`, cborTagGenericObject, name, name))
+	}
+	bodies = append(bodies, "any")
+
+	var types []MsgType
+	for t := range msgTypes {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "; holochain messages encoded with the %s codec, protocol version %s\n", CBORCodecName, ProtocolVersion)
+	b.WriteString(";\n; message types:\n")
+	for _, t := range types {
+		def := msgTypes[t]
+		fmt.Fprintf(&b, ";   %d = %s (since %s)\n", int(t), def.Name, def.Version)
+	}
+	b.WriteString("\nmessage = Message\n\n")
+	b.WriteString("; the value of any interface field, tagged with the name of its type if it has one\n")
+	b.WriteString("body = " + strings.Join(bodies, " /\n       ") + "\n\n")
+	b.WriteString("hash = bstr ; a multihash\n")
+	b.WriteString("peer-id = bstr ; a libp2p peer ID\n")
+	b.WriteString(fmt.Sprintf("time = #6.%d(tstr) ; RFC 3339\n", cborTagDateTime))
+	for _, name := range s.order {
+		b.WriteString("\n" + name + " = " + s.rules[name] + "\n")
+	}
+	return b.String()
+}
+
+type cborSchema struct {
+	rules map[string]string
+	order []string
+}
+
+// typeOf returns the CDDL type of values of a go type as the cbor codec
+// encodes them, adding rules for the structs it finds
+func (s *cborSchema) typeOf(t reflect.Type) string {
+	switch t {
+	case hashType:
+		return "hash"
+	case peerIDType:
+		return "peer-id"
+	case timeType:
+		return "time"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "int"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "uint"
+	case reflect.Float32, reflect.Float64:
+		return "float64"
+	case reflect.String:
+		return "tstr"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bstr / null"
+		}
+		return "[* " + s.element(t.Elem()) + "] / null"
+	case reflect.Array:
+		return fmt.Sprintf("[%d*%d %s]", t.Len(), t.Len(), s.element(t.Elem()))
+	case reflect.Ptr:
+		return s.element(t.Elem()) + " / null"
+	case reflect.Interface:
+		return "body / null"
+	case reflect.Struct:
+		name, ok := bodyTypeNames[t]
+		if !ok {
+			name = t.Name()
+		}
+		if name == "" {
+			return s.structOf(t)
+		}
+		if _, ok := s.rules[name]; !ok {
+			// add the rule before its fields so that recursive types terminate
+			s.rules[name] = ""
+			s.order = append(s.order, name)
+			s.rules[name] = s.structOf(t)
+		}
+		return name
+	}
+	return "any"
+}
+
+// element returns the type of an element of an array or pointer
+func (s *cborSchema) element(t reflect.Type) string {
+	e := s.typeOf(t)
+	if strings.Contains(e, " / ") {
+		e = "(" + e + ")"
+	}
+	return e
+}
+
+func (s *cborSchema) structOf(t reflect.Type) string {
+	var b bytes.Buffer
+	b.WriteString("{\n")
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath == "" {
+			b.WriteString("  ? " + f.Name + ": " + s.typeOf(f.Type) + ",\n")
+		}
+	}
+	b.WriteString("}")
+	return b.String()
+}
@@ -2,7 +2,7 @@
 // Use of this source code is governed by GPLv3 found in the LICENSE file
 //----------------------------------------------------------------------------------------
 
-// implements in-memory chain representation with marshaling, & validation
+// implements chain representation with marshaling, & validation
 
 package holochain
 
@@ -19,6 +19,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/boltdb/bolt"
 	ic "github.com/libp2p/go-libp2p-crypto"
 
 	. "github.com/holochain/holochain-proto/hash"
@@ -31,6 +32,7 @@ var ErrHashNotFound = errors.New("hash not found")
 var ErrIncompleteChain = errors.New("operation not allowed on incomplete chain")
 var ErrChainLockedForBundle = errors.New("chain locked for bundle")
 var ErrBundleNotStarted = errors.New("bundle not started")
+var ErrBundleClosed = errors.New("bundle closed while committing to it")
 
 const (
 	ChainMarshalFlagsNone            = 0x00
@@ -38,6 +40,7 @@ const (
 	ChainMarshalFlagsNoEntries       = 0x02
 	ChainMarshalFlagsOmitDNA         = 0x04
 	ChainMarshalFlagsNoPrivate       = 0x08
+	ChainMarshalFlagsFromCheckpoint  = 0x10 // start at the latest checkpoint, if there is one
 	ChainMarshalPrivateEntryRedacted = "%%PRIVATE ENTRY REDACTED%%"
 )
 
@@ -46,9 +49,20 @@ type Bundle struct {
 	userParam string
 	chain     *Chain
 	sharing   []CommittingAction
+	started   time.Time
+	timeout   time.Duration // zero if the bundle never times out
+	closed    bool          // set when the bundle is closed, guarded by the lock of its chain
 }
 
-// Chain structure for providing in-memory access to chain data, entries headers and hashes
+// Expired returns true if the bundle has a timeout and it has elapsed by now
+func (b *Bundle) Expired(now time.Time) bool {
+	return b.timeout > 0 && !now.Before(b.started.Add(b.timeout))
+}
+
+// Chain structure for providing access to chain data, entries headers and hashes.
+// Chains loaded from a file are backed by an indexed store and read their data
+// from disk as needed, in which case the in-memory fields below are left empty,
+// so they are only accessed through the Chain methods.
 type Chain struct {
 	Hashes   []Hash
 	Headers  []*Header
@@ -59,7 +73,9 @@ type Chain struct {
 
 	//---
 
-	s        *os.File // if this stream is not nil, new entries will get marshaled to it
+	s        *os.File    // if this stream is not nil, new entries will get marshaled to it
+	store    *chainStore // if not nil, the chain data is read from and indexed in this store
+	cipher   *DataCipher // if not nil, entries are encrypted when marshaled to s
 	hashSpec HashSpec
 	lk       sync.RWMutex
 	bundle   *Bundle // non-nil when this chain has a bundle in progress
@@ -81,73 +97,199 @@ func NewChain(hashSpec HashSpec) (chain *Chain) {
 	return
 }
 
-// NewChainFromFile creates a chain from a file, loading any data there,
+// NewChainFromFile creates a chain from a file, indexing any data there,
 // and setting it to be persisted to. If no file exists it will be created.
 func NewChainFromFile(spec HashSpec, path string) (c *Chain, err error) {
+	return NewEncryptedChainFromFile(spec, path, nil)
+}
+
+// NewEncryptedChainFromFile creates a chain from a file like NewChainFromFile but
+// with the entries in the file encrypted by the given cipher.  Headers are not
+// encrypted.  If cipher is nil the file is read and written in the clear.  A file
+// that was written in the clear can't be opened with a cipher, it returns
+// ErrChainNotEncrypted and must first be converted with EncryptChainFile.
+func NewEncryptedChainFromFile(spec HashSpec, path string, cipher *DataCipher) (c *Chain, err error) {
 	defer func() {
 		if err != nil {
 			Debugf("error loading chain :%s", err.Error())
 		}
 	}()
 	c = NewChain(spec)
+	c.cipher = cipher
 
 	var f *os.File
-	if FileExists(path) {
-		f, err = os.Open(path)
+	f, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	c.store, err = openChainStore(spec, path)
+	if err == bolt.ErrTimeout {
+		// the index is held open by another process so fall back to loading the
+		// chain into memory, the index will catch up the next time it's opened
+		Debugf("chain index for %s in use, loading chain into memory", path)
+		err = c.load(path)
+	}
+	if err == nil && c.store != nil && cipher != nil && c.store.len() > 0 {
+		// entries are decrypted lazily so check the key against the first one now
+		_, err = c.entry(0)
+		if err == ErrDataDecryptionFailed && c.inClear(0) {
+			err = ErrChainNotEncrypted
+		}
 		if err != nil {
+			c.store.close()
+			c.store = nil
+		}
+	}
+	if err != nil {
+		f.Close()
+		return
+	}
+	c.s = f
+	return
+}
+
+// load reads all the data in a chain file into memory
+func (c *Chain) load(path string) (err error) {
+	var f *os.File
+	f, err = os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	var i int
+	for {
+		var header *Header
+		var e Entry
+		header, e, err = readPair(ChainMarshalFlagsNone, f)
+		if err != nil && err.Error() == "EOF" {
+			err = nil
+			break
+		}
+		if err != nil {
+			Debugf("error reading pair:%s", err.Error())
 			return
 		}
-		var i int
-		for {
-			var header *Header
-			var e Entry
-			header, e, err = readPair(ChainMarshalFlagsNone, f)
-			if err != nil && err.Error() == "EOF" {
-				err = nil
-				break
-			}
-			if err != nil {
-				Debugf("error reading pair:%s", err.Error())
-				return
+		if c.cipher != nil {
+			sealed := e
+			e, err = c.cipher.OpenEntry(sealed)
+			if err == ErrDataDecryptionFailed && i == 0 && inClear(c.hashSpec, header, sealed) {
+				err = ErrChainNotEncrypted
 			}
-			c.addPair(header, e, i)
-			i++
-		}
-		f.Close()
-		i--
-		// if we read anything then we have to calculate the final hash and add it
-		if i >= 0 {
-			hd := c.Headers[i]
-			var hash Hash
-
-			// hash the header
-			hash, _, err = hd.Sum(spec)
 			if err != nil {
 				return
 			}
-
-			c.Hashes = append(c.Hashes, hash)
-			c.Hmap[hash] = i
-
-			// finally validate that it all hashes out correctly
-			/*			err = c.Validate(h)
-						if err != nil {
-							return
-						}
-			*/
 		}
+		c.addPair(header, e, i)
+		i++
+	}
+	i--
+	// if we read anything then we have to calculate the final hash and add it
+	if i >= 0 {
+		hd := c.Headers[i]
+		var hash Hash
 
-		f, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+		// hash the header
+		hash, _, err = hd.Sum(c.hashSpec)
 		if err != nil {
 			return
 		}
-	} else {
-		f, err = os.Create(path)
-		if err != nil {
-			return
+
+		c.Hashes = append(c.Hashes, hash)
+		c.Hmap[hash] = i
+	}
+	return
+}
+
+// inClear returns whether the ith entry in the chain's store is unencrypted
+func (c *Chain) inClear(i int) bool {
+	header, err := c.store.header(i)
+	if err != nil {
+		return false
+	}
+	e, err := c.store.entry(i)
+	return err == nil && inClear(c.hashSpec, header, e)
+}
+
+// length returns the number of headers in the chain
+func (c *Chain) length() int {
+	if c.store != nil {
+		return c.store.len()
+	}
+	return len(c.Headers)
+}
+
+// header returns the ith header of the chain
+func (c *Chain) header(i int) (header *Header, err error) {
+	if c.store != nil {
+		header, err = c.store.header(i)
+		return
+	}
+	if i < 0 || i >= len(c.Headers) {
+		err = ErrHashNotFound
+		return
+	}
+	header = c.Headers[i]
+	return
+}
+
+// entry returns the ith entry of the chain
+func (c *Chain) entry(i int) (entry Entry, err error) {
+	if c.store != nil {
+		entry, err = c.store.entry(i)
+		if err == nil && c.cipher != nil {
+			entry, err = c.cipher.OpenEntry(entry)
 		}
+		return
 	}
-	c.s = f
+	if i < 0 || i >= len(c.Entries) {
+		err = ErrIncompleteChain
+		return
+	}
+	entry = c.Entries[i]
+	return
+}
+
+// hash returns the hash of the ith header of the chain
+func (c *Chain) hash(i int) (hash Hash, err error) {
+	if c.store != nil {
+		hash, err = c.store.hash(i)
+		return
+	}
+	if i < 0 || i >= len(c.Hashes) {
+		err = ErrHashNotFound
+		return
+	}
+	hash = c.Hashes[i]
+	return
+}
+
+// headerIdx returns the index of the header with the given hash
+func (c *Chain) headerIdx(h Hash) (i int, ok bool, err error) {
+	if c.store != nil {
+		i, ok, err = c.store.lookup(chainHeaderBucket, []byte(h))
+		return
+	}
+	i, ok = c.Hmap[h]
+	return
+}
+
+// entryIdx returns the index of the header of the entry with the given hash
+func (c *Chain) entryIdx(h Hash) (i int, ok bool, err error) {
+	if c.store != nil {
+		i, ok, err = c.store.lookup(chainEntryBucket, []byte(h))
+		return
+	}
+	i, ok = c.Emap[h]
+	return
+}
+
+// typeTop returns the index of latest header of a given type
+func (c *Chain) typeTop(entryType string) (i int, ok bool, err error) {
+	if c.store != nil {
+		i, ok, err = c.store.lookup(chainTypeBucket, []byte(entryType))
+		return
+	}
+	i, ok = c.TypeTops[entryType]
 	return
 }
 
@@ -160,9 +302,9 @@ func (c *Chain) Top() (header *Header) {
 func (c *Chain) Nth(n int) (header *Header) {
 	c.lk.RLock()
 	defer c.lk.RUnlock()
-	l := len(c.Headers)
+	l := c.length()
 	if l-n > 0 {
-		header = c.Headers[l-n-1]
+		header, _ = c.header(l - n - 1)
 	}
 	return
 }
@@ -171,10 +313,18 @@ func (c *Chain) Nth(n int) (header *Header) {
 func (c *Chain) TopType(entryType string) (hash *Hash, header *Header) {
 	c.lk.RLock()
 	defer c.lk.RUnlock()
-	i, ok := c.TypeTops[entryType]
-	if ok {
-		header = c.Headers[i]
-		var hs = c.Hashes[i].Clone()
+	i, ok, err := c.typeTop(entryType)
+	if ok && err == nil {
+		var hs Hash
+		hs, err = c.hash(i)
+		if err == nil {
+			header, err = c.header(i)
+		}
+		if err != nil {
+			header = nil
+			return
+		}
+		hs = hs.Clone()
 		hash = &hs
 	}
 	return
@@ -194,6 +344,37 @@ func (c *Chain) AddEntry(now time.Time, entryType string, e Entry, privKey ic.Pr
 	return
 }
 
+// topHash returns the hash of the chain's last header, or if it's an empty bundle
+// chain, the last header of the chain it's a bundle of.  Not thread safe.
+func (c *Chain) topHash() (hash Hash, err error) {
+	l := c.length()
+	if l > 0 {
+		hash, err = c.hash(l - 1)
+	} else if c.bundleOf != nil {
+		hash, err = c.bundleOf.topHash()
+	} else {
+		hash = NullHash()
+	}
+	return
+}
+
+// typeTopHash returns the hash of the chain's last header of the given type,
+// looking through the chains it's a bundle of if it has none.  Not thread safe.
+func (c *Chain) typeTopHash(entryType string) (hash Hash, err error) {
+	i, ok, err := c.typeTop(entryType)
+	if err != nil {
+		return
+	}
+	if ok {
+		hash, err = c.hash(i)
+	} else if c.bundleOf != nil {
+		hash, err = c.bundleOf.typeTopHash(entryType)
+	} else {
+		hash = NullHash()
+	}
+	return
+}
+
 // prepareHeader builds a header that could be added to the chain.
 // Not thread safe, this must be called with the chain locked for writing so something else
 // doesn't get inserted
@@ -205,44 +386,20 @@ func (c *Chain) prepareHeader(now time.Time, entryType string, e Entry, privKey
 	}
 	// get the previous hashes
 	var ph, pth Hash
-
-	l := len(c.Hashes)
-	if l == 0 {
-		if c.bundleOf != nil {
-			l := len(c.bundleOf.Hashes)
-			if l == 0 {
-				ph = NullHash()
-			} else {
-				ph = c.bundleOf.Hashes[l-1]
-			}
-		} else {
-			ph = NullHash()
-		}
-	} else {
-		ph = c.Hashes[l-1]
+	ph, err = c.topHash()
+	if err != nil {
+		return
 	}
-
-	i, ok := c.TypeTops[entryType]
-	if !ok {
-		if c.bundleOf != nil {
-			i, ok = c.bundleOf.TypeTops[entryType]
-			if !ok {
-				pth = NullHash()
-			} else {
-				pth = c.bundleOf.Hashes[i]
-			}
-		} else {
-			pth = NullHash()
-		}
-	} else {
-		pth = c.Hashes[i]
+	pth, err = c.typeTopHash(entryType)
+	if err != nil {
+		return
 	}
 
 	hash, header, err = newHeader(c.hashSpec, now, entryType, e, privKey, ph, pth, change)
 	if err != nil {
 		return
 	}
-	entryIdx = l
+	entryIdx = c.length()
 	return
 }
 
@@ -252,20 +409,32 @@ func (c *Chain) addEntry(entryIdx int, hash Hash, header *Header, e Entry) (err
 		err = ErrChainLockedForBundle
 		return
 	}
-	l := len(c.Hashes)
+	l := c.length()
 	if l != entryIdx {
 		err = errors.New("entry indexes don't match can't create new entry")
 		return
 	}
 
+	var g GobEntry
+	g = *e.(*GobEntry)
+
+	if c.store != nil {
+		var e Entry = &g
+		if c.cipher != nil {
+			e, err = c.cipher.SealEntry(e)
+			if err != nil {
+				return
+			}
+		}
+		err = c.store.append(c.s, entryIdx, hash, header, e)
+		return
+	}
+
 	if l != len(c.Entries) {
 		err = ErrIncompleteChain
 		return
 	}
 
-	var g GobEntry
-	g = *e.(*GobEntry)
-
 	c.Hashes = append(c.Hashes, hash)
 	c.Headers = append(c.Headers, header)
 	c.Entries = append(c.Entries, &g)
@@ -274,7 +443,14 @@ func (c *Chain) addEntry(entryIdx int, hash Hash, header *Header, e Entry) (err
 	c.Hmap[hash] = entryIdx
 
 	if c.s != nil {
-		err = writePair(c.s, header, &g)
+		var e Entry = &g
+		if c.cipher != nil {
+			e, err = c.cipher.SealEntry(e)
+			if err != nil {
+				return
+			}
+		}
+		err = writePair(c.s, header, e)
 	}
 
 	return
@@ -284,9 +460,12 @@ func (c *Chain) addEntry(entryIdx int, hash Hash, header *Header, e Entry) (err
 func (c *Chain) Get(h Hash) (header *Header, err error) {
 	c.lk.RLock()
 	defer c.lk.RUnlock()
-	i, ok := c.Hmap[h]
+	i, ok, err := c.headerIdx(h)
+	if err != nil {
+		return
+	}
 	if ok {
-		header = c.Headers[i]
+		header, err = c.header(i)
 	} else {
 		err = ErrHashNotFound
 	}
@@ -297,10 +476,21 @@ func (c *Chain) Get(h Hash) (header *Header, err error) {
 func (c *Chain) GetEntry(h Hash) (entry Entry, entryType string, err error) {
 	c.lk.RLock()
 	defer c.lk.RUnlock()
-	i, ok := c.Emap[h]
+	i, ok, err := c.entryIdx(h)
+	if err != nil {
+		return
+	}
 	if ok {
-		entry = c.Entries[i]
-		entryType = c.Headers[i].Type
+		var header *Header
+		header, err = c.header(i)
+		if err != nil {
+			return
+		}
+		entry, err = c.entry(i)
+		if err != nil {
+			return
+		}
+		entryType = header.Type
 	} else {
 		err = ErrHashNotFound
 	}
@@ -311,9 +501,12 @@ func (c *Chain) GetEntry(h Hash) (entry Entry, entryType string, err error) {
 func (c *Chain) GetEntryHeader(h Hash) (header *Header, err error) {
 	c.lk.RLock()
 	defer c.lk.RUnlock()
-	i, ok := c.Emap[h]
+	i, ok, err := c.entryIdx(h)
+	if err != nil {
+		return
+	}
 	if ok {
-		header = c.Headers[i]
+		header, err = c.header(i)
 	} else {
 		err = ErrHashNotFound
 	}
@@ -379,11 +572,26 @@ func (c *Chain) MarshalChain(writer io.Writer, flags int64, whitelistTypes []str
 	c.lk.RLock()
 	defer c.lk.RUnlock()
 
-	if len(c.Headers) != len(c.Entries) {
+	if c.store == nil && len(c.Headers) != len(c.Entries) {
 		err = ErrIncompleteChain
 		return
 	}
 
+	// the first pair written is either the DNA or the latest checkpoint, the
+	// flag is only left set if there was a checkpoint to start at
+	start := 0
+	if (flags & ChainMarshalFlagsFromCheckpoint) != 0 {
+		var ok bool
+		start, ok, err = c.typeTop(CheckpointEntryType)
+		if err != nil {
+			return
+		}
+		if !ok {
+			start = 0
+			flags &^= ChainMarshalFlagsFromCheckpoint
+		}
+	}
+
 	err = binary.Write(writer, binary.LittleEndian, flags)
 	if err != nil {
 		return err
@@ -392,12 +600,21 @@ func (c *Chain) MarshalChain(writer io.Writer, flags int64, whitelistTypes []str
 	var pairsToWrite []ChainPair
 	var lastHeaderToWrite int
 
-	for i, hdr := range c.Headers {
+	l := c.length()
+	for i := start; i < l; i++ {
 		var empty []string
 		var e Entry
+		var hdr *Header
+		hdr, err = c.header(i)
+		if err != nil {
+			return
+		}
 
-		if i == 0 || filterPass(i, hdr, whitelistTypes, empty) {
-			e = c.Entries[i]
+		if i == start || filterPass(i, hdr, whitelistTypes, empty) {
+			e, err = c.entry(i)
+			if err != nil {
+				return
+			}
 
 			if (i == 0) && ((flags & ChainMarshalFlagsOmitDNA) != 0) {
 				e = &GobEntry{C: ""}
@@ -434,7 +651,11 @@ func (c *Chain) MarshalChain(writer io.Writer, flags int64, whitelistTypes []str
 	}
 
 	if (flags & ChainMarshalFlagsNoHeaders) == 0 {
-		hash := c.Hashes[lastHeaderToWrite]
+		var hash Hash
+		hash, err = c.hash(lastHeaderToWrite)
+		if err != nil {
+			return
+		}
 		err = hash.MarshalHash(writer)
 	}
 	return
@@ -502,9 +723,24 @@ func UnmarshalChain(hashSpec HashSpec, reader io.Reader) (flags int64, c *Chain,
 
 // Walk traverses chain from most recent to first entry calling fn on each one
 func (c *Chain) Walk(fn WalkerFn) (err error) {
-	l := len(c.Headers)
+	l := c.length()
 	for i := l - 1; i >= 0; i-- {
-		err = fn(&c.Hashes[i], c.Headers[i], c.Entries[i])
+		var hash Hash
+		var header *Header
+		var entry Entry
+		hash, err = c.hash(i)
+		if err != nil {
+			return
+		}
+		header, err = c.header(i)
+		if err != nil {
+			return
+		}
+		entry, err = c.entry(i)
+		if err != nil {
+			return
+		}
+		err = fn(&hash, header, entry)
 		if err != nil {
 			return
 		}
@@ -512,15 +748,28 @@ func (c *Chain) Walk(fn WalkerFn) (err error) {
 	return
 }
 
-// Validate traverses chain confirming the hashes
+// Validate traverses chain confirming the hashes.  If the entries are being
+// checked and the chain has a checkpoint, the latest checkpoint is verified and
+// the headers before it are trusted instead of being walked.
 // @TODO confirm that TypeLinks are also correct
 // @TODO confirm signatures
 func (c *Chain) Validate(skipEntries bool) (err error) {
 	c.lk.RLock()
 	defer c.lk.RUnlock()
-	l := len(c.Headers)
-	for i := 0; i < l; i++ {
-		hd := c.Headers[i]
+	l := c.length()
+	start := 0
+	if !skipEntries {
+		start, err = c.verifyCheckpoint()
+		if err != nil {
+			return
+		}
+	}
+	for i := start; i < l; i++ {
+		var hd *Header
+		hd, err = c.header(i)
+		if err != nil {
+			return
+		}
 
 		var hash, nexth Hash
 		// hash the header
@@ -530,10 +779,18 @@ func (c *Chain) Validate(skipEntries bool) (err error) {
 		}
 		// we can't compare top hash to next link, because it doesn't exist yet!
 		if i < l-2 {
-			nexth = c.Headers[i+1].HeaderLink
+			var next *Header
+			next, err = c.header(i + 1)
+			if err != nil {
+				return
+			}
+			nexth = next.HeaderLink
 		} else {
 			// so get it from the Hashes (even though this could be cheated)
-			nexth = c.Hashes[i]
+			nexth, err = c.hash(i)
+			if err != nil {
+				return
+			}
 		}
 
 		if !hash.Equal(nexth) {
@@ -542,8 +799,13 @@ func (c *Chain) Validate(skipEntries bool) (err error) {
 		}
 
 		if !skipEntries {
+			var e Entry
+			e, err = c.entry(i)
+			if err != nil {
+				return
+			}
 			var b []byte
-			b, err = c.Entries[i].Marshal()
+			b, err = e.Marshal()
 			if err != nil {
 				return
 			}
@@ -570,17 +832,19 @@ func (c *Chain) String() string {
 func (c *Chain) Dump(start int) string {
 	c.lk.RLock()
 	defer c.lk.RUnlock()
-	l := len(c.Headers)
+	l := c.length()
 	r := ""
 	for i := start; i < l; i++ {
-		hdr := c.Headers[i]
-		hash := c.Hashes[i]
+		hdr, hash, e, err := c.get(i)
+		if err != nil {
+			r += fmt.Sprintf("error reading chain at %d: %v\n", i, err)
+			break
+		}
 		r += fmt.Sprintf("%s:%s @ %v\n", hdr.Type, hash, hdr.Time)
 		r += fmt.Sprintf("    Sig: %v\n", hdr.Sig)
 		r += fmt.Sprintf("    Next Header: %v\n", hdr.HeaderLink)
 		r += fmt.Sprintf("    Next %s: %v\n", hdr.Type, hdr.TypeLink)
 		r += fmt.Sprintf("    Entry: %v\n", hdr.EntryLink)
-		e := c.Entries[i]
 		switch hdr.Type {
 		case KeyEntryType:
 			r += fmt.Sprintf("       %v\n", e.(*GobEntry).C)
@@ -590,6 +854,8 @@ func (c *Chain) Dump(start int) string {
 			r += fmt.Sprintf("       %v\n", e.(*GobEntry).C)
 		case MigrateEntryType:
 			r += fmt.Sprintf("       %v\n", e.(*GobEntry).C)
+		case CheckpointEntryType:
+			r += fmt.Sprintf("       %v\n", e.(*GobEntry).C)
 		default:
 			r += fmt.Sprintf("       %v\n", e)
 		}
@@ -602,7 +868,7 @@ func (c *Chain) Dump(start int) string {
 func (c *Chain) JSON(start int) (string, error) {
 	c.lk.RLock()
 	defer c.lk.RUnlock()
-	l := len(c.Headers)
+	l := c.length()
 	firstEntry := false
 	lastEntry := false
 
@@ -611,10 +877,10 @@ func (c *Chain) JSON(start int) (string, error) {
 	buffer.WriteString("{")
 
 	for i := start; i < l; i++ {
-		hdr := c.Headers[i]
-		hash := c.Hashes[i]
-
-		e := c.Entries[i]
+		hdr, hash, e, err := c.get(i)
+		if err != nil {
+			return "", err
+		}
 		lastEntry = (i == l-1)
 
 		switch hdr.Type {
@@ -644,11 +910,53 @@ func (c *Chain) JSON(start int) (string, error) {
 	return PrettyPrintJSON(buffer.Bytes())
 }
 
+// chainJSON is the shape of a chain when encoded by MarshalJSON
+type chainJSON struct {
+	Hashes   []Hash
+	Headers  []*Header
+	Entries  []Entry
+	TypeTops map[string]int
+	Hmap     map[Hash]int
+	Emap     map[Hash]int
+}
+
+// MarshalJSON encodes the headers, entries, hashes and indexes of the chain,
+// reading them from the store if the chain is backed by one
+func (c *Chain) MarshalJSON() ([]byte, error) {
+	c.lk.RLock()
+	defer c.lk.RUnlock()
+	if c.store == nil {
+		return json.Marshal(chainJSON{c.Hashes, c.Headers, c.Entries, c.TypeTops, c.Hmap, c.Emap})
+	}
+	l := c.length()
+	j := chainJSON{
+		Hashes:   make([]Hash, l),
+		Headers:  make([]*Header, l),
+		Entries:  make([]Entry, l),
+		TypeTops: make(map[string]int),
+		Hmap:     make(map[Hash]int),
+		Emap:     make(map[Hash]int),
+	}
+	for i := 0; i < l; i++ {
+		hdr, hash, e, err := c.get(i)
+		if err != nil {
+			return nil, err
+		}
+		j.Hashes[i] = hash
+		j.Headers[i] = hdr
+		j.Entries[i] = e
+		j.TypeTops[hdr.Type] = i
+		j.Hmap[hash] = i
+		j.Emap[hdr.EntryLink] = i
+	}
+	return json.Marshal(j)
+}
+
 // Dot converts a chain to a GraphViz 'dot' format dump of the headers and entries
 func (c *Chain) Dot(start int) (dump string, err error) {
 	c.lk.RLock()
 	defer c.lk.RUnlock()
-	l := len(c.Headers)
+	l := c.length()
 
 	var buffer bytes.Buffer
 
@@ -658,8 +966,13 @@ func (c *Chain) Dot(start int) (dump string, err error) {
 	buffer.WriteString(`edge [penwidth=2, color="#8d00ff"];` + "\n")
 
 	for i := start; i < l; i++ {
-		hdr := c.Headers[i]
-		hash := c.Hashes[i]
+		var hdr *Header
+		var hash Hash
+		var e Entry
+		hdr, hash, e, err = c.get(i)
+		if err != nil {
+			return
+		}
 		headerLabel := ""
 		contentLabel := ""
 		contentBody := ""
@@ -689,7 +1002,6 @@ func (c *Chain) Dot(start int) (dump string, err error) {
 		if i == 0 {
 			contentBody = "See dna.json"
 		} else {
-			e := c.Entries[i]
 			contentBody = fmt.Sprintf("%s", e.(*GobEntry).C)
 			contentBody = strings.Replace(contentBody, `{"`, `\{"`, -1)
 			contentBody = strings.Replace(contentBody, `"}`, `"\}`, -1)
@@ -713,56 +1025,124 @@ func (c *Chain) Dot(start int) (dump string, err error) {
 
 // Length returns the number of entries in the chain
 func (c *Chain) Length() int {
-	return len(c.Headers)
+	return c.length()
+}
+
+// get returns the header, hash and entry at a given index
+func (c *Chain) get(i int) (header *Header, hash Hash, entry Entry, err error) {
+	header, err = c.header(i)
+	if err != nil {
+		return
+	}
+	hash, err = c.hash(i)
+	if err != nil {
+		return
+	}
+	entry, err = c.entry(i)
+	return
 }
 
-// BundleStarted returns the index of the chain item before the bundle or 0 if no bundle is active
+// BundleStarted returns the innermost bundle in progress or nil if no bundle is active
 func (c *Chain) BundleStarted() *Bundle {
-	return c.bundle
+	bundle := c.bundle
+	for bundle != nil && bundle.chain.bundle != nil {
+		bundle = bundle.chain.bundle
+	}
+	return bundle
+}
+
+// BundleDepth returns the number of nested bundles in progress
+func (c *Chain) BundleDepth() (depth int) {
+	for bundle := c.bundle; bundle != nil; bundle = bundle.chain.bundle {
+		depth++
+	}
+	return
 }
 
-// StartBundle marks a bundle start point and returns an error if already started
+// StartBundle marks a bundle start point.  If a bundle is already in progress
+// the new bundle is nested inside it and works as a savepoint, closing it
+// only adds its entries to the enclosing bundle.
 func (c *Chain) StartBundle(userParam interface{}) (err error) {
+	err = c.StartBundleWithTimeout(userParam, 0)
+	return
+}
+
+// StartBundleWithTimeout starts a bundle that expires after timeout, a zero
+// timeout never expires
+func (c *Chain) StartBundleWithTimeout(userParam interface{}, timeout time.Duration) (err error) {
 	j, err := json.Marshal(userParam)
 	if err != nil {
 		return
 	}
-	c.lk.RLock()
-	defer c.lk.RUnlock()
-	if c.BundleStarted() != nil {
-		err = errors.New("Bundle already started")
-		return
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	parent := c
+	l := c.length()
+	if b := c.BundleStarted(); b != nil {
+		parent = b.chain
+		l = parent.Length()
 	}
 	bundle := Bundle{
-		idx:       c.Length() - 1,
+		idx:       l - 1,
 		chain:     NewChain(c.hashSpec),
 		userParam: string(j),
+		started:   time.Now(),
+		timeout:   timeout,
 	}
 	bundle.sharing = make([]CommittingAction, 0)
-	bundle.chain.bundleOf = c
-	c.bundle = &bundle
+	bundle.chain.bundleOf = parent
+	parent.bundle = &bundle
 	return
 }
 
-// CloseBundle closes a started bundle and if commit
-// copies entries from the bundle onto the chain
+// CloseBundle closes the innermost started bundle and if commit copies its
+// entries onto the chain, or onto the enclosing bundle if it was nested, in
+// which case the enclosing bundle also takes over sharing the entries.
 func (c *Chain) CloseBundle(commit bool) (err error) {
-	c.lk.RLock()
-	defer c.lk.RUnlock()
-	if c.bundle == nil {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	err = c.closeBundle(c.BundleStarted(), commit)
+	return
+}
+
+// closeBundleIfStarted closes the bundle like CloseBundle but only if it's still
+// the innermost started bundle, otherwise it returns ErrBundleNotStarted
+func (c *Chain) closeBundleIfStarted(bundle *Bundle, commit bool) (err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	err = c.closeBundle(bundle, commit)
+	return
+}
+
+// closeBundle, low level bundle close, not thread safe, must be called with the chain locked
+func (c *Chain) closeBundle(bundle *Bundle, commit bool) (err error) {
+	if bundle == nil || bundle != c.BundleStarted() {
 		err = ErrBundleNotStarted
 		return
 	}
-	bundle := c.bundle
-	c.bundle = nil
+	parent := bundle.chain.bundleOf
+	if parent != c {
+		parent.lk.Lock()
+		defer parent.lk.Unlock()
+	}
+	// once closed nothing more can be committed to the bundle
+	bundle.chain.lk.Lock()
+	defer bundle.chain.lk.Unlock()
+	bundle.closed = true
+	parent.bundle = nil
 	if commit {
-		l := c.Length()
+		l := parent.length()
 		for i, header := range bundle.chain.Headers {
-			err = c.addEntry(i+l, bundle.chain.Hashes[i], header, bundle.chain.Entries[i])
+			err = parent.addEntry(i+l, bundle.chain.Hashes[i], header, bundle.chain.Entries[i])
 			if err != nil {
 				return
 			}
 		}
+		if parent.bundleOf != nil {
+			outer := parent.bundleOf.bundle
+			outer.sharing = append(outer.sharing, bundle.sharing...)
+			bundle.sharing = nil
+		}
 	}
 	return
 }
@@ -771,6 +1151,10 @@ func (c *Chain) CloseBundle(commit bool) (err error) {
 func (c *Chain) Close() {
 	c.s.Close()
 	c.s = nil
+	if c.store != nil {
+		c.store.close()
+		c.store = nil
+	}
 }
 
 func appendEntryAsJSON(buffer *bytes.Buffer, hdr *Header, hash *Hash, g *GobEntry) {
@@ -44,7 +44,7 @@ func TestChainNewChainFromFile(t *testing.T) {
 	e = GobEntry{C: "some other data2"}
 	c.AddEntry(now, "entryTypeFoo2", &e, key)
 	dump := c.String()
-	c.s.Close()
+	c.Close()
 	c, err = NewChainFromFile(hashSpec, path)
 	Convey("it should load chain data if available", t, func() {
 		So(err, ShouldBeNil)
@@ -54,7 +54,7 @@ func TestChainNewChainFromFile(t *testing.T) {
 	e = GobEntry{C: "yet other data"}
 	c.AddEntry(now, "yourData", &e, key)
 	dump = c.String()
-	c.s.Close()
+	c.Close()
 
 	c, err = NewChainFromFile(hashSpec, path)
 	Convey("should continue to append data after reload", t, func() {
@@ -753,6 +753,79 @@ func TestChainBundle(t *testing.T) {
 	})
 }
 
+func TestNestedBundles(t *testing.T) {
+	hashSpec, key, now := chainTestSetup()
+	c := NewChain(hashSpec)
+	c.AddEntry(now, DNAEntryType, &GobEntry{C: "fake DNA"}, key)
+	c.AddEntry(now, "entryTypeFoo2", &GobEntry{C: "foo data"}, key)
+
+	Convey("starting a bundle inside a bundle should nest it", t, func() {
+		So(c.BundleDepth(), ShouldEqual, 0)
+		So(c.StartBundle("outer"), ShouldBeNil)
+		outer := c.BundleStarted()
+		_, err := outer.chain.AddEntry(now, "entryTypeFoo1", &GobEntry{C: "outer data"}, key)
+		So(err, ShouldBeNil)
+
+		So(c.StartBundle("inner"), ShouldBeNil)
+		So(c.BundleDepth(), ShouldEqual, 2)
+		inner := c.BundleStarted()
+		So(inner, ShouldNotEqual, outer)
+		So(inner.userParam, ShouldEqual, `"inner"`)
+		So(inner.idx, ShouldEqual, 0)
+		So(inner.chain.bundleOf, ShouldEqual, outer.chain)
+
+		_, err = outer.chain.AddEntry(now, "entryTypeFoo1", &GobEntry{C: "more outer data"}, key)
+		So(err, ShouldEqual, ErrChainLockedForBundle)
+	})
+
+	Convey("entries in the inner bundle should link to the enclosing chains", t, func() {
+		outer := c.bundle
+		inner := c.BundleStarted()
+		hash, err := inner.chain.AddEntry(now, "entryTypeFoo2", &GobEntry{C: "inner data"}, key)
+		So(err, ShouldBeNil)
+		hd, _ := inner.chain.Get(hash)
+		So(hd.HeaderLink.String(), ShouldEqual, outer.chain.Hashes[0].String())
+		So(hd.TypeLink.String(), ShouldEqual, c.Hashes[1].String())
+	})
+
+	Convey("closing the inner bundle without commit should only roll back the inner bundle", t, func() {
+		So(c.CloseBundle(false), ShouldBeNil)
+		So(c.BundleDepth(), ShouldEqual, 1)
+		So(c.BundleStarted().chain.Length(), ShouldEqual, 1)
+		So(c.Length(), ShouldEqual, 2)
+	})
+
+	Convey("closing the inner bundle with commit should add its entries to the outer bundle", t, func() {
+		So(c.StartBundle("inner"), ShouldBeNil)
+		_, err := c.BundleStarted().chain.AddEntry(now, "entryTypeFoo2", &GobEntry{C: "inner data"}, key)
+		So(err, ShouldBeNil)
+		So(c.CloseBundle(true), ShouldBeNil)
+		So(c.BundleDepth(), ShouldEqual, 1)
+		So(c.BundleStarted().chain.Length(), ShouldEqual, 2)
+		So(c.Length(), ShouldEqual, 2)
+
+		So(c.CloseBundle(true), ShouldBeNil)
+		So(c.BundleDepth(), ShouldEqual, 0)
+		So(c.Length(), ShouldEqual, 4)
+		So(c.Validate(false), ShouldBeNil)
+		So(c.CloseBundle(true), ShouldEqual, ErrBundleNotStarted)
+	})
+
+	Convey("an inner bundle of an empty outer bundle should link to the chain", t, func() {
+		So(c.StartBundle("outer"), ShouldBeNil)
+		So(c.StartBundle("inner"), ShouldBeNil)
+		hash, err := c.BundleStarted().chain.AddEntry(now, "entryTypeFoo1", &GobEntry{C: "inner data"}, key)
+		So(err, ShouldBeNil)
+		hd, _ := c.BundleStarted().chain.Get(hash)
+		So(hd.HeaderLink.String(), ShouldEqual, c.Hashes[3].String())
+		So(hd.TypeLink.String(), ShouldEqual, c.Hashes[2].String())
+		So(c.CloseBundle(true), ShouldBeNil)
+		So(c.CloseBundle(true), ShouldBeNil)
+		So(c.Length(), ShouldEqual, 5)
+		So(c.Validate(false), ShouldBeNil)
+	})
+}
+
 /*
 func TestPersistingChain(t *testing.T) {
 	hashSpec, key, now := chainTestSetup()
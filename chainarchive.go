@@ -0,0 +1,363 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements signed chain archives for backing up and migrating an agent's source chain
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	. "github.com/holochain/holochain-proto/hash"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+)
+
+const (
+	// ChainArchiveFormat identifies a file as a chain archive
+	ChainArchiveFormat = "holochain-chain-archive"
+
+	// ChainArchiveVersion is the version of the archive format this code writes and reads
+	ChainArchiveVersion = 1
+)
+
+var ErrChainArchiveBadSignature = errors.New("chain archive signature doesn't verify")
+var ErrChainArchiveRedacted = errors.New("chain archive was exported without its private entries and can't be imported")
+var ErrChainArchiveAgentMismatch = errors.New("chain archive belongs to a different agent")
+var ErrChainArchiveNoKeys = errors.New("chain archive doesn't include the agent's keys")
+var ErrMissingArchivePassphrase = errors.New("chain archive keys require a passphrase")
+var ErrChainDiverges = errors.New("chain archive diverges from the local chain")
+
+// ChainArchive is a self-describing, signed export of an agent's source chain.
+// The chain is serialized with MarshalChain, and private entries are redacted
+// unless the archive was made with them.  The agent's private key may be
+// included encrypted with a passphrase so that the agent can be moved to a new device.
+type ChainArchive struct {
+	Format   string
+	Version  int
+	Created  time.Time
+	DNAHash  string
+	Identity AgentIdentity
+	PubKey   string // b58 encoded public key of the agent that signed the archive
+	Private  bool   // true if private entries are included
+	Keys     []byte `json:",omitempty"` // private key sealed with the archive passphrase
+	Chain    []byte
+	Sig      []byte
+}
+
+// ExportChain creates a signed archive of the holochain's source chain.  If
+// passphrase is not empty the agent's private key is included encrypted with it.
+// Unless includePrivate is set private entries are redacted, and an archive
+// with redacted entries can be verified but not imported.
+func (h *Holochain) ExportChain(includePrivate bool, passphrase string) (a *ChainArchive, err error) {
+	if !h.Started() {
+		err = mkErr("chain not started")
+		return
+	}
+	a = &ChainArchive{
+		Format:   ChainArchiveFormat,
+		Version:  ChainArchiveVersion,
+		Created:  time.Now(),
+		DNAHash:  h.dnaHash.String(),
+		Identity: h.agent.Identity(),
+		Private:  includePrivate,
+	}
+	a.PubKey, err = h.agent.EncodePubKey()
+	if err != nil {
+		return
+	}
+
+	var privateTypeNames []string
+	if !includePrivate {
+		for _, def := range h.GetPrivateEntryDefs() {
+			privateTypeNames = append(privateTypeNames, def.Name)
+		}
+	}
+	var b bytes.Buffer
+	err = h.chain.MarshalChain(&b, ChainMarshalFlagsNone, nil, privateTypeNames)
+	if err != nil {
+		return
+	}
+	a.Chain = b.Bytes()
+
+	if passphrase != "" {
+		var c *DataCipher
+		c, err = archiveKeysCipher(passphrase, a.PubKey)
+		if err != nil {
+			return
+		}
+		var k []byte
+		k, err = h.agent.PrivKey().Bytes()
+		if err != nil {
+			return
+		}
+		a.Keys, err = c.Seal(k)
+		if err != nil {
+			return
+		}
+	}
+
+	var data []byte
+	data, err = a.signedData()
+	if err != nil {
+		return
+	}
+	a.Sig, err = h.agent.PrivKey().Sign(data)
+	return
+}
+
+// archiveKeysCipher returns the cipher for the keys in an archive, salted with
+// the agent's public key
+func archiveKeysCipher(passphrase string, pubKey string) (c *DataCipher, err error) {
+	if passphrase == "" {
+		err = ErrMissingArchivePassphrase
+		return
+	}
+	c, err = NewPassphraseDataCipher(passphrase, b58.Decode(pubKey))
+	return
+}
+
+// signedData returns the bytes of the archive that are covered by the signature
+func (a *ChainArchive) signedData() (data []byte, err error) {
+	unsigned := *a
+	unsigned.Sig = nil
+	data, err = json.Marshal(&unsigned)
+	return
+}
+
+// Encode writes the archive to a writer
+func (a *ChainArchive) Encode(writer io.Writer) (err error) {
+	err = json.NewEncoder(writer).Encode(a)
+	return
+}
+
+// DecodeChainArchive reads an archive from a reader
+func DecodeChainArchive(reader io.Reader) (a *ChainArchive, err error) {
+	var archive ChainArchive
+	err = json.NewDecoder(reader).Decode(&archive)
+	if err != nil {
+		return
+	}
+	if archive.Format != ChainArchiveFormat {
+		err = errors.New("not a chain archive")
+		return
+	}
+	if archive.Version != ChainArchiveVersion {
+		err = fmt.Errorf("unsupported chain archive version: %d", archive.Version)
+		return
+	}
+	a = &archive
+	return
+}
+
+// Verify checks the archive's signature and that its chain is intact and
+// belongs to the agent and DNA the archive claims.  It returns the archived chain.
+func (a *ChainArchive) Verify(hashSpec HashSpec) (c *Chain, err error) {
+	var pubKey ic.PubKey
+	pubKey, err = DecodePubKey(a.PubKey)
+	if err != nil {
+		return
+	}
+	var data []byte
+	data, err = a.signedData()
+	if err != nil {
+		return
+	}
+	var matches bool
+	matches, err = pubKey.Verify(data, a.Sig)
+	if err != nil {
+		return
+	}
+	if !matches {
+		err = ErrChainArchiveBadSignature
+		return
+	}
+
+	_, c, err = UnmarshalChain(hashSpec, bytes.NewBuffer(a.Chain))
+	if err != nil {
+		return
+	}
+	if c.Length() < 2 {
+		err = errors.New("chain archive has no genesis entries")
+		return
+	}
+	if redacted(c) {
+		err = ErrChainArchiveRedacted
+		return
+	}
+	err = c.Validate(false)
+	if err != nil {
+		return
+	}
+
+	if c.Headers[0].EntryLink.String() != a.DNAHash {
+		err = errors.New("chain archive DNA hash doesn't match its chain")
+		return
+	}
+	var entry AgentEntry
+	_, top := c.TopType(AgentEntryType)
+	if top == nil {
+		err = errors.New("chain archive has no agent entry")
+		return
+	}
+	j, ok := c.Entries[c.Emap[top.EntryLink]].Content().(string)
+	if !ok {
+		err = errors.New("chain archive has a malformed agent entry")
+		return
+	}
+	err = json.Unmarshal([]byte(j), &entry)
+	if err != nil {
+		return
+	}
+	if entry.PublicKey != a.PubKey {
+		err = ErrChainArchiveAgentMismatch
+	}
+	return
+}
+
+// Redacted returns true if private entries were redacted from the archived
+// chain, in which case the archive can't be imported
+func (a *ChainArchive) Redacted(hashSpec HashSpec) (isRedacted bool, err error) {
+	var c *Chain
+	_, c, err = UnmarshalChain(hashSpec, bytes.NewBuffer(a.Chain))
+	if err != nil {
+		return
+	}
+	isRedacted = redacted(c)
+	return
+}
+
+// redacted returns true if any of the entries of an unmarshaled chain were redacted
+func redacted(c *Chain) bool {
+	for _, e := range c.Entries {
+		if e.Content() == ChainMarshalPrivateEntryRedacted {
+			return true
+		}
+	}
+	return false
+}
+
+// Agent returns the agent stored in the archive, decrypting its keys with the passphrase
+func (a *ChainArchive) Agent(passphrase string) (agent Agent, err error) {
+	if len(a.Keys) == 0 {
+		err = ErrChainArchiveNoKeys
+		return
+	}
+	var c *DataCipher
+	c, err = archiveKeysCipher(passphrase, a.PubKey)
+	if err != nil {
+		return
+	}
+	var k []byte
+	k, err = c.Open(a.Keys)
+	if err != nil {
+		return
+	}
+	la := LibP2PAgent{identity: a.Identity}
+	la.priv, err = ic.UnmarshalPrivateKey(k)
+	if err != nil {
+		return
+	}
+	la.pub = la.priv.GetPublic()
+	agent = &la
+	return
+}
+
+// ImportChain verifies an archive and adds the entries it holds that are
+// missing from the holochain's source chain.  The local chain must be empty or
+// share its history with the archived chain, and the archive must be for the
+// holochain's DNA and agent.  A chain that is imported into an unstarted holochain is started
+// from the archived genesis entries without re-running genesis.
+func (h *Holochain) ImportChain(a *ChainArchive) (added int, err error) {
+	var c *Chain
+	c, err = a.Verify(h.hashSpec)
+	if err != nil {
+		return
+	}
+
+	var pubKey string
+	pubKey, err = h.agent.EncodePubKey()
+	if err != nil {
+		return
+	}
+	if pubKey != a.PubKey {
+		err = ErrChainArchiveAgentMismatch
+		return
+	}
+
+	started := h.Started()
+	var dnaHash Hash
+	if started {
+		dnaHash = h.dnaHash
+	} else {
+		var buf bytes.Buffer
+		err = h.EncodeDNA(&buf)
+		if err != nil {
+			return
+		}
+		e := GobEntry{C: buf.Bytes()}
+		dnaHash, err = e.Sum(h.hashSpec)
+		if err != nil {
+			return
+		}
+	}
+	if dnaHash.String() != a.DNAHash {
+		err = errors.New("chain archive is for a different DNA")
+		return
+	}
+
+	err = h.importPairs(c, &added)
+	if err != nil || started {
+		return
+	}
+
+	h.dnaHash = c.Headers[0].EntryLink.Clone()
+	h.agentHash = c.Headers[1].EntryLink
+	_, top := c.TopType(AgentEntryType)
+	h.agentTopHash = top.EntryLink
+	if err = WriteFile([]byte(h.dnaHash.String()), h.rootPath, DNAHashFileName); err != nil {
+		return
+	}
+	if err = h.Prepare(); err != nil {
+		return
+	}
+	err = h.dht.SetupDHT()
+	return
+}
+
+// importPairs appends the entries of c that come after the end of the local
+// chain, an archive that is behind the local chain adds nothing
+func (h *Holochain) importPairs(c *Chain, added *int) (err error) {
+	h.chain.lk.Lock()
+	defer h.chain.lk.Unlock()
+	l := h.chain.length()
+	n := c.Length()
+	if l < n {
+		n = l
+	}
+	for i := 0; i < n; i++ {
+		var hash Hash
+		hash, err = h.chain.hash(i)
+		if err != nil {
+			return
+		}
+		if !hash.Equal(c.Hashes[i]) {
+			err = ErrChainDiverges
+			return
+		}
+	}
+	for i := l; i < c.Length(); i++ {
+		err = h.chain.addEntry(i, c.Hashes[i], c.Headers[i], c.Entries[i])
+		if err != nil {
+			return
+		}
+		*added++
+	}
+	return
+}
@@ -0,0 +1,164 @@
+package holochain
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChainArchive(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	commit(h, "evenNumbers", "2")
+	h.NewEntry(time.Now(), "privateData", &GobEntry{C: "sekrit private data"})
+
+	Convey("it should export a signed archive of the chain", t, func() {
+		a, err := h.ExportChain(true, "")
+		So(err, ShouldBeNil)
+		So(a.Format, ShouldEqual, ChainArchiveFormat)
+		So(a.DNAHash, ShouldEqual, h.dnaHash.String())
+		So(a.Identity, ShouldEqual, h.agent.Identity())
+		So(len(a.Keys), ShouldEqual, 0)
+
+		var buf bytes.Buffer
+		So(a.Encode(&buf), ShouldBeNil)
+		a, err = DecodeChainArchive(&buf)
+		So(err, ShouldBeNil)
+		c, err := a.Verify(h.hashSpec)
+		So(err, ShouldBeNil)
+		So(c.String(), ShouldEqual, h.chain.String())
+
+		_, err = DecodeChainArchive(bytes.NewBufferString(`{"Format":"bogus"}`))
+		So(err.Error(), ShouldEqual, "not a chain archive")
+	})
+
+	Convey("it should reject an archive that has been tampered with", t, func() {
+		a, _ := h.ExportChain(true, "")
+		a.DNAHash = "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2"
+		_, err := a.Verify(h.hashSpec)
+		So(err, ShouldEqual, ErrChainArchiveBadSignature)
+	})
+
+	Convey("private entries should be redacted unless requested and a redacted archive can't be imported", t, func() {
+		a, err := h.ExportChain(false, "")
+		So(err, ShouldBeNil)
+		So(string(a.Chain), ShouldContainSubstring, ChainMarshalPrivateEntryRedacted)
+		So(string(a.Chain), ShouldNotContainSubstring, "sekrit private data")
+		isRedacted, err := a.Redacted(h.hashSpec)
+		So(err, ShouldBeNil)
+		So(isRedacted, ShouldBeTrue)
+		_, err = a.Verify(h.hashSpec)
+		So(err, ShouldEqual, ErrChainArchiveRedacted)
+		_, err = h.ImportChain(a)
+		So(err, ShouldEqual, ErrChainArchiveRedacted)
+	})
+
+	Convey("it should include the agent's keys encrypted with a passphrase", t, func() {
+		a, err := h.ExportChain(true, "some passphrase")
+		So(err, ShouldBeNil)
+		isRedacted, err := a.Redacted(h.hashSpec)
+		So(err, ShouldBeNil)
+		So(isRedacted, ShouldBeFalse)
+		So(len(a.Keys), ShouldBeGreaterThan, 0)
+		_, err = a.Verify(h.hashSpec)
+		So(err, ShouldBeNil)
+
+		agent, err := a.Agent("some passphrase")
+		So(err, ShouldBeNil)
+		So(agent.Identity(), ShouldEqual, h.agent.Identity())
+		So(ic.KeyEqual(agent.PrivKey(), h.agent.PrivKey()), ShouldBeTrue)
+
+		_, err = a.Agent("wrong passphrase")
+		So(err, ShouldEqual, ErrDataDecryptionFailed)
+		_, err = a.Agent("")
+		So(err, ShouldEqual, ErrMissingArchivePassphrase)
+
+		a, _ = h.ExportChain(true, "")
+		_, err = a.Agent("some passphrase")
+		So(err, ShouldEqual, ErrChainArchiveNoKeys)
+	})
+
+	Convey("importing should add the entries the local chain is missing", t, func() {
+		old, _ := h.ExportChain(true, "")
+		l := h.chain.Length()
+		commit(h, "evenNumbers", "4")
+		a, _ := h.ExportChain(true, "")
+
+		added, err := h.ImportChain(a)
+		So(err, ShouldBeNil)
+		So(added, ShouldEqual, 0)
+
+		So(h.chain.store.truncate(h.hashSpec, l), ShouldBeNil)
+		So(h.chain.Length(), ShouldEqual, l)
+		added, err = h.ImportChain(a)
+		So(err, ShouldBeNil)
+		So(added, ShouldEqual, 1)
+		So(h.chain.Length(), ShouldEqual, l+1)
+		So(h.chain.Verify(ChainVerificationFull), ShouldBeNil)
+
+		// an archive that is behind the local chain has nothing to add
+		added, err = h.ImportChain(old)
+		So(err, ShouldBeNil)
+		So(added, ShouldEqual, 0)
+	})
+
+	Convey("importing should refuse a diverging chain", t, func() {
+		a, _ := h.ExportChain(true, "")
+		l := h.chain.Length()
+		So(h.chain.store.truncate(h.hashSpec, l-1), ShouldBeNil)
+		commit(h, "evenNumbers", "6")
+		_, err := h.ImportChain(a)
+		So(err, ShouldEqual, ErrChainDiverges)
+		So(h.chain.Length(), ShouldEqual, l)
+	})
+
+	Convey("importing should refuse an archive from another agent", t, func() {
+		a, _ := h.ExportChain(true, "")
+		agent := h.agent
+		defer func() { h.agent = agent }()
+		h.agent, _ = NewAgent(LibP2P, "Joe", MakeTestSeed("Joe"))
+		_, err := h.ImportChain(a)
+		So(err, ShouldEqual, ErrChainArchiveAgentMismatch)
+	})
+}
+
+func TestChainArchiveImportUnstarted(t *testing.T) {
+	d, s, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+	commit(h, "evenNumbers", "2")
+
+	a, err := h.ExportChain(true, "")
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = s.Clone(filepath.Join(s.Path, "test"), filepath.Join(s.Path, "test2"), h.agent, CloneWithSameUUID, InitializeDB)
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("importing into an unstarted chain should start it from the archive", t, func() {
+		h2, err := s.Load("test2")
+		So(err, ShouldBeNil)
+		So(h2.Started(), ShouldBeFalse)
+		h2.Config.DHTPort = h.Config.DHTPort + 1
+
+		added, err := h2.ImportChain(a)
+		So(err, ShouldBeNil)
+		defer h2.Close()
+		So(added, ShouldEqual, h.chain.Length())
+		So(h2.Started(), ShouldBeTrue)
+		So(h2.DNAHash().String(), ShouldEqual, h.DNAHash().String())
+		So(h2.AgentHash().String(), ShouldEqual, h.AgentHash().String())
+		So(h2.chain.String(), ShouldEqual, h.chain.String())
+
+		b, err := ReadFile(h2.rootPath, DNAHashFileName)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, h.DNAHash().String())
+	})
+}
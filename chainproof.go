@@ -0,0 +1,269 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements Merkle inclusion proofs for source chain entries
+
+package holochain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	. "github.com/holochain/holochain-proto/hash"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+var ErrChainProofInvalid = errors.New("chain proof doesn't verify")
+
+// the prefixes that keep the leaves of a chain's Merkle tree from being
+// confused with its interior nodes
+const (
+	chainProofLeafPrefix = 0x00
+	chainProofNodePrefix = 0x01
+)
+
+// ChainProof proves that a header is at Index in an agent's chain of Length
+// headers.  The hashes of the chain's headers are the leaves of a Merkle tree
+// whose root the agent signs, so the proof needs only the header, the sibling
+// hashes on the path from its leaf to the root, and the agent's public key.
+type ChainProof struct {
+	Index  int
+	Length int
+	Header Header
+	Path   []Hash // sibling hashes from the header's leaf up to the root
+	Root   Hash
+	PubKey string // b58 encoded public key of the agent whose chain it is
+	Sig    Signature
+}
+
+// chainProofLeaf returns the leaf of the Merkle tree for a header hash
+func chainProofLeaf(hashSpec HashSpec, hash Hash) (Hash, error) {
+	return Sum(hashSpec, append([]byte{chainProofLeafPrefix}, []byte(hash)...))
+}
+
+// chainProofNode returns the interior node of the Merkle tree above two nodes
+func chainProofNode(hashSpec HashSpec, left Hash, right Hash) (Hash, error) {
+	b := append([]byte{chainProofNodePrefix}, []byte(left)...)
+	return Sum(hashSpec, append(b, []byte(right)...))
+}
+
+// chainProofSignedData returns the bytes the agent signs to commit to a root
+func chainProofSignedData(root Hash, length int) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(length))
+	return append(b, []byte(root)...)
+}
+
+// chainMerkleTree returns the root of the Merkle tree over a chain's header
+// hashes and the path of sibling hashes from the ith leaf up to the root.
+// The nodes of each level are paired up and an odd node at the end of a
+// level is carried up to the next as it is.
+func chainMerkleTree(hashSpec HashSpec, hashes []Hash, i int) (root Hash, path []Hash, err error) {
+	level := make([]Hash, len(hashes))
+	for j, h := range hashes {
+		level[j], err = chainProofLeaf(hashSpec, h)
+		if err != nil {
+			return
+		}
+	}
+	for len(level) > 1 {
+		if sibling := i ^ 1; sibling < len(level) {
+			path = append(path, level[sibling])
+		}
+		next := make([]Hash, 0, (len(level)+1)/2)
+		for j := 0; j < len(level); j += 2 {
+			if j+1 == len(level) {
+				next = append(next, level[j])
+				continue
+			}
+			var node Hash
+			node, err = chainProofNode(hashSpec, level[j], level[j+1])
+			if err != nil {
+				return
+			}
+			next = append(next, node)
+		}
+		level = next
+		i /= 2
+	}
+	root = level[0]
+	return
+}
+
+// headerHashes returns the hashes of the first n headers.  Not thread safe.
+func (c *Chain) headerHashes(n int) (hashes []Hash, err error) {
+	hashes = make([]Hash, n)
+	for i := 0; i < n; i++ {
+		hashes[i], err = c.hash(i)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Prove makes a proof that the header with the given hash, or the header of
+// the entry with the given hash, is in the chain, signed with privKey
+func (c *Chain) Prove(hash Hash, privKey ic.PrivKey) (proof *ChainProof, err error) {
+	c.lk.RLock()
+	defer c.lk.RUnlock()
+
+	i, ok, err := c.headerIdx(hash)
+	if err != nil {
+		return
+	}
+	if !ok {
+		i, ok, err = c.entryIdx(hash)
+		if err != nil {
+			return
+		}
+		if !ok {
+			err = ErrHashNotFound
+			return
+		}
+	}
+
+	var header *Header
+	header, err = c.header(i)
+	if err != nil {
+		return
+	}
+	l := c.length()
+	p := ChainProof{Index: i, Length: l, Header: *header}
+
+	var hashes []Hash
+	hashes, err = c.headerHashes(l)
+	if err != nil {
+		return
+	}
+	p.Root, p.Path, err = chainMerkleTree(c.hashSpec, hashes, i)
+	if err != nil {
+		return
+	}
+
+	var pk []byte
+	pk, err = ic.MarshalPublicKey(privKey.GetPublic())
+	if err != nil {
+		return
+	}
+	p.PubKey = b58.Encode(pk)
+	p.Sig.S, err = privKey.Sign(chainProofSignedData(p.Root, p.Length))
+	if err != nil {
+		return
+	}
+	proof = &p
+	return
+}
+
+// VerifyChainProof checks that the proof's header was signed by the agent and
+// that it is at the proof's index in the chain whose root the agent signed
+func VerifyChainProof(hashSpec HashSpec, proof *ChainProof) (err error) {
+	if proof.Index < 0 || proof.Index >= proof.Length {
+		err = fmt.Errorf("chain proof index %d out of range for chain of %d headers", proof.Index, proof.Length)
+		return
+	}
+	var pubKey ic.PubKey
+	pubKey, err = DecodePubKey(proof.PubKey)
+	if err != nil {
+		return
+	}
+	var matches bool
+	matches, err = pubKey.Verify([]byte(proof.Header.EntryLink), proof.Header.Sig.S)
+	if err != nil {
+		return
+	}
+	if !matches {
+		err = ErrChainProofInvalid
+		return
+	}
+
+	var hash Hash
+	hash, _, err = proof.Header.Sum(hashSpec)
+	if err != nil {
+		return
+	}
+	hash, err = chainProofLeaf(hashSpec, hash)
+	if err != nil {
+		return
+	}
+	i, n, used := proof.Index, proof.Length, 0
+	for n > 1 {
+		if sibling := i ^ 1; sibling < n {
+			if used == len(proof.Path) {
+				err = ErrChainProofInvalid
+				return
+			}
+			if i%2 == 0 {
+				hash, err = chainProofNode(hashSpec, hash, proof.Path[used])
+			} else {
+				hash, err = chainProofNode(hashSpec, proof.Path[used], hash)
+			}
+			if err != nil {
+				return
+			}
+			used++
+		}
+		i /= 2
+		n = (n + 1) / 2
+	}
+	if used != len(proof.Path) || !hash.Equal(proof.Root) {
+		err = ErrChainProofInvalid
+		return
+	}
+
+	matches, err = pubKey.Verify(chainProofSignedData(proof.Root, proof.Length), proof.Sig.S)
+	if err != nil {
+		return
+	}
+	if !matches {
+		err = ErrChainProofInvalid
+	}
+	return
+}
+
+// Agent returns the ID of the agent whose chain the proof is for
+func (proof *ChainProof) Agent() (ID peer.ID, err error) {
+	var pubKey ic.PubKey
+	pubKey, err = DecodePubKey(proof.PubKey)
+	if err != nil {
+		return
+	}
+	ID, err = peer.IDFromPublicKey(pubKey)
+	return
+}
+
+// ToJSON serializes what a verified proof establishes for the app's validation functions
+func (proof *ChainProof) ToJSON() (result string, err error) {
+	var hdr string
+	hdr, err = proof.Header.ToJSON()
+	if err != nil {
+		return
+	}
+	var agent peer.ID
+	agent, err = proof.Agent()
+	if err != nil {
+		return
+	}
+	result = fmt.Sprintf(`{"Index":%d,"Length":%d,"Header":%s,"Agent":"%s"}`, proof.Index, proof.Length, hdr, peer.IDB58Encode(agent))
+	return
+}
+
+// chainProofsJSON serializes a package's proofs as a JSON object
+func chainProofsJSON(proofs []ChainProof) (result string, err error) {
+	var s []string
+	for i := range proofs {
+		var j string
+		j, err = proofs[i].ToJSON()
+		if err != nil {
+			return
+		}
+		s = append(s, j)
+	}
+	result = `{"Proofs":[` + strings.Join(s, ",") + `]}`
+	return
+}
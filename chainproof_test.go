@@ -0,0 +1,81 @@
+package holochain
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChainProof(t *testing.T) {
+	hashSpec, key, now := chainTestSetup()
+	c := NewChain(hashSpec)
+
+	Convey("a proof of every header in chains of any length should verify", t, func() {
+		for l := 1; l <= 9; l++ {
+			c.AddEntry(now, "entryTypeFoo", &GobEntry{C: fmt.Sprintf("some data%d", l)}, key)
+			for i := 0; i < l; i++ {
+				proof, err := c.Prove(c.Hashes[i], key)
+				So(err, ShouldBeNil)
+				So(proof.Index, ShouldEqual, i)
+				So(proof.Length, ShouldEqual, l)
+				So(VerifyChainProof(hashSpec, proof), ShouldBeNil)
+			}
+		}
+	})
+
+	Convey("a proof should be compact", t, func() {
+		proof, _ := c.Prove(c.Hashes[4], key)
+		So(len(proof.Path), ShouldEqual, 4)
+	})
+
+	Convey("it should prove an entry by its hash and fail on unknown hashes", t, func() {
+		proof, err := c.Prove(c.Headers[3].EntryLink, key)
+		So(err, ShouldBeNil)
+		So(proof.Index, ShouldEqual, 3)
+		bogus, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		_, err = c.Prove(bogus, key)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("the proof should identify the agent", t, func() {
+		proof, _ := c.Prove(c.Hashes[0], key)
+		agent, err := proof.Agent()
+		So(err, ShouldBeNil)
+		id, _ := peer.IDFromPrivateKey(key)
+		So(agent, ShouldEqual, id)
+	})
+
+	Convey("a proof that has been tampered with should fail", t, func() {
+		proof, _ := c.Prove(c.Hashes[4], key)
+
+		p := *proof
+		p.Index = 5
+		So(VerifyChainProof(hashSpec, &p), ShouldEqual, ErrChainProofInvalid)
+		p.Index = 9
+		So(VerifyChainProof(hashSpec, &p), ShouldNotBeNil)
+
+		p = *proof
+		p.Length = 8
+		So(VerifyChainProof(hashSpec, &p), ShouldEqual, ErrChainProofInvalid)
+
+		p = *proof
+		p.Path = append([]Hash{}, proof.Path...)
+		p.Path[1] = p.Path[0]
+		So(VerifyChainProof(hashSpec, &p), ShouldEqual, ErrChainProofInvalid)
+
+		p = *proof
+		p.Header.Type = "entryTypeBar"
+		So(VerifyChainProof(hashSpec, &p), ShouldEqual, ErrChainProofInvalid)
+
+		// a proof signed by a different agent
+		a, _ := NewAgent(LibP2P, "Joe", MakeTestSeed("Joe"))
+		other, _ := c.Prove(c.Hashes[4], a.PrivKey())
+		So(VerifyChainProof(hashSpec, other), ShouldEqual, ErrChainProofInvalid)
+		p = *proof
+		p.Sig = other.Sig
+		So(VerifyChainProof(hashSpec, &p), ShouldEqual, ErrChainProofInvalid)
+	})
+}
@@ -0,0 +1,556 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements an indexed on-disk store for chains so that headers and entries are
+// read from the chain file when they are needed instead of all being loaded into memory.
+// The chain file format is unchanged, the index is kept in a boltdb file along side it
+// and is rebuilt from the chain file if it is missing or out of date.
+
+package holochain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	. "github.com/holochain/holochain-proto/hash"
+)
+
+// ChainIndexFileSuffix is appended to the chain file name to get the name of its index
+const ChainIndexFileSuffix = ".idx"
+
+// ChainTornFileSuffix is appended to the chain file name to get the name of the file
+// that data cut from the end of the chain file is saved to
+const ChainTornFileSuffix = ".torn"
+
+// chainIndexLockTimeout is how long to wait for an index held open by another process
+const chainIndexLockTimeout = time.Second
+
+var (
+	chainRecordBucket = []byte("record") // index -> chainRecord
+	chainHeaderBucket = []byte("header") // header hash -> index
+	chainEntryBucket  = []byte("entry")  // entry hash -> index
+	chainTypeBucket   = []byte("type")   // entry type -> index of top header of that type
+	chainMetaBucket   = []byte("meta")
+
+	chainLengthKey = []byte("length")
+	chainSizeKey   = []byte("size")
+
+	chainBuckets = [][]byte{chainRecordBucket, chainHeaderBucket, chainEntryBucket, chainTypeBucket, chainMetaBucket}
+)
+
+var ErrChainIndexCorrupt = errors.New("chain index corrupt")
+
+// chainRecord locates a header and its entry in the chain file
+type chainRecord struct {
+	header int64 // offset of the header
+	entry  int64 // offset of the entry
+	end    int64 // offset of the byte after the entry
+	hash   Hash  // hash of the header
+}
+
+func (r *chainRecord) marshal() []byte {
+	b := make([]byte, 24+len(r.hash))
+	binary.BigEndian.PutUint64(b[0:], uint64(r.header))
+	binary.BigEndian.PutUint64(b[8:], uint64(r.entry))
+	binary.BigEndian.PutUint64(b[16:], uint64(r.end))
+	copy(b[24:], []byte(r.hash))
+	return b
+}
+
+func (r *chainRecord) unmarshal(b []byte) (err error) {
+	if len(b) < 24 {
+		err = ErrChainIndexCorrupt
+		return
+	}
+	r.header = int64(binary.BigEndian.Uint64(b[0:]))
+	r.entry = int64(binary.BigEndian.Uint64(b[8:]))
+	r.end = int64(binary.BigEndian.Uint64(b[16:]))
+	r.hash = Hash(string(b[24:]))
+	return
+}
+
+// chainStore gives indexed access to the headers and entries in a chain file.
+// Stores are shared by all the chains in a process that are opened on the same
+// file, so that they all see the same index.
+type chainStore struct {
+	path   string
+	f      *os.File // read only handle on the chain file
+	info   os.FileInfo
+	db     *bolt.DB
+	length int   // number of indexed headers
+	size   int64 // number of bytes of the chain file that have been indexed
+	refs   int
+	lk     sync.RWMutex
+}
+
+var chainStores = make(map[string]*chainStore)
+var chainStoresLk sync.Mutex
+
+// openChainStore returns the store for the chain file at path, building or
+// updating its index as necessary
+func openChainStore(spec HashSpec, path string) (s *chainStore, err error) {
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	chainStoresLk.Lock()
+	defer chainStoresLk.Unlock()
+
+	var info os.FileInfo
+	info, err = os.Stat(path)
+	if err != nil {
+		return
+	}
+	s = chainStores[path]
+	if s != nil {
+		if os.SameFile(s.info, info) {
+			s.refs++
+			return
+		}
+		// the file has been replaced so close the stale store, which also
+		// releases the lock it holds on the index file
+		s.db.Close()
+		s.f.Close()
+		delete(chainStores, path)
+	}
+
+	s = &chainStore{path: path, info: info, refs: 1}
+	s.f, err = os.Open(path)
+	if err != nil {
+		return
+	}
+	s.db, err = bolt.Open(path+ChainIndexFileSuffix, 0600, &bolt.Options{Timeout: chainIndexLockTimeout})
+	if err == nil {
+		err = s.index(spec)
+		if err != nil {
+			s.db.Close()
+		}
+	}
+	if err != nil {
+		s.f.Close()
+		s = nil
+		return
+	}
+	chainStores[path] = s
+	return
+}
+
+// close releases the store when the last chain using it is closed
+func (s *chainStore) close() {
+	chainStoresLk.Lock()
+	defer chainStoresLk.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return
+	}
+	s.db.Close()
+	s.f.Close()
+	if chainStores[s.path] == s {
+		delete(chainStores, s.path)
+	}
+}
+
+// index brings the index up to date with the chain file, indexing any records
+// appended since it was last updated, or rebuilding it if it doesn't match the file
+func (s *chainStore) index(spec HashSpec) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	err = s.update(spec, false)
+	return
+}
+
+// update indexes the records in the chain file after the last indexed one.  If
+// the file ends part way through its last record, most likely from an
+// interrupted write, the file is cut back to the end of the last complete
+// record.  Any other record that can't be read, including one that runs past
+// the end of the file but is followed by other records, is reported as a
+// *ChainIntegrityError and the file is left as it is for repair to deal with.
+// Not thread safe, must be called with the store locked.
+func (s *chainStore) update(spec HashSpec, rebuild bool) (err error) {
+	var fi os.FileInfo
+	fi, err = s.f.Stat()
+	if err != nil {
+		return
+	}
+	fileSize := fi.Size()
+
+	err = s.db.Update(func(tx *bolt.Tx) (err error) {
+		for _, b := range chainBuckets {
+			_, err = tx.CreateBucketIfNotExists(b)
+			if err != nil {
+				return
+			}
+		}
+		meta := tx.Bucket(chainMetaBucket)
+		s.length = boltGetInt(meta, chainLengthKey)
+		s.size = int64(boltGetInt(meta, chainSizeKey))
+
+		if s.size > fileSize {
+			Debugf("chain index of %s is ahead of the chain file, rebuilding", s.path)
+			rebuild = true
+		}
+		if rebuild {
+			for _, b := range chainBuckets {
+				err = tx.DeleteBucket(b)
+				if err != nil {
+					return
+				}
+				_, err = tx.CreateBucket(b)
+				if err != nil {
+					return
+				}
+			}
+			meta = tx.Bucket(chainMetaBucket)
+			s.length = 0
+			s.size = 0
+		}
+		if s.size == fileSize {
+			return
+		}
+
+		prev := NullHash()
+		if s.length > 0 {
+			var last chainRecord
+			err = last.unmarshal(tx.Bucket(chainRecordBucket).Get(itob(s.length - 1)))
+			if err != nil {
+				return
+			}
+			prev = last.hash
+		}
+
+		r := &countingReader{r: bufio.NewReader(io.NewSectionReader(s.f, s.size, fileSize-s.size))}
+		for {
+			start := r.n
+			rec := chainRecord{header: s.size + start}
+			var hd Header
+			err = UnmarshalHeader(r, &hd, 34)
+			if err == io.EOF && r.n == start {
+				err = nil
+				break
+			}
+			headerRead := err == nil
+			if headerRead {
+				rec.entry = s.size + r.n
+				err = skipEntry(r, fileSize-s.size-r.n)
+			}
+			if err != nil {
+				offset := s.size + start
+				if err != io.EOF && err != io.ErrUnexpectedEOF {
+					err = &ChainIntegrityError{Index: s.length, Reason: fmt.Sprintf("unreadable record at offset %d: %v", offset, err)}
+					return
+				}
+				links := []Hash{prev}
+				if headerRead {
+					var hash Hash
+					hash, _, err = hd.Sum(spec)
+					if err != nil {
+						return
+					}
+					links = append(links, hash)
+				}
+				var followed bool
+				followed, err = s.followedByRecord(offset, fileSize, links)
+				if err != nil {
+					return
+				}
+				if followed {
+					err = &ChainIntegrityError{Index: s.length, Reason: fmt.Sprintf("record at offset %d runs past the end of the file but is followed by other records", offset)}
+					return
+				}
+				err = s.cutTorn(offset, fileSize)
+				if err != nil {
+					return
+				}
+				r.n = start
+				break
+			}
+			rec.end = s.size + r.n
+			rec.hash, _, err = hd.Sum(spec)
+			if err != nil {
+				return
+			}
+			err = s.putRecord(tx, s.length, &rec, &hd)
+			if err != nil {
+				return
+			}
+			prev = rec.hash
+			s.length++
+		}
+		s.size += r.n
+		err = meta.Put(chainLengthKey, itob(s.length))
+		if err != nil {
+			return
+		}
+		err = meta.Put(chainSizeKey, itob(int(s.size)))
+		return
+	})
+	return
+}
+
+// skipEntry reads past an entry in the chain file checking that all of it is there
+func skipEntry(r io.Reader, remaining int64) (err error) {
+	var l uint64
+	err = binary.Read(r, binary.LittleEndian, &l)
+	if err != nil {
+		return
+	}
+	if l > uint64(remaining-8) {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	_, err = io.CopyN(ioutil.Discard, r, int64(l))
+	return
+}
+
+// followedByRecord reports whether a header that links to one of the given
+// hashes starts anywhere after the record at offset.  If one does, the record
+// isn't the last one in the file, so it can't have been torn by an interrupted
+// write and the length it was read with must be corrupt.
+func (s *chainStore) followedByRecord(offset int64, fileSize int64, links []Hash) (followed bool, err error) {
+	b := make([]byte, fileSize-offset)
+	_, err = s.f.ReadAt(b, offset)
+	if err != nil {
+		return
+	}
+	for _, link := range links {
+		if link.IsNullHash() {
+			continue
+		}
+		l := []byte(link)
+		for i := 0; ; {
+			p := bytes.Index(b[i:], l)
+			if p < 0 {
+				break
+			}
+			p += i
+			i = p + 1
+			// the header link is preceded by the length of the header's type,
+			// the type and 15 bytes of time
+			for n := 0; n < 256; n++ {
+				q := p - 16 - n
+				if q < 1 {
+					break
+				}
+				if b[q] != byte(n) {
+					continue
+				}
+				var hd Header
+				if UnmarshalHeader(bytes.NewReader(b[q:]), &hd, 34) == nil && hd.HeaderLink.Equal(link) {
+					followed = true
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// cutTorn truncates the chain file at offset, appending the data that was cut
+// to the torn file so that nothing is lost
+func (s *chainStore) cutTorn(offset int64, fileSize int64) (err error) {
+	Infof("chain file %s has an incomplete record at offset %d, truncating it", s.path, offset)
+	b := make([]byte, fileSize-offset)
+	_, err = s.f.ReadAt(b, offset)
+	if err != nil {
+		return
+	}
+	var f *os.File
+	f, err = os.OpenFile(s.path+ChainTornFileSuffix, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(b)
+	f.Close()
+	if err != nil {
+		return
+	}
+	err = os.Truncate(s.path, offset)
+	return
+}
+
+// truncate cuts the chain back to its first i headers and rebuilds the index
+func (s *chainStore) truncate(spec HashSpec, i int) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	var rec chainRecord
+	rec, err = s.record(i)
+	if err != nil {
+		return
+	}
+	var fi os.FileInfo
+	fi, err = s.f.Stat()
+	if err != nil {
+		return
+	}
+	err = s.cutTorn(rec.header, fi.Size())
+	if err != nil {
+		return
+	}
+	err = s.update(spec, true)
+	return
+}
+
+func (s *chainStore) putRecord(tx *bolt.Tx, i int, rec *chainRecord, header *Header) (err error) {
+	idx := itob(i)
+	err = tx.Bucket(chainRecordBucket).Put(idx, rec.marshal())
+	if err != nil {
+		return
+	}
+	err = tx.Bucket(chainHeaderBucket).Put([]byte(rec.hash), idx)
+	if err != nil {
+		return
+	}
+	err = tx.Bucket(chainEntryBucket).Put([]byte(header.EntryLink), idx)
+	if err != nil {
+		return
+	}
+	err = tx.Bucket(chainTypeBucket).Put([]byte(header.Type), idx)
+	return
+}
+
+// append writes a header and entry to the end of the chain file and indexes them
+func (s *chainStore) append(w io.Writer, i int, hash Hash, header *Header, entry Entry) (err error) {
+	var buf bytes.Buffer
+	err = MarshalHeader(&buf, header)
+	if err != nil {
+		return
+	}
+	headerLen := int64(buf.Len())
+	err = MarshalEntry(&buf, entry)
+	if err != nil {
+		return
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if i != s.length {
+		err = errors.New("entry indexes don't match can't create new entry")
+		return
+	}
+	_, err = w.Write(buf.Bytes())
+	if err != nil {
+		return
+	}
+	rec := chainRecord{header: s.size, entry: s.size + headerLen, end: s.size + int64(buf.Len()), hash: hash}
+	err = s.db.Update(func(tx *bolt.Tx) (err error) {
+		err = s.putRecord(tx, i, &rec, header)
+		if err != nil {
+			return
+		}
+		meta := tx.Bucket(chainMetaBucket)
+		err = meta.Put(chainLengthKey, itob(i+1))
+		if err != nil {
+			return
+		}
+		err = meta.Put(chainSizeKey, itob(int(rec.end)))
+		return
+	})
+	if err != nil {
+		return
+	}
+	s.length = i + 1
+	s.size = rec.end
+	return
+}
+
+// len returns the number of headers in the chain
+func (s *chainStore) len() int {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	return s.length
+}
+
+func (s *chainStore) record(i int) (rec chainRecord, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(chainRecordBucket).Get(itob(i))
+		if v == nil {
+			return ErrHashNotFound
+		}
+		return rec.unmarshal(v)
+	})
+	return
+}
+
+// header reads the ith header from the chain file
+func (s *chainStore) header(i int) (header *Header, err error) {
+	var rec chainRecord
+	rec, err = s.record(i)
+	if err != nil {
+		return
+	}
+	b := make([]byte, rec.entry-rec.header)
+	_, err = s.f.ReadAt(b, rec.header)
+	if err != nil {
+		return
+	}
+	var hd Header
+	err = hd.Unmarshal(b, 34)
+	if err != nil {
+		return
+	}
+	header = &hd
+	return
+}
+
+// entry reads the ith entry from the chain file
+func (s *chainStore) entry(i int) (entry Entry, err error) {
+	var rec chainRecord
+	rec, err = s.record(i)
+	if err != nil {
+		return
+	}
+	b := make([]byte, rec.end-rec.entry)
+	_, err = s.f.ReadAt(b, rec.entry)
+	if err != nil {
+		return
+	}
+	entry, err = UnmarshalEntry(bytes.NewReader(b))
+	return
+}
+
+// hash returns the hash of the ith header
+func (s *chainStore) hash(i int) (hash Hash, err error) {
+	var rec chainRecord
+	rec, err = s.record(i)
+	if err == nil {
+		hash = rec.hash
+	}
+	return
+}
+
+// lookup returns the index stored under a key in one of the index buckets
+func (s *chainStore) lookup(bucket []byte, key []byte) (i int, ok bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucket).Get(key)
+		if v != nil {
+			i = btoi(v)
+			ok = true
+		}
+		return nil
+	})
+	return
+}
+
+// countingReader counts the bytes read through it so that the offsets of
+// records in the chain file can be found while it is being read
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += int64(n)
+	return
+}
@@ -0,0 +1,162 @@
+package holochain
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/holochain/holochain-proto/hash"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChainStore(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	hashSpec, key, now := chainTestSetup()
+	path := filepath.Join(d, "chain.dat")
+
+	c, err := NewChainFromFile(hashSpec, path)
+	if err != nil {
+		panic(err)
+	}
+	h1, _ := c.AddEntry(now, "entryTypeFoo1", &GobEntry{C: "some data1"}, key)
+	h2, _ := c.AddEntry(now, "entryTypeFoo2", &GobEntry{C: "some data2"}, key)
+	h3, _ := c.AddEntry(now, "entryTypeFoo1", &GobEntry{C: "some data3"}, key)
+	dump := c.String()
+
+	Convey("it should index the chain instead of loading it into memory", t, func() {
+		So(c.store, ShouldNotBeNil)
+		So(FileExists(path+ChainIndexFileSuffix), ShouldBeTrue)
+		So(len(c.Headers), ShouldEqual, 0)
+		So(len(c.Entries), ShouldEqual, 0)
+		So(len(c.Hashes), ShouldEqual, 0)
+		So(c.Length(), ShouldEqual, 3)
+	})
+
+	Convey("it should read headers and entries from the chain file", t, func() {
+		hd, err := c.Get(h2)
+		So(err, ShouldBeNil)
+		So(hd.Type, ShouldEqual, "entryTypeFoo2")
+
+		e, entryType, err := c.GetEntry(hd.EntryLink)
+		So(err, ShouldBeNil)
+		So(entryType, ShouldEqual, "entryTypeFoo2")
+		So(e.Content(), ShouldEqual, "some data2")
+
+		So(c.Nth(1).EntryLink.String(), ShouldEqual, hd.EntryLink.String())
+		So(c.Nth(3), ShouldBeNil)
+
+		hash, hd := c.TopType("entryTypeFoo1")
+		So(hash.String(), ShouldEqual, h3.String())
+		So(hd.TypeLink.String(), ShouldEqual, h1.String())
+
+		_, err = c.Get(hd.EntryLink)
+		So(err, ShouldEqual, ErrHashNotFound)
+
+		var contents []string
+		err = c.Walk(func(key *Hash, header *Header, entry Entry) error {
+			contents = append(contents, fmt.Sprintf("%s:%v", key.String(), entry.Content()))
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(contents, ShouldResemble, []string{h3.String() + ":some data3", h2.String() + ":some data2", h1.String() + ":some data1"})
+	})
+
+	Convey("it should encode to JSON the same way as a chain loaded into memory", t, func() {
+		mem := NewChain(hashSpec)
+		So(mem.load(path), ShouldBeNil)
+		j, err := json.Marshal(c)
+		So(err, ShouldBeNil)
+		memJ, err := json.Marshal(mem)
+		So(err, ShouldBeNil)
+		So(string(j), ShouldEqual, string(memJ))
+	})
+
+	Convey("chains opened on the same file should share the index", t, func() {
+		c1, err := NewChainFromFile(hashSpec, path)
+		So(err, ShouldBeNil)
+		So(c1.store, ShouldEqual, c.store)
+		So(c1.store.refs, ShouldEqual, 2)
+		c1.Close()
+		So(c.store.refs, ShouldEqual, 1)
+	})
+	c.Close()
+
+	Convey("it should rebuild a missing index from the chain file", t, func() {
+		err := os.Remove(path + ChainIndexFileSuffix)
+		So(err, ShouldBeNil)
+		c, err = NewChainFromFile(hashSpec, path)
+		So(err, ShouldBeNil)
+		So(c.String(), ShouldEqual, dump)
+		hd, err := c.Get(h1)
+		So(err, ShouldBeNil)
+		So(hd.Type, ShouldEqual, "entryTypeFoo1")
+		c.Close()
+	})
+
+	Convey("it should index records appended to the chain file since it was last opened", t, func() {
+		// append to the file the way older versions did, without updating the index
+		mem := NewChain(hashSpec)
+		mem.load(path)
+		h4, _ := mem.AddEntry(now, "entryTypeFoo2", &GobEntry{C: "some data4"}, key)
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+		So(err, ShouldBeNil)
+		err = writePair(f, mem.Headers[3], mem.Entries[3])
+		So(err, ShouldBeNil)
+		f.Close()
+
+		c, err = NewChainFromFile(hashSpec, path)
+		So(err, ShouldBeNil)
+		So(c.Length(), ShouldEqual, 4)
+		So(c.String(), ShouldEqual, mem.String())
+		hash, _ := c.TopType("entryTypeFoo2")
+		So(hash.String(), ShouldEqual, h4.String())
+		c.Close()
+	})
+
+	Convey("it should rebuild an index that is ahead of the chain file", t, func() {
+		// drop the last record by rewriting the file without it
+		mem := NewChain(hashSpec)
+		mem.load(path)
+		f, err := os.Create(path)
+		So(err, ShouldBeNil)
+		for i := 0; i < 3; i++ {
+			writePair(f, mem.Headers[i], mem.Entries[i])
+		}
+		f.Close()
+
+		c, err = NewChainFromFile(hashSpec, path)
+		So(err, ShouldBeNil)
+		So(c.Length(), ShouldEqual, 3)
+		So(c.String(), ShouldEqual, dump)
+		c.Close()
+	})
+
+	Convey("it should close the store of a chain file that has been replaced", t, func() {
+		c, err = NewChainFromFile(hashSpec, path)
+		So(err, ShouldBeNil)
+		stale := c.store
+
+		mem := NewChain(hashSpec)
+		mem.load(path)
+		So(os.Remove(path), ShouldBeNil)
+		f, err := os.Create(path)
+		So(err, ShouldBeNil)
+		for i := 0; i < 3; i++ {
+			writePair(f, mem.Headers[i], mem.Entries[i])
+		}
+		f.Close()
+
+		c1, err := NewChainFromFile(hashSpec, path)
+		So(err, ShouldBeNil)
+		So(c1.store, ShouldNotBeNil)
+		So(c1.store, ShouldNotEqual, stale)
+		So(c1.String(), ShouldEqual, dump)
+		_, err = stale.header(0)
+		So(err, ShouldNotBeNil)
+		c1.Close()
+		c.Close()
+	})
+}
@@ -0,0 +1,205 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements integrity verification and repair of chains
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+
+	. "github.com/holochain/holochain-proto/hash"
+	ic "github.com/libp2p/go-libp2p-crypto"
+)
+
+const (
+	// ChainVerificationOff skips verifying the chain when it's loaded, this is the default
+	ChainVerificationOff = "off"
+
+	// ChainVerificationHeaders checks that each header hashes to the link in the next one
+	ChainVerificationHeaders = "headers"
+
+	// ChainVerificationFull checks the headers, that each entry hashes to its header's entry link
+	// and that each header is signed by the agent key in effect when it was added
+	ChainVerificationFull = "full"
+)
+
+var ErrChainNotRepairable = errors.New("only chains backed by an indexed store can be repaired")
+
+// ChainIntegrityError reports the index of the first header in a chain that
+// failed verification and why
+type ChainIntegrityError struct {
+	Index  int
+	Reason string
+}
+
+func (e *ChainIntegrityError) Error() string {
+	return fmt.Sprintf("chain integrity failure at index %d: %s", e.Index, e.Reason)
+}
+
+// Verify checks the integrity of the chain at the given level of verification
+// returning a *ChainIntegrityError for the first header that fails
+func (c *Chain) Verify(level string) (err error) {
+	switch level {
+	case "", ChainVerificationOff:
+		return
+	case ChainVerificationHeaders, ChainVerificationFull:
+	default:
+		err = fmt.Errorf("Unknown ChainVerification: %s", level)
+		return
+	}
+	c.lk.RLock()
+	defer c.lk.RUnlock()
+
+	fail := func(i int, format string, args ...interface{}) error {
+		return &ChainIntegrityError{Index: i, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	// headers are signed by the key of the latest agent or checkpoint entry,
+	// except for the ones before the first such entry which are signed by its key
+	var agentKey ic.PubKey
+	var unchecked []*Header
+	checkSig := func(i int, hd *Header) error {
+		matches, e := agentKey.Verify([]byte(hd.EntryLink), hd.Sig.S)
+		if e != nil || !matches {
+			return fail(i, "header signature doesn't match the agent key")
+		}
+		return nil
+	}
+
+	prev := NullHash()
+	typeTops := make(map[string]Hash)
+	l := c.length()
+	for i := 0; i < l; i++ {
+		hd, e := c.header(i)
+		if e != nil {
+			err = fail(i, "unable to read header: %v", e)
+			return
+		}
+		if !hd.HeaderLink.Equal(prev) {
+			err = fail(i, "header link %v doesn't match the hash of the previous header %v", hd.HeaderLink, prev)
+			return
+		}
+		prevType, ok := typeTops[hd.Type]
+		if !ok {
+			prevType = NullHash()
+		}
+		if !hd.TypeLink.Equal(prevType) {
+			err = fail(i, "type link %v doesn't match the hash of the previous %s header %v", hd.TypeLink, hd.Type, prevType)
+			return
+		}
+
+		var hash, stored Hash
+		hash, _, e = hd.Sum(c.hashSpec)
+		if e != nil {
+			err = fail(i, "unable to hash header: %v", e)
+			return
+		}
+		stored, e = c.hash(i)
+		if e != nil {
+			err = fail(i, "unable to read header hash: %v", e)
+			return
+		}
+		if !hash.Equal(stored) {
+			err = fail(i, "header hashes to %v but is recorded as %v", hash, stored)
+			return
+		}
+
+		if level == ChainVerificationFull {
+			entry, e := c.entry(i)
+			if e != nil {
+				err = fail(i, "unable to read entry: %v", e)
+				return
+			}
+			var entryHash Hash
+			entryHash, e = entry.Sum(c.hashSpec)
+			if e != nil {
+				err = fail(i, "unable to hash entry: %v", e)
+				return
+			}
+			if !entryHash.Equal(hd.EntryLink) {
+				err = fail(i, "entry hashes to %v but the header's entry link is %v", entryHash, hd.EntryLink)
+				return
+			}
+
+			var key ic.PubKey
+			key, e = signingKey(hd.Type, entry)
+			if e != nil {
+				err = fail(i, "unable to read the agent key: %v", e)
+				return
+			}
+			if key != nil {
+				agentKey = key
+			}
+			if agentKey == nil {
+				unchecked = append(unchecked, hd)
+			} else {
+				for j, uhd := range unchecked {
+					if err = checkSig(j, uhd); err != nil {
+						return
+					}
+				}
+				unchecked = nil
+				if err = checkSig(i, hd); err != nil {
+					return
+				}
+			}
+		}
+
+		prev = hash
+		typeTops[hd.Type] = hash
+	}
+	return
+}
+
+// signingKey returns the public key that an agent or checkpoint entry sets for
+// signing the headers from it on, or nil for entries of any other type
+func signingKey(entryType string, e Entry) (key ic.PubKey, err error) {
+	j, _ := e.Content().(string)
+	var pk string
+	switch entryType {
+	case AgentEntryType:
+		var ae AgentEntry
+		ae, err = AgentEntryFromJSON(j)
+		if err != nil {
+			return
+		}
+		pk = ae.PublicKey
+	case CheckpointEntryType:
+		var cp CheckpointEntry
+		cp, err = CheckpointEntryFromJSON(j)
+		if err != nil {
+			return
+		}
+		pk = cp.PubKey
+	default:
+		return
+	}
+	key, err = DecodePubKey(pk)
+	return
+}
+
+// Repair verifies the chain at the given level and if it fails truncates the
+// chain to the headers before the one that failed.  The data that is cut is
+// saved to the chain's torn file.  It returns the number of headers removed.
+func (c *Chain) Repair(level string) (removed int, err error) {
+	err = c.Verify(level)
+	ie, ok := err.(*ChainIntegrityError)
+	if !ok {
+		return
+	}
+	if c.store == nil {
+		err = ErrChainNotRepairable
+		return
+	}
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	l := c.length()
+	err = c.store.truncate(c.hashSpec, ie.Index)
+	if err == nil {
+		removed = l - ie.Index
+	}
+	return
+}
@@ -0,0 +1,267 @@
+package holochain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// flipByte corrupts the byte at offset in a file
+func flipByte(path string, offset int64) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	f.ReadAt(b, offset)
+	b[0] ^= 0xff
+	f.WriteAt(b, offset)
+}
+
+func TestChainVerify(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	hashSpec, key, now := chainTestSetup()
+	path := filepath.Join(d, "chain.dat")
+
+	c, err := NewChainFromFile(hashSpec, path)
+	if err != nil {
+		panic(err)
+	}
+	defer c.Close()
+	c.AddEntry(now, "entryTypeFoo1", &GobEntry{C: "some data1"}, key)
+	c.AddEntry(now, "entryTypeFoo2", &GobEntry{C: "some data2"}, key)
+	c.AddEntry(now, "entryTypeFoo1", &GobEntry{C: "some data3"}, key)
+	c.AddEntry(now, "entryTypeFoo2", &GobEntry{C: "some data4"}, key)
+
+	Convey("a good chain should pass verification at every level", t, func() {
+		So(c.Verify(ChainVerificationOff), ShouldBeNil)
+		So(c.Verify(ChainVerificationHeaders), ShouldBeNil)
+		So(c.Verify(ChainVerificationFull), ShouldBeNil)
+		So(c.Verify("bogus").Error(), ShouldEqual, "Unknown ChainVerification: bogus")
+	})
+
+	Convey("in memory chains should also be verifiable", t, func() {
+		mem := NewChain(hashSpec)
+		So(mem.load(path), ShouldBeNil)
+		So(mem.Verify(ChainVerificationFull), ShouldBeNil)
+		_, err := mem.Repair(ChainVerificationFull)
+		So(err, ShouldBeNil)
+
+		mem.Entries[1] = &GobEntry{C: "changed"}
+		err = mem.Verify(ChainVerificationFull)
+		So(err.(*ChainIntegrityError).Index, ShouldEqual, 1)
+		_, err = mem.Repair(ChainVerificationFull)
+		So(err, ShouldEqual, ErrChainNotRepairable)
+	})
+
+	Convey("full verification should report a header that isn't signed by the agent key", t, func() {
+		a, _ := NewAgent(LibP2P, "agent id", MakeTestSeed(""))
+		other, _ := NewAgent(LibP2P, "other id", MakeTestSeed("other"))
+		ae, _ := a.AgentEntry(nil)
+		j, _ := ae.ToJSON()
+
+		mem := NewChain(hashSpec)
+		mem.AddEntry(now, DNAEntryType, &GobEntry{C: []byte("dna")}, a.PrivKey())
+		mem.AddEntry(now, AgentEntryType, &GobEntry{C: j}, a.PrivKey())
+		mem.AddEntry(now, "entryTypeFoo1", &GobEntry{C: "some data1"}, a.PrivKey())
+		So(mem.Verify(ChainVerificationFull), ShouldBeNil)
+
+		mem.AddEntry(now, "entryTypeFoo1", &GobEntry{C: "some data2"}, other.PrivKey())
+		So(mem.Verify(ChainVerificationHeaders), ShouldBeNil)
+		err := mem.Verify(ChainVerificationFull)
+		So(err, ShouldNotBeNil)
+		ie := err.(*ChainIntegrityError)
+		So(ie.Index, ShouldEqual, 3)
+		So(ie.Reason, ShouldContainSubstring, "signature")
+
+		// headers before the agent entry are checked against its key
+		mem = NewChain(hashSpec)
+		mem.AddEntry(now, DNAEntryType, &GobEntry{C: []byte("dna")}, other.PrivKey())
+		mem.AddEntry(now, AgentEntryType, &GobEntry{C: j}, a.PrivKey())
+		err = mem.Verify(ChainVerificationFull)
+		So(err, ShouldNotBeNil)
+		So(err.(*ChainIntegrityError).Index, ShouldEqual, 0)
+	})
+
+	Convey("full verification should report an entry that doesn't match its header", t, func() {
+		rec, _ := c.store.record(2)
+		flipByte(path, rec.end-1)
+		So(c.Verify(ChainVerificationHeaders), ShouldBeNil)
+		err := c.Verify(ChainVerificationFull)
+		So(err, ShouldNotBeNil)
+		ie := err.(*ChainIntegrityError)
+		So(ie.Index, ShouldEqual, 2)
+		So(ie.Reason, ShouldContainSubstring, "entry")
+		flipByte(path, rec.end-1)
+		So(c.Verify(ChainVerificationFull), ShouldBeNil)
+	})
+
+	Convey("header verification should report a header that doesn't hash correctly", t, func() {
+		rec, _ := c.store.record(1)
+		// the last byte of the signature, before the change hash and meta data
+		flipByte(path, rec.entry-8-34-1)
+		err := c.Verify(ChainVerificationHeaders)
+		So(err, ShouldNotBeNil)
+		ie := err.(*ChainIntegrityError)
+		So(ie.Index, ShouldEqual, 1)
+		So(ie.Reason, ShouldContainSubstring, "header hashes to")
+	})
+
+	Convey("repair should truncate the chain before the broken header", t, func() {
+		size := c.store.size
+		rec, _ := c.store.record(1)
+		removed, err := c.Repair(ChainVerificationFull)
+		So(err, ShouldBeNil)
+		So(removed, ShouldEqual, 3)
+		So(c.Length(), ShouldEqual, 1)
+		So(c.Verify(ChainVerificationFull), ShouldBeNil)
+		So(c.store.size, ShouldEqual, rec.header)
+
+		torn, err := ioutil.ReadFile(path + ChainTornFileSuffix)
+		So(err, ShouldBeNil)
+		So(int64(len(torn)), ShouldEqual, size-rec.header)
+
+		// and the chain can continue to grow
+		_, err = c.AddEntry(now, "entryTypeFoo2", &GobEntry{C: "some data5"}, key)
+		So(err, ShouldBeNil)
+		So(c.Verify(ChainVerificationFull), ShouldBeNil)
+	})
+}
+
+func TestChainTornRecord(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	hashSpec, key, now := chainTestSetup()
+	path := filepath.Join(d, "chain.dat")
+
+	c, err := NewChainFromFile(hashSpec, path)
+	if err != nil {
+		panic(err)
+	}
+	c.AddEntry(now, "entryTypeFoo1", &GobEntry{C: "some data1"}, key)
+	c.AddEntry(now, "entryTypeFoo2", &GobEntry{C: "some data2"}, key)
+	dump := c.String()
+	size := c.store.size
+	rec, _ := c.store.record(1)
+
+	// make the bytes of a complete record and write only part of them
+	// as an interrupted write would
+	mem := NewChain(hashSpec)
+	mem.load(path)
+	mem.AddEntry(now, "entryTypeFoo1", &GobEntry{C: "some data3"}, key)
+	var buf bytes.Buffer
+	writePair(&buf, mem.Headers[2], mem.Entries[2])
+	torn := buf.Bytes()[:buf.Len()-3]
+	c.s.Write(torn)
+	c.Close()
+
+	Convey("opening a chain with a torn trailing record should truncate it", t, func() {
+		c, err = NewChainFromFile(hashSpec, path)
+		So(err, ShouldBeNil)
+		So(c.Length(), ShouldEqual, 2)
+		So(c.String(), ShouldEqual, dump)
+		fi, err := os.Stat(path)
+		So(err, ShouldBeNil)
+		So(fi.Size(), ShouldEqual, size)
+
+		b, err := ioutil.ReadFile(path + ChainTornFileSuffix)
+		So(err, ShouldBeNil)
+		So(bytes.Equal(b, torn), ShouldBeTrue)
+
+		_, err = c.AddEntry(now, "entryTypeFoo1", &GobEntry{C: "some data3"}, key)
+		So(err, ShouldBeNil)
+		So(c.Verify(ChainVerificationFull), ShouldBeNil)
+		c.Close()
+	})
+
+	Convey("opening a chain with an entry length that runs past the end before the last record should fail without truncating it", t, func() {
+		os.Remove(path + ChainTornFileSuffix)
+		os.Remove(path + ChainIndexFileSuffix)
+		orig, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		b := make([]byte, len(orig))
+		copy(b, orig)
+		binary.LittleEndian.PutUint64(b[rec.entry:], uint64(len(b)))
+		So(ioutil.WriteFile(path, b, 0600), ShouldBeNil)
+		_, err = NewChainFromFile(hashSpec, path)
+		So(err, ShouldNotBeNil)
+		ie, ok := err.(*ChainIntegrityError)
+		So(ok, ShouldBeTrue)
+		So(ie.Index, ShouldEqual, 1)
+		fi, err := os.Stat(path)
+		So(err, ShouldBeNil)
+		So(fi.Size(), ShouldEqual, len(b))
+		So(FileExists(path+ChainTornFileSuffix), ShouldBeFalse)
+
+		So(ioutil.WriteFile(path, orig, 0600), ShouldBeNil)
+	})
+
+	Convey("opening a chain with an unreadable record before the end should fail without truncating it", t, func() {
+		os.Remove(path + ChainTornFileSuffix)
+		os.Remove(path + ChainIndexFileSuffix)
+		b, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		// corrupt the version byte of the time in the second header
+		offset := int(size) + 1 + len("entryTypeFoo1")
+		b[offset] = 0xff
+		So(ioutil.WriteFile(path, b, 0600), ShouldBeNil)
+		_, err = NewChainFromFile(hashSpec, path)
+		So(err, ShouldNotBeNil)
+		ie, ok := err.(*ChainIntegrityError)
+		So(ok, ShouldBeTrue)
+		So(ie.Index, ShouldEqual, 2)
+		fi, err := os.Stat(path)
+		So(err, ShouldBeNil)
+		So(fi.Size(), ShouldEqual, len(b))
+		So(FileExists(path+ChainTornFileSuffix), ShouldBeFalse)
+	})
+}
+
+func TestChainVerificationConfig(t *testing.T) {
+	Convey("it should fail on an unknown ChainVerification", t, func() {
+		config := Config{ChainVerification: "bogus"}
+		err := config.Setup()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "Unknown ChainVerification: bogus")
+	})
+
+	Convey("HC_CHAIN_VERIFICATION should override the config", t, func() {
+		os.Setenv("HC_CHAIN_VERIFICATION", ChainVerificationFull)
+		defer os.Unsetenv("HC_CHAIN_VERIFICATION")
+		config := Config{}
+		So(config.Setup(), ShouldBeNil)
+		So(config.ChainVerification, ShouldEqual, ChainVerificationFull)
+	})
+
+	Convey("a holochain should fail to load a chain that fails verification", t, func() {
+		d, s, h := PrepareTestChain("test")
+		defer CleanupTestDir(d)
+		path := filepath.Join(h.DBPath(), StoreFileName)
+		top := h.chain.Length() - 1
+		rec, _ := h.chain.store.record(top)
+		h.Close()
+		flipByte(path, rec.end-1)
+
+		_, err := s.Load("test")
+		So(err, ShouldBeNil)
+
+		os.Setenv("HC_CHAIN_VERIFICATION", ChainVerificationFull)
+		defer os.Unsetenv("HC_CHAIN_VERIFICATION")
+		_, err = s.Load("test")
+		So(err, ShouldNotBeNil)
+		So(err.(*ChainIntegrityError).Index, ShouldEqual, top)
+
+		// a load option overrides both the config and the environment
+		h, err = s.LoadWithOptions("test", LoadOptions{ChainVerification: ChainVerificationOff})
+		So(err, ShouldBeNil)
+		So(h.Config.ChainVerification, ShouldEqual, ChainVerificationOff)
+		h.Close()
+	})
+}
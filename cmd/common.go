@@ -189,6 +189,12 @@ func GetService(root string) (service *holo.Service, err error) {
 
 // GetHolochain os a helper function to load a holochain from a directory or report an error based on a command name
 func GetHolochain(name string, service *holo.Service, cmd string) (h *holo.Holochain, err error) {
+	h, err = GetHolochainWithOptions(name, service, cmd, holo.LoadOptions{})
+	return
+}
+
+// GetHolochainWithOptions is like GetHolochain but overrides parts of the holochain's Config with the given load options
+func GetHolochainWithOptions(name string, service *holo.Service, cmd string, options holo.LoadOptions) (h *holo.Holochain, err error) {
 	if service == nil {
 		err = ErrServiceUninitialized
 		return
@@ -199,7 +205,7 @@ func GetHolochain(name string, service *holo.Service, cmd string) (h *holo.Holoc
 		return
 	}
 
-	h, err = service.Load(name)
+	h, err = service.LoadWithOptions(name, options)
 	if err != nil {
 		return
 	}
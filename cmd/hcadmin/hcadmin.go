@@ -10,10 +10,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 
 	holo "github.com/holochain/holochain-proto"
 	"github.com/holochain/holochain-proto/cmd"
 	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
 	"github.com/urfave/cli"
 )
 
@@ -31,6 +33,8 @@ func setupApp() (app *cli.App) {
 	var service *holo.Service
 	var bridgeCalleeAppData, bridgeCallerAppData, dumpFormat string
 	var start int
+	var headersOnly bool
+	var exportPrivate, exportKeys bool
 
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{
@@ -143,14 +147,14 @@ func setupApp() (app *cli.App) {
 					} else if dumpFormat != "" {
 						switch dumpFormat {
 						case "string":
-							dump = fmt.Sprintf("DHT for: %s\n%v", dnaHash, h.DHT())
+							dump = fmt.Sprintf("DHT for: %s\n%v%s", dnaHash, h.DHT(), dumpUsage(h))
 						case "json":
 							dump, err = h.DHT().JSON()
 						default:
 							err = cmd.MakeErr(c, "format for dht dump must be one of json, string")
 						}
 					} else {
-						dump = fmt.Sprintf("DHT for: %s\n%v", dnaHash, h.DHT())
+						dump = fmt.Sprintf("DHT for: %s\n%v%s", dnaHash, h.DHT(), dumpUsage(h))
 					}
 				}
 				if err != nil {
@@ -175,34 +179,10 @@ func setupApp() (app *cli.App) {
 				}
 				name := c.Args()[1]
 
-				info, err := os.Stat(srcPath)
+				err := installApp(service, root, srcPath, name, nil)
 				if err != nil {
 					return fmt.Errorf("join: %v", err)
 				}
-
-				// assume a regular file is a package
-				if info.Mode().IsRegular() {
-
-					dstPath := filepath.Join(root, name)
-					_, err := cmd.UpackageAppPackage(service, srcPath, dstPath, name, "json")
-
-					if err != nil {
-						return fmt.Errorf("join: error unpackaging %s: %v", srcPath, err)
-					}
-					err = service.InitAppDir(dstPath, "json")
-					if err != nil {
-						return fmt.Errorf("join: error initializing the app: %v", err)
-					}
-				} else {
-					agent, err := holo.LoadAgent(root)
-					if err != nil {
-						return fmt.Errorf("join: error loading agent (%s): %v", root, err)
-					}
-					_, err = service.Clone(srcPath, filepath.Join(root, name), agent, holo.CloneWithSameUUID, holo.InitializeDB)
-					if err != nil {
-						return fmt.Errorf("join: error cloning from source directory %s: %v", srcPath, err)
-					}
-				}
 				err = genChain(service, name)
 				if err != nil {
 					return fmt.Errorf("join: error in chain genesis: %v", err)
@@ -299,6 +279,245 @@ func setupApp() (app *cli.App) {
 				return nil
 			},
 		},
+		{
+			Name:      "receipts",
+			Aliases:   []string{"r"},
+			Usage:     "display the replication status of entries from the hold receipts received for them",
+			ArgsUsage: "holochain-name [entry-hash]",
+			Action: func(c *cli.Context) error {
+				if service == nil {
+					return cmd.ErrServiceUninitialized
+				}
+				if len(c.Args()) < 1 || len(c.Args()) > 2 {
+					return errors.New("receipts: expected 1 or 2 arguments")
+				}
+				h, err := cmd.GetHolochain(c.Args().First(), service, "receipts")
+				if err != nil {
+					return err
+				}
+				var hashes []Hash
+				if len(c.Args()) == 2 {
+					hash, err := NewHash(c.Args()[1])
+					if err != nil {
+						return err
+					}
+					hashes = append(hashes, hash)
+				} else {
+					err := h.Chain().Walk(func(key *Hash, header *holo.Header, entry holo.Entry) error {
+						hashes = append([]Hash{header.EntryLink}, hashes...)
+						return nil
+					})
+					if err != nil {
+						return err
+					}
+				}
+				for _, hash := range hashes {
+					receipts, err := h.GetReceipts(hash)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("%v receipts: %d\n", hash.String(), len(receipts))
+					for _, r := range receipts {
+						p := HashFromPeerID(r.Peer)
+						fmt.Printf("  %v code: %d at %v\n", p.String(), r.Code, r.Time)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "export",
+			ArgsUsage: "holochain-name archive-file",
+			Usage:     "export a holochain's source chain to a signed archive for backup or moving to another device (only archives made with --private can be imported)",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:        "private",
+					Destination: &exportPrivate,
+					Usage:       "include private entries in the archive, without them private entries are redacted and the archive can't be imported",
+				},
+				cli.BoolFlag{
+					Name:        "keys",
+					Destination: &exportKeys,
+					Usage:       "include the agent's keys in the archive encrypted with HC_ARCHIVE_PASSPHRASE",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 2 {
+					return errors.New("export: expected holochain-name and archive-file arguments")
+				}
+				h, err := cmd.GetHolochain(c.Args().First(), service, "export")
+				if err != nil {
+					return err
+				}
+				var passphrase string
+				if exportKeys {
+					passphrase = os.Getenv("HC_ARCHIVE_PASSPHRASE")
+					if passphrase == "" {
+						return errors.New("export: --keys requires HC_ARCHIVE_PASSPHRASE to be set")
+					}
+				}
+				archive, err := h.ExportChain(exportPrivate, passphrase)
+				if err != nil {
+					return fmt.Errorf("export: %v", err)
+				}
+				redacted, err := archive.Redacted(h.HashSpec())
+				if err != nil {
+					return fmt.Errorf("export: %v", err)
+				}
+				if redacted {
+					fmt.Printf("warning: private entries are redacted from this archive so it can't be imported, use --private to make an archive that can be restored\n")
+				}
+				f, err := os.OpenFile(c.Args()[1], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+				if err != nil {
+					return fmt.Errorf("export: %v", err)
+				}
+				defer f.Close()
+				err = archive.Encode(f)
+				if err != nil {
+					return fmt.Errorf("export: %v", err)
+				}
+				fmt.Printf("exported %d headers to %s\n", h.Chain().Length(), c.Args()[1])
+				return nil
+			},
+		},
+		{
+			Name:      "import",
+			ArgsUsage: "archive-file holochain-name [package/source path]",
+			Usage:     "import a chain archive into an installed holochain, or into a new instance installed from an app package (or source directory)",
+			Action: func(c *cli.Context) error {
+				if service == nil {
+					return cmd.ErrServiceUninitialized
+				}
+				if len(c.Args()) < 2 || len(c.Args()) > 3 {
+					return errors.New("import: expected 2 or 3 arguments")
+				}
+				name := c.Args()[1]
+				f, err := os.Open(c.Args().First())
+				if err != nil {
+					return fmt.Errorf("import: %v", err)
+				}
+				archive, err := holo.DecodeChainArchive(f)
+				f.Close()
+				if err != nil {
+					return fmt.Errorf("import: %v", err)
+				}
+
+				if len(c.Args()) == 3 {
+					// restore the archived agent if the archive has its keys
+					var agent holo.Agent
+					passphrase := os.Getenv("HC_ARCHIVE_PASSPHRASE")
+					if len(archive.Keys) > 0 && passphrase != "" {
+						agent, err = archive.Agent(passphrase)
+						if err != nil {
+							return fmt.Errorf("import: error restoring agent: %v", err)
+						}
+					}
+					err = installApp(service, root, c.Args()[2], name, agent)
+					if err != nil {
+						return fmt.Errorf("import: %v", err)
+					}
+				}
+
+				h, err := service.Load(name)
+				if err == nil {
+					var added int
+					added, err = h.ImportChain(archive)
+					if err == nil {
+						fmt.Printf("imported %d headers, chain has %d\n", added, h.Chain().Length())
+						return nil
+					}
+				}
+				// don't leave behind an instance that was installed just for the import
+				if len(c.Args()) == 3 {
+					os.RemoveAll(filepath.Join(root, name))
+				}
+				return fmt.Errorf("import: %v", err)
+			},
+		},
+		{
+			Name:  "chain",
+			Usage: "verify or repair the integrity of a holochain's source chain, or encrypt it",
+			Subcommands: []cli.Command{
+				{
+					Name:      "verify",
+					ArgsUsage: "holochain-name",
+					Usage:     "check that the chain's headers link together and are signed by the agent, and that its entries match their headers",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:        "headers",
+							Destination: &headersOnly,
+							Usage:       "only verify the headers",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						h, err := getChainHolochain(c, service, "chain verify")
+						if err != nil {
+							return err
+						}
+						err = h.Chain().Verify(verificationLevel(headersOnly))
+						if err != nil {
+							return reportChainIntegrity(err)
+						}
+						fmt.Printf("chain verified: %d headers\n", h.Chain().Length())
+						return nil
+					},
+				},
+				{
+					Name:      "repair",
+					ArgsUsage: "holochain-name",
+					Usage:     "truncate the chain to the last header before the first integrity failure",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:        "headers",
+							Destination: &headersOnly,
+							Usage:       "only verify the headers when looking for failures",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						h, err := getChainHolochain(c, service, "chain repair")
+						if err != nil {
+							return err
+						}
+						err = h.Chain().Verify(verificationLevel(headersOnly))
+						if err == nil {
+							fmt.Printf("chain verified: %d headers, nothing to repair\n", h.Chain().Length())
+							return nil
+						}
+						reportChainIntegrity(err)
+						removed, err := h.Chain().Repair(verificationLevel(headersOnly))
+						if err != nil {
+							return err
+						}
+						fmt.Printf("chain repaired: removed %d headers, %d remain\n", removed, h.Chain().Length())
+						return nil
+					},
+				},
+				{
+					Name:      "encrypt",
+					ArgsUsage: "holochain-name",
+					Usage:     "encrypt a chain that was written in the clear after turning on DataEncryption in the holochain's config",
+					Action: func(c *cli.Context) error {
+						h, err := cmd.GetHolochainWithOptions(c.Args().First(), service, "chain encrypt", holo.LoadOptions{ChainVerification: holo.ChainVerificationOff, EncryptChain: true})
+						if err != nil {
+							return err
+						}
+						if h.Config.DataEncryption == holo.DataEncryptionNone {
+							return errors.New("chain encrypt: DataEncryption isn't turned on in the holochain's config")
+						}
+						fmt.Printf("chain encrypted: %d headers\n", h.Chain().Length())
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "schema",
+			Usage: "print the CDDL schema of the messages nodes exchange with the cbor codec",
+			Action: func(c *cli.Context) error {
+				fmt.Print(holo.CBORSchema())
+				return nil
+			},
+		},
 	}
 
 	app.Before = func(c *cli.Context) error {
@@ -344,6 +563,76 @@ func main() {
 	}
 }
 
+// dumpUsage returns a human readable list of the bytes of entry data held on
+// behalf of each source, together with the quota set in the DNA
+func dumpUsage(h *holo.Holochain) string {
+	usage, err := h.DHT().Usage()
+	if err != nil {
+		return fmt.Sprintf("Source usage: %v\n", err)
+	}
+	quota := h.Nucleus().DNA().DHTConfig.SourceQuota
+	var q string
+	if quota > 0 {
+		q = fmt.Sprintf(" of %d", quota)
+	}
+	ids := make([]string, 0, len(usage))
+	bytes := make(map[string]int)
+	for id, n := range usage {
+		s := peer.IDB58Encode(id)
+		ids = append(ids, s)
+		bytes[s] = n
+	}
+	sort.Strings(ids)
+	result := fmt.Sprintf("Source usage: %d\n", len(ids))
+	for _, id := range ids {
+		result += fmt.Sprintf("    %s: %d%s bytes\n", id, bytes[id], q)
+	}
+	return result
+}
+
+// installApp installs an instance of an app from a package or source directory
+// without generating its chain.  If agent is not nil it becomes the app's agent
+// instead of the service's default agent.
+func installApp(service *holo.Service, root string, srcPath string, name string, agent holo.Agent) (err error) {
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return
+	}
+
+	// assume a regular file is a package
+	if info.Mode().IsRegular() {
+
+		dstPath := filepath.Join(root, name)
+		_, err = cmd.UpackageAppPackage(service, srcPath, dstPath, name, "json")
+
+		if err != nil {
+			return fmt.Errorf("error unpackaging %s: %v", srcPath, err)
+		}
+		err = service.InitAppDir(dstPath, "json")
+		if err != nil {
+			return fmt.Errorf("error initializing the app: %v", err)
+		}
+		if agent != nil {
+			err = holo.SaveAgent(dstPath, agent)
+			if err != nil {
+				return fmt.Errorf("error saving agent: %v", err)
+			}
+		}
+	} else {
+		if agent == nil {
+			agent, err = holo.LoadAgent(root)
+			if err != nil {
+				return fmt.Errorf("error loading agent (%s): %v", root, err)
+			}
+		}
+		_, err = service.Clone(srcPath, filepath.Join(root, name), agent, holo.CloneWithSameUUID, holo.InitializeDB)
+		if err != nil {
+			return fmt.Errorf("error cloning from source directory %s: %v", srcPath, err)
+		}
+	}
+	return
+}
+
 func genChain(service *holo.Service, name string) error {
 	h, err := service.GenChain(name)
 	if err != nil {
@@ -354,3 +643,26 @@ func genChain(service *holo.Service, name string) error {
 	}
 	return nil
 }
+
+// getChainHolochain loads a holochain for the chain commands with load time
+// verification turned off, so that a chain that fails verification can still be
+// examined and repaired
+func getChainHolochain(c *cli.Context, service *holo.Service, cmdName string) (h *holo.Holochain, err error) {
+	h, err = cmd.GetHolochainWithOptions(c.Args().First(), service, cmdName, holo.LoadOptions{ChainVerification: holo.ChainVerificationOff})
+	return
+}
+
+func verificationLevel(headersOnly bool) string {
+	if headersOnly {
+		return holo.ChainVerificationHeaders
+	}
+	return holo.ChainVerificationFull
+}
+
+// reportChainIntegrity prints where and why a chain failed verification
+func reportChainIntegrity(err error) error {
+	if ie, ok := err.(*holo.ChainIntegrityError); ok {
+		fmt.Printf("chain broken at index %d: %s\n", ie.Index, ie.Reason)
+	}
+	return err
+}
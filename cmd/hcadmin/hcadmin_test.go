@@ -89,6 +89,7 @@ func TestJoinFromSourceDir(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(out, ShouldContainSubstring, "DHT for: Qm")
 		So(out, ShouldContainSubstring, "DHT changes: 2")
+		So(out, ShouldContainSubstring, "Source usage: 1\n")
 	})
 }
 
@@ -120,6 +121,13 @@ func TestStatus(t *testing.T) {
 		So(out, ShouldContainSubstring, "DNA Hash: Qm")
 		So(out, ShouldContainSubstring, "ID Hash: Qm")
 	})
+
+	app = setupApp()
+	Convey("receipts should show the replication status of the chain's entries", t, func() {
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "receipts", "testApp"})
+		So(err, ShouldBeNil)
+		So(out, ShouldContainSubstring, "receipts: 0\n")
+	})
 }
 
 func TestJoinFromPackage(t *testing.T) {
@@ -289,6 +297,161 @@ func TestDumpChainAsJSON(t *testing.T) {
 	})
 }
 
+func TestChainVerifyRepair(t *testing.T) {
+	d := holo.SetupTestDir()
+	defer os.RemoveAll(d)
+
+	app := setupApp()
+	_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "init", "test-identity"})
+	if err != nil {
+		panic(err)
+	}
+	err = holo.WriteFile([]byte(holo.BasicTemplateAppPackage), d, "appPackage."+holo.BasicTemplateAppPackageFormat)
+	if err != nil {
+		panic(err)
+	}
+	app = setupApp()
+	_, err = runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "join", filepath.Join(d, "appPackage."+holo.BasicTemplateAppPackageFormat), "testApp"})
+	if err != nil {
+		panic(err)
+	}
+
+	var n int
+	Convey("chain verify should report a good chain", t, func() {
+		app := setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "chain", "verify", "testApp"})
+		So(err, ShouldBeNil)
+		fmt.Sscanf(out, "chain verified: %d headers\n", &n)
+		So(n, ShouldBeGreaterThanOrEqualTo, 2)
+		So(out, ShouldEqual, fmt.Sprintf("chain verified: %d headers\n", n))
+	})
+
+	// corrupt the last byte of the top entry
+	path := filepath.Join(d, "testApp", holo.ChainDataDir, holo.StoreFileName)
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		panic(err)
+	}
+	fi, _ := f.Stat()
+	b := make([]byte, 1)
+	f.ReadAt(b, fi.Size()-1)
+	b[0] ^= 0xff
+	f.WriteAt(b, fi.Size()-1)
+	f.Close()
+
+	Convey("chain verify should report where and why the chain is broken", t, func() {
+		app := setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "chain", "verify", "--headers", "testApp"})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, fmt.Sprintf("chain verified: %d headers\n", n))
+
+		app = setupApp()
+		out, err = runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "chain", "verify", "testApp"})
+		So(err, ShouldNotBeNil)
+		So(out, ShouldStartWith, fmt.Sprintf("chain broken at index %d: ", n-1))
+	})
+
+	Convey("chain repair should truncate the chain to before the broken index", t, func() {
+		app := setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "chain", "repair", "testApp"})
+		So(err, ShouldBeNil)
+		So(out, ShouldContainSubstring, fmt.Sprintf("chain broken at index %d: ", n-1))
+		So(out, ShouldEndWith, fmt.Sprintf("chain repaired: removed 1 headers, %d remain\n", n-1))
+		So(holo.FileExists(path+holo.ChainTornFileSuffix), ShouldBeTrue)
+
+		app = setupApp()
+		out, err = runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "chain", "repair", "testApp"})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, fmt.Sprintf("chain verified: %d headers, nothing to repair\n", n-1))
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	// each directory is the holochain service of a different device
+	d1 := holo.SetupTestDir()
+	defer os.RemoveAll(d1)
+	d2 := holo.SetupTestDir()
+	defer os.RemoveAll(d2)
+	d3 := holo.SetupTestDir()
+	defer os.RemoveAll(d3)
+
+	pkg := filepath.Join(d1, "appPackage."+holo.BasicTemplateAppPackageFormat)
+	err := holo.WriteFile([]byte(holo.BasicTemplateAppPackage), d1, "appPackage."+holo.BasicTemplateAppPackageFormat)
+	if err != nil {
+		panic(err)
+	}
+	for i, d := range []string{d1, d2, d3} {
+		app := setupApp()
+		_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "init", fmt.Sprintf("identity-%d", i)})
+		if err != nil {
+			panic(err)
+		}
+	}
+	app := setupApp()
+	_, err = runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d1, "join", pkg, "testApp"})
+	if err != nil {
+		panic(err)
+	}
+
+	os.Setenv("HC_ARCHIVE_PASSPHRASE", "some passphrase")
+	defer os.Unsetenv("HC_ARCHIVE_PASSPHRASE")
+	archive := filepath.Join(d1, "testApp.archive")
+	var n int
+	Convey("export should write a signed archive of the chain", t, func() {
+		app := setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d1, "export", "--keys", "testApp", archive})
+		So(err, ShouldBeNil)
+		fmt.Sscanf(out, "exported %d headers to ", &n)
+		So(n, ShouldBeGreaterThanOrEqualTo, 2)
+		So(out, ShouldEqual, fmt.Sprintf("exported %d headers to %s\n", n, archive))
+
+		app = setupApp()
+		_, err = runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d1, "export", "testApp", archive})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("import should install the app on a new device and restore the chain and agent", t, func() {
+		app := setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d2, "import", archive, "testApp", pkg})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, fmt.Sprintf("imported %d headers, chain has %d\n", n, n))
+
+		agent, err := holo.LoadAgent(filepath.Join(d2, "testApp"))
+		So(err, ShouldBeNil)
+		So(string(agent.Identity()), ShouldEqual, "identity-0")
+
+		app = setupApp()
+		out, err = runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d2, "import", archive, "testApp"})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, fmt.Sprintf("imported 0 headers, chain has %d\n", n))
+	})
+
+	Convey("import should refuse an archive for another agent", t, func() {
+		os.Unsetenv("HC_ARCHIVE_PASSPHRASE")
+		app := setupApp()
+		_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d3, "import", archive, "testApp", pkg})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "import: "+holo.ErrChainArchiveAgentMismatch.Error())
+		So(holo.DirExists(d3, "testApp"), ShouldBeFalse)
+
+		app = setupApp()
+		_, err = runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d1, "export", "--keys", "testApp", archive + "2"})
+		So(err.Error(), ShouldEqual, "export: --keys requires HC_ARCHIVE_PASSPHRASE to be set")
+	})
+}
+
 func runAppWithStdoutCapture(app *cli.App, args []string) (out string, err error) {
 	return cmd.RunAppWithStdoutCapture(app, args, time.Second*5)
 }
+
+func TestSchema(t *testing.T) {
+	d := holo.SetupTestDir()
+	defer os.RemoveAll(d)
+	app := setupApp()
+	Convey("schema should print the cbor message schema", t, func() {
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "schema"})
+		So(err, ShouldBeNil)
+		So(out, ShouldContainSubstring, "message = Message")
+		So(out, ShouldContainSubstring, `#6.27(["HoldReq", HoldReq])`)
+	})
+}
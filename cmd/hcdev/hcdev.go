@@ -40,7 +40,7 @@ var bridgeSpecsFile string
 var scenarioConfig *holo.TestConfig
 
 // flags for holochain config generation
-var dhtPort, logPrefix, bootstrapServer string
+var dhtPort, logPrefix, bootstrapServer, hashTableType string
 var mdns bool = true
 var upnp bool
 
@@ -150,6 +150,11 @@ func setupApp() (app *cli.App) {
 			Usage:       "url of bootstrap server or '_' for none",
 			Destination: &bootstrapServer,
 		},
+		cli.StringFlag{
+			Name:        "hashTable",
+			Usage:       fmt.Sprintf("storage type for the DHT: %s, %s or %s (default: %s)", holo.BuntHTType, holo.BoltHTType, holo.MemHTType, holo.BuntHTType),
+			Destination: &hashTableType,
+		},
 		cli.StringFlag{
 			Name:        "bridgeSpecs",
 			Usage:       fmt.Sprintf("path to bridge specs file (default: %s)", defaultSpecsFile),
@@ -934,6 +939,12 @@ func setupApp() (app *cli.App) {
 				return err
 			}
 		}
+		if hashTableType != "" {
+			err = os.Setenv("HOLOCHAINCONFIG_HASHTABLETYPE", hashTableType)
+			if err != nil {
+				return err
+			}
+		}
 
 		holo.Debugf("args:%v\n", c.Args())
 
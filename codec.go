@@ -0,0 +1,98 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements the codecs that messages are encoded with on the wire
+
+package holochain
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+const (
+	GobCodecName  = "gob"
+	CBORCodecName = "cbor"
+)
+
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// Codec encodes and decodes messages for sending them between nodes.  The
+// codec used on a stream is negotiated along with the protocol version.
+type Codec interface {
+	Name() string
+	Encode(m *Message) (data []byte, err error)
+	Decode(r io.Reader, m *Message) (err error)
+}
+
+// WireCodecs lists the codecs nodes speak in the order they prefer them
+var WireCodecs = []string{CBORCodecName, GobCodecName}
+
+var codecs = map[string]Codec{
+	GobCodecName:  gobCodec{},
+	CBORCodecName: cborCodec{},
+}
+
+// GetCodec returns the codec with the given name
+func GetCodec(name string) (codec Codec, err error) {
+	codec, ok := codecs[name]
+	if !ok {
+		err = ErrUnknownCodec
+	}
+	return
+}
+
+var bodyTypes = make(map[string]reflect.Type)
+var bodyTypeNames = make(map[reflect.Type]string)
+
+// RegisterMessageBody registers a type that can be sent as the body of a
+// message, or in any other interface{} field of one, with all the codecs.
+// The name identifies the type to peers that don't speak gob (see CBORSchema)
+// so it is part of the protocol and must not change when the go type does.
+func RegisterMessageBody(name string, body interface{}) {
+	gob.Register(body)
+	t := reflect.TypeOf(body)
+	if other, ok := bodyTypes[name]; ok && other != t {
+		panic(fmt.Sprintf("message body type name %s already registered for %v", name, other))
+	}
+	bodyTypes[name] = t
+	bodyTypeNames[t] = name
+}
+
+type gobCodec struct{}
+
+func (c gobCodec) Name() string {
+	return GobCodecName
+}
+
+func (c gobCodec) Encode(m *Message) (data []byte, err error) {
+	return m.Encode()
+}
+
+func (c gobCodec) Decode(r io.Reader, m *Message) (err error) {
+	return m.Decode(r)
+}
+
+type cborCodec struct{}
+
+func (c cborCodec) Name() string {
+	return CBORCodecName
+}
+
+func (c cborCodec) Encode(m *Message) (data []byte, err error) {
+	return CBORMarshal(m)
+}
+
+func (c cborCodec) Decode(r io.Reader, m *Message) (err error) {
+	br, ok := r.(cborReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	d := cborDecoder{r: &cborLimitReader{r: br, n: cborMaxMessageSize}}
+	return d.decode(reflect.ValueOf(m).Elem())
+}
@@ -0,0 +1,241 @@
+package holochain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	. "github.com/holochain/holochain-proto/hash"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCodecs(t *testing.T) {
+	Convey("it should get codecs by name", t, func() {
+		c, err := GetCodec(CBORCodecName)
+		So(err, ShouldBeNil)
+		So(c.Name(), ShouldEqual, CBORCodecName)
+		c, err = GetCodec(GobCodecName)
+		So(err, ShouldBeNil)
+		So(c.Name(), ShouldEqual, GobCodecName)
+		_, err = GetCodec("xml")
+		So(err, ShouldEqual, ErrUnknownCodec)
+	})
+
+	Convey("protocols should offer the codecs in order of preference for the versions that negotiate them", t, func() {
+		p := NewProtocol("action", "fakednahash", ActionReceiver)
+		So(p.IDs, ShouldResemble, []protocol.ID{
+			"/hc-action-fakednahash/0.1.0/cbor",
+			"/hc-action-fakednahash/0.1.0",
+			"/hc-action-fakednahash/0.0.0",
+		})
+		So(p.ID, ShouldEqual, p.IDs[0])
+		c, err := p.Codec(p.IDs[0])
+		So(err, ShouldBeNil)
+		So(c.Name(), ShouldEqual, CBORCodecName)
+		v, _ := p.Version(p.IDs[0])
+		So(v, ShouldEqual, "0.1.0")
+		c, err = p.Codec(p.IDs[2])
+		So(err, ShouldBeNil)
+		So(c.Name(), ShouldEqual, GobCodecName)
+		_, err = p.Codec("/hc-action-fakednahash/0.1.0/xml")
+		So(err, ShouldEqual, ErrUnknownCodec)
+	})
+}
+
+func TestCBORCodec(t *testing.T) {
+	node, err := makeNode(1234, "node1")
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+	codec, _ := GetCodec(CBORCodecName)
+	hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	roundtrip := func(m *Message) *Message {
+		d, err := codec.Encode(m)
+		So(err, ShouldBeNil)
+		var m2 Message
+		So(codec.Decode(bytes.NewReader(d), &m2), ShouldBeNil)
+		return &m2
+	}
+
+	Convey("it should encode and decode all the message body types", t, func() {
+		bodies := []interface{}{
+			"fish",
+			HoldReq{EntryHash: hash, RelatedHash: hash},
+			GetReq{H: hash, StatusMask: StatusDefault, GetMask: GetMaskAll},
+			GetResp{Entry: GobEntry{C: "3"}, EntryType: "evenNumbers", Sources: []string{"a", "b"}},
+			LinkQuery{Base: hash, T: "tag", Count: 10, Descending: true, Sources: []string{"a"}},
+			LinkQueryResp{Links: []TaggedHash{{H: hash.String(), T: "tag", Time: node.NewMessage(0, nil).Time}}, Next: "x"},
+			GossipReq{MyIdx: 1, YourIdx: 2},
+			FindNodeReq{H: hash},
+			ListAddReq{ListType: BlockedList, Peers: []string{node.HashAddr.Pretty()}, WarrantType: 1, Warrant: []byte{1, 2, 3}},
+			CloserPeersResp{CloserPeers: []PeerInfo{{ID: []byte(node.HashAddr), Addrs: [][]byte{node.NetAddr.Bytes()}}}},
+			ValidateResponse{Type: "evenNumbers", Header: Header{Type: "evenNumbers", EntryLink: hash, Sig: Signature{S: []byte{4}}}, Entry: GobEntry{C: "2"}, Package: Package{Chain: []byte{5}}},
+			AppMsg{ZomeType: "zySampleZome", Body: "hi"},
+			ErrorResponse{Code: ErrHashModifiedCode, Payload: GetResp{FollowHash: hash.String()}},
+			DHTChangeUnknownHashQueuedForRetry,
+		}
+		for _, body := range bodies {
+			m := node.NewMessage(OK_RESPONSE, body)
+			m2 := roundtrip(m)
+			So(m2.Type, ShouldEqual, m.Type)
+			So(m2.From, ShouldEqual, m.From)
+			So(m2.Time.Equal(m.Time), ShouldBeTrue)
+			So(m2.Body, ShouldResemble, body)
+		}
+	})
+
+	Convey("it should encode messages nested in gossip", t, func() {
+		put := node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash})
+		m := node.NewMessage(OK_RESPONSE, Gossip{Puts: []Put{{Idx: 1, M: *put}}})
+		m2 := roundtrip(m)
+		g := m2.Body.(Gossip)
+		So(g.Puts[0].Idx, ShouldEqual, 1)
+		So(fmt.Sprintf("%v", g.Puts[0].M), ShouldEqual, fmt.Sprintf("%v", *put))
+	})
+
+	Convey("it should decode pointers to bodies as values like gob does", t, func() {
+		m2 := roundtrip(node.NewMessage(OK_RESPONSE, &LinkQueryResp{Next: "x"}))
+		So(m2.Body, ShouldResemble, LinkQueryResp{Next: "x"})
+	})
+
+	Convey("it should skip fields it doesn't know about", t, func() {
+		type newerGetReq struct {
+			H          Hash
+			GetMask    int
+			NewOption  string
+			StatusMask int
+		}
+		d, err := CBORMarshal(newerGetReq{H: hash, GetMask: 2, NewOption: "x", StatusMask: 1})
+		So(err, ShouldBeNil)
+		var older struct {
+			H       Hash
+			GetMask int
+		}
+		So(CBORUnmarshal(d, &older), ShouldBeNil)
+		So(older.H, ShouldEqual, hash)
+		So(older.GetMask, ShouldEqual, 2)
+	})
+
+	Convey("it should refuse to encode unregistered body types", t, func() {
+		type unregistered struct{ X int }
+		_, err := codec.Encode(node.NewMessage(OK_RESPONSE, unregistered{}))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("hashes and peer IDs should be byte strings", t, func() {
+		d, err := CBORMarshal(FindNodeReq{H: hash})
+		So(err, ShouldBeNil)
+		// a map of 1 pair, the text key "H" and then a byte string of the hash
+		So(d[:3], ShouldResemble, []byte{0xa1, 0x61, 'H'})
+		So(d[3]>>5, ShouldEqual, cborBytes)
+	})
+
+	Convey("it should not trust lengths that aren't followed by the data", t, func() {
+		var x interface{}
+		// an array of 1<<26 items, and a byte string of 1<<26 bytes, with nothing after the head
+		So(CBORUnmarshal([]byte{0x9a, 0x04, 0, 0, 0}, &x), ShouldNotBeNil)
+		So(CBORUnmarshal([]byte{0x5a, 0x04, 0, 0, 0}, &x), ShouldEqual, io.EOF)
+		var s []string
+		So(CBORUnmarshal([]byte{0x9a, 0x04, 0, 0, 0, 0x61, 'a'}, &s), ShouldNotBeNil)
+		var b []byte
+		So(CBORUnmarshal([]byte{0x5a, 0x04, 0, 0, 0, 'a'}, &b), ShouldEqual, io.ErrUnexpectedEOF)
+	})
+
+	Convey("it should limit how deeply items are nested", t, func() {
+		var x interface{}
+		nested := append(bytes.Repeat([]byte{0x81}, cborMaxDepth), 0x00)
+		So(CBORUnmarshal(nested, &x), ShouldEqual, ErrCBORTooDeep)
+		tagged := append(bytes.Repeat([]byte{0xc6}, cborMaxDepth), 0x00)
+		So(CBORUnmarshal(tagged, &x), ShouldEqual, ErrCBORTooDeep)
+		So(CBORUnmarshal(nested[cborMaxDepth-32:], &x), ShouldBeNil)
+	})
+
+	Convey("it should limit the number of items in a message", t, func() {
+		var x interface{}
+		many := append([]byte{0x9a, 0, 0x10, 0, 0}, make([]byte, cborMaxItems)...)
+		So(CBORUnmarshal(many, &x), ShouldEqual, ErrCBORTooManyItems)
+	})
+
+	Convey("it should limit the size of a message read from a stream", t, func() {
+		d, err := codec.Encode(node.NewMessage(OK_RESPONSE, "a string that makes the message longer than the limit"))
+		So(err, ShouldBeNil)
+		var m Message
+		dec := cborDecoder{r: &cborLimitReader{r: bytes.NewReader(d), n: int64(len(d) - 1)}}
+		So(dec.decode(reflect.ValueOf(&m).Elem()), ShouldEqual, ErrCBORMessageTooLarge)
+		dec = cborDecoder{r: &cborLimitReader{r: bytes.NewReader(d), n: int64(len(d))}}
+		So(dec.decode(reflect.ValueOf(&m).Elem()), ShouldBeNil)
+	})
+
+	Convey("it should publish a schema of the registered body types", t, func() {
+		schema := CBORSchema()
+		So(schema, ShouldContainSubstring, "message = Message\n")
+		So(schema, ShouldContainSubstring, "  ? Body: body / null,\n")
+		So(schema, ShouldContainSubstring, `#6.27(["HoldReq", HoldReq])`)
+		So(schema, ShouldContainSubstring, "HoldReq = {\n  ? EntryHash: hash,\n")
+		So(schema, ShouldContainSubstring, "  ? Links: [* TaggedHash] / null,\n")
+		So(schema, ShouldContainSubstring, ";   5 = GET_REQUEST (since 0.0.0)\n")
+	})
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	node1, err := makeNode(1234, "node1")
+	if err != nil {
+		panic(err)
+	}
+	defer node1.Close()
+	node2, err := makeNode(1235, "node2")
+	if err != nil {
+		panic(err)
+	}
+	defer node2.Close()
+	node3, err := makeNode(1236, "node3")
+	if err != nil {
+		panic(err)
+	}
+	defer node3.Close()
+
+	// node2 only speaks gob
+	gobID := protocol.ID("/hc-kademlia-fakednahash/" + ProtocolVersion)
+	node2.protocols[KademliaProtocol] = &Protocol{ID: gobID, Receiver: KademliaReceiver, IDs: []protocol.ID{gobID}}
+
+	for _, n := range []*Node{node1, node2, node3} {
+		n.StartProtocol(h, KademliaProtocol)
+	}
+	node1.host.Peerstore().AddAddr(node2.HashAddr, node2.NetAddr, pstore.PermanentAddrTTL)
+	node1.host.Peerstore().AddAddr(node3.HashAddr, node3.NetAddr, pstore.PermanentAddrTTL)
+
+	Convey("nodes should negotiate the codec they prefer", t, func() {
+		s, err := node1.host.NewStream(context.Background(), node3.HashAddr, node1.protocols[KademliaProtocol].IDs...)
+		So(err, ShouldBeNil)
+		So(s.Protocol(), ShouldEqual, protocol.ID("/hc-kademlia-fakednahash/"+ProtocolVersion+"/"+CBORCodecName))
+		s.Close()
+
+		m := node1.NewMessage(FIND_NODE_REQUEST, FindNodeReq{H: HashFromPeerID(node3.HashAddr)})
+		r, err := node1.Send(context.Background(), KademliaProtocol, node3.HashAddr, m)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, OK_RESPONSE)
+		So(fmt.Sprintf("%T", r.Body), ShouldEqual, "holochain.CloserPeersResp")
+	})
+
+	Convey("nodes should fall back to gob with nodes that only speak it", t, func() {
+		s, err := node1.host.NewStream(context.Background(), node2.HashAddr, node1.protocols[KademliaProtocol].IDs...)
+		So(err, ShouldBeNil)
+		So(s.Protocol(), ShouldEqual, gobID)
+		s.Close()
+
+		m := node1.NewMessage(FIND_NODE_REQUEST, FindNodeReq{H: HashFromPeerID(node2.HashAddr)})
+		r, err := node1.Send(context.Background(), KademliaProtocol, node2.HashAddr, m)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, OK_RESPONSE)
+		So(fmt.Sprintf("%T", r.Body), ShouldEqual, "holochain.CloserPeersResp")
+	})
+}
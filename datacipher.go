@@ -0,0 +1,265 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//---------------------------------------------------------------------------------------
+// encryption of chain and dht data at rest
+
+package holochain
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// DataEncryptionNone stores chain and dht data in the clear
+	DataEncryptionNone = ""
+
+	// DataEncryptionAgent encrypts data at rest with a key derived from the agent's private key
+	DataEncryptionAgent = "agent"
+
+	// DataEncryptionPassphrase encrypts data at rest with a key derived from a passphrase
+	// which is taken from the HC_DATA_PASSPHRASE environment variable
+	DataEncryptionPassphrase = "passphrase"
+)
+
+// dataCipherKeyLabel separates the data encryption key from any other use of the agent's key
+const dataCipherKeyLabel = "holochain data at rest"
+
+var ErrDataDecryptionFailed = errors.New("unable to decrypt data, wrong key or corrupted data")
+var ErrMissingDataPassphrase = errors.New("passphrase data encryption requires HC_DATA_PASSPHRASE to be set")
+var ErrChainNotEncrypted = errors.New("chain file was written without encryption, run 'hcadmin chain encrypt' to encrypt it before turning on DataEncryption")
+var ErrChainAlreadyEncrypted = errors.New("chain file is already encrypted")
+
+// DataCipher encrypts and decrypts data that a holochain stores on disk
+type DataCipher struct {
+	aead cipher.AEAD
+}
+
+// NewDataCipher creates a DataCipher using AES-256-GCM with the given 32 byte key
+func NewDataCipher(key []byte) (c *DataCipher, err error) {
+	var block cipher.Block
+	block, err = aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	var aead cipher.AEAD
+	aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+	c = &DataCipher{aead: aead}
+	return
+}
+
+// NewAgentDataCipher creates a DataCipher keyed from the agent's private key
+func NewAgentDataCipher(agent Agent) (c *DataCipher, err error) {
+	var k []byte
+	k, err = agent.PrivKey().Bytes()
+	if err != nil {
+		return
+	}
+	mac := hmac.New(sha256.New, k)
+	mac.Write([]byte(dataCipherKeyLabel))
+	c, err = NewDataCipher(mac.Sum(nil))
+	return
+}
+
+// NewPassphraseDataCipher creates a DataCipher keyed from a passphrase, the
+// salt should be unique to the agent so that equal passphrases give different keys
+func NewPassphraseDataCipher(passphrase string, salt []byte) (c *DataCipher, err error) {
+	if passphrase == "" {
+		err = ErrMissingDataPassphrase
+		return
+	}
+	var k []byte
+	k, err = scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return
+	}
+	c, err = NewDataCipher(k)
+	return
+}
+
+// Seal encrypts data, returning the nonce followed by the cipher text
+func (c *DataCipher) Seal(data []byte) (sealed []byte, err error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return
+	}
+	sealed = c.aead.Seal(nonce, nonce, data, nil)
+	return
+}
+
+// Open decrypts data that was encrypted with Seal
+func (c *DataCipher) Open(sealed []byte) (data []byte, err error) {
+	n := c.aead.NonceSize()
+	if len(sealed) < n {
+		err = ErrDataDecryptionFailed
+		return
+	}
+	data, err = c.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		err = ErrDataDecryptionFailed
+	}
+	return
+}
+
+// SealEntry returns an entry whose content is the encrypted serialization of the given entry
+func (c *DataCipher) SealEntry(e Entry) (sealed Entry, err error) {
+	var b []byte
+	b, err = e.Marshal()
+	if err != nil {
+		return
+	}
+	b, err = c.Seal(b)
+	if err != nil {
+		return
+	}
+	sealed = &GobEntry{C: b}
+	return
+}
+
+// OpenEntry returns the entry that was encrypted with SealEntry
+func (c *DataCipher) OpenEntry(sealed Entry) (e Entry, err error) {
+	b, ok := sealed.Content().([]byte)
+	if !ok {
+		err = ErrDataDecryptionFailed
+		return
+	}
+	b, err = c.Open(b)
+	if err != nil {
+		return
+	}
+	var g GobEntry
+	err = g.Unmarshal(b)
+	if err != nil {
+		return
+	}
+	e = &g
+	return
+}
+
+// inClear returns whether an entry read from a chain file is the unencrypted entry
+// of its header, as it is in a chain file that was written without a cipher
+func inClear(spec HashSpec, header *Header, e Entry) bool {
+	hash, err := e.Sum(spec)
+	return err == nil && hash.Equal(header.EntryLink)
+}
+
+// EncryptChainFile rewrites a chain file that was written in the clear with its
+// entries encrypted by the given cipher, so that encryption can be turned on for
+// an existing chain.  The headers, and so the chain's hashes, are unchanged.
+func EncryptChainFile(spec HashSpec, path string, cipher *DataCipher) (n int, err error) {
+	var plain *Chain
+	plain, err = NewChainFromFile(spec, path)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if plain != nil {
+			plain.Close()
+		}
+	}()
+	n = plain.length()
+	if n == 0 {
+		return
+	}
+	var header *Header
+	var e Entry
+	header, err = plain.header(0)
+	if err == nil {
+		e, err = plain.entry(0)
+	}
+	if err != nil {
+		return
+	}
+	if !inClear(spec, header, e) {
+		err = ErrChainAlreadyEncrypted
+		return
+	}
+
+	tmp := path + ".encrypting"
+	os.Remove(tmp)
+	os.Remove(tmp + ChainIndexFileSuffix)
+	var sealed *Chain
+	sealed, err = NewEncryptedChainFromFile(spec, tmp, cipher)
+	if err != nil {
+		return
+	}
+	for i := 0; i < n && err == nil; i++ {
+		var hash Hash
+		hash, err = plain.hash(i)
+		if err == nil {
+			header, err = plain.header(i)
+		}
+		if err == nil {
+			e, err = plain.entry(i)
+		}
+		if err == nil {
+			err = sealed.addEntry(i, hash, header, e)
+		}
+	}
+	sealed.Close()
+	plain.Close()
+	plain = nil
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		os.Remove(tmp + ChainIndexFileSuffix)
+		return
+	}
+	// the old index is for the unencrypted records so it goes too
+	os.Remove(path + ChainIndexFileSuffix)
+	if _, err = os.Stat(tmp + ChainIndexFileSuffix); err == nil {
+		err = os.Rename(tmp+ChainIndexFileSuffix, path+ChainIndexFileSuffix)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// cipherHT wraps a HashTable so that entry values are encrypted before they
+// are stored and decrypted when they are retrieved
+type cipherHT struct {
+	HashTable
+	cipher *DataCipher
+}
+
+// NewCipherHT returns a HashTable that encrypts the values stored in ht
+func NewCipherHT(ht HashTable, c *DataCipher) HashTable {
+	return &cipherHT{HashTable: ht, cipher: c}
+}
+
+// Put stores an encrypted value to the DHT store
+func (ht *cipherHT) Put(m *Message, entryType string, key Hash, src peer.ID, value []byte, status int) (err error) {
+	var sealed []byte
+	sealed, err = ht.cipher.Seal(value)
+	if err != nil {
+		return
+	}
+	err = ht.HashTable.Put(m, entryType, key, src, sealed, status)
+	return
+}
+
+// Get retrieves and decrypts a value from the DHT store
+func (ht *cipherHT) Get(key Hash, statusMask int, getMask int) (data []byte, entryType string, sources []string, status int, err error) {
+	data, entryType, sources, status, err = ht.HashTable.Get(key, statusMask, getMask)
+	// N.B. when err is ErrHashModified data holds the unencrypted replacing hash
+	if err == nil && data != nil {
+		data, err = ht.cipher.Open(data)
+	}
+	return
+}
@@ -0,0 +1,240 @@
+package holochain
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/holochain/holochain-proto/hash"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDataCipher(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	c, err := NewAgentDataCipher(h.agent)
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should seal and open data", t, func() {
+		sealed, err := c.Seal([]byte("some secret"))
+		So(err, ShouldBeNil)
+		So(bytes.Contains(sealed, []byte("some secret")), ShouldBeFalse)
+		data, err := c.Open(sealed)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "some secret")
+	})
+
+	Convey("it should fail to open data sealed with a different key", t, func() {
+		other, err := NewPassphraseDataCipher("my passphrase", []byte("salt"))
+		So(err, ShouldBeNil)
+		sealed, _ := other.Seal([]byte("some secret"))
+		_, err = c.Open(sealed)
+		So(err, ShouldEqual, ErrDataDecryptionFailed)
+		_, err = c.Open([]byte("x"))
+		So(err, ShouldEqual, ErrDataDecryptionFailed)
+	})
+
+	Convey("it should require a passphrase", t, func() {
+		_, err := NewPassphraseDataCipher("", []byte("salt"))
+		So(err, ShouldEqual, ErrMissingDataPassphrase)
+	})
+
+	Convey("it should seal and open entries", t, func() {
+		sealed, err := c.SealEntry(&GobEntry{C: "some data"})
+		So(err, ShouldBeNil)
+		e, err := c.OpenEntry(sealed)
+		So(err, ShouldBeNil)
+		So(e.Content(), ShouldEqual, "some data")
+	})
+}
+
+func TestEncryptedChainFromFile(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	hashSpec, key, now := chainTestSetup()
+	c, _ := NewPassphraseDataCipher("my passphrase", []byte("salt"))
+	path := filepath.Join(d, "chain.dat")
+
+	chain, err := NewEncryptedChainFromFile(hashSpec, path, c)
+	if err != nil {
+		panic(err)
+	}
+	chain.AddEntry(now, "entryTypeFoo1", &GobEntry{C: "some private data"}, key)
+	chain.AddEntry(now, "entryTypeFoo2", &GobEntry{C: "some other data"}, key)
+	dump := chain.String()
+	chain.Close()
+
+	Convey("the entries should not be stored in the clear", t, func() {
+		b, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(bytes.Contains(b, []byte("some private data")), ShouldBeFalse)
+	})
+
+	Convey("it should load the chain with the same cipher", t, func() {
+		chain, err := NewEncryptedChainFromFile(hashSpec, path, c)
+		So(err, ShouldBeNil)
+		So(chain.String(), ShouldEqual, dump)
+		chain.AddEntry(now, "entryTypeFoo3", &GobEntry{C: "more data"}, key)
+		dump = chain.String()
+		chain.Close()
+
+		chain, err = NewEncryptedChainFromFile(hashSpec, path, c)
+		So(err, ShouldBeNil)
+		So(chain.String(), ShouldEqual, dump)
+		chain.Close()
+	})
+
+	Convey("it should fail to load the chain with a different cipher", t, func() {
+		other, _ := NewPassphraseDataCipher("wrong passphrase", []byte("salt"))
+		_, err := NewEncryptedChainFromFile(hashSpec, path, other)
+		So(err, ShouldEqual, ErrDataDecryptionFailed)
+	})
+}
+
+func TestEncryptChainFile(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	hashSpec, key, now := chainTestSetup()
+	c, _ := NewPassphraseDataCipher("my passphrase", []byte("salt"))
+	path := filepath.Join(d, "chain.dat")
+
+	chain, err := NewChainFromFile(hashSpec, path)
+	if err != nil {
+		panic(err)
+	}
+	chain.AddEntry(now, "entryTypeFoo1", &GobEntry{C: "some private data"}, key)
+	chain.AddEntry(now, "entryTypeFoo2", &GobEntry{C: "some other data"}, key)
+	dump := chain.String()
+	chain.Close()
+
+	Convey("a chain written in the clear should not load with a cipher", t, func() {
+		_, err := NewEncryptedChainFromFile(hashSpec, path, c)
+		So(err, ShouldEqual, ErrChainNotEncrypted)
+	})
+
+	Convey("it should encrypt a chain written in the clear", t, func() {
+		n, err := EncryptChainFile(hashSpec, path, c)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 2)
+		b, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(bytes.Contains(b, []byte("some private data")), ShouldBeFalse)
+
+		chain, err := NewEncryptedChainFromFile(hashSpec, path, c)
+		So(err, ShouldBeNil)
+		So(chain.String(), ShouldEqual, dump)
+		So(chain.Verify(ChainVerificationFull), ShouldBeNil)
+		chain.Close()
+	})
+
+	Convey("it should not encrypt a chain twice", t, func() {
+		_, err := EncryptChainFile(hashSpec, path, c)
+		So(err, ShouldEqual, ErrChainAlreadyEncrypted)
+	})
+}
+
+func TestCipherHT(t *testing.T) {
+	node, err := makeNode(1234, "")
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+	mem, _ := NewMemHT("")
+	c, _ := NewPassphraseDataCipher("my passphrase", []byte("salt"))
+	ht := NewCipherHT(mem, c)
+	hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	newHash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+
+	Convey("it should store encrypted values and retrieve them decrypted", t, func() {
+		err := ht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash}), "someType", hash, node.HashAddr, []byte("some value"), StatusLive)
+		So(err, ShouldBeNil)
+
+		data, _, _, _, err := mem.Get(hash, StatusLive, GetMaskEntry)
+		So(err, ShouldBeNil)
+		So(bytes.Contains(data, []byte("some value")), ShouldBeFalse)
+
+		data, entryType, _, _, err := ht.Get(hash, StatusLive, GetMaskEntry+GetMaskEntryType)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "some value")
+		So(entryType, ShouldEqual, "someType")
+	})
+
+	Convey("it should return the replacing hash of modified entries as is", t, func() {
+		err := ht.Mod(node.NewMessage(MOD_REQUEST, HoldReq{RelatedHash: hash, EntryHash: newHash}), hash, newHash, time.Now())
+		So(err, ShouldBeNil)
+		data, _, _, _, err := ht.Get(hash, StatusDefault, GetMaskDefault)
+		So(err, ShouldEqual, ErrHashModified)
+		So(string(data), ShouldEqual, newHash.String())
+	})
+}
+
+func TestDataEncryptionConfig(t *testing.T) {
+	Convey("it should fail on an unknown DataEncryption", t, func() {
+		config := Config{DataEncryption: "bogus"}
+		err := config.Setup()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "Unknown DataEncryption: bogus")
+	})
+
+	Convey("passphrase encryption should require HC_DATA_PASSPHRASE", t, func() {
+		config := Config{DataEncryption: DataEncryptionPassphrase}
+		So(config.Setup(), ShouldEqual, ErrMissingDataPassphrase)
+		os.Setenv("HC_DATA_PASSPHRASE", "my passphrase")
+		defer os.Unsetenv("HC_DATA_PASSPHRASE")
+		So(config.Setup(), ShouldBeNil)
+	})
+
+	Convey("a holochain with agent encryption should encrypt its chain file", t, func() {
+		os.Setenv("HOLOCHAINCONFIG_DATAENCRYPTION", DataEncryptionAgent)
+		d, _, h := PrepareTestChain("test")
+		os.Unsetenv("HOLOCHAINCONFIG_DATAENCRYPTION")
+		defer CleanupTestChain(h, d)
+
+		So(h.Config.DataEncryption, ShouldEqual, DataEncryptionAgent)
+		c, err := h.DataCipher()
+		So(err, ShouldBeNil)
+		So(c, ShouldNotBeNil)
+		So(h.chain.cipher, ShouldEqual, c)
+		_, ok := h.dht.ht.(*cipherHT)
+		So(ok, ShouldBeTrue)
+
+		b, err := ioutil.ReadFile(filepath.Join(h.DBPath(), StoreFileName))
+		So(err, ShouldBeNil)
+		So(bytes.Contains(b, []byte(h.agent.Identity())), ShouldBeFalse)
+
+		// entries should still be readable from the chain
+		hash := commit(h, "oddNumbers", "7")
+		entry, _, err := h.chain.GetEntry(hash)
+		So(err, ShouldBeNil)
+		So(entry.Content(), ShouldEqual, "7")
+	})
+
+	Convey("turning on encryption for a chain written in the clear should need the chain encrypted first", t, func() {
+		d, s, h := PrepareTestChain("test")
+		defer CleanupTestDir(d)
+		dump := h.chain.String()
+		h.Close()
+
+		os.Setenv("HOLOCHAINCONFIG_DATAENCRYPTION", DataEncryptionAgent)
+		defer os.Unsetenv("HOLOCHAINCONFIG_DATAENCRYPTION")
+		_, err := s.Load("test")
+		So(err, ShouldEqual, ErrChainNotEncrypted)
+
+		h, err = s.LoadWithOptions("test", LoadOptions{EncryptChain: true})
+		So(err, ShouldBeNil)
+		So(h.chain.String(), ShouldEqual, dump)
+		h.Close()
+
+		h, err = s.Load("test")
+		So(err, ShouldBeNil)
+		So(h.chain.cipher, ShouldNotBeNil)
+		So(h.chain.String(), ShouldEqual, dump)
+		h.Close()
+	})
+}
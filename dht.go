@@ -11,10 +11,11 @@ import (
 	"errors"
 	"fmt"
 	"gopkg.in/mgo.v2/bson"
-	"path/filepath"
 	"sync"
+	"time"
 
 	. "github.com/holochain/holochain-proto/hash"
+	queue "github.com/holochain/holochain-proto/peerqueue"
 	peer "github.com/libp2p/go-libp2p-peer"
 )
 
@@ -30,17 +31,23 @@ type DHTConfig struct {
 
 	// ShardingMethod : Identifier for sharding method (none, XOR, hashmask, other nearness algorithms?, etc.)
 
-	// MaxLinkSets : (integer) Maximum number of results to return on a GetLinks query to keep computation and traffic to a reasonable size. You need to break these result sets into multiple "pages" of results retrieve more.
+	// MaxLinkSets : (integer) Maximum number of results to return on a GetLinks query to keep computation and traffic to a reasonable size. You need to break these result sets into multiple "pages" of results retrieve more. ZERO means no maximum.
+	MaxLinkSets int `json:",omitempty" toml:",omitempty" yaml:",omitempty"`
 
 	// ValidationTimeout : (integer) Time period in seconds, until data that needs to be validated against a source remains "alive" to keep trying to get validation from that source. If someone commits something and then goes offline, how long do they have to come back online before DHT sync requests consider that data invalid?
 
 	//PeerTimeout : (integer) Time period in seconds, until a node drops a peer from its neighborhood list for failing to respond to gossip requests.
 
 	// WireEncryption : settings for point-to-point encryption of messages on the network (none, AES, what are the options?)
+	// Closed groups can already keep outsiders off their network with a shared secret, see Config.PrivateNetwork
 
-	// DataEncryption : What are the options for encrypting data at rest in the dht.db that don't break db functionality? Is there really a point to trying to do this?
+	// DataEncryption : encryption of data at rest is a choice of each node rather than of the DNA, see Config.DataEncryption
 
-	// MaxEntrySize : Sets the maximum allowable size of entries for this holochain
+	// MaxEntrySize : (integer) Sets the maximum allowable size in bytes of entries for this holochain. Nodes refuse to hold larger entries. ZERO means no maximum.
+	MaxEntrySize int `json:",omitempty" toml:",omitempty" yaml:",omitempty"`
+
+	// SourceQuota : (integer) Maximum number of bytes of entry data a node will hold on behalf of any single source agent. ZERO means no quota.
+	SourceQuota int `json:",omitempty" toml:",omitempty" yaml:",omitempty"`
 }
 
 type gossipWithReq struct {
@@ -61,6 +68,12 @@ type DHT struct {
 	gchan       Channel
 	config      *DHTConfig
 	glk         sync.RWMutex
+
+	changeFeed    Channel
+	feedLk        sync.Mutex // serializes changes so the feed reports them in order
+	subscriptions map[int]*dhtSubscription
+	subLk         sync.RWMutex
+	lastSubID     int
 	//	sources      map[peer.ID]bool
 	//	fingerprints map[string]bool
 }
@@ -83,6 +96,7 @@ const (
 type HoldReq struct {
 	EntryHash   Hash // hash of the entry responsible for the change
 	RelatedHash Hash // hash of the related entry (link=base,del=deleted, mod=modified by)
+	Size        int  // size of the entry if the sender knows it, so it can be refused before fetching
 }
 
 // HoldResp holds the signature and code of how a hold request was treated
@@ -111,10 +125,25 @@ type LinkQuery struct {
 	Base       Hash
 	T          string
 	StatusMask int
-	// order
-	// filter, etc
+	Page       int      // which page of Count links to return, ignored if Cursor is set
+	Count      int      // number of links to return, capped by the DHT's MaxLinkSets
+	Cursor     string   // continue returning links after the link the cursor points to
+	Order      string   // what to order the links by, one of the LinkOrder constants
+	Descending bool     // whether to reverse the order of the links
+	Sources    []string // only return links made by these sources
+	EntryTypes []string // only return links to entries of these types
+	TagPrefix  string   // only return links with tags that start with the prefix
+	TagRegex   string   // only return links with tags that match the regular expression
 }
 
+const (
+	// constants for ordering the results of a LinkQuery
+
+	LinkOrderHash   = "hash"   // order by the hash of the link, the default
+	LinkOrderTime   = "time"   // order by the time the link was made
+	LinkOrderSource = "source" // order by the agent that made the link
+)
+
 // GetOptions options to holochain level Get functions
 type GetOptions struct {
 	StatusMask int  // mask of which status of entries to return
@@ -125,13 +154,23 @@ type GetOptions struct {
 
 // GetLinksOptions options to holochain level GetLinks functions
 type GetLinksOptions struct {
-	Load       bool // indicates whether GetLinks should retrieve the entries of all links
-	StatusMask int  // mask of which status of links to return
+	Load       bool     // indicates whether GetLinks should retrieve the entries of all links
+	StatusMask int      // mask of which status of links to return
+	Page       int      // which page of Count links to return
+	Count      int      // how many links to return per page
+	Cursor     string   // the Next value of a previous response to continue from
+	Order      string   // what to order the links by, one of the LinkOrder constants
+	Descending bool     // whether to reverse the order of the links
+	Sources    []string // only return links made by these sources
+	EntryTypes []string // only return links to entries of these types
+	TagPrefix  string   // only return links with tags that start with the prefix
+	TagRegex   string   // only return links with tags that match the regular expression
 }
 
 // LinkQueryResp holds response to getLinks query
 type LinkQueryResp struct {
 	Links []TaggedHash
+	Next  string // cursor for retrieving the following links, empty if there are no more
 }
 
 type ListAddReq struct {
@@ -152,10 +191,13 @@ const (
 var ErrNotAcceptedByAnyRemoteNode = errors.New("Change not accepted by any remote node")
 
 // NewDHT creates a new DHT structure
-func NewDHT(h *Holochain) *DHT {
-	dht := DHT{}
-	dht.Open(h)
-	return &dht
+func NewDHT(h *Holochain) (dht *DHT, err error) {
+	d := DHT{}
+	err = d.Open(h)
+	if err == nil {
+		dht = &d
+	}
+	return
 }
 
 // Open sets up the DHTs data structures and store
@@ -166,10 +208,22 @@ func (dht *DHT) Open(options interface{}) (err error) {
 	dht.dlog = &h.Config.Loggers.DHT
 	dht.config = &h.Nucleus().DNA().DHTConfig
 
-	dht.ht = &BuntHT{}
-	dht.ht.Open(filepath.Join(h.DBPath(), DHTStoreFileName))
+	dht.ht, err = CreateHashTable(h.Config.HashTableType, h.DBPath())
+	if err != nil {
+		return
+	}
+	var c *DataCipher
+	c, err = h.DataCipher()
+	if err != nil {
+		return
+	}
+	if c != nil {
+		dht.ht = NewCipherHT(dht.ht, c)
+	}
 	dht.retryQueue = make(chan *retry, 100)
 	dht.changeQueue = make(Channel, 100)
+	dht.changeFeed = make(Channel, DHTChangeFeedQueueSize)
+	dht.subscriptions = make(map[int]*dhtSubscription)
 	//go dht.HandleChangeRequests()
 
 	//	dht.sources = make(map[peer.ID]bool)
@@ -246,7 +300,9 @@ func (dht *DHT) SetupDHT() (err error) {
 // N.B. This call assumes that the value has already been validated
 func (dht *DHT) Put(m *Message, entryType string, key Hash, src peer.ID, value []byte, status int) (err error) {
 	dht.dlog.Logf("put %v=>%s", key, string(value))
-	err = dht.ht.Put(m, entryType, key, src, value, status)
+	err = dht.record(DHTChange{Type: DHTChangePut, Hash: key.String(), EntryType: entryType}, func() error {
+		return dht.ht.Put(m, entryType, key, src, value, status)
+	})
 	return
 }
 
@@ -254,15 +310,19 @@ func (dht *DHT) Put(m *Message, entryType string, key Hash, src peer.ID, value [
 // N.B. this functions assumes that the validity of this action has been confirmed
 func (dht *DHT) Del(m *Message, key Hash) (err error) {
 	dht.dlog.Logf("del %v", key)
-	err = dht.ht.Del(m, key)
+	err = dht.record(DHTChange{Type: DHTChangeDel, Hash: key.String()}, func() error {
+		return dht.ht.Del(m, key)
+	})
 	return
 }
 
 // Mod moves the given hash to the StatusModified status
 // N.B. this functions assumes that the validity of this action has been confirmed
-func (dht *DHT) Mod(m *Message, key Hash, newkey Hash) (err error) {
+func (dht *DHT) Mod(m *Message, key Hash, newkey Hash, t time.Time) (err error) {
 	dht.dlog.Logf("mod %v", key)
-	err = dht.ht.Mod(m, key, newkey)
+	err = dht.record(DHTChange{Type: DHTChangeMod, Hash: key.String(), NewHash: newkey.String()}, func() error {
+		return dht.ht.Mod(m, key, newkey, t)
+	})
 	return
 }
 
@@ -287,17 +347,21 @@ func (dht *DHT) Get(key Hash, statusMask int, getMask int) (data []byte, entryTy
 // PutLink associates a link with a stored hash
 // N.B. this function assumes that the data associated has been properly retrieved
 // and validated from the cource chain
-func (dht *DHT) PutLink(m *Message, base string, link string, tag string) (err error) {
+func (dht *DHT) PutLink(m *Message, base string, link string, tag string, entryType string, t time.Time) (err error) {
 	dht.dlog.Logf("putLink on %v link %v as %s", base, link, tag)
-	err = dht.ht.PutLink(m, base, link, tag)
+	err = dht.record(DHTChange{Type: DHTChangeLink, Hash: base, Link: link, Tag: tag}, func() error {
+		return dht.ht.PutLink(m, base, link, tag, entryType, t)
+	})
 	return
 }
 
 // DelLink removes a link and tag associated with a stored hash
 // N.B. this function assumes that the action has been properly validated
-func (dht *DHT) DelLink(m *Message, base string, link string, tag string) (err error) {
+func (dht *DHT) DelLink(m *Message, base string, link string, tag string, t time.Time) (err error) {
 	dht.dlog.Logf("delLink on %v link %v as %s", base, link, tag)
-	err = dht.ht.DelLink(m, base, link, tag)
+	err = dht.record(DHTChange{Type: DHTChangeDelLink, Hash: base, Link: link, Tag: tag}, func() error {
+		return dht.ht.DelLink(m, base, link, tag, t)
+	})
 	return
 }
 
@@ -308,6 +372,75 @@ func (dht *DHT) GetLinks(base Hash, tag string, statusMask int) (results []Tagge
 	return
 }
 
+// Forget removes all the data stored for a hash from the DHT's HashTable
+func (dht *DHT) Forget(key Hash) (err error) {
+	dht.dlog.Logf("forget %v", key)
+	err = dht.ht.Forget(key)
+	return
+}
+
+// PutReceipt stores a receipt returned by a node for a change to a hash
+func (dht *DHT) PutReceipt(key Hash, receipt Receipt) (err error) {
+	err = dht.ht.PutReceipt(key, receipt)
+	return
+}
+
+// GetReceipts returns a list of receipts that were generated regarding a hash
+func (dht *DHT) GetReceipts(key Hash) (receipts []Receipt, err error) {
+	receipts, err = dht.ht.GetReceipts(key)
+	return
+}
+
+// PutHeaderProof stores the proof of one of an agent's headers under the header it
+// follows, unless a proof is already stored there in which case that one is returned
+func (dht *DHT) PutHeaderProof(agent peer.ID, proof ChainProof) (seen *ChainProof, err error) {
+	seen, err = dht.ht.PutHeaderProof(agent, proof)
+	return
+}
+
+// GetUsage returns the number of bytes of entry data held on behalf of a source
+func (dht *DHT) GetUsage(src peer.ID) (bytes int, err error) {
+	bytes, err = dht.ht.GetUsage(src)
+	return
+}
+
+// Usage returns the number of bytes of entry data held on behalf of each source
+func (dht *DHT) Usage() (usage map[peer.ID]int, err error) {
+	usage, err = dht.ht.Usage()
+	return
+}
+
+// putReq makes the body of a PUT_REQUEST for an entry including its size
+func putReq(hash Hash, entry Entry) (req HoldReq) {
+	req.EntryHash = hash
+	b, err := entry.Marshal()
+	if err == nil {
+		req.Size = len(b)
+	}
+	return
+}
+
+// checkHoldLimits returns ErrEntryTooLarge or ErrQuotaExceeded if holding the value
+// on behalf of the source would exceed the MaxEntrySize or SourceQuota of the DNA
+func (dht *DHT) checkHoldLimits(src peer.ID, value []byte) (err error) {
+	size := len(value)
+	if dht.config.MaxEntrySize > 0 && size > dht.config.MaxEntrySize {
+		err = ErrEntryTooLarge
+		return
+	}
+	if dht.config.SourceQuota > 0 {
+		var used int
+		used, err = dht.GetUsage(src)
+		if err != nil {
+			return
+		}
+		if used+size > dht.config.SourceQuota {
+			err = ErrQuotaExceeded
+		}
+	}
+	return
+}
+
 // HandleChangeRequests waits on a channel for dht change requests
 func (dht *DHT) HandleChangeRequests() (err error) {
 	err = dht.handleTillDone("HandleChangeRequests", dht.changeQueue, handleChangeRequests)
@@ -320,7 +453,7 @@ func handleChangeRequests(dht *DHT, x interface{}) (err error) {
 	return
 }
 
-func (dht *DHT) sendChange(p peer.ID, msg *Message) (held bool, err error) {
+func (dht *DHT) sendChange(p peer.ID, key Hash, msg *Message) (held bool, err error) {
 	if dht == nil || dht.h.node == nil {
 		return
 	}
@@ -336,9 +469,20 @@ func (dht *DHT) sendChange(p peer.ID, msg *Message) (held bool, err error) {
 			if t.Code == ReceiptRejected {
 				// TODO what else do we do if rejected?
 				dht.dlog.Logf("DHT send of %v failed to peer %v was rejected", msg, p)
+				dht.updateReputation(p, Reputation{RejectedPuts: 1})
+			} else if dht.receiptValid(p, msg, &t) {
+				held = true
+				dht.updateReputation(p, Reputation{Holds: 1})
+				// keep the receipt as proof of the node having agreed to hold the change
+				err = dht.PutReceipt(key, Receipt{Peer: p, Code: t.Code, Signature: t.Signature, Msg: *msg, Time: time.Now()})
+				if err != nil {
+					dht.dlog.Logf("DHT failed to store receipt from peer %v: %v", p, err)
+					err = nil
+				}
+			} else {
+				dht.dlog.Logf("DHT send of %v to peer %v returned a receipt with a bad signature", msg, p)
+				dht.updateReputation(p, Reputation{InvalidData: 1})
 			}
-			held = true
-			// TODO check the signature on the receipt
 		case CloserPeersResp:
 			closerPeers := peerInfos2Pis(t.CloserPeers)
 			//	s := fmt.Sprintf("%v says closer to %v are: ", p.Pretty()[2:4], key)
@@ -373,7 +517,7 @@ func (dht *DHT) change(req changeReq) (err error) {
 		wg.Add(1)
 		go func(p peer.ID) {
 			defer wg.Done()
-			wasHeld, err := dht.sendChange(p, msg)
+			wasHeld, err := dht.sendChange(p, key, msg)
 			if err != nil {
 				dht.dlog.Logf("DHT sendChange of %v failed to peer %v with error: %s", msg.Type, p, err)
 			} else if wasHeld {
@@ -432,8 +576,8 @@ func (dht *DHT) Query(key Hash, msgType MsgType, body interface{}) (response int
 		err = nil
 	}
 
-	// get closest peers in the routing table
-	rtp := dht.h.node.routingTable.NearestPeers(key, AlphaValue)
+	// start with the fastest of the closest peers in the routing table
+	rtp := dht.queryStartPeers(key)
 	dht.h.Debugf("peers in rt: %d %s", len(rtp), rtp)
 	if len(rtp) == 0 {
 		Info("DHT Query with no peers in routing table!")
@@ -480,6 +624,20 @@ func (dht *DHT) Query(key Hash, msgType MsgType, body interface{}) (response int
 	return
 }
 
+// queryStartPeers returns the peers a query fans out to first, the
+// AlphaValue peers that have responded fastest of the KValue peers in the
+// routing table closest to the key
+func (dht *DHT) queryStartPeers(key Hash) (peers []peer.ID) {
+	pq := queue.NewLatencyPQ(key, dht.h.node.Latency)
+	for _, p := range dht.h.node.routingTable.NearestPeers(key, KValue) {
+		pq.Enqueue(p)
+	}
+	for pq.Len() > 0 && len(peers) < AlphaValue {
+		peers = append(peers, pq.Dequeue())
+	}
+	return
+}
+
 // Send sends a message to the node
 func (dht *DHT) send(ctx context.Context, to peer.ID, msg *Message) (response interface{}, err error) {
 	if ctx == nil {
@@ -531,6 +689,11 @@ func (dht *DHT) JSON() (result string, err error) {
 func (dht *DHT) Close() {
 	close(dht.changeQueue)
 	dht.changeQueue = nil
+	dht.feedLk.Lock()
+	close(dht.changeFeed)
+	dht.changeFeed = nil
+	dht.feedLk.Unlock()
+	dht.unsubscribeAll()
 	close(dht.retryQueue)
 	dht.retryQueue = nil
 	close(dht.gchan)
@@ -591,6 +754,24 @@ func (dht *DHT) MakeReceiptSignature(msg *Message, code int) (sig Signature, err
 	return
 }
 
+// receiptValid checks the signature on a hold receipt against the public key
+// of the peer that sent it, if we know it from the world model
+func (dht *DHT) receiptValid(p peer.ID, msg *Message, resp *HoldResp) bool {
+	if dht.h.world == nil {
+		return true
+	}
+	record := dht.h.world.GetNodeRecord(p)
+	if record == nil || record.PubKey == nil {
+		return true
+	}
+	data, err := MakeReceiptData(msg, resp.Code)
+	if err != nil {
+		return false
+	}
+	matches, err := dht.h.VerifySignature(resp.Signature, string(data), record.PubKey)
+	return err == nil && matches
+}
+
 // MakeHoldResp creates fill the HoldResp struct with a the holding status and signature
 func (dht *DHT) MakeHoldResp(msg *Message, status int) (holdResp *HoldResp, err error) {
 	hr := HoldResp{}
@@ -0,0 +1,203 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//---------------------------------------------------------------------------------------
+// feed of the changes made to the local DHT and subscriptions to it
+
+package holochain
+
+import (
+	"errors"
+	"sync"
+
+	. "github.com/holochain/holochain-proto/hash"
+)
+
+// the types of change reported by the DHT change feed
+const (
+	DHTChangePut     = "put"
+	DHTChangeMod     = "mod"
+	DHTChangeDel     = "del"
+	DHTChangeLink    = "link"
+	DHTChangeDelLink = "delLink"
+)
+
+// DHTChangeFeedQueueSize is the number of changes that can be waiting to be
+// handed to subscribers, further changes are dropped from the feed
+const DHTChangeFeedQueueSize = 100
+
+// DHTSubscriptionQueueSize is the number of changes that can be waiting to be
+// delivered to a subscriber, further changes are dropped for that subscriber
+const DHTSubscriptionQueueSize = 100
+
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// DHTChange describes a change made to the local DHT
+type DHTChange struct {
+	Idx       int    // index of the change in the HashTable, see GetIdxMessage
+	Type      string // one of the DHTChange types
+	Hash      string // hash that was put, modified or deleted, or the base of a link
+	NewHash   string // for mods the hash of the replacing entry
+	Link      string // for links the linked hash
+	Tag       string // for links the tag
+	EntryType string // the entry type of Hash
+}
+
+// DHTChangeFilter selects the changes a subscription gets, empty fields match any change
+type DHTChangeFilter struct {
+	Hash      string   // the hash of an entry or a link base
+	Tag       string   // the tag of a link
+	EntryType string   // the entry type of the entry or link base
+	Types     []string // the types of change
+}
+
+// DHTChangeFn is called with each change that matches a subscription
+type DHTChangeFn func(id int, change DHTChange)
+
+type dhtSubscription struct {
+	filter  DHTChangeFilter
+	fn      DHTChangeFn
+	changes chan DHTChange
+	pending sync.WaitGroup // changes queued that fn hasn't been called with yet
+}
+
+// deliver calls the subscription's function with its changes in order until
+// the subscription is stopped
+func (s *dhtSubscription) deliver(id int) {
+	for change := range s.changes {
+		s.fn(id, change)
+		s.pending.Done()
+	}
+}
+
+// Match returns true if the change passes the filter
+func (f *DHTChangeFilter) Match(change *DHTChange) bool {
+	if f.Hash != "" && f.Hash != change.Hash {
+		return false
+	}
+	if f.Tag != "" && f.Tag != change.Tag {
+		return false
+	}
+	if f.EntryType != "" && f.EntryType != change.EntryType {
+		return false
+	}
+	if len(f.Types) > 0 && !contains(f.Types, change.Type) {
+		return false
+	}
+	return true
+}
+
+// Subscribe registers fn to be called with every change to the DHT that matches
+// the filter and returns an id for unsubscribing.  Changes are delivered in order
+// from a go routine of the subscription's own, and if fn falls more than
+// DHTSubscriptionQueueSize changes behind the changes it misses are dropped.
+func (dht *DHT) Subscribe(filter DHTChangeFilter, fn DHTChangeFn) (id int) {
+	dht.subLk.Lock()
+	defer dht.subLk.Unlock()
+	dht.lastSubID++
+	id = dht.lastSubID
+	s := &dhtSubscription{filter: filter, fn: fn, changes: make(chan DHTChange, DHTSubscriptionQueueSize)}
+	dht.subscriptions[id] = s
+	go s.deliver(id)
+	return
+}
+
+// Unsubscribe stops a subscription made with Subscribe
+func (dht *DHT) Unsubscribe(id int) (err error) {
+	dht.subLk.Lock()
+	defer dht.subLk.Unlock()
+	s := dht.subscriptions[id]
+	if s == nil {
+		err = ErrSubscriptionNotFound
+		return
+	}
+	close(s.changes)
+	delete(dht.subscriptions, id)
+	return
+}
+
+// unsubscribeAll stops all the subscriptions
+func (dht *DHT) unsubscribeAll() {
+	dht.subLk.Lock()
+	defer dht.subLk.Unlock()
+	for id, s := range dht.subscriptions {
+		close(s.changes)
+		delete(dht.subscriptions, id)
+	}
+}
+
+func (dht *DHT) hasSubscriptions() bool {
+	dht.subLk.RLock()
+	defer dht.subLk.RUnlock()
+	return len(dht.subscriptions) > 0
+}
+
+// record makes a change to the HashTable with fn and if it succeeds adds it to
+// the change feed.  Changes are serialized so that the index reported with each
+// change is the one the HashTable gave it, and never wait on the feed, which
+// drops changes when it's full.
+func (dht *DHT) record(change DHTChange, fn func() error) (err error) {
+	dht.feedLk.Lock()
+	defer dht.feedLk.Unlock()
+	err = fn()
+	if err != nil || !dht.hasSubscriptions() || dht.changeFeed == nil {
+		return
+	}
+	change.Idx, err = dht.ht.GetIdx()
+	if err != nil {
+		return
+	}
+	if change.EntryType == "" {
+		hash, e := NewHash(change.Hash)
+		if e == nil {
+			_, change.EntryType, _, _, _ = dht.ht.Get(hash, StatusAny, GetMaskEntryType)
+		}
+	}
+	select {
+	case dht.changeFeed <- change:
+	default:
+		dht.dlog.Logf("change feed full, dropping change %d", change.Idx)
+	}
+	return
+}
+
+// HandleChangeFeed waits on a channel for changes to the DHT and delivers them to subscribers
+func (dht *DHT) HandleChangeFeed() (err error) {
+	err = dht.handleTillDone("HandleChangeFeed", dht.changeFeed, handleChangeFeed)
+	return
+}
+
+func handleChangeFeed(dht *DHT, x interface{}) (err error) {
+	change := x.(DHTChange)
+	dht.subLk.RLock()
+	defer dht.subLk.RUnlock()
+	for id, s := range dht.subscriptions {
+		if s.filter.Match(&change) {
+			s.pending.Add(1)
+			select {
+			case s.changes <- change:
+			default:
+				s.pending.Done()
+				dht.dlog.Logf("subscription %d fell behind, dropping change %d", id, change.Idx)
+			}
+		}
+	}
+	return
+}
+
+// Subscribe registers a zome function to be called with changes to the DHT that match the filter
+func (h *Holochain) Subscribe(zomeName string, filter DHTChangeFilter, callback string) (id int, err error) {
+	_, err = h.GetZome(zomeName)
+	if err != nil {
+		return
+	}
+	id = h.dht.Subscribe(filter, func(id int, change DHTChange) {
+		r, _, err := h.MakeRibosome(zomeName)
+		if err == nil {
+			_, err = r.RunSubscriptionCallback(change, callback, id)
+		}
+		if err != nil {
+			h.dht.dlog.Logf("subscription %d callback %s failed: %v", id, callback, err)
+		}
+	})
+	return
+}
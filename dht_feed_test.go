@@ -0,0 +1,193 @@
+package holochain
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDHTChangeFilter(t *testing.T) {
+	change := DHTChange{Type: DHTChangeLink, Hash: "QmBase", Link: "QmLink", Tag: "foo", EntryType: "profile"}
+	Convey("an empty filter should match any change", t, func() {
+		f := DHTChangeFilter{}
+		So(f.Match(&change), ShouldBeTrue)
+	})
+	Convey("a filter should match on each of its fields", t, func() {
+		So((&DHTChangeFilter{Hash: "QmBase"}).Match(&change), ShouldBeTrue)
+		So((&DHTChangeFilter{Hash: "QmLink"}).Match(&change), ShouldBeFalse)
+		So((&DHTChangeFilter{Tag: "foo"}).Match(&change), ShouldBeTrue)
+		So((&DHTChangeFilter{Tag: "bar"}).Match(&change), ShouldBeFalse)
+		So((&DHTChangeFilter{EntryType: "profile"}).Match(&change), ShouldBeTrue)
+		So((&DHTChangeFilter{EntryType: "oddNumbers"}).Match(&change), ShouldBeFalse)
+		So((&DHTChangeFilter{Types: []string{DHTChangePut, DHTChangeLink}}).Match(&change), ShouldBeTrue)
+		So((&DHTChangeFilter{Types: []string{DHTChangePut}}).Match(&change), ShouldBeFalse)
+		So((&DHTChangeFilter{Hash: "QmBase", Tag: "bar"}).Match(&change), ShouldBeFalse)
+	})
+}
+
+func TestDHTSubscribe(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	dht := h.dht
+	var lk sync.Mutex
+	changes := make(map[int][]DHTChange)
+	collect := func(id int, change DHTChange) {
+		lk.Lock()
+		defer lk.Unlock()
+		changes[id] = append(changes[id], change)
+	}
+	deliver := func() {
+		for len(dht.changeFeed) > 0 {
+			handleChangeFeed(dht, <-dht.changeFeed)
+		}
+		waitDelivered(dht)
+	}
+
+	hash := commit(h, "evenNumbers", "2")
+	hash2 := commit(h, "evenNumbers", "4")
+
+	Convey("changes should not be queued without subscribers", t, func() {
+		m := h.node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash})
+		err := dht.Put(m, "evenNumbers", hash, h.nodeID, []byte("2"), StatusLive)
+		So(err, ShouldBeNil)
+		So(len(dht.changeFeed), ShouldEqual, 0)
+	})
+
+	var id int
+	Convey("subscribers should get the changes that match their filters", t, func() {
+		id = dht.Subscribe(DHTChangeFilter{}, collect)
+		linkID := dht.Subscribe(DHTChangeFilter{Types: []string{DHTChangeLink}}, collect)
+		So(linkID, ShouldEqual, id+1)
+
+		m := h.node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash2})
+		err := dht.Put(m, "evenNumbers", hash2, h.nodeID, []byte("4"), StatusLive)
+		So(err, ShouldBeNil)
+		idx, _ := dht.GetIdx()
+
+		m = h.node.NewMessage(LINK_REQUEST, HoldReq{RelatedHash: hash2, EntryHash: hash})
+		err = dht.PutLink(m, hash.String(), hash2.String(), "next", "evenNumbers", time.Now())
+		So(err, ShouldBeNil)
+		deliver()
+
+		So(len(changes[id]), ShouldEqual, 2)
+		So(fmt.Sprintf("%v", changes[id][0]), ShouldEqual, fmt.Sprintf("%v", DHTChange{Idx: idx, Type: DHTChangePut, Hash: hash2.String(), EntryType: "evenNumbers"}))
+		So(changes[id][1].Type, ShouldEqual, DHTChangeLink)
+		So(changes[id][1].Idx, ShouldEqual, idx+1)
+		So(changes[id][1].Hash, ShouldEqual, hash.String())
+		So(changes[id][1].Link, ShouldEqual, hash2.String())
+		So(changes[id][1].Tag, ShouldEqual, "next")
+		So(len(changes[linkID]), ShouldEqual, 1)
+		So(changes[linkID][0], ShouldResemble, changes[id][1])
+
+		So(dht.Unsubscribe(linkID), ShouldBeNil)
+		So(dht.Unsubscribe(linkID), ShouldEqual, ErrSubscriptionNotFound)
+	})
+
+	Convey("mods and dels should be reported", t, func() {
+		changes = make(map[int][]DHTChange)
+		m := h.node.NewMessage(MOD_REQUEST, HoldReq{RelatedHash: hash, EntryHash: hash2})
+		err := dht.Mod(m, hash, hash2, time.Now())
+		So(err, ShouldBeNil)
+		m = h.node.NewMessage(DEL_REQUEST, HoldReq{RelatedHash: hash2, EntryHash: hash2})
+		err = dht.Del(m, hash2)
+		So(err, ShouldBeNil)
+		deliver()
+
+		So(len(changes[id]), ShouldEqual, 2)
+		So(changes[id][0].Type, ShouldEqual, DHTChangeMod)
+		So(changes[id][0].Hash, ShouldEqual, hash.String())
+		So(changes[id][0].NewHash, ShouldEqual, hash2.String())
+		So(changes[id][1].Type, ShouldEqual, DHTChangeDel)
+		So(changes[id][1].Hash, ShouldEqual, hash2.String())
+		So(changes[id][1].EntryType, ShouldEqual, "evenNumbers")
+		So(dht.Unsubscribe(id), ShouldBeNil)
+	})
+
+	Convey("a zome should be able to subscribe with a callback", t, func() {
+		zome, _ := h.GetZome("jsSampleZome")
+		v, err := NewJSRibosome(h, zome)
+		So(err, ShouldBeNil)
+		z := v.(*JSRibosome)
+		_, err = z.Run(`subscribe({Types:["put"]},"asyncPing")`)
+		So(err, ShouldBeNil)
+		subID, err := z.lastResult.Export()
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", subID), ShouldEqual, fmt.Sprintf("%d", id+2))
+
+		_, err = h.Subscribe("bogusZome", DHTChangeFilter{}, "asyncPing")
+		So(err.Error(), ShouldEqual, "unknown zome: bogusZome")
+
+		hash3 := commit(h, "evenNumbers", "6")
+		ShouldLog(h.nucleus.alog, func() {
+			m := h.node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash3})
+			err = dht.Put(m, "evenNumbers", hash3, h.nodeID, []byte("6"), StatusLive)
+			So(err, ShouldBeNil)
+			deliver()
+		}, fmt.Sprintf(`async result of message with %v was: {"Idx":`, subID), fmt.Sprintf(`"Type":"put","Hash":"%s"`, hash3.String()))
+
+		_, err = z.Run(fmt.Sprintf(`unsubscribe(%v)`, subID))
+		So(err, ShouldBeNil)
+		So(dht.hasSubscriptions(), ShouldBeFalse)
+	})
+}
+
+func TestDHTSlowSubscriber(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+	dht := h.dht
+
+	stall := make(chan bool)
+	var got []DHTChange
+	id := dht.Subscribe(DHTChangeFilter{}, func(id int, change DHTChange) {
+		<-stall
+		got = append(got, change)
+	})
+	fast := 0
+	dht.Subscribe(DHTChangeFilter{}, func(id int, change DHTChange) {
+		fast++
+	})
+
+	Convey("a stalled subscriber should neither block changes to the DHT nor other subscribers", t, func() {
+		n := DHTSubscriptionQueueSize + 10
+		for i := 0; i < n; i++ {
+			hash := commit(h, "evenNumbers", fmt.Sprintf("%d", 2*(i+100)))
+			m := h.node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash})
+			done := make(chan error, 1)
+			go func() {
+				done <- dht.Put(m, "evenNumbers", hash, h.nodeID, []byte("x"), StatusLive)
+			}()
+			select {
+			case err := <-done:
+				So(err, ShouldBeNil)
+			case <-time.After(time.Second):
+				t.Fatal("put blocked on a stalled subscriber")
+			}
+			for len(dht.changeFeed) > 0 {
+				handleChangeFeed(dht, <-dht.changeFeed)
+			}
+		}
+		close(stall)
+		waitDelivered(dht)
+		So(fast, ShouldBeGreaterThanOrEqualTo, n)
+		// the stalled subscriber gets the one it was stuck on and a full queue
+		So(len(got), ShouldBeLessThanOrEqualTo, DHTSubscriptionQueueSize+1)
+		So(dht.Unsubscribe(id), ShouldBeNil)
+	})
+}
+
+// waitDelivered waits for the subscriptions to be called with the changes queued for them
+func waitDelivered(dht *DHT) {
+	dht.subLk.RLock()
+	subs := make([]*dhtSubscription, 0, len(dht.subscriptions))
+	for _, s := range dht.subscriptions {
+		subs = append(subs, s)
+	}
+	dht.subLk.RUnlock()
+	for _, s := range subs {
+		s.pending.Wait()
+	}
+}
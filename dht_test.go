@@ -1,6 +1,7 @@
 package holochain
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -22,13 +23,38 @@ func TestNewDHT(t *testing.T) {
 
 	Convey("It should initialize the DHT struct and data store", t, func() {
 		So(FileExists(h.DBPath(), DHTStoreFileName), ShouldBeFalse)
-		dht := NewDHT(h)
+		dht, err := NewDHT(h)
+		So(err, ShouldBeNil)
 		So(FileExists(h.DBPath(), DHTStoreFileName), ShouldBeTrue)
 		So(dht.h, ShouldEqual, h)
 		So(dht.config, ShouldEqual, &h.nucleus.dna.DHTConfig)
 	})
 }
 
+func TestDHTConfigEncoding(t *testing.T) {
+	Convey("unset limits should be left out of the DNA so that its hash doesn't change", t, func() {
+		for _, format := range []string{"json", "toml", "yaml"} {
+			var buf bytes.Buffer
+			err := Encode(&buf, format, &DHTConfig{HashType: "sha2-256"})
+			So(err, ShouldBeNil)
+			s := strings.ToLower(buf.String())
+			So(s, ShouldNotContainSubstring, "maxlinksets")
+			So(s, ShouldNotContainSubstring, "maxentrysize")
+			So(s, ShouldNotContainSubstring, "sourcequota")
+
+			buf.Reset()
+			err = Encode(&buf, format, &DHTConfig{HashType: "sha2-256", MaxLinkSets: 10, MaxEntrySize: 100, SourceQuota: 1000})
+			So(err, ShouldBeNil)
+			var config DHTConfig
+			err = Decode(&buf, format, &config)
+			So(err, ShouldBeNil)
+			So(config.MaxLinkSets, ShouldEqual, 10)
+			So(config.MaxEntrySize, ShouldEqual, 100)
+			So(config.SourceQuota, ShouldEqual, 1000)
+		}
+	})
+}
+
 func TestSetupDHT(t *testing.T) {
 	d, _, h := PrepareTestChain("test")
 	defer CleanupTestChain(h, d)
@@ -177,6 +203,60 @@ func TestDHTQueryGet(t *testing.T) {
 	})
 }
 
+func TestDHTQueryLatency(t *testing.T) {
+	nodesCount := 6
+	mt := setupMultiNodeTesting(nodesCount)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+	h := nodes[0]
+
+	// the last node holds the entry
+	holder := nodes[nodesCount-1]
+	now := time.Unix(1, 1) // pick a constant time so the test will always work
+	e := GobEntry{C: "4"}
+	_, hd, err := holder.NewEntry(now, "evenNumbers", &e)
+	if err != nil {
+		panic(err)
+	}
+	hash := hd.EntryLink
+	_, err = holder.dht.send(nil, holder.nodeID, holder.node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash}))
+	if err != nil {
+		panic(err)
+	}
+
+	// slow down two of the peers
+	delay := 50 * time.Millisecond
+	slowResponses(nodes[1].node, delay)
+	slowResponses(nodes[2].node, delay)
+
+	starConnect(t, mt.ctx, nodes, nodesCount)
+
+	Convey("sending to a peer should measure its round trip time", t, func() {
+		unknown, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		for i := 1; i < nodesCount; i++ {
+			h.dht.send(nil, nodes[i].nodeID, h.node.NewMessage(GET_REQUEST, GetReq{H: unknown, StatusMask: StatusLive}))
+			So(h.node.Latency(nodes[i].nodeID), ShouldBeGreaterThan, 0)
+		}
+		So(h.node.Latency(nodes[1].nodeID), ShouldBeGreaterThanOrEqualTo, delay)
+		So(h.node.Latency(nodes[2].nodeID), ShouldBeGreaterThanOrEqualTo, delay)
+		So(h.node.Latency(nodes[3].nodeID), ShouldBeLessThan, delay)
+	})
+
+	Convey("a query should start with the fastest of the closest peers", t, func() {
+		peers := h.dht.queryStartPeers(hash)
+		So(len(peers), ShouldEqual, AlphaValue)
+		So(peers, ShouldNotContain, nodes[1].nodeID)
+		So(peers, ShouldNotContain, nodes[2].nodeID)
+	})
+
+	Convey("the query should get the entry from the fast peers", t, func() {
+		r, err := h.dht.Query(hash, GET_REQUEST, GetReq{H: hash, StatusMask: StatusLive})
+		So(err, ShouldBeNil)
+		resp := r.(GetResp)
+		So(fmt.Sprintf("%v", resp.Entry), ShouldEqual, fmt.Sprintf("%v", e))
+	})
+}
+
 func TestDHTKadPut(t *testing.T) {
 	nodesCount := 6
 	mt := setupMultiNodeTesting(nodesCount)
@@ -242,6 +322,77 @@ func TestDHTKadPut(t *testing.T) {
 	})
 }
 
+func TestActionReceiverHoldLimits(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+	config := &h.nucleus.dna.DHTConfig
+
+	now := time.Unix(1, 1) // pick a constant time so the test will always work
+	_, hd, _ := h.NewEntry(now, "evenNumbers", &GobEntry{C: "123456"})
+	big := hd.EntryLink
+
+	Convey("PUT_REQUEST of an entry larger than MaxEntrySize should be rejected", t, func() {
+		config.MaxEntrySize = 4
+		defer func() { config.MaxEntrySize = 0 }()
+		used, _ := h.dht.GetUsage(h.nodeID)
+
+		m := h.node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: big})
+		_, err := ActionReceiver(h, m)
+		So(err, ShouldEqual, ErrEntryTooLarge)
+
+		_, _, _, status, err := h.dht.Get(big, StatusAny, GetMaskEntryType)
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, StatusRejected)
+		n, _ := h.dht.GetUsage(h.nodeID)
+		So(n, ShouldEqual, used)
+	})
+
+	Convey("PUT_REQUEST beyond the SourceQuota should be rejected", t, func() {
+		_, hd, _ := h.NewEntry(now, "evenNumbers", &GobEntry{C: "2"})
+		m := h.node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hd.EntryLink})
+		used, _ := h.dht.GetUsage(h.nodeID)
+		config.SourceQuota = used
+		defer func() { config.SourceQuota = 0 }()
+
+		_, err := ActionReceiver(h, m)
+		So(err, ShouldEqual, ErrQuotaExceeded)
+		usage, err := h.dht.Usage()
+		So(err, ShouldBeNil)
+		So(usage[h.nodeID], ShouldEqual, used)
+	})
+
+	Convey("PUT_REQUEST of an entry the sender says is larger than MaxEntrySize should be refused without fetching it", t, func() {
+		config.MaxEntrySize = 4
+		defer func() { config.MaxEntrySize = 0 }()
+		_, hd, _ := h.NewEntry(now, "evenNumbers", &GobEntry{C: "1234568"})
+
+		m := h.node.NewMessage(PUT_REQUEST, putReq(hd.EntryLink, &GobEntry{C: "1234568"}))
+		So(m.Body.(HoldReq).Size, ShouldBeGreaterThan, 4)
+		_, err := ActionReceiver(h, m)
+		So(err, ShouldEqual, ErrEntryTooLarge)
+		_, _, _, _, err = h.dht.Get(hd.EntryLink, StatusAny, GetMaskEntryType)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("the source of a put entry should be the agent proved by its validation package", t, func() {
+		_, hd, _ := h.NewEntry(now, "evenNumbers", &GobEntry{C: "4"})
+		a := NewPutAction("evenNumbers", &GobEntry{C: "4"}, hd)
+		resp, err := h.GetValidationResponse(a, hd.EntryLink)
+		So(err, ShouldBeNil)
+		relay, _ := makePeer("relay")
+		So(entrySource(h, &resp, relay), ShouldEqual, h.nodeID)
+
+		resp.Package.Proofs = nil
+		So(entrySource(h, &resp, relay), ShouldEqual, relay)
+	})
+
+	Convey("hold limit errors should survive being sent over the wire", t, func() {
+		for _, e := range []error{ErrEntryTooLarge, ErrQuotaExceeded} {
+			So(NewErrorResponse(e).DecodeResponseError(), ShouldEqual, e)
+		}
+	})
+}
+
 func TestActionReceiver(t *testing.T) {
 	d, _, h := PrepareTestChain("test")
 	defer CleanupTestChain(h, d)
@@ -455,7 +606,7 @@ func TestActionReceiver(t *testing.T) {
 	Convey("DELETE_REQUEST should set status of hash to deleted", t, func() {
 		entry := DelEntry{Hash: hash2, Message: "expired"}
 		a := NewDelAction(entry)
-		_, err := h.doCommit(a, NullHash())
+		_, _, err := h.doCommit(a, NullHash())
 		entryHash := a.header.EntryLink
 		m := h.node.NewMessage(DEL_REQUEST, HoldReq{RelatedHash: hash2, EntryHash: entryHash})
 		r, err := ActionReceiver(h, m)
@@ -534,7 +685,7 @@ func TestActionReceiver(t *testing.T) {
 						So(err, ShouldBeNil)
 		                           	So(r.(HoldResp).Code, ShouldEqual, ReceiptOK)
 
-						peerList, err := h.dht.getList(BlockedList)
+						peerList, err := h.dht.GetList(BlockedList)
 						So(err, ShouldBeNil)
 						So(len(peerList.Records), ShouldEqual, 1)
 						So(peerList.Records[0].ID, ShouldEqual, pid)
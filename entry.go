@@ -243,6 +243,18 @@ func sysValidateEntry(h *Holochain, def *EntryDef, entry Entry, pkg *Package) (e
 		return
 	}
 
+	if max := h.nucleus.dna.DHTConfig.MaxEntrySize; max > 0 {
+		var b []byte
+		b, err = entry.Marshal()
+		if err != nil {
+			return
+		}
+		if len(b) > max {
+			err = ErrEntryTooLarge
+			return
+		}
+	}
+
 	// see if there is a schema validator for the entry type and validate it if so
 	if def.validator != nil {
 		var input interface{}
@@ -307,6 +319,18 @@ func sysValidateEntry(h *Holochain, def *EntryDef, entry Entry, pkg *Package) (e
 				return
 			}
 		}
+		if def == CheckpointEntryDef {
+			var cp CheckpointEntry
+			cp, err = CheckpointEntryFromJSON(entry.Content().(string))
+			if err != nil {
+				err = ValidationFailed(fmt.Sprintf("Error (%s) when decoding checkpoint", err.Error()))
+				return
+			}
+			if err = cp.Verify(); err != nil {
+				err = ValidationFailed(err.Error())
+				return
+			}
+		}
 	} else if def.DataFormat == DataFormatLinks {
 		// Perform base validation on links entries, i.e. that all items exist and are of the right types
 		// so first unmarshall the json, and then check that the hashes are real.
@@ -0,0 +1,220 @@
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+
+	. "github.com/holochain/holochain-proto/hash"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+)
+
+const (
+	CheckpointEntryType   = SysEntryTypePrefix + "checkpoint"
+	CheckpointEntrySchema = `
+{
+  "$id": "http://example.com/example.json",
+  "type": "object",
+  "definitions": {},
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "properties": {
+    "Length": {
+      "$id": "/properties/Length",
+      "type": "integer",
+      "title": "The Length Schema ",
+      "minimum": 1
+    },
+    "Top": {
+      "$id": "/properties/Top",
+      "type": "string",
+      "title": "The Top Schema ",
+      "default": ""
+    },
+    "Root": {
+      "$id": "/properties/Root",
+      "type": "string",
+      "title": "The Root Schema ",
+      "default": ""
+    },
+    "PubKey": {
+      "$id": "/properties/PubKey",
+      "type": "string",
+      "title": "The PubKey Schema ",
+      "default": ""
+    },
+    "Sig": {
+      "$id": "/properties/Sig",
+      "type": "string",
+      "title": "The Sig Schema ",
+      "default": ""
+    }
+  },
+  "required": ["Length", "Top", "Root", "PubKey", "Sig"]
+}
+`
+)
+
+var ErrCheckpointInvalid = errors.New("checkpoint doesn't verify")
+
+// CheckpointEntry struct is a signed digest of the Length headers of a chain
+// that come before it.  Root is the root of the Merkle tree of their hashes,
+// the same tree that chain proofs use, and Top is the hash of the last of them.
+type CheckpointEntry struct {
+	Length int
+	Top    Hash
+	Root   Hash
+	PubKey string
+	Sig    Signature
+}
+
+var CheckpointEntryDef = &EntryDef{Name: CheckpointEntryType, DataFormat: DataFormatJSON, Sharing: Public, Schema: CheckpointEntrySchema}
+
+func (e *CheckpointEntry) Def() *EntryDef {
+	return CheckpointEntryDef
+}
+
+func (e *CheckpointEntry) ToJSON() (encodedEntry string, err error) {
+	var x struct {
+		Length int
+		Top    string
+		Root   string
+		PubKey string
+		Sig    string
+	}
+	x.Length = e.Length
+	x.Top = e.Top.String()
+	x.Root = e.Root.String()
+	x.PubKey = e.PubKey
+	x.Sig = e.Sig.B58String()
+	var j []byte
+	j, err = json.Marshal(x)
+	encodedEntry = string(j)
+	return
+}
+
+func CheckpointEntryFromJSON(j string) (entry CheckpointEntry, err error) {
+	var x struct {
+		Length int
+		Top    string
+		Root   string
+		PubKey string
+		Sig    string
+	}
+	err = json.Unmarshal([]byte(j), &x)
+	if err != nil {
+		return
+	}
+	entry.Length = x.Length
+	entry.Top, err = NewHash(x.Top)
+	if err != nil {
+		return
+	}
+	entry.Root, err = NewHash(x.Root)
+	if err != nil {
+		return
+	}
+	entry.PubKey = x.PubKey
+	entry.Sig = SignatureFromB58String(x.Sig)
+	return
+}
+
+// newCheckpointEntry makes a signed checkpoint of the first length headers of the chain.  Not thread safe.
+func (c *Chain) newCheckpointEntry(length int, privKey ic.PrivKey) (entry CheckpointEntry, err error) {
+	if length < 1 {
+		err = errors.New("can't checkpoint an empty chain")
+		return
+	}
+	var hashes []Hash
+	hashes, err = c.headerHashes(length)
+	if err != nil {
+		return
+	}
+	entry.Length = length
+	entry.Top = hashes[length-1]
+	entry.Root, _, err = chainMerkleTree(c.hashSpec, hashes, 0)
+	if err != nil {
+		return
+	}
+	var pk []byte
+	pk, err = ic.MarshalPublicKey(privKey.GetPublic())
+	if err != nil {
+		return
+	}
+	entry.PubKey = b58.Encode(pk)
+	entry.Sig.S, err = privKey.Sign(chainProofSignedData(entry.Root, entry.Length))
+	return
+}
+
+// Verify checks that the checkpoint was signed by the key it holds
+func (e *CheckpointEntry) Verify() (err error) {
+	var pubKey ic.PubKey
+	pubKey, err = DecodePubKey(e.PubKey)
+	if err != nil {
+		return
+	}
+	var matches bool
+	matches, err = pubKey.Verify(chainProofSignedData(e.Root, e.Length), e.Sig.S)
+	if err != nil {
+		return
+	}
+	if !matches {
+		err = ErrCheckpointInvalid
+	}
+	return
+}
+
+// verifyCheckpoint verifies the latest checkpoint in the chain and returns its
+// index, or 0 if there is none.  The checkpoint's header must be signed by the
+// checkpoint's agent and follow the headers it covers.  Not thread safe.
+func (c *Chain) verifyCheckpoint() (i int, err error) {
+	i, ok, err := c.typeTop(CheckpointEntryType)
+	if err != nil || !ok {
+		i = 0
+		return
+	}
+	var hd *Header
+	hd, err = c.header(i)
+	if err != nil {
+		return
+	}
+	var e Entry
+	e, err = c.entry(i)
+	if err != nil {
+		return
+	}
+	j, _ := e.Content().(string)
+	var cp CheckpointEntry
+	cp, err = CheckpointEntryFromJSON(j)
+	if err != nil {
+		return
+	}
+	if err = cp.Verify(); err != nil {
+		return
+	}
+	var pubKey ic.PubKey
+	pubKey, err = DecodePubKey(cp.PubKey)
+	if err != nil {
+		return
+	}
+	var matches bool
+	matches, err = pubKey.Verify([]byte(hd.EntryLink), hd.Sig.S)
+	if err != nil {
+		return
+	}
+	if !matches || !hd.HeaderLink.Equal(cp.Top) {
+		err = ErrCheckpointInvalid
+		return
+	}
+	if i > 0 {
+		// the chain holds the headers the checkpoint covers so they must match it
+		var top Hash
+		top, err = c.hash(i - 1)
+		if err != nil {
+			return
+		}
+		if i != cp.Length || !top.Equal(cp.Top) {
+			err = ErrCheckpointInvalid
+		}
+	}
+	return
+}
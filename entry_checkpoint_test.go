@@ -0,0 +1,59 @@
+package holochain
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckpointEntryDef(t *testing.T) {
+	entry := CheckpointEntry{}
+	Convey("validate CheckpointEntryDef properties", t, func() {
+		So(CheckpointEntryType, ShouldEqual, "%checkpoint")
+		So(entry.Def().Name, ShouldEqual, CheckpointEntryType)
+		So(entry.Def().DataFormat, ShouldEqual, DataFormatJSON)
+		So(entry.Def().Sharing, ShouldEqual, Public)
+	})
+}
+
+func TestCheckpointEntry(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+	commit(h, "evenNumbers", "2")
+
+	h.chain.lk.RLock()
+	entry, err := h.chain.newCheckpointEntry(h.chain.Length(), h.agent.PrivKey())
+	h.chain.lk.RUnlock()
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("a checkpoint should digest the chain so far", t, func() {
+		So(entry.Length, ShouldEqual, h.chain.Length())
+		So(entry.Top.String(), ShouldEqual, h.chain.Hashes[h.chain.Length()-1].String())
+		pubKey, _ := h.agent.EncodePubKey()
+		So(entry.PubKey, ShouldEqual, pubKey)
+		So(entry.Verify(), ShouldBeNil)
+	})
+
+	Convey("it should roundtrip as JSON", t, func() {
+		j, err := entry.ToJSON()
+		So(err, ShouldBeNil)
+		e, err := CheckpointEntryFromJSON(j)
+		So(err, ShouldBeNil)
+		So(e, ShouldResemble, entry)
+	})
+
+	Convey("sys validation should check the checkpoint's signature", t, func() {
+		a := ActionCheckpoint{entry: entry}
+		So(sysValidateEntry(h, CheckpointEntryDef, a.Entry(), nil), ShouldBeNil)
+
+		a.entry.Length++
+		err := sysValidateEntry(h, CheckpointEntryDef, a.Entry(), nil)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "Validation Failed: "+ErrCheckpointInvalid.Error())
+
+		err = sysValidateEntry(h, CheckpointEntryDef, &GobEntry{C: `{"Length":1}`}, nil)
+		So(err, ShouldNotBeNil)
+	})
+}
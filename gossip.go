@@ -10,12 +10,9 @@ import (
 	"errors"
 	"fmt"
 	. "github.com/holochain/holochain-proto/hash"
+	queue "github.com/holochain/holochain-proto/peerqueue"
 	peer "github.com/libp2p/go-libp2p-peer"
-	"github.com/tidwall/buntdb"
 	"math/rand"
-	"sort"
-	"strconv"
-	"strings"
 	"time"
 )
 
@@ -41,6 +38,7 @@ type PeerListType string
 
 const (
 	BlockedList = "blockedlist"
+	ForkedList  = "forkedlist"
 )
 
 type PeerRecord struct {
@@ -68,63 +66,19 @@ func (dht *DHT) HaveFingerprint(f Hash) (result bool, err error) {
 
 // GetFingerprint returns the index that of the message that made a change or -1 if we don't have it
 func (dht *DHT) GetFingerprint(f Hash) (index int, err error) {
-	index = -1
-	db := dht.ht.(*BuntHT).db
-	err = db.View(func(tx *buntdb.Tx) error {
-		idxStr, e := tx.Get("f:" + f.String())
-		if e == buntdb.ErrNotFound {
-			return nil
-		}
-		if e != nil {
-			return e
-		}
-		index, e = strconv.Atoi(idxStr)
-		if e != nil {
-			return e
-		}
-		return nil
-	})
+	index, err = dht.ht.GetFingerprint(f)
 	return
 }
 
 // GetPuts returns a list of puts after the given index
 func (dht *DHT) GetPuts(since int) (puts []Put, err error) {
-	puts = make([]Put, 0)
-	db := dht.ht.(*BuntHT).db
-	err = db.View(func(tx *buntdb.Tx) error {
-		err = tx.AscendGreaterOrEqual("idx", string(since), func(key, value string) bool {
-			x := strings.Split(key, ":")
-			idx, _ := strconv.Atoi(x[1])
-			if idx >= since {
-				p := Put{Idx: idx}
-				if value != "" {
-					err := ByteDecoder([]byte(value), &p.M)
-					if err != nil {
-						return false
-					}
-				}
-				puts = append(puts, p)
-			}
-			return true
-		})
-		sort.Slice(puts, func(i, j int) bool { return puts[i].Idx < puts[j].Idx })
-		return err
-	})
+	puts, err = dht.ht.GetPuts(since)
 	return
 }
 
 // GetGossiper loads returns last known index of the gossiper, and adds them if not didn't exist before
 func (dht *DHT) GetGossiper(id peer.ID) (idx int, err error) {
-	key := "peer:" + peer.IDB58Encode(id)
-	db := dht.ht.(*BuntHT).db
-	err = db.View(func(tx *buntdb.Tx) error {
-		var e error
-		idx, e = getIntVal(key, tx)
-		if e != nil {
-			return e
-		}
-		return nil
-	})
+	idx, err = dht.ht.GetGossiper(id)
 	return
 }
 
@@ -162,46 +116,47 @@ func (dht *DHT) getGossipers() (glist []peer.ID, err error) {
 
 func (dht *DHT) _getGossipers() (glist []peer.ID, err error) {
 	glist = make([]peer.ID, 0)
-	db := dht.ht.(*BuntHT).db
-	err = db.View(func(tx *buntdb.Tx) error {
-		err = tx.Ascend("peer", func(key, value string) bool {
-			x := strings.Split(key, ":")
-			id, e := peer.IDB58Decode(x[1])
-			if e != nil {
-				return false
-			}
-			//			idx, _ := strconv.Atoi(value)
-			glist = append(glist, id)
-			return true
-		})
-		return nil
-	})
+	var gossipers []GossiperData
+	gossipers, err = dht.ht.GetGossipers()
+	if err != nil {
+		return
+	}
+	for _, g := range gossipers {
+		glist = append(glist, g.ID)
+	}
 	ns := dht.config.RedundancyFactor
 	if ns > 1 {
-		size := len(glist)
-		hlist := make([]Hash, size)
-		for i := 0; i < size; i++ {
-			h := HashFromPeerID(glist[i])
-			hlist[i] = h
+		// order by distance weighted by reputation so the closest gossipers
+		// that behave are the ones kept when the list is cut down
+		pq := queue.NewReputationPQ(HashFromPeerID(dht.h.nodeID), dht.reputationScore)
+		for _, id := range glist {
+			pq.Enqueue(id)
 		}
-		me := HashFromPeerID(dht.h.nodeID)
-
-		hlist = SortByDistance(me, hlist)
-		glist = make([]peer.ID, len(hlist))
-		for i := 0; i < len(hlist); i++ {
-			glist[i] = PeerIDFromHash(hlist[i])
+		for i := range glist {
+			glist[i] = pq.Dequeue()
 		}
 	}
 	return
 }
 
-// FindGossiper picks a random DHT node to gossip with
+// FindGossiper picks a random DHT node to gossip with, avoiding nodes that
+// have misbehaved unless there are no others
 func (dht *DHT) FindGossiper() (g peer.ID, err error) {
 	var glist []peer.ID
 	glist, err = dht.getGossipers()
 	if err != nil {
 		return
 	}
+	var good []peer.ID
+	for _, id := range glist {
+		rep, e := dht.GetReputation(id)
+		if e != nil || !rep.Misbehaving() {
+			good = append(good, id)
+		}
+	}
+	if len(good) > 0 {
+		glist = good
+	}
 	if len(glist) == 0 {
 		err = ErrDHTErrNoGossipersAvailable
 	} else {
@@ -222,23 +177,7 @@ func (dht *DHT) AddGossiper(id peer.ID) (err error) {
 
 // internal update gossiper function, assumes all checks have been made
 func (dht *DHT) updateGossiper(id peer.ID, newIdx int) (err error) {
-	db := dht.ht.(*BuntHT).db
-	err = db.Update(func(tx *buntdb.Tx) error {
-		key := "peer:" + peer.IDB58Encode(id)
-		idx, e := getIntVal(key, tx)
-		if e != nil {
-			return e
-		}
-		if newIdx < idx {
-			return nil
-		}
-		sidx := fmt.Sprintf("%d", newIdx)
-		_, _, err = tx.Set(key, sidx, nil)
-		if err != nil {
-			return err
-		}
-		return nil
-	})
+	err = dht.ht.UpdateGossiper(id, newIdx)
 	return
 }
 
@@ -257,12 +196,7 @@ func (dht *DHT) UpdateGossiper(id peer.ID, newIdx int) (err error) {
 // DeleteGossiper removes a gossiper from the database
 func (dht *DHT) DeleteGossiper(id peer.ID) (err error) {
 	dht.glog.Logf("deleting %v", id)
-	db := dht.ht.(*BuntHT).db
-	err = db.Update(func(tx *buntdb.Tx) error {
-		key := "peer:" + peer.IDB58Encode(id)
-		_, e := tx.Delete(key)
-		return e
-	})
+	err = dht.ht.DeleteGossiper(id)
 	return
 }
 
@@ -466,47 +400,15 @@ func (dht *DHT) HandleGossipPuts() (err error) {
 	return nil
 }
 
-// getList returns the peer list of the given type
-func (dht *DHT) getList(listType PeerListType) (result PeerList, err error) {
-	result.Type = listType
-	result.Records = make([]PeerRecord, 0)
-	db := dht.ht.(*BuntHT).db
-	err = db.View(func(tx *buntdb.Tx) error {
-		err = tx.Ascend("list", func(key, value string) bool {
-			x := strings.Split(key, ":")
-
-			if x[1] == string(listType) {
-				pid, e := peer.IDB58Decode(x[2])
-				if e != nil {
-					return false
-				}
-				r := PeerRecord{ID: pid, Warrant: value}
-				result.Records = append(result.Records, r)
-			}
-			return true
-		})
-		return nil
-	})
+// GetList returns the peer list of the given type
+func (dht *DHT) GetList(listType PeerListType) (result PeerList, err error) {
+	result, err = dht.ht.GetList(listType)
 	return
 }
 
-// addToList adds the peers to a list
-func (dht *DHT) addToList(m *Message, list PeerList) (err error) {
+// AddToList adds the peers to a list
+func (dht *DHT) AddToList(m *Message, list PeerList) (err error) {
 	dht.dlog.Logf("addToList %s=>%v", list.Type, list.Records)
-	db := dht.ht.(*BuntHT).db
-	err = db.Update(func(tx *buntdb.Tx) error {
-		_, err = incIdx(tx, m)
-		if err != nil {
-			return err
-		}
-		for _, r := range list.Records {
-			k := peer.IDB58Encode(r.ID)
-			_, _, err = tx.Set("list:"+string(list.Type)+":"+k, r.Warrant, nil)
-			if err != nil {
-				return err
-			}
-		}
-		return err
-	})
+	err = dht.ht.AddToList(m, list)
 	return
 }
@@ -305,7 +305,7 @@ func TestPeerLists(t *testing.T) {
 	defer CleanupTestChain(h, d)
 
 	Convey("it should start with an empty blockedlist", t, func() {
-		peerList, err := h.dht.getList(BlockedList)
+		peerList, err := h.dht.GetList(BlockedList)
 		So(err, ShouldBeNil)
 		So(len(peerList.Records), ShouldEqual, 0)
 	})
@@ -316,13 +316,13 @@ func TestPeerLists(t *testing.T) {
 		pids := []PeerRecord{PeerRecord{ID: pid1}, PeerRecord{ID: pid2}}
 
 		idx, _ := h.dht.GetIdx()
-		err := h.dht.addToList(h.node.NewMessage(LISTADD_REQUEST, ListAddReq{ListType: BlockedList, Peers: []string{peer.IDB58Encode(pid1), peer.IDB58Encode(pid2)}}), PeerList{BlockedList, pids})
+		err := h.dht.AddToList(h.node.NewMessage(LISTADD_REQUEST, ListAddReq{ListType: BlockedList, Peers: []string{peer.IDB58Encode(pid1), peer.IDB58Encode(pid2)}}), PeerList{BlockedList, pids})
 		So(err, ShouldBeNil)
 
 		afterIdx, _ := h.dht.GetIdx()
 		So(afterIdx-idx, ShouldEqual, 1)
 
-		peerList, err := h.dht.getList(BlockedList)
+		peerList, err := h.dht.GetList(BlockedList)
 		So(err, ShouldBeNil)
 		So(peerList.Type, ShouldEqual, BlockedList)
 		So(len(peerList.Records), ShouldEqual, 2)
@@ -207,7 +207,10 @@ func UnmarshalHeader(reader io.Reader, hd *Header, hashSize int) (err error) {
 	if err != nil {
 		return
 	}
-	hd.Time.UnmarshalBinary(b)
+	err = hd.Time.UnmarshalBinary(b)
+	if err != nil {
+		return
+	}
 
 	hd.HeaderLink, err = UnmarshalHash(reader)
 	if err != nil {
@@ -9,8 +9,6 @@ package holochain
 import (
 	"bytes"
 	"context"
-	"encoding/gob"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
@@ -23,7 +21,6 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -54,20 +51,40 @@ type Loggers struct {
 
 // Config holds the non-DNA configuration for a holo-chain, from config file or environment variables
 type Config struct {
-	DHTPort          int
-	EnableMDNS       bool
-	PeerModeAuthor   bool
-	PeerModeDHTNode  bool
-	EnableNATUPnP    bool
-	EnableWorldModel bool
-	BootstrapServer  string
-	Loggers          Loggers
+	DHTPort           int
+	EnableMDNS        bool
+	PeerModeAuthor    bool
+	PeerModeDHTNode   bool
+	EnableNATUPnP     bool
+	PrivateNetwork    bool
+	EnableWorldModel  bool
+	EnablePruning     bool
+	BootstrapServer   string
+	HashTableType     string
+	DataEncryption    string
+	ChainVerification string
+	Loggers           Loggers
+
+	// RateLimits limits how fast a peer can send messages on each protocol, by
+	// protocol name, and peers that keep going over the limits are blocked
+	// after RateLimitBlockAfter strikes, or never if it's below zero
+	RateLimits          map[string]RateLimit
+	RateLimitBlockAfter int
+
+	// PruneGracePeriod is how many seconds a node keeps a hash it's no longer
+	// responsible for after the nodes now responsible for it have all returned
+	// hold receipts of it, when EnablePruning is set.  Zero means the default.
+	PruneGracePeriod int
 
 	holdingCheckInterval     time.Duration
+	pruneGracePeriod         time.Duration
+	dataPassphrase           string
+	networkKey               string
 	gossipInterval           time.Duration
 	bootstrapRefreshInterval time.Duration
 	routingRefreshInterval   time.Duration
 	retryInterval            time.Duration
+	bundleCheckInterval      time.Duration
 }
 
 // Progenitor holds data on the creator of the DNA
@@ -99,6 +116,8 @@ type Holochain struct {
 	gossipProtocol   *Protocol
 	actionProtocol   *Protocol
 	asyncSends       chan error
+	dataCipher       *DataCipher // cached cipher for data at rest, see DataCipher()
+	encryptChain     bool        // encrypt a chain written in the clear when opening it, see LoadOptions
 }
 
 func (h *Holochain) Nucleus() (n *Nucleus) {
@@ -171,31 +190,33 @@ var _holochainInitialized bool
 func InitializeHolochain() {
 	// this should only run once
 	if !_holochainInitialized {
-		gob.Register(Header{})
-		gob.Register(AgentEntry{})
-		gob.Register(HoldReq{})
-		gob.Register(HoldResp{})
-		gob.Register(GetReq{})
-		gob.Register(GetResp{})
-		gob.Register(LinkQuery{})
-		gob.Register(GossipReq{})
-		gob.Register(Gossip{})
-		gob.Register(ValidateQuery{})
-		gob.Register(ValidateResponse{})
-		gob.Register(Put{})
-		gob.Register(GobEntry{})
-		gob.Register(LinkQueryResp{})
-		gob.Register(TaggedHash{})
-		gob.Register(ErrorResponse{})
-		gob.Register(DelEntry{})
-		gob.Register(Package{})
-		gob.Register(AppMsg{})
-		gob.Register(ListAddReq{})
-		gob.Register(FindNodeReq{})
-		gob.Register(CloserPeersResp{})
-		gob.Register(PeerInfo{})
+		RegisterMessageBody("Header", Header{})
+		RegisterMessageBody("AgentEntry", AgentEntry{})
+		RegisterMessageBody("HoldReq", HoldReq{})
+		RegisterMessageBody("HoldResp", HoldResp{})
+		RegisterMessageBody("GetReq", GetReq{})
+		RegisterMessageBody("GetResp", GetResp{})
+		RegisterMessageBody("LinkQuery", LinkQuery{})
+		RegisterMessageBody("GossipReq", GossipReq{})
+		RegisterMessageBody("Gossip", Gossip{})
+		RegisterMessageBody("ValidateQuery", ValidateQuery{})
+		RegisterMessageBody("ValidateResponse", ValidateResponse{})
+		RegisterMessageBody("Put", Put{})
+		RegisterMessageBody("GobEntry", GobEntry{})
+		RegisterMessageBody("LinkQueryResp", LinkQueryResp{})
+		RegisterMessageBody("TaggedHash", TaggedHash{})
+		RegisterMessageBody("ErrorResponse", ErrorResponse{})
+		RegisterMessageBody("DelEntry", DelEntry{})
+		RegisterMessageBody("Package", Package{})
+		RegisterMessageBody("AppMsg", AppMsg{})
+		RegisterMessageBody("ListAddReq", ListAddReq{})
+		RegisterMessageBody("FindNodeReq", FindNodeReq{})
+		RegisterMessageBody("CloserPeersResp", CloserPeersResp{})
+		RegisterMessageBody("PeerInfo", PeerInfo{})
+		RegisterMessageBody("RateLimitedResp", RateLimitedResp{})
 
 		RegisterBultinRibosomes()
+		RegisterBuiltinHashTables()
 
 		infoLog.New(nil)
 		infoLog.Enabled = true
@@ -287,7 +308,16 @@ func (h *Holochain) createNode() (err error) {
 		ip = "0.0.0.0"
 	}
 	listenaddr := fmt.Sprintf("/ip4/%s/tcp/%d", ip, h.Config.DHTPort)
-	h.node, err = NewNode(listenaddr, h.dnaHash.String(), h.Agent().(*LibP2PAgent), h.Config.EnableNATUPnP, &h.Config.Loggers.Debug)
+	var psk []byte
+	if h.Config.PrivateNetwork {
+		psk = NetworkPSK(h.Config.networkKey, h.dnaHash)
+	}
+	h.node, err = NewNode(listenaddr, h.dnaHash.String(), h.Agent().(*LibP2PAgent), h.Config.EnableNATUPnP, psk, &h.Config.Loggers.Debug)
+	if err != nil {
+		return
+	}
+	h.node.reputation = func(id peer.ID) int { return h.dht.reputationScore(id) }
+	err = h.node.SetRateLimits(h.Config.RateLimits, h.Config.RateLimitBlockAfter)
 	return
 }
 
@@ -312,15 +342,26 @@ func (h *Holochain) Prepare() (err error) {
 		return
 	}
 
-	h.dht = NewDHT(h)
+	h.dht, err = NewDHT(h)
+	if err != nil {
+		return
+	}
 	h.nucleus.h = h
 
 	if h.Config.EnableWorldModel {
 		h.world = NewWorld(h.node.HashAddr, h.dht, &h.Config.Loggers.World)
+		var forkedList PeerList
+		forkedList, err = h.dht.GetList(ForkedList)
+		if err != nil {
+			return err
+		}
+		for _, r := range forkedList.Records {
+			h.world.SetForked(r.ID)
+		}
 	}
 
 	var peerList PeerList
-	peerList, err = h.dht.getList(BlockedList)
+	peerList, err = h.dht.GetList(BlockedList)
 	if err != nil {
 		return err
 	}
@@ -350,6 +391,14 @@ func (h *Holochain) Activate() (err error) {
 			return
 		}
 	}
+	if h.chain != nil {
+		// bring back any bundles that were in progress when we were shut down
+		// and cancel those that timed out while we were down
+		if err = h.restoreBundles(); err != nil {
+			return
+		}
+		BundleTimeoutTask(h)
+	}
 	return
 }
 
@@ -395,8 +444,13 @@ func (h *Holochain) AgentTopHash() (id Hash) {
 
 // Top returns a hash of top header or err if not yet defined
 func (h *Holochain) Top() (top Hash, err error) {
-	//TODO: LOCK!!!
-	tp := h.chain.Hashes[len(h.chain.Hashes)-1]
+	h.chain.lk.RLock()
+	defer h.chain.lk.RUnlock()
+	var tp Hash
+	tp, err = h.chain.hash(h.chain.length() - 1)
+	if err != nil {
+		return
+	}
 	top = tp.Clone()
 	return
 }
@@ -514,6 +568,60 @@ func initLogger(l *Logger, envOverride string, writer io.Writer) (err error) {
 }
 
 func (config *Config) Setup() (err error) {
+	if config.HashTableType != "" {
+		_, ok := hashTableFactories[config.HashTableType]
+		if !ok {
+			err = fmt.Errorf("Unknown HashTable type: %s", config.HashTableType)
+			return
+		}
+	}
+
+	switch config.DataEncryption {
+	case DataEncryptionNone, DataEncryptionAgent:
+	case DataEncryptionPassphrase:
+		config.dataPassphrase = os.Getenv("HC_DATA_PASSPHRASE")
+		if config.dataPassphrase == "" {
+			err = ErrMissingDataPassphrase
+			return
+		}
+	default:
+		err = fmt.Errorf("Unknown DataEncryption: %s", config.DataEncryption)
+		return
+	}
+
+	if config.PrivateNetwork {
+		config.networkKey = os.Getenv("HC_NETWORK_KEY")
+		if config.networkKey == "" {
+			err = ErrMissingNetworkKey
+			return
+		}
+	}
+
+	if config.RateLimits == nil {
+		config.RateLimits = make(map[string]RateLimit)
+		for name, limit := range DefaultRateLimits {
+			config.RateLimits[name] = limit
+		}
+	}
+	if config.RateLimitBlockAfter == 0 {
+		config.RateLimitBlockAfter = DefaultRateLimitBlockAfter
+	}
+	if _, err = newRateLimiter(config.RateLimits, config.RateLimitBlockAfter); err != nil {
+		return
+	}
+
+	cv := os.Getenv("HC_CHAIN_VERIFICATION")
+	if cv != "" {
+		config.ChainVerification = cv
+		Debugf("using environment variable to set chain verification to: %s", cv)
+	}
+	switch config.ChainVerification {
+	case "", ChainVerificationOff, ChainVerificationHeaders, ChainVerificationFull:
+	default:
+		err = fmt.Errorf("Unknown ChainVerification: %s", config.ChainVerification)
+		return
+	}
+
 	if config.EnableWorldModel {
 		config.holdingCheckInterval = DefaultHoldingCheckInterval
 	}
@@ -528,6 +636,23 @@ func (config *Config) Setup() (err error) {
 		}
 	}
 
+	if config.PruneGracePeriod < 0 {
+		err = fmt.Errorf("Invalid PruneGracePeriod: %d", config.PruneGracePeriod)
+		return
+	}
+	config.pruneGracePeriod = DefaultPruneGracePeriod
+	if config.PruneGracePeriod > 0 {
+		config.pruneGracePeriod = time.Duration(config.PruneGracePeriod) * time.Second
+	}
+	pg := os.Getenv("HC_PRUNE_GRACE_PERIOD")
+	if pg != "" {
+		i, err := strconv.Atoi(pg)
+		if err == nil && i >= 0 {
+			config.pruneGracePeriod = time.Duration(i) * time.Second
+			Debugf("using environment variable to set pruneGracePeriod to: %d", i)
+		}
+	}
+
 	gi := os.Getenv("HC_GOSSIP_INTERVAL")
 	if gi != "" {
 		i, _ := strconv.Atoi(gi)
@@ -540,6 +665,7 @@ func (config *Config) Setup() (err error) {
 	config.bootstrapRefreshInterval = BootstrapTTL
 	config.routingRefreshInterval = DefaultRoutingRefreshInterval
 	config.retryInterval = DefaultRetryInterval
+	config.bundleCheckInterval = DefaultBundleCheckInterval
 	err = config.SetupLogging()
 	return
 }
@@ -644,6 +770,8 @@ func (h *Holochain) GetEntryDef(t string) (zome *Zome, d *EntryDef, err error) {
 		d = DelEntryDef
 	case MigrateEntryType:
 		d = MigrateEntryDef
+	case CheckpointEntryType:
+		d = CheckpointEntryDef
 	default:
 		for _, z := range h.nucleus.dna.Zomes {
 			d, err = z.GetEntryDef(t)
@@ -751,7 +879,7 @@ func (h *Holochain) Reset() (err error) {
 		return
 	}
 
-	h.chain, err = NewChainFromFile(h.hashSpec, filepath.Join(h.DBPath(), StoreFileName))
+	err = h.openChain()
 	if err != nil {
 		return
 	}
@@ -774,6 +902,12 @@ func (h *Holochain) DHT() *DHT {
 	return h.dht
 }
 
+// GetReceipts returns the receipts of the nodes that have agreed to hold changes to a hash
+func (h *Holochain) GetReceipts(hash Hash) (receipts []Receipt, err error) {
+	receipts, err = h.dht.GetReceipts(hash)
+	return
+}
+
 // DHT exposes the Node structure
 func (h *Holochain) Node() *Node {
 	return h.node
@@ -841,6 +975,7 @@ func (h *Holochain) StartBackgroundTasks() {
 	go h.DHT().HandleGossipWiths()
 	go h.HandleAsyncSends()
 	go h.DHT().HandleChangeRequests()
+	go h.DHT().HandleChangeFeed()
 
 	if h.Config.gossipInterval > 0 {
 		h.node.stoppers[GossipingStopper] = h.TaskTicker(h.Config.gossipInterval, GossipTask)
@@ -859,6 +994,7 @@ func (h *Holochain) StartBackgroundTasks() {
 	}
 
 	h.node.stoppers[RefreshingStopper] = h.TaskTicker(h.Config.routingRefreshInterval, RoutingRefreshTask)
+	h.node.stoppers[BundleTimeoutStopper] = h.TaskTicker(h.Config.bundleCheckInterval, BundleTimeoutTask)
 }
 
 // BootstrapRefreshTask refreshes our node and gets nodes from the bootstrap server
@@ -917,6 +1053,7 @@ func (h *Holochain) Send(basectx context.Context, proto int, to peer.ID, message
 		err = ctx.Err()
 		if err == context.DeadlineExceeded {
 			err = SendTimeoutErr
+			h.dht.updateReputation(to, Reputation{Timeouts: 1})
 		}
 	case err = <-sent:
 	}
@@ -946,6 +1083,10 @@ type QueryReturn struct {
 	Headers bool
 }
 
+// QueryConstrain limits the entries a query returns.  Since and Until limit the
+// header time (Since inclusive, Until exclusive), the Compare constraints must
+// all hold, and And, Or and Not combine other constraints.  Count and Page only
+// apply to the top level constraint.
 type QueryConstrain struct {
 	EntryTypes []string
 	Contains   string
@@ -953,10 +1094,20 @@ type QueryConstrain struct {
 	Matches    string
 	Count      int
 	Page       int
+	Since      time.Time
+	Until      time.Time
+	Compare    []QueryCompare
+	And        []QueryConstrain
+	Or         []QueryConstrain
+	Not        *QueryConstrain
 }
 
+// QueryOrder sets the order of query results.  Without a Field the results are
+// in chain order, or reversed if Ascending, and with a Field they are sorted by
+// the value of that JSON field, descending unless Ascending.
 type QueryOrder struct {
 	Ascending bool
+	Field     string
 }
 
 type QueryOptions struct {
@@ -964,6 +1115,7 @@ type QueryOptions struct {
 	Constrain QueryConstrain
 	Order     QueryOrder
 	Bundle    bool
+	Aggregate QueryAggregate
 }
 
 type QueryResult struct {
@@ -995,11 +1147,23 @@ func (h *Holochain) Query(options *QueryOptions) (results []QueryResult, err err
 	} else {
 		chain = h.chain
 	}
-	var re *regexp.Regexp
-	var equalsMap, containsMap map[string]interface{}
-	var reMap map[string]*regexp.Regexp
+	var filter *queryFilter
+	filter, err = newQueryFilter(&options.Constrain)
+	if err != nil {
+		return
+	}
+	var sortValues map[*Header]interface{}
+	if options.Order.Field != "" {
+		sortValues = make(map[*Header]interface{})
+	}
 	defs := make(map[string]*EntryDef)
-	for i, header := range chain.Headers {
+	l := chain.Length()
+	for i := 0; i < l; i++ {
+		var header *Header
+		header, err = chain.header(i)
+		if err != nil {
+			return
+		}
 
 		var def *EntryDef
 		var ok bool
@@ -1012,119 +1176,31 @@ func (h *Holochain) Query(options *QueryOptions) (results []QueryResult, err err
 			defs[header.Type] = def
 		}
 
-		var skip bool
-		if len(options.Constrain.EntryTypes) > 0 {
-			skip = true
-			for _, et := range options.Constrain.EntryTypes {
-				if header.Type == et {
-					skip = false
-					break
-				}
-			}
-		}
-		if !skip && (options.Constrain.Equals != "" || options.Constrain.Contains != "" || options.Constrain.Matches != "") {
-			var content string
-			var contentMap map[string]interface{}
-			if def.DataFormat == DataFormatJSON {
-				contentMap = make(map[string]interface{})
-				err = json.Unmarshal([]byte(chain.Entries[i].Content().(string)), &contentMap)
-				if err != nil {
-					return
-				}
-			} else {
-				content = chain.Entries[i].Content().(string)
-			}
-
-			if !skip && options.Constrain.Equals != "" {
-				if def.DataFormat == DataFormatJSON {
-					if equalsMap == nil {
-						equalsMap = make(map[string]interface{})
-						err = json.Unmarshal([]byte(options.Constrain.Equals), &equalsMap)
-						if err != nil {
-							return
-						}
-					}
-					skip = true
-					for fieldName, fieldValue := range equalsMap {
-						if contentMap[fieldName] == fieldValue {
-							skip = false
-							break
-						}
-					}
-				} else {
-					if content != options.Constrain.Equals {
-						skip = true
-					}
-				}
-			}
-			if !skip && options.Constrain.Contains != "" {
-				if def.DataFormat == DataFormatJSON {
-					if containsMap == nil {
-						containsMap = make(map[string]interface{})
-						err = json.Unmarshal([]byte(options.Constrain.Contains), &containsMap)
-						if err != nil {
-							return
-						}
-					}
-					skip = true
-					for fieldName, fieldValue := range containsMap {
-						if strings.Index(contentMap[fieldName].(string), fieldValue.(string)) >= 0 {
-							skip = false
-							break
-						}
-					}
-				} else {
-					if strings.Index(content, options.Constrain.Contains) < 0 {
-						skip = true
-					}
-				}
-			}
-			if !skip && options.Constrain.Matches != "" {
-				if def.DataFormat == DataFormatJSON {
-					if reMap == nil {
-						reMapStr := make(map[string]interface{})
-						err = json.Unmarshal([]byte(options.Constrain.Matches), &reMapStr)
-						if err != nil {
-							return
-						}
-						reMap = make(map[string]*regexp.Regexp)
-						for fieldName, fieldValue := range reMapStr {
-							reMap[fieldName], err = regexp.Compile(fieldValue.(string))
-							if err != nil {
-								return
-							}
-						}
-					}
-					skip = true
-					for fieldName, fieldRe := range reMap {
-						if fieldRe.Match([]byte(contentMap[fieldName].(string))) {
-							skip = false
-							break
-						}
-					}
-
-				} else {
-					if re == nil {
-						re, err = regexp.Compile(options.Constrain.Matches)
-						if err != nil {
-							return
-						}
-					}
-
-					if !re.Match([]byte(content)) {
-						skip = true
-					}
-				}
-
-			}
+		idx := i
+		qc := queryContent{def: def, load: func() (Entry, error) { return chain.entry(idx) }}
+		var pass bool
+		pass, err = filter.match(header, &qc)
+		if err != nil {
+			return
 		}
 
-		if !skip {
+		if pass {
 			// we always need the header to be returned at this level.  The
 			// Return values gets limited down to the actual info in the Ribosomes
 			qr := QueryResult{Header: header}
 			if options.Return.Entries {
-				qr.Entry = chain.Entries[i]
+				qr.Entry, err = qc.get()
+				if err != nil {
+					return
+				}
+			}
+			if sortValues != nil {
+				var fields map[string]interface{}
+				fields, err = qc.jsonFields()
+				if err != nil {
+					return
+				}
+				sortValues[header] = fields[options.Order.Field]
 			}
 			if options.Order.Ascending {
 				results = append([]QueryResult{qr}, results...)
@@ -1133,6 +1209,9 @@ func (h *Holochain) Query(options *QueryOptions) (results []QueryResult, err err
 			}
 		}
 	}
+	if sortValues != nil {
+		sortQueryResults(results, sortValues, options.Order.Ascending)
+	}
 	if options.Constrain.Count > 0 {
 		start := options.Constrain.Page * options.Constrain.Count
 		if start >= len(results) {
@@ -1148,6 +1227,65 @@ func (h *Holochain) Query(options *QueryOptions) (results []QueryResult, err err
 	return
 }
 
+// DataCipher returns the cipher used to encrypt the chain and DHT data at rest
+// as set by Config.DataEncryption, or nil if the data is stored in the clear
+func (h *Holochain) DataCipher() (c *DataCipher, err error) {
+	if h.dataCipher != nil || h.Config.DataEncryption == DataEncryptionNone {
+		c = h.dataCipher
+		return
+	}
+	if h.agent == nil {
+		err = errors.New("data encryption requires an agent")
+		return
+	}
+	switch h.Config.DataEncryption {
+	case DataEncryptionAgent:
+		c, err = NewAgentDataCipher(h.agent)
+	case DataEncryptionPassphrase:
+		var salt []byte
+		salt, err = h.agent.PubKey().Bytes()
+		if err != nil {
+			return
+		}
+		c, err = NewPassphraseDataCipher(h.Config.dataPassphrase, salt)
+	default:
+		err = fmt.Errorf("Unknown DataEncryption: %s", h.Config.DataEncryption)
+	}
+	if err == nil {
+		h.dataCipher = c
+	}
+	return
+}
+
+// openChain opens the source chain file, encrypting its entries if the Config asks for it
+func (h *Holochain) openChain() (err error) {
+	var c *DataCipher
+	c, err = h.DataCipher()
+	if err != nil {
+		return
+	}
+	path := filepath.Join(h.DBPath(), StoreFileName)
+	h.chain, err = NewEncryptedChainFromFile(h.hashSpec, path, c)
+	if err == ErrChainNotEncrypted && h.encryptChain {
+		var n int
+		n, err = EncryptChainFile(h.hashSpec, path, c)
+		if err != nil {
+			return
+		}
+		Infof("encrypted the %d entries of the chain", n)
+		h.chain, err = NewEncryptedChainFromFile(h.hashSpec, path, c)
+	}
+	if err != nil {
+		return
+	}
+	err = h.chain.Verify(h.Config.ChainVerification)
+	if err != nil {
+		h.chain.Close()
+		h.chain = nil
+	}
+	return
+}
+
 // RedundancyFactor returns the redundancy that was set in the DNA
 func (h *Holochain) RedundancyFactor() int {
 	return h.nucleus.dna.DHTConfig.RedundancyFactor
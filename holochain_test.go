@@ -3,6 +3,7 @@ package holochain
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
 	. "github.com/holochain/holochain-proto/hash"
@@ -97,6 +98,36 @@ func TestSetupConfig(t *testing.T) {
 	os.Unsetenv("HC_GOSSIP_INTERVAL")
 	os.Unsetenv("HC_HOLDING_INTERVAL")
 
+	Convey("it should set the default rate limits", t, func() {
+		config := Config{}
+		So(config.Setup(), ShouldBeNil)
+		So(config.RateLimits, ShouldResemble, DefaultRateLimits)
+		So(config.RateLimitBlockAfter, ShouldEqual, DefaultRateLimitBlockAfter)
+
+		config = Config{RateLimits: map[string]RateLimit{"bogus": {Rate: 1, Burst: 1}}}
+		So(config.Setup().Error(), ShouldEqual, "unknown protocol bogus in rate limits")
+	})
+
+	Convey("it should set the prune grace period", t, func() {
+		config := Config{}
+		config.Setup()
+		So(config.EnablePruning, ShouldBeFalse)
+		So(config.pruneGracePeriod, ShouldEqual, DefaultPruneGracePeriod)
+
+		config.PruneGracePeriod = 60
+		config.Setup()
+		So(config.pruneGracePeriod, ShouldEqual, time.Minute)
+
+		os.Setenv("HC_PRUNE_GRACE_PERIOD", "5")
+		config.Setup()
+		So(config.EnablePruning, ShouldBeFalse)
+		So(config.pruneGracePeriod, ShouldEqual, time.Second*5)
+
+		config.PruneGracePeriod = -1
+		So(config.Setup().Error(), ShouldEqual, "Invalid PruneGracePeriod: -1")
+	})
+	os.Unsetenv("HC_PRUNE_GRACE_PERIOD")
+
 }
 
 func TestSetupLogging(t *testing.T) {
@@ -735,6 +766,158 @@ func TestQuery(t *testing.T) {
 	})
 }
 
+func TestQueryConstraints(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	t0 := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	add := func(days int, entryType string, content string) {
+		_, _, err := h.NewEntry(t0.AddDate(0, 0, days), entryType, &GobEntry{C: content})
+		if err != nil {
+			panic(err)
+		}
+	}
+	add(0, "profile", `{"firstName":"Pebbles","lastName":"Flintstone","age":3}`)
+	add(1, "oddNumbers", "7")
+	add(2, "profile", `{"firstName":"Zippy","lastName":"Pinhead","age":40}`)
+	add(3, "profile", `{"firstName":"Zerbina","lastName":"Pinhead","age":38}`)
+	add(4, "profile", `{"firstName":"Fred","lastName":"Flintstone"}`)
+	add(5, "oddNumbers", "9")
+
+	firstNames := func(results []QueryResult) (names []string) {
+		for _, r := range results {
+			var p map[string]interface{}
+			json.Unmarshal([]byte(r.Entry.Content().(string)), &p)
+			names = append(names, p["firstName"].(string))
+		}
+		return
+	}
+
+	Convey("query should constrain by header time", t, func() {
+		q := &QueryOptions{}
+		q.Constrain.Since = t0.AddDate(0, 0, 1)
+		q.Constrain.Until = t0.AddDate(0, 0, 5)
+		results, err := h.Query(q)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 4)
+		So(results[0].Entry.Content(), ShouldEqual, "7")
+		So(firstNames(results[1:]), ShouldResemble, []string{"Zippy", "Zerbina", "Fred"})
+	})
+
+	Convey("query should compare JSON fields numerically", t, func() {
+		q := &QueryOptions{}
+		q.Constrain.Compare = []QueryCompare{{Field: "age", Op: QueryCompareGT, Value: 3}}
+		results, err := h.Query(q)
+		So(err, ShouldBeNil)
+		So(firstNames(results), ShouldResemble, []string{"Zippy", "Zerbina"})
+
+		q.Constrain.Compare = append(q.Constrain.Compare, QueryCompare{Field: "age", Op: QueryCompareLE, Value: 38})
+		results, err = h.Query(q)
+		So(err, ShouldBeNil)
+		So(firstNames(results), ShouldResemble, []string{"Zerbina"})
+
+		q.Constrain.Compare = []QueryCompare{{Field: "age", Op: "~"}}
+		_, err = h.Query(q)
+		So(err.Error(), ShouldEqual, "unknown query comparison: ~")
+	})
+
+	Convey("query should combine constraints with and, or and not", t, func() {
+		q := &QueryOptions{}
+		q.Constrain.EntryTypes = []string{"profile"}
+		q.Constrain.Or = []QueryConstrain{
+			{Compare: []QueryCompare{{Field: "age", Op: QueryCompareGE, Value: 40}}},
+			{Equals: `{"lastName":"Flintstone"}`},
+		}
+		results, err := h.Query(q)
+		So(err, ShouldBeNil)
+		So(firstNames(results), ShouldResemble, []string{"Pebbles", "Zippy", "Fred"})
+
+		q.Constrain.Not = &QueryConstrain{Matches: `{"firstName":"^P"}`}
+		results, err = h.Query(q)
+		So(err, ShouldBeNil)
+		So(firstNames(results), ShouldResemble, []string{"Zippy", "Fred"})
+
+		q = &QueryOptions{}
+		q.Constrain.And = []QueryConstrain{
+			{EntryTypes: []string{"profile", "oddNumbers"}},
+			{Not: &QueryConstrain{EntryTypes: []string{"profile"}}},
+		}
+		results, err = h.Query(q)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 2)
+		So(results[0].Entry.Content(), ShouldEqual, "7")
+		So(results[1].Entry.Content(), ShouldEqual, "9")
+	})
+
+	Convey("query should sort by a JSON field", t, func() {
+		q := &QueryOptions{}
+		q.Constrain.EntryTypes = []string{"profile"}
+		q.Order.Field = "age"
+		results, err := h.Query(q)
+		So(err, ShouldBeNil)
+		So(firstNames(results), ShouldResemble, []string{"Zippy", "Zerbina", "Pebbles", "Fred"})
+
+		q.Order.Ascending = true
+		results, err = h.Query(q)
+		So(err, ShouldBeNil)
+		So(firstNames(results), ShouldResemble, []string{"Pebbles", "Zerbina", "Zippy", "Fred"})
+
+		q.Order.Field = "firstName"
+		q.Constrain.Count = 2
+		results, err = h.Query(q)
+		So(err, ShouldBeNil)
+		So(firstNames(results), ShouldResemble, []string{"Fred", "Pebbles"})
+	})
+
+	Convey("query should aggregate the results", t, func() {
+		q := &QueryOptions{}
+		q.Constrain.EntryTypes = []string{"profile"}
+		q.Aggregate = QueryAggregate{Fn: QueryAggregateCount}
+		v, err := h.QueryAggregate(q)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, 4)
+
+		// paging options don't limit the aggregate
+		q.Constrain.Count = 1
+		q.Constrain.Page = 1
+		v, err = h.QueryAggregate(q)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, 4)
+		So(q.Constrain.Count, ShouldEqual, 1)
+		q.Constrain.Count = 0
+		q.Constrain.Page = 0
+
+		q.Aggregate.Field = "age"
+		v, err = h.QueryAggregate(q)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, 3)
+
+		q.Aggregate.Fn = QueryAggregateSum
+		v, err = h.QueryAggregate(q)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, 81.0)
+
+		q.Aggregate.Fn = QueryAggregateMin
+		v, err = h.QueryAggregate(q)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, 3.0)
+
+		q.Aggregate.Fn = QueryAggregateMax
+		v, err = h.QueryAggregate(q)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, 40.0)
+
+		q.Aggregate.Field = "height"
+		v, err = h.QueryAggregate(q)
+		So(err, ShouldBeNil)
+		So(v, ShouldBeNil)
+
+		q.Aggregate.Fn = "avg"
+		_, err = h.QueryAggregate(q)
+		So(err.Error(), ShouldEqual, "unknown query aggregate: avg")
+	})
+}
+
 func TestGetEntryDef(t *testing.T) {
 	d, _, h := SetupTestChain("test")
 	defer CleanupTestDir(d)
@@ -830,6 +1013,15 @@ func TestSigning(t *testing.T) {
 //	})
 //}
 
+// topEntry returns the most recent entry on the chain
+func topEntry(h *Holochain) Entry {
+	entry, err := h.chain.entry(h.chain.Length() - 1)
+	if err != nil {
+		panic(err)
+	}
+	return entry
+}
+
 func commit(h *Holochain, entryType, entryStr string) (entryHash Hash) {
 	entry := GobEntry{C: entryStr}
 	a := NewCommitAction(entryType, &entry)
@@ -8,9 +8,14 @@
 package holochain
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	. "github.com/holochain/holochain-proto/hash"
 	peer "github.com/libp2p/go-libp2p-peer"
+	"sort"
+	"strings"
+	"time"
 )
 
 const (
@@ -62,13 +67,25 @@ const (
 	ReceiptRejected
 )
 
+// Receipt holds a signed hold receipt returned by a node for a change request
+type Receipt struct {
+	Peer      peer.ID   // the node that signed the receipt
+	Code      int       // the code of the receipt, i.e. ReceiptOK
+	Signature Signature // the node's signature of the receipt data
+	Msg       Message   // the change request message the receipt was for
+	Time      time.Time // when the receipt was received
+}
+
 // TaggedHash holds associated entries for the LinkQueryResponse
 type TaggedHash struct {
-	H         string // the hash of the link; gets filled by dht base node when answering get link request
-	E         string // the value of link, gets filled if options set Load to true
-	EntryType string // the entry type of the link, gets filled if options set Load to true
-	T         string // the tag of the link, gets filled only if a tag wasn't specified and all tags are being returns
-	Source    string // the statuses on the link, gets filled if options set Load to true
+	H         string    // the hash of the link; gets filled by dht base node when answering get link request
+	E         string    // the value of link, gets filled if options set Load to true
+	EntryType string    // the entry type of the link, gets filled if options set Load to true
+	T         string    // the tag of the link, gets filled only if a tag wasn't specified and all tags are being returns
+	Source    string    // the statuses on the link, gets filled if options set Load to true
+	Time      time.Time `json:"-"` // when the link was made, used for ordering links on the DHT node
+
+	linkedType string // the entry type recorded when the link was made, used for filtering on the DHT node
 }
 
 var ErrLinkNotFound = errors.New("link not found")
@@ -77,9 +94,14 @@ var ErrHashDeleted = errors.New("hash deleted")
 var ErrHashModified = errors.New("hash modified")
 var ErrHashRejected = errors.New("hash rejected")
 var ErrEntryTypeMismatch = errors.New("entry type mismatch")
+var ErrEntryTooLarge = errors.New("entry too large")
+var ErrQuotaExceeded = errors.New("source quota exceeded")
 
 type HashTableIterateFn func(hash Hash) (stop bool)
 
+// HashTableFactory creates a HashTable that stores its data in the given directory
+type HashTableFactory func(dbPath string) (HashTable, error)
+
 // HashTable provides an abstraction for storing the necessary DHT data
 type HashTable interface {
 
@@ -95,8 +117,9 @@ type HashTable interface {
 	// Del moves the given hash to the StatusDeleted status
 	Del(msg *Message, key Hash) (err error)
 
-	// Mod moves the given hash to the StatusModified status
-	Mod(msg *Message, key Hash, newkey Hash) (err error)
+	// Mod moves the given hash to the StatusModified status, recording the
+	// replacedBy link at the time in the header of the modifying entry
+	Mod(msg *Message, key Hash, newkey Hash, t time.Time) (err error)
 
 	// Exists checks for the existence of the hash in the table
 	Exists(key Hash, statusMask int) (err error)
@@ -107,11 +130,12 @@ type HashTable interface {
 	// Get retrieves a value from the DHT store
 	Get(key Hash, statusMask int, getMask int) (data []byte, entryType string, sources []string, status int, err error)
 
-	// PutLink associates a link with a stored hash
-	PutLink(m *Message, base string, link string, tag string) (err error)
+	// PutLink associates a link with a stored hash, recording the entry type of the
+	// linked entry (if known) and the time in the header of the linking entry
+	PutLink(m *Message, base string, link string, tag string, entryType string, t time.Time) (err error)
 
 	// DelLink removes a link and tag associated with a stored hash
-	DelLink(m *Message, base string, link string, tag string) (err error)
+	DelLink(m *Message, base string, link string, tag string, t time.Time) (err error)
 
 	// GetLinks retrieves meta value associated with a base
 	GetLinks(base Hash, tag string, statusMask int) (results []TaggedHash, err error)
@@ -131,6 +155,157 @@ type HashTable interface {
 	// Iterate call fn on all the hashes in the table
 	Iterate(fn HashTableIterateFn)
 
+	// GetFingerprint returns the index of the message that made a change or -1 if we don't have it
+	GetFingerprint(f Hash) (index int, err error)
+
+	// GetPuts returns a list of puts after the given index
+	GetPuts(since int) (puts []Put, err error)
+
+	// GetGossiper returns the last known index of the gossiper, or 0 if it isn't known
+	GetGossiper(id peer.ID) (idx int, err error)
+
+	// GetGossipers returns all the gossipers with their last known index
+	GetGossipers() (gossipers []GossiperData, err error)
+
+	// UpdateGossiper sets the last known index of a gossiper if it's greater than the stored one
+	UpdateGossiper(id peer.ID, newIdx int) (err error)
+
+	// DeleteGossiper removes a gossiper
+	DeleteGossiper(id peer.ID) (err error)
+
+	// GetReputation returns the reputation of a peer, which is empty if it isn't known
+	GetReputation(id peer.ID) (rep Reputation, err error)
+
+	// UpdateReputation adds the counts in delta to the reputation of a peer, after
+	// decaying its counts to the current time
+	UpdateReputation(id peer.ID, delta Reputation) (err error)
+
+	// PutHeaderProof stores the proof of one of an agent's headers under the header it
+	// follows, unless a proof is already stored there in which case that one is returned
+	PutHeaderProof(agent peer.ID, proof ChainProof) (seen *ChainProof, err error)
+
+	// GetList returns the peer list of the given type
+	GetList(listType PeerListType) (result PeerList, err error)
+
+	// AddToList adds the peers to a list
+	AddToList(m *Message, list PeerList) (err error)
+
+	// Forget removes all the data stored for a hash, including the links on it and
+	// the proofs of its headers, from the table, the index of changes is left untouched
+	Forget(key Hash) (err error)
+
+	// PutReceipt stores a receipt returned by a node for a change to a hash
+	PutReceipt(key Hash, receipt Receipt) (err error)
+
 	// GetReceipts returns a list of receipts that were generated regarding a hash
-	//GetReceipts()
+	GetReceipts(key Hash) (receipts []Receipt, err error)
+
+	// GetUsage returns the number of bytes of entry data held on behalf of a source
+	GetUsage(src peer.ID) (bytes int, err error)
+
+	// Usage returns the number of bytes of entry data held on behalf of each source
+	Usage() (usage map[peer.ID]int, err error)
+}
+
+var hashTableFactories = make(map[string]HashTableFactory)
+
+// RegisterHashTable sets up a HashTable implementation to be used by the CreateHashTable function
+func RegisterHashTable(name string, factory HashTableFactory) {
+	if factory == nil {
+		panic(fmt.Sprintf("HashTable factory for type %s does not exist.", name))
+	}
+	_, registered := hashTableFactories[name]
+	if registered {
+		panic(fmt.Sprintf("HashTable factory for type %s already registered. ", name))
+	}
+	hashTableFactories[name] = factory
+}
+
+// RegisterBuiltinHashTables adds the built in HashTable types to the factory hash
+func RegisterBuiltinHashTables() {
+	RegisterHashTable(BuntHTType, NewBuntHT)
+	RegisterHashTable(BoltHTType, NewBoltHT)
+	RegisterHashTable(MemHTType, NewMemHT)
+}
+
+// RegisteredHashTables returns the sorted names of all the registered HashTable types
+func RegisteredHashTables() (names []string) {
+	for k := range hashTableFactories {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return
+}
+
+// CreateHashTable returns a new opened HashTable of the given type
+// an empty type name creates the default BuntHT
+func CreateHashTable(name string, dbPath string) (HashTable, error) {
+	if name == "" {
+		name = BuntHTType
+	}
+	factory, ok := hashTableFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("Invalid HashTable type. Must be one of: %s", strings.Join(RegisteredHashTables(), ", "))
+	}
+
+	return factory(dbPath)
+}
+
+// dumpHashTableIdx converts message and data of a DHT change request to a string for human consumption
+func dumpHashTableIdx(ht HashTable, idx int) (str string, err error) {
+	var msg Message
+	msg, err = ht.GetIdxMessage(idx)
+	if err != nil {
+		return
+	}
+	f, _ := msg.Fingerprint()
+	str = fmt.Sprintf("MSG (fingerprint %v):\n   %v\n", f, msg)
+	switch msg.Type {
+	case PUT_REQUEST:
+		key := msg.Body.(HoldReq).EntryHash
+		entry, entryType, _, _, e := ht.Get(key, StatusDefault, GetMaskAll)
+		if e != nil {
+			err = fmt.Errorf("couldn't get %v err:%v ", key, e)
+			return
+		} else {
+			str += fmt.Sprintf("DATA: type:%s entry: %v\n", entryType, entry)
+		}
+	}
+	return
+}
+
+// dumpHashTableIdxJSON converts message and data of a DHT change request to a JSON string representation.
+func dumpHashTableIdxJSON(ht HashTable, idx int) (str string, err error) {
+	var msg Message
+	var buffer bytes.Buffer
+	var msgField, dataField string
+	msg, err = ht.GetIdxMessage(idx)
+
+	if err != nil {
+		return "", err
+	}
+
+	f, _ := msg.Fingerprint()
+	buffer.WriteString(fmt.Sprintf("{ \"index\": %d,", idx))
+	msgField = fmt.Sprintf("\"message\": { \"fingerprint\": \"%v\", \"content\": \"%v\" },", f, msg)
+
+	switch msg.Type {
+	case PUT_REQUEST:
+		key := msg.Body.(HoldReq).EntryHash
+		entry, entryType, _, _, e := ht.Get(key, StatusAny, GetMaskAll)
+		if e != nil {
+			err = fmt.Errorf("couldn't get %v err:%v ", key, e)
+			return
+		}
+		dataField = fmt.Sprintf("\"data\": { \"type\": \"%s\", \"entry\": \"%v\" }", entryType, entry)
+	}
+
+	if len(dataField) > 0 {
+		buffer.WriteString(msgField)
+		buffer.WriteString(dataField)
+	} else {
+		buffer.WriteString(strings.TrimSuffix(msgField, ","))
+	}
+	buffer.WriteString("}")
+	return PrettyPrintJSON(buffer.Bytes())
 }
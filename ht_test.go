@@ -1,12 +1,497 @@
 package holochain
 
 import (
-	//	. "github.com/holochain/holochain-proto/hash"
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
+	"time"
 )
 
-func TestHT(t *testing.T) {
-	Convey("", t, func() {
+// forEachHashTable runs the given test function against a freshly opened instance
+// of every registered HashTable type, so that all implementations are held to the
+// same behavior
+func forEachHashTable(t *testing.T, fn func(name string, ht HashTable, node *Node)) {
+	node, err := makeNode(1234, "")
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	for _, name := range RegisteredHashTables() {
+		d := SetupTestDir()
+		ht, err := CreateHashTable(name, d)
+		if err != nil {
+			panic(err)
+		}
+		fn(name, ht, node)
+		ht.Close()
+		CleanupTestDir(d)
+	}
+}
+
+func TestHTRegistry(t *testing.T) {
+	Convey("it should have the builtin HashTable types registered", t, func() {
+		names := RegisteredHashTables()
+		So(names, ShouldContain, BuntHTType)
+		So(names, ShouldContain, BoltHTType)
+		So(names, ShouldContain, MemHTType)
+	})
+
+	Convey("it should fail to create an unknown HashTable type", t, func() {
+		_, err := CreateHashTable("bogus", "")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "Invalid HashTable type")
+	})
+
+	Convey("it should panic on registering a type twice", t, func() {
+		So(func() { RegisterHashTable(BuntHTType, NewBuntHT) }, ShouldPanic)
+	})
+}
+
+func TestHTPutGetModDel(t *testing.T) {
+	forEachHashTable(t, func(name string, ht HashTable, node *Node) {
+		id := node.HashAddr
+		hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		newhashStr := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh4"
+		newhash, _ := NewHash(newhashStr)
+		var idx int
+
+		Convey(name+": it should store and retrieve", t, func() {
+			err := ht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash}), "someType", hash, id, []byte("some value"), StatusLive)
+			So(err, ShouldBeNil)
+			idx, _ = ht.GetIdx()
+			So(idx, ShouldEqual, 1)
+
+			data, entryType, sources, status, err := ht.Get(hash, StatusLive, GetMaskAll)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "some value")
+			So(entryType, ShouldEqual, "someType")
+			So(status, ShouldEqual, StatusLive)
+			So(sources[0], ShouldEqual, id.Pretty())
+
+			So(ht.Exists(hash, StatusLive), ShouldBeNil)
+			src, err := ht.Source(hash)
+			So(err, ShouldBeNil)
+			So(src, ShouldEqual, id)
+
+			badhash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+			_, entryType, _, _, err = ht.Get(badhash, StatusLive, GetMaskDefault)
+			So(entryType, ShouldEqual, "")
+			So(err, ShouldEqual, ErrHashNotFound)
+			So(ht.Exists(badhash, StatusLive), ShouldEqual, ErrHashNotFound)
+		})
+
+		Convey(name+": it should iterate", t, func() {
+			hlist := make([]Hash, 0)
+			ht.Iterate(func(hsh Hash) bool {
+				hlist = append(hlist, hsh)
+				return true
+			})
+			So(len(hlist), ShouldEqual, 1)
+			So(hlist[0].String(), ShouldEqual, hash.String())
+		})
+
+		Convey(name+": mod should move the hash to the modified status and record replacedBy link", t, func() {
+			m := node.NewMessage(MOD_REQUEST, HoldReq{RelatedHash: hash, EntryHash: newhash})
+			err := ht.Mod(m, hash, newhash, time.Now())
+			So(err, ShouldBeNil)
+			data, _, _, status, err := ht.Get(hash, StatusAny, GetMaskAll)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "some value")
+			So(status, ShouldEqual, StatusModified)
+
+			afterIdx, _ := ht.GetIdx()
+			So(afterIdx-idx, ShouldEqual, 1)
+
+			_, _, _, _, err = ht.Get(hash, StatusLive, GetMaskDefault)
+			So(err, ShouldEqual, ErrHashNotFound)
+
+			data, _, _, _, err = ht.Get(hash, StatusDefault, GetMaskDefault)
+			So(err, ShouldEqual, ErrHashModified)
+			So(string(data), ShouldEqual, newhashStr)
+
+			links, err := ht.GetLinks(hash, SysTagReplacedBy, StatusLive)
+			So(err, ShouldBeNil)
+			So(len(links), ShouldEqual, 1)
+			So(links[0].H, ShouldEqual, newhashStr)
+		})
+
+		Convey(name+": del should move the hash to the deleted status", t, func() {
+			m := node.NewMessage(DEL_REQUEST, HoldReq{RelatedHash: hash})
+			err := ht.Del(m, hash)
+			So(err, ShouldBeNil)
+
+			_, _, _, status, err := ht.Get(hash, StatusAny, GetMaskAll)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, StatusDeleted)
+
+			_, _, _, _, err = ht.Get(hash, StatusDefault, GetMaskDefault)
+			So(err, ShouldEqual, ErrHashDeleted)
+
+			badhash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+			err = ht.Del(m, badhash)
+			So(err, ShouldEqual, ErrHashNotFound)
+		})
+
+		Convey(name+": it should record the change messages by index and fingerprint", t, func() {
+			afterIdx, _ := ht.GetIdx()
+			So(afterIdx, ShouldEqual, 3)
+
+			msg, err := ht.GetIdxMessage(1)
+			So(err, ShouldBeNil)
+			So(msg.Type, ShouldEqual, PUT_REQUEST)
+			_, err = ht.GetIdxMessage(99)
+			So(err, ShouldEqual, ErrNoSuchIdx)
+
+			f, _ := msg.Fingerprint()
+			i, err := ht.GetFingerprint(f)
+			So(err, ShouldBeNil)
+			So(i, ShouldEqual, 1)
+			i, err = ht.GetFingerprint(hash)
+			So(err, ShouldBeNil)
+			So(i, ShouldEqual, -1)
+
+			puts, err := ht.GetPuts(2)
+			So(err, ShouldBeNil)
+			So(len(puts), ShouldEqual, 2)
+			So(puts[0].Idx, ShouldEqual, 2)
+			So(puts[0].M.Type, ShouldEqual, MOD_REQUEST)
+			So(puts[1].Idx, ShouldEqual, 3)
+			So(puts[1].M.Type, ShouldEqual, DEL_REQUEST)
+		})
+
+		Convey(name+": it should dump the contents", t, func() {
+			So(ht.String(), ShouldContainSubstring, "DHT changes: 3")
+			j, err := ht.JSON()
+			So(err, ShouldBeNil)
+			So(j, ShouldContainSubstring, hash.String())
+		})
+	})
+}
+
+func TestHTLinking(t *testing.T) {
+	forEachHashTable(t, func(name string, ht HashTable, node *Node) {
+		id := node.HashAddr
+		baseStr := "QmZcUPvPhD1Xvk6mwijYF8AfR3mG31S1YsEfHG4khrFPRr"
+		base, _ := NewHash(baseStr)
+		linkingEntryHash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+		linkHash1Str := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1"
+		linkHash1, _ := NewHash(linkHash1Str)
+		linkHash2Str := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2"
+		fakeMsg := node.NewMessage(LINK_REQUEST, HoldReq{RelatedHash: linkHash1, EntryHash: linkingEntryHash})
+
+		Convey(name+": it should fail if base doesn't exist", t, func() {
+			err := ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag foo", "", time.Now())
+			So(err, ShouldEqual, ErrHashNotFound)
+			_, err = ht.GetLinks(base, "tag foo", StatusLive)
+			So(err, ShouldEqual, ErrHashNotFound)
+		})
+
+		err := ht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: base}), "someType", base, id, []byte("some value"), StatusLive)
+		if err != nil {
+			panic(err)
+		}
+
+		Convey(name+": it should store and retrieve links on a base", t, func() {
+			So(ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag foo", "", time.Now()), ShouldBeNil)
+			So(ht.PutLink(fakeMsg, baseStr, linkHash2Str, "tag foo", "", time.Now()), ShouldBeNil)
+			So(ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag bar", "", time.Now()), ShouldBeNil)
+
+			data, err := ht.GetLinks(base, "tag foo", StatusLive)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 2)
+			So(data[0].H, ShouldEqual, linkHash1Str)
+			So(data[1].H, ShouldEqual, linkHash2Str)
+			So(data[0].Source, ShouldEqual, id.Pretty())
+			So(data[0].T, ShouldEqual, "")
+
+			data, err = ht.GetLinks(base, "", StatusLive)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 3)
+			tags := map[string]int{}
+			for _, th := range data {
+				tags[th.T]++
+			}
+			So(tags["tag foo"], ShouldEqual, 2)
+			So(tags["tag bar"], ShouldEqual, 1)
+		})
+
+		Convey(name+": it should delete links", t, func() {
+			err := ht.DelLink(fakeMsg, baseStr, linkHash1Str, "tag baz", time.Now())
+			So(err, ShouldEqual, ErrLinkNotFound)
+
+			So(ht.DelLink(fakeMsg, baseStr, linkHash1Str, "tag foo", time.Now()), ShouldBeNil)
+			data, err := ht.GetLinks(base, "tag foo", StatusLive)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 1)
+			So(data[0].H, ShouldEqual, linkHash2Str)
+
+			data, err = ht.GetLinks(base, "tag foo", StatusDeleted)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 1)
+			So(data[0].H, ShouldEqual, linkHash1Str)
+		})
+
+		Convey(name+": it should record the linked entry type and the time of the linking entry", t, func() {
+			linkTime := fakeMsg.Time.Add(-time.Hour)
+			So(ht.PutLink(fakeMsg, baseStr, linkHash2Str, "tag typed", "someType", linkTime), ShouldBeNil)
+			data, err := ht.GetLinks(base, "tag typed", StatusLive)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 1)
+			So(data[0].linkedType, ShouldEqual, "someType")
+			So(data[0].Time.Equal(linkTime), ShouldBeTrue)
+
+			So(ht.DelLink(fakeMsg, baseStr, linkHash2Str, "tag typed", time.Now()), ShouldBeNil)
+			data, err = ht.GetLinks(base, "tag typed", StatusDeleted)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 1)
+			So(data[0].linkedType, ShouldEqual, "someType")
+		})
+	})
+}
+
+func TestHTForget(t *testing.T) {
+	forEachHashTable(t, func(name string, ht HashTable, node *Node) {
+		id := node.HashAddr
+		baseStr := "QmZcUPvPhD1Xvk6mwijYF8AfR3mG31S1YsEfHG4khrFPRr"
+		base, _ := NewHash(baseStr)
+		otherStr := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2"
+		other, _ := NewHash(otherStr)
+		linkingEntryHash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+		linkMsg := node.NewMessage(LINK_REQUEST, HoldReq{RelatedHash: other, EntryHash: linkingEntryHash})
+
+		Convey(name+": forgetting an unknown hash should fail", t, func() {
+			So(ht.Forget(base), ShouldEqual, ErrHashNotFound)
+		})
+
+		Convey(name+": it should remove the entry and its links but not the change index", t, func() {
+			So(ht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: base}), "someType", base, id, []byte("some value"), StatusLive), ShouldBeNil)
+			So(ht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: other}), "someType", other, id, []byte("other value"), StatusLive), ShouldBeNil)
+			So(ht.PutLink(linkMsg, baseStr, otherStr, "tag", "", time.Now()), ShouldBeNil)
+			idx, _ := ht.GetIdx()
+
+			So(ht.Forget(base), ShouldBeNil)
+			So(ht.Exists(base, StatusAny), ShouldEqual, ErrHashNotFound)
+			_, err := ht.GetLinks(base, "tag", StatusLive)
+			So(err, ShouldEqual, ErrHashNotFound)
+			So(ht.Exists(other, StatusLive), ShouldBeNil)
+
+			newIdx, _ := ht.GetIdx()
+			So(newIdx, ShouldEqual, idx)
+
+			// putting it back should not resurrect the old links
+			So(ht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: base}), "someType", base, id, []byte("some value"), StatusLive), ShouldBeNil)
+			links, err := ht.GetLinks(base, "", StatusLive)
+			So(err, ShouldBeNil)
+			So(len(links), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestHTReceipts(t *testing.T) {
+	forEachHashTable(t, func(name string, ht HashTable, node *Node) {
+		hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		pid1, _ := makePeer("peer1")
+		pid2, _ := makePeer("peer2")
+		m := node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash})
+
+		Convey(name+": there should be no receipts for an unknown hash", t, func() {
+			receipts, err := ht.GetReceipts(hash)
+			So(err, ShouldBeNil)
+			So(len(receipts), ShouldEqual, 0)
+		})
+
+		Convey(name+": it should store one receipt per peer and message", t, func() {
+			So(ht.PutReceipt(hash, Receipt{Peer: pid1, Code: ReceiptOK, Msg: *m, Time: m.Time}), ShouldBeNil)
+			So(ht.PutReceipt(hash, Receipt{Peer: pid2, Code: ReceiptOK, Msg: *m, Time: m.Time}), ShouldBeNil)
+			// a second receipt from the same peer for the same message replaces the first
+			So(ht.PutReceipt(hash, Receipt{Peer: pid1, Code: ReceiptRejected, Msg: *m, Time: m.Time}), ShouldBeNil)
+
+			receipts, err := ht.GetReceipts(hash)
+			So(err, ShouldBeNil)
+			So(len(receipts), ShouldEqual, 2)
+			codes := make(map[peer.ID]int)
+			for _, r := range receipts {
+				codes[r.Peer] = r.Code
+				So(r.Msg.Type, ShouldEqual, PUT_REQUEST)
+			}
+			So(codes[pid1], ShouldEqual, ReceiptRejected)
+			So(codes[pid2], ShouldEqual, ReceiptOK)
+		})
+	})
+}
+
+func TestHTUsage(t *testing.T) {
+	forEachHashTable(t, func(name string, ht HashTable, node *Node) {
+		id := node.HashAddr
+		other, _ := makePeer("peer1")
+		hash1, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		hash2, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+
+		Convey(name+": usage should be zero for an unknown source", t, func() {
+			n, err := ht.GetUsage(id)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+		})
+
+		Convey(name+": it should track the bytes held for each source", t, func() {
+			So(ht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash1}), "someType", hash1, id, []byte("12345"), StatusLive), ShouldBeNil)
+			So(ht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash2}), "someType", hash2, other, []byte("123"), StatusLive), ShouldBeNil)
+			n, _ := ht.GetUsage(id)
+			So(n, ShouldEqual, 5)
+
+			// putting the same hash again should replace rather than add to the usage
+			So(ht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash1}), "someType", hash1, id, []byte("1234567"), StatusLive), ShouldBeNil)
+			n, _ = ht.GetUsage(id)
+			So(n, ShouldEqual, 7)
+
+			usage, err := ht.Usage()
+			So(err, ShouldBeNil)
+			So(usage[id], ShouldEqual, 7)
+			So(usage[other], ShouldEqual, 3)
+		})
+
+		Convey(name+": forgetting a hash should release its usage", t, func() {
+			So(ht.Forget(hash1), ShouldBeNil)
+			n, _ := ht.GetUsage(id)
+			So(n, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestHTGossipersAndLists(t *testing.T) {
+	forEachHashTable(t, func(name string, ht HashTable, node *Node) {
+		pid1, _ := makePeer("peer1")
+		pid2, _ := makePeer("peer2")
+
+		Convey(name+": it should track gossipers", t, func() {
+			idx, err := ht.GetGossiper(pid1)
+			So(err, ShouldBeNil)
+			So(idx, ShouldEqual, 0)
+
+			So(ht.UpdateGossiper(pid1, 3), ShouldBeNil)
+			So(ht.UpdateGossiper(pid2, 0), ShouldBeNil)
+			idx, _ = ht.GetGossiper(pid1)
+			So(idx, ShouldEqual, 3)
+
+			// updates to a lower index are ignored
+			So(ht.UpdateGossiper(pid1, 2), ShouldBeNil)
+			idx, _ = ht.GetGossiper(pid1)
+			So(idx, ShouldEqual, 3)
+
+			gossipers, err := ht.GetGossipers()
+			So(err, ShouldBeNil)
+			So(len(gossipers), ShouldEqual, 2)
+
+			So(ht.DeleteGossiper(pid2), ShouldBeNil)
+			gossipers, _ = ht.GetGossipers()
+			So(len(gossipers), ShouldEqual, 1)
+			So(gossipers[0].ID, ShouldEqual, pid1)
+			So(gossipers[0].PutIdx, ShouldEqual, 3)
+		})
+
+		Convey(name+": it should store peer lists", t, func() {
+			list, err := ht.GetList(BlockedList)
+			So(err, ShouldBeNil)
+			So(len(list.Records), ShouldEqual, 0)
+
+			m := node.NewMessage(LISTADD_REQUEST, ListAddReq{ListType: BlockedList, Peers: []string{peer.IDB58Encode(pid1)}})
+			err = ht.AddToList(m, PeerList{BlockedList, []PeerRecord{{ID: pid1, Warrant: "bad"}}})
+			So(err, ShouldBeNil)
+
+			list, err = ht.GetList(BlockedList)
+			So(err, ShouldBeNil)
+			So(len(list.Records), ShouldEqual, 1)
+			So(list.Records[0].ID, ShouldEqual, pid1)
+			So(list.Records[0].Warrant, ShouldEqual, "bad")
+
+			So(list.Type, ShouldEqual, BlockedList)
+
+			idx, _ := ht.GetIdx()
+			So(idx, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestHTReputation(t *testing.T) {
+	forEachHashTable(t, func(name string, ht HashTable, node *Node) {
+		pid1, _ := makePeer("peer1")
+		pid2, _ := makePeer("peer2")
+
+		Convey(name+": an unknown peer should have an empty reputation", t, func() {
+			rep, err := ht.GetReputation(pid1)
+			So(err, ShouldBeNil)
+			So(rep, ShouldResemble, Reputation{})
+		})
+
+		Convey(name+": it should add to the reputation of each peer", t, func() {
+			So(ht.UpdateReputation(pid1, Reputation{Timeouts: 1, Holds: 2}), ShouldBeNil)
+			So(ht.UpdateReputation(pid1, Reputation{InvalidData: 1, RejectedPuts: 3, Holds: 1}), ShouldBeNil)
+			So(ht.UpdateReputation(pid2, Reputation{Holds: 5}), ShouldBeNil)
+
+			rep, err := ht.GetReputation(pid1)
+			So(err, ShouldBeNil)
+			So(repCounts(rep), ShouldResemble, Reputation{Timeouts: 1, InvalidData: 1, RejectedPuts: 3, Holds: 3})
+			So(rep.Decayed.IsZero(), ShouldBeFalse)
+			rep, _ = ht.GetReputation(pid2)
+			So(repCounts(rep), ShouldResemble, Reputation{Holds: 5})
+		})
+
+		Convey(name+": deleting a gossiper should keep its reputation", t, func() {
+			So(ht.UpdateGossiper(pid2, 1), ShouldBeNil)
+			So(ht.DeleteGossiper(pid2), ShouldBeNil)
+			rep, _ := ht.GetReputation(pid2)
+			So(repCounts(rep), ShouldResemble, Reputation{Holds: 5})
+		})
+	})
+}
+
+func TestHTHeaderProofs(t *testing.T) {
+	hashSpec, key, _ := chainTestSetup()
+	p1, p2 := forkedChainProofs(hashSpec, key)
+	agent, _ := peer.IDFromPrivateKey(key)
+	other, _ := makePeer("peer1")
+
+	forEachHashTable(t, func(name string, ht HashTable, node *Node) {
+		Convey(name+": it should store the first proof of a header that follows a header", t, func() {
+			seen, err := ht.PutHeaderProof(agent, *p1)
+			So(err, ShouldBeNil)
+			So(seen, ShouldBeNil)
+		})
+
+		Convey(name+": it should return the stored proof for any later header that follows it", t, func() {
+			seen, err := ht.PutHeaderProof(agent, *p2)
+			So(err, ShouldBeNil)
+			So(seen.Header.EntryLink.String(), ShouldEqual, p1.Header.EntryLink.String())
+			seen, err = ht.PutHeaderProof(agent, *p1)
+			So(err, ShouldBeNil)
+			So(seen.Header.EntryLink.String(), ShouldEqual, p1.Header.EntryLink.String())
+		})
+
+		Convey(name+": it should keep each agent's headers apart", t, func() {
+			seen, err := ht.PutHeaderProof(other, *p2)
+			So(err, ShouldBeNil)
+			So(seen, ShouldBeNil)
+		})
+
+		Convey(name+": it should forget the proofs of an entry's headers along with the entry", t, func() {
+			hash := p1.Header.EntryLink
+			So(ht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash}), "oddNumbers", hash, agent, []byte("3"), StatusLive), ShouldBeNil)
+			So(ht.Forget(hash), ShouldBeNil)
+
+			// so the next header that follows the same header is stored in its place
+			seen, err := ht.PutHeaderProof(agent, *p2)
+			So(err, ShouldBeNil)
+			So(seen, ShouldBeNil)
+			seen, err = ht.PutHeaderProof(agent, *p1)
+			So(err, ShouldBeNil)
+			So(seen.Header.EntryLink.String(), ShouldEqual, p2.Header.EntryLink.String())
+
+			// and other agents' proofs are kept
+			seen, err = ht.PutHeaderProof(other, *p1)
+			So(err, ShouldBeNil)
+			So(seen.Header.EntryLink.String(), ShouldEqual, p2.Header.EntryLink.String())
+		})
 	})
 }
@@ -204,8 +204,13 @@ func buildJSValidateAction(action Action, def *EntryDef, pkg *ValidationPackage,
 	srcs := mkJSSources(sources)
 
 	var pkgObj string
-	if pkg == nil || pkg.Chain == nil {
+	if pkg == nil || (pkg.Chain == nil && len(pkg.Proofs) == 0) {
 		pkgObj = "{}"
+	} else if pkg.Chain == nil {
+		pkgObj, err = chainProofsJSON(pkg.Proofs)
+		if err != nil {
+			return
+		}
 	} else {
 		var j []byte
 		j, err = json.Marshal(pkg.Chain)
@@ -316,6 +321,7 @@ const (
 		`Headers:"` + HeadersEntryType + `"` +
 		`Del:"` + DelEntryType + `"` +
 		`Migrate:"` + MigrateEntryType + `"` +
+		`Checkpoint:"` + CheckpointEntryType + `"` +
 		`}` +
 		`HashNotFound:null` +
 		`,Status:{Live:` + StatusLiveVal +
@@ -336,6 +342,7 @@ const (
 		`,Headers:` + PkgReqChainOptHeadersStr +
 		`,Entries:` + PkgReqChainOptEntriesStr +
 		`,Full:` + PkgReqChainOptFullStr +
+		`,Proof:` + PkgReqChainOptProofStr +
 		"}" +
 		"}" +
 		`,Bridge:{Caller:` + BridgeCallerStr +
@@ -344,6 +351,7 @@ const (
 		`,BundleCancel:{` +
 		`Reason:{UserCancel:"` + BundleCancelReasonUserCancel +
 		`",Timeout:"` + BundleCancelReasonTimeout +
+		`",Diverged:"` + BundleCancelReasonDiverged +
 		`"},Response:{OK:"` + BundleCancelResponseOK +
 		`",Commit:"` + BundleCancelResponseCommit +
 		`"}}` +
@@ -665,6 +673,70 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 				return
 			},
 		},
+		"getReceipts": fnData{
+			apiFn: &APIFnGetReceipts{},
+			f: func(args []Arg, _f APIFunction, call otto.FunctionCall) (result otto.Value, err error) {
+				f := _f.(*APIFnGetReceipts)
+				f.hash = args[0].value.(Hash)
+				var r interface{}
+				r, err = f.Call(h)
+				if err != nil {
+					return
+				}
+				var code string
+				for i, receipt := range r.([]Receipt) {
+					if i > 0 {
+						code += ","
+					}
+					code += fmt.Sprintf(`{Peer:"%s",Code:%d,Signature:"%s",Time:"%s"}`, peer.IDB58Encode(receipt.Peer), receipt.Code, receipt.Signature.B58String(), receipt.Time.Format(time.RFC3339))
+				}
+				code = "[" + code + "]"
+				var object *otto.Object
+				object, err = jsr.vm.Object(code)
+				if err != nil {
+					return
+				}
+				result, err = jsr.vm.ToValue(object)
+				return
+			},
+		},
+		"subscribe": fnData{
+			apiFn: &APIFnSubscribe{},
+			f: func(args []Arg, _f APIFunction, call otto.FunctionCall) (result otto.Value, err error) {
+				f := _f.(*APIFnSubscribe)
+				f.zome = jsr.zome.Name
+				var j []byte
+				j, err = json.Marshal(args[0].value)
+				if err != nil {
+					return
+				}
+				err = json.Unmarshal(j, &f.filter)
+				if err != nil {
+					return
+				}
+				f.callback = args[1].value.(string)
+				var r interface{}
+				r, err = f.Call(h)
+				if err != nil {
+					return
+				}
+				result, err = jsr.vm.ToValue(r)
+				return
+			},
+		},
+		"unsubscribe": fnData{
+			apiFn: &APIFnUnsubscribe{},
+			f: func(args []Arg, _f APIFunction, call otto.FunctionCall) (result otto.Value, err error) {
+				f := _f.(*APIFnUnsubscribe)
+				f.id = int(args[0].value.(int64))
+				_, err = f.Call(h)
+				if err != nil {
+					return
+				}
+				result = otto.UndefinedValue()
+				return
+			},
+		},
 		"sign": fnData{
 			apiFn: &APIFnSign{},
 			f: func(args []Arg, _f APIFunction, call otto.FunctionCall) (result otto.Value, err error) {
@@ -862,6 +934,19 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 				return
 			},
 		},
+		"checkpoint": fnData{
+			apiFn: &APIFnCheckpoint{},
+			f: func(args []Arg, _f APIFunction, call otto.FunctionCall) (result otto.Value, err error) {
+				f := _f.(*APIFnCheckpoint)
+				var r interface{}
+				r, err = f.Call(h)
+				if err != nil {
+					return
+				}
+				result, err = jsr.vm.ToValue(r.(Hash).String())
+				return
+			},
+		},
 
 		"query": fnData{
 			apiFn: &APIFnQuery{},
@@ -886,6 +971,10 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 				if err != nil {
 					return
 				}
+				if f.options != nil && f.options.Aggregate.Fn != "" {
+					result, err = jsr.vm.ToValue(r)
+					return
+				}
 				qr := r.([]QueryResult)
 
 				defs := make(map[string]*EntryDef)
@@ -1166,6 +1255,9 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 
 				l := len(call.ArgumentList)
 				options := GetLinksOptions{Load: false, StatusMask: StatusLive}
+				// when asking for a page of links we return an object with the
+				// links and the cursor for the next page instead of just the links
+				var paged bool
 				if l == 3 {
 					opts, ok := args[2].value.(map[string]interface{})
 					if ok {
@@ -1187,11 +1279,45 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 							}
 							options.StatusMask = int(maskval)
 						}
+						page, ok := opts["Page"]
+						if ok {
+							pageval, ok := numInterfaceToInt(page)
+							if !ok {
+								err = errors.New(fmt.Sprintf("expecting int Page attribute in object, got %T", page))
+								return
+							}
+							options.Page = int(pageval)
+							paged = true
+						}
+						count, ok := opts["Count"]
+						if ok {
+							countval, ok := numInterfaceToInt(count)
+							if !ok {
+								err = errors.New(fmt.Sprintf("expecting int Count attribute in object, got %T", count))
+								return
+							}
+							options.Count = int(countval)
+							paged = true
+						}
+						cursor, ok := opts["Cursor"]
+						if ok {
+							cursorval, ok := cursor.(string)
+							if !ok {
+								err = errors.New(fmt.Sprintf("expecting string Cursor attribute in object, got %T", cursor))
+								return
+							}
+							options.Cursor = cursorval
+							paged = true
+						}
+						err = options.setQueryOptions(opts)
+						if err != nil {
+							return
+						}
 					}
 				}
 				var response interface{}
 				f := _f.(*APIFnGetLinks)
-				f.action = *NewGetLinksAction(&LinkQuery{Base: base, T: tag, StatusMask: options.StatusMask}, &options)
+				f.action = *NewGetLinksAction(newLinkQuery(base, tag, &options), &options)
 				response, err = f.Call(h)
 
 				if err == nil {
@@ -1243,6 +1369,9 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 					}
 					if err == nil {
 						js = `[` + js + `]`
+						if paged {
+							js = `{Links:` + js + `,Next:"` + jsSanitizeString(lqr.Next) + `"}`
+						}
 						var obj *otto.Object
 						jsr.h.Debugf("getLinks code:\n%s", js)
 						obj, err = jsr.vm.Object(js)
@@ -1412,6 +1541,19 @@ func (jsr *JSRibosome) Run(code string) (result interface{}, err error) {
 	return
 }
 
+// RunSubscriptionCallback calls the zome function subscribed to changes to the DHT with a change
+func (jsr *JSRibosome) RunSubscriptionCallback(change DHTChange, callback string, id int) (result interface{}, err error) {
+	var j []byte
+	j, err = json.Marshal(change)
+	if err != nil {
+		return
+	}
+	code := fmt.Sprintf(`%s(JSON.parse("%s"),%d)`, callback, jsSanitizeString(string(j)), id)
+	jsr.h.Debugf("Calling %s\n", code)
+	result, err = jsr.Run(code)
+	return
+}
+
 func (jsr *JSRibosome) RunAsyncSendResponse(response AppMsg, callback string, callbackID string) (result interface{}, err error) {
 
 	code := fmt.Sprintf(`%s(JSON.parse("%s"),"%s")`, callback, jsSanitizeString(response.Body), jsSanitizeString(callbackID))
@@ -327,7 +327,7 @@ func TestNewJSRibosome(t *testing.T) {
 			// a string calling function
 			_, err := z.Run(`call("zySampleZome","addEven","432")`)
 			So(err, ShouldBeNil)
-			So(h.chain.Entries[len(h.chain.Hashes)-1].Content(), ShouldEqual, "432")
+			So(topEntry(h).Content(), ShouldEqual, "432")
 			z := v.(*JSRibosome)
 			hash, _ := NewHash(z.lastResult.String())
 			entry, _, _ := h.chain.GetEntry(hash)
@@ -336,7 +336,7 @@ func TestNewJSRibosome(t *testing.T) {
 			// a json calling function
 			_, err = z.Run(`call("zySampleZome","addPrime",{prime:7})`)
 			So(err, ShouldBeNil)
-			So(h.chain.Entries[len(h.chain.Hashes)-1].Content(), ShouldEqual, `{"prime":7}`)
+			So(topEntry(h).Content(), ShouldEqual, `{"prime":7}`)
 			hashJSONStr := z.lastResult.String()
 			var hashStr string
 			json.Unmarshal([]byte(hashJSONStr), &hashStr)
@@ -433,6 +433,14 @@ func TestNewJSRibosome(t *testing.T) {
 			entry, _, _ := h.chain.GetEntry(migrationEntryHash)
 			So(entry.Content(), ShouldEqual, "{\"Type\":\"close\",\"DNAHash\":\""+dnaHash.String()+"\",\"Key\":\""+key.String()+"\",\"Data\":\""+data+"\"}")
 		})
+		Convey("checkpoint", func() {
+			_, err := z.Run(`checkpoint()`)
+			So(err, ShouldBeNil)
+			checkpointHash, _ := NewHash(z.lastResult.String())
+			_, entryType, err := h.chain.GetEntry(checkpointHash)
+			So(err, ShouldBeNil)
+			So(entryType, ShouldEqual, CheckpointEntryType)
+		})
 	})
 }
 
@@ -491,6 +499,17 @@ func TestJSQuery(t *testing.T) {
 			_, err := z.Run(`debug(query({Constrain:{EntryTypes:["rating"]}}))`)
 			So(err, ShouldBeNil)
 		}, `[{"Links":[{"Base":"QmSwMfay3iCynzBFeq9rPzTMTnnuQSMUSe84whjcC9JPAo","Link":"QmYeinX5vhuA91D3v24YbgyLofw9QAxY6PoATrBHnRwbtt","Tag":"4stars"}]}]`)
+
+		commit(h, "profile", `{"firstName":"Zerbina","lastName":"Pinhead","age":4321}`)
+		commit(h, "profile", `{"firstName":"Pebbles","lastName":"Flintstone","age":1234}`)
+		ShouldLog(h.nucleus.alog, func() {
+			_, err := z.Run(`debug(query({Constrain:{EntryTypes:["profile"],Or:[{Compare:[{Field:"age",Op:">",Value:2000}]},{Equals:'{"firstName":"Zippy"}'}]},Order:{Field:"firstName",Ascending:true}}))`)
+			So(err, ShouldBeNil)
+		}, `[{"firstName":"Zerbina","lastName":"Pinhead","age":4321},{"firstName":"Zippy","lastName":"Pinhead"}]`)
+		ShouldLog(h.nucleus.alog, func() {
+			_, err := z.Run(`debug(query({Constrain:{EntryTypes:["profile"]},Aggregate:{Fn:"sum",Field:"age"}}))`)
+			So(err, ShouldBeNil)
+		}, `5555`)
 	})
 }
 
@@ -584,6 +603,17 @@ func TestJSbuildValidate(t *testing.T) {
 		//	So(code, ShouldEqual, `validatePut("evenNumbers","2",{"EntryLink":"","Type":"","Time":"0001-01-01T00:00:00Z"},pgk,["fake_src_hash"])`)
 	})
 
+	Convey("it should build put with a proof package", t, func() {
+		a := NewPutAction("evenNumbers", &e, &header)
+		pkg, _ := MakePackage(h, PackagingReq{PkgReqChain: int64(PkgReqChainOptProof)})
+		vpkg, err := MakeValidationPackage(h, &pkg)
+		So(err, ShouldBeNil)
+		code, err := buildJSValidateAction(a, &def, vpkg, []string{"fake_src_hash"})
+		So(err, ShouldBeNil)
+		So(code, ShouldContainSubstring, fmt.Sprintf(`{"Proofs":[{"Index":%d,"Length":%d,"Header":{"Type":"%s"`, h.chain.Length()-1, h.chain.Length(), AgentEntryType))
+		So(code, ShouldContainSubstring, fmt.Sprintf(`"Agent":"%s"}]}`, h.nodeIDStr))
+	})
+
 }
 
 func TestJSValidateCommit(t *testing.T) {
@@ -789,6 +819,19 @@ func TestJSDHT(t *testing.T) {
 		So(fmt.Sprintf("%v", x), ShouldEqual, `7`)
 	})
 
+	Convey("getReceipts should return the hold receipts received for an entry", t, func() {
+		pid, _ := makePeer("peer1")
+		m := h.node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash})
+		err := h.dht.PutReceipt(hash, Receipt{Peer: pid, Code: ReceiptOK, Msg: *m, Time: m.Time})
+		So(err, ShouldBeNil)
+		v, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`var r=getReceipts("%s");r.length+" "+r[0].Peer+" "+r[0].Code;`, hash.String())})
+		So(err, ShouldBeNil)
+		z := v.(*JSRibosome)
+		x, err := z.lastResult.Export()
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", x), ShouldEqual, fmt.Sprintf("1 %s %d", peer.IDB58Encode(pid), ReceiptOK))
+	})
+
 	Convey("get should return entry of sys types", t, func() {
 		ShouldLog(h.nucleus.alog, func() {
 			_, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`debug(get("%s"));`, h.agentHash.String())})
@@ -897,6 +940,54 @@ func TestJSDHT(t *testing.T) {
 		So(l0["EntryType"], ShouldEqual, "review")
 	})
 
+	Convey("getLinks with Count option should return pages of Links and a cursor to continue", t, func() {
+		v, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`getLinks("%s","4stars",{Count:1});`, hash.String())})
+		So(err, ShouldBeNil)
+		z := v.(*JSRibosome)
+		So(z.lastResult.Class(), ShouldEqual, "Object")
+		x, _ := z.lastResult.Export()
+		page := x.(map[string]interface{})
+		links := page["Links"].([]map[string]interface{})
+		So(len(links), ShouldEqual, 1)
+		So(links[0]["Hash"], ShouldEqual, reviewHash.String())
+		next := page["Next"].(string)
+		So(next, ShouldEqual, reviewHash.String()+":4stars")
+
+		v, err = NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`getLinks("%s","4stars",{Count:1,Cursor:"%s"});`, hash.String(), next)})
+		So(err, ShouldBeNil)
+		z = v.(*JSRibosome)
+		x, _ = z.lastResult.Export()
+		page = x.(map[string]interface{})
+		links = page["Links"].([]map[string]interface{})
+		So(len(links), ShouldEqual, 1)
+		So(links[0]["Hash"], ShouldEqual, profileHash.String())
+		So(page["Next"], ShouldEqual, "")
+
+		v, err = NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`getLinks("%s","4stars",{Count:1,Page:1});`, hash.String())})
+		So(err, ShouldBeNil)
+		z = v.(*JSRibosome)
+		x, _ = z.lastResult.Export()
+		links = x.(map[string]interface{})["Links"].([]map[string]interface{})
+		So(len(links), ShouldEqual, 1)
+		So(links[0]["Hash"], ShouldEqual, profileHash.String())
+	})
+
+	Convey("getLinks with EntryTypes option should only return Links to entries of those types", t, func() {
+		v, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`getLinks("%s","4stars",{EntryTypes:["profile"]});`, hash.String())})
+		So(err, ShouldBeNil)
+		z := v.(*JSRibosome)
+		So(z.lastResult.Class(), ShouldEqual, "Array")
+		links, _ := z.lastResult.Export()
+		So(len(links.([]map[string]interface{})), ShouldEqual, 1)
+		So(links.([]map[string]interface{})[0]["Hash"], ShouldEqual, profileHash.String())
+	})
+
+	Convey("getLinks with an unknown Order option should fail", t, func() {
+		_, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`getLinks("%s","4stars",{Order:"bogus"});`, hash.String())})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "unknown link order: bogus")
+	})
+
 	Convey("getLinks with load option should return the Links and entries for linked sys types", t, func() {
 		commit(h, "rating", fmt.Sprintf(`{"Links":[{"Base":"%s","Link":"%s","Tag":"4stars"},{"Base":"%s","Link":"%s","Tag":"4stars"}]}`, profileHash.String(), h.nodeIDStr, profileHash.String(), h.agentHash.String()))
 		v, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`getLinks("%s","4stars",{Load:true});`, profileHash.String())})
@@ -1083,7 +1174,7 @@ func TestJSDHT(t *testing.T) {
 		So(found, ShouldBeTrue)
 
 		// the old peerID should now be in the blockedlist
-		peerList, err := h.dht.getList(BlockedList)
+		peerList, err := h.dht.GetList(BlockedList)
 		So(err, ShouldBeNil)
 		So(len(peerList.Records), ShouldEqual, 1)
 		So(peerList.Records[0].ID, ShouldEqual, oldPeer)
@@ -98,7 +98,7 @@ func newQueryRunner(q *dhtQuery) *dhtQueryRunner {
 	ctx := ctxproc.OnClosingContext(proc)
 	return &dhtQueryRunner{
 		query:          q,
-		peersToQuery:   queue.NewChanQueue(ctx, queue.NewXORDistancePQ(q.key)),
+		peersToQuery:   queue.NewChanQueue(ctx, queue.NewReputationPQ(q.key, q.node.reputation)),
 		peersRemaining: todoctr.NewSyncCounter(),
 		peersSeen:      pset.New(),
 		rateLimit:      make(chan struct{}, q.concurrency),
@@ -0,0 +1,726 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements a purely in-memory instance of HashTable
+// nothing is written to disk so it's suitable for tests and ephemeral nodes,
+// but all the DHT data is lost when the table is closed
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+const (
+	MemHTType = "memory"
+)
+
+// memHTEntry holds the data stored for a hash in the MemHT
+type memHTEntry struct {
+	value      []byte
+	entryType  string
+	source     string
+	status     int
+	replacedBy string
+}
+
+type MemHT struct {
+	lk           sync.RWMutex
+	entries      map[string]*memHTEntry
+	links        map[string]map[string][]linkEvent // base => link:tag => linking events
+	idx          int
+	messages     map[int][]byte
+	fingerprints map[string]int
+	gossipers    map[peer.ID]int
+	reputations  map[peer.ID]Reputation
+	headers      map[string][]byte   // agent:previous header => header proof
+	headersOf    map[string][]string // entry => keys in headers of the proofs of its headers
+	lists        map[PeerListType]map[peer.ID]string
+	receipts     map[string]map[string][]byte // hash => peer:fingerprint => receipt
+	usage        map[peer.ID]int
+}
+
+// NewMemHT creates a MemHT, the path is ignored because nothing is stored on disk
+func NewMemHT(dbPath string) (ht HashTable, err error) {
+	m := &MemHT{}
+	err = m.Open(nil)
+	if err == nil {
+		ht = m
+	}
+	return
+}
+
+// Open initializes the table
+func (ht *MemHT) Open(options interface{}) (err error) {
+	ht.entries = make(map[string]*memHTEntry)
+	ht.links = make(map[string]map[string][]linkEvent)
+	ht.idx = 0
+	ht.messages = make(map[int][]byte)
+	ht.fingerprints = make(map[string]int)
+	ht.gossipers = make(map[peer.ID]int)
+	ht.reputations = make(map[peer.ID]Reputation)
+	ht.headers = make(map[string][]byte)
+	ht.headersOf = make(map[string][]string)
+	ht.lists = make(map[PeerListType]map[peer.ID]string)
+	ht.receipts = make(map[string]map[string][]byte)
+	ht.usage = make(map[peer.ID]int)
+	return
+}
+
+// Close cleans up any resources used by the table
+func (ht *MemHT) Close() {
+	ht.lk.Lock()
+	defer ht.lk.Unlock()
+	ht.entries = nil
+	ht.links = nil
+	ht.messages = nil
+	ht.fingerprints = nil
+	ht.gossipers = nil
+	ht.reputations = nil
+	ht.headers = nil
+	ht.headersOf = nil
+	ht.lists = nil
+	ht.receipts = nil
+	ht.usage = nil
+}
+
+// incIdx adds a new index record for gossiping later
+// assumes the write lock is held
+func (ht *MemHT) incIdx(m *Message) (err error) {
+	// if message is nil we can't record this for gossiping
+	// this should only be the case for the DNA
+	if m == nil {
+		return
+	}
+	var b []byte
+	b, err = ByteEncoder(m)
+	if err != nil {
+		return
+	}
+	var f Hash
+	f, err = m.Fingerprint()
+	if err != nil {
+		return
+	}
+	ht.idx++
+	ht.messages[ht.idx] = b
+	ht.fingerprints[f.String()] = ht.idx
+	return
+}
+
+// Put stores a value to the DHT store
+// N.B. This call assumes that the value has already been validated
+func (ht *MemHT) Put(m *Message, entryType string, key Hash, src peer.ID, value []byte, status int) (err error) {
+	ht.lk.Lock()
+	defer ht.lk.Unlock()
+	err = ht.incIdx(m)
+	if err != nil {
+		return
+	}
+	ht.releaseUsage(key.String())
+	v := make([]byte, len(value))
+	copy(v, value)
+	ht.usage[src] += len(v)
+	ht.entries[key.String()] = &memHTEntry{value: v, entryType: entryType, source: peer.IDB58Encode(src), status: status}
+	return
+}
+
+// setStatus changes the status of an entry, assumes the write lock is held
+func (ht *MemHT) setStatus(m *Message, key string, status int) (e *memHTEntry, err error) {
+	e = ht.entries[key]
+	if e == nil {
+		err = ErrHashNotFound
+		return
+	}
+	err = ht.incIdx(m)
+	if err != nil {
+		return
+	}
+	e.status = status
+	return
+}
+
+// Del moves the given hash to the StatusDeleted status
+// N.B. this functions assumes that the validity of this action has been confirmed
+func (ht *MemHT) Del(m *Message, key Hash) (err error) {
+	ht.lk.Lock()
+	defer ht.lk.Unlock()
+	_, err = ht.setStatus(m, key.String(), StatusDeleted)
+	return
+}
+
+// Mod moves the given hash to the StatusModified status
+// N.B. this functions assumes that the validity of this action has been confirmed
+func (ht *MemHT) Mod(m *Message, key Hash, newkey Hash, t time.Time) (err error) {
+	ht.lk.Lock()
+	defer ht.lk.Unlock()
+	k := key.String()
+	var e *memHTEntry
+	e, err = ht.setStatus(m, k, StatusModified)
+	if err == nil {
+		link := newkey.String()
+		err = ht.link(k, link, SysTagReplacedBy, m.From, StatusLive, newkey, "", t)
+		if err == nil {
+			e.replacedBy = link
+		}
+	}
+	return
+}
+
+// get returns the value of an entry checking it against the status mask
+// with the same semantics as the BuntHT, assumes the read lock is held
+func (ht *MemHT) get(k string, statusMask int) (e *memHTEntry, val []byte, err error) {
+	e = ht.entries[k]
+	if e == nil {
+		err = ErrHashNotFound
+		return
+	}
+	val = e.value
+	if statusMask == StatusDefault {
+		// if the status mask is not given (i.e. Default) then
+		// we return information about the status if it's other than live
+		switch e.status {
+		case StatusDeleted:
+			err = ErrHashDeleted
+		case StatusModified:
+			val = []byte(e.replacedBy)
+			err = ErrHashModified
+		case StatusRejected:
+			err = ErrHashRejected
+		case StatusLive:
+		default:
+			panic("unknown status!")
+		}
+	} else if (e.status & statusMask) == 0 {
+		// otherwise we return the value only if the status is in the mask
+		err = ErrHashNotFound
+	}
+	return
+}
+
+// Exists checks for the existence of the hash in the store
+func (ht *MemHT) Exists(key Hash, statusMask int) (err error) {
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	_, _, err = ht.get(key.String(), statusMask)
+	return
+}
+
+// Source returns the source node address of a given hash
+func (ht *MemHT) Source(key Hash) (id peer.ID, err error) {
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	e := ht.entries[key.String()]
+	if e == nil {
+		err = ErrHashNotFound
+		return
+	}
+	id, err = peer.IDB58Decode(e.source)
+	return
+}
+
+// Get retrieves a value from the DHT store
+func (ht *MemHT) Get(key Hash, statusMask int, getMask int) (data []byte, entryType string, sources []string, status int, err error) {
+	if getMask == GetMaskDefault {
+		getMask = GetMaskEntry
+	}
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	var e *memHTEntry
+	var val []byte
+	e, val, err = ht.get(key.String(), statusMask)
+	data = make([]byte, len(val)) // gotta do this because value is valid if ErrHashModified
+	copy(data, val)
+	if err != nil {
+		return
+	}
+	if (getMask & GetMaskEntryType) != 0 {
+		entryType = e.entryType
+	}
+	if (getMask & GetMaskSources) != 0 {
+		sources = append(sources, e.source)
+	}
+	status = e.status
+	return
+}
+
+// link is a low level routine to add a link, also used by delLink
+// this ensure monotonic recording of linking attempts
+// assumes the write lock is held
+func (ht *MemHT) link(base string, link string, tag string, src peer.ID, status int, linkingEntryHash Hash, entryType string, t time.Time) (err error) {
+	links := ht.links[base]
+	if links == nil {
+		links = make(map[string][]linkEvent)
+		ht.links[base] = links
+	}
+	key := link + ":" + tag
+	records, exists := links[key]
+	// when deleting the key must exist
+	if !exists && status == StatusDeleted {
+		err = ErrLinkNotFound
+		return
+	}
+	links[key] = appendLinkEvent(records, status, peer.IDB58Encode(src), linkingEntryHash.String(), entryType, t)
+	return
+}
+
+func (ht *MemHT) putLink(m *Message, base string, link string, tag string, status int, entryType string, t time.Time) (err error) {
+	ht.lk.Lock()
+	defer ht.lk.Unlock()
+	_, _, err = ht.get(base, StatusLive)
+	if err != nil {
+		return
+	}
+	err = ht.link(base, link, tag, m.From, status, m.Body.(HoldReq).EntryHash, entryType, t)
+	if err != nil {
+		return
+	}
+	err = ht.incIdx(m)
+	return
+}
+
+// PutLink associates a link with a stored hash
+// N.B. this function assumes that the data associated has been properly retrieved
+// and validated from the cource chain
+func (ht *MemHT) PutLink(m *Message, base string, link string, tag string, entryType string, t time.Time) (err error) {
+	err = ht.putLink(m, base, link, tag, StatusLive, entryType, t)
+	return
+}
+
+// DelLink removes a link and tag associated with a stored hash
+// N.B. this function assumes that the action has been properly validated
+func (ht *MemHT) DelLink(m *Message, base string, link string, tag string, t time.Time) (err error) {
+	err = ht.putLink(m, base, link, tag, StatusDeleted, "", t)
+	return
+}
+
+// iterateLinks calls fn for each of the link records stored on a base in
+// key order, assumes the read lock is held
+func (ht *MemHT) iterateLinks(base string, fn func(link string, tag string, records []linkEvent)) {
+	links := ht.links[base]
+	keys := make([]string, 0, len(links))
+	for k := range links {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		x := strings.SplitN(k, ":", 2)
+		fn(x[0], x[1], links[k])
+	}
+}
+
+// Forget removes all the data stored for a hash, including the links on it
+func (ht *MemHT) Forget(key Hash) (err error) {
+	ht.lk.Lock()
+	defer ht.lk.Unlock()
+	k := key.String()
+	if ht.entries[k] == nil {
+		err = ErrHashNotFound
+		return
+	}
+	ht.releaseUsage(k)
+	delete(ht.entries, k)
+	delete(ht.links, k)
+	for _, h := range ht.headersOf[k] {
+		delete(ht.headers, h)
+	}
+	delete(ht.headersOf, k)
+	return
+}
+
+// GetLinks retrieves meta value associated with a base
+func (ht *MemHT) GetLinks(base Hash, tag string, statusMask int) (results []TaggedHash, err error) {
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	b := base.String()
+	_, _, err = ht.get(b, StatusLive+StatusModified) //only get links on live and modified bases
+	if err != nil {
+		return
+	}
+
+	if statusMask == StatusDefault {
+		statusMask = StatusLive
+	}
+
+	results = make([]TaggedHash, 0)
+	ht.iterateLinks(b, func(link string, t string, records []linkEvent) {
+		if tag == "" || tag == t {
+			l := len(records)
+			//TODO: this is totally bogus currently simply
+			// looking at the last item we ever got
+			if l > 0 {
+				entry := records[l-1]
+				if (entry.Status & statusMask) > 0 {
+					th := TaggedHash{H: link, Source: entry.Source, Time: entry.Time, linkedType: entry.EntryType}
+					if tag == "" {
+						th.T = t
+					}
+					results = append(results, th)
+				}
+			}
+		}
+	})
+	return
+}
+
+// GetIdx returns the current index of changes to the HashTable
+func (ht *MemHT) GetIdx() (idx int, err error) {
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	idx = ht.idx
+	return
+}
+
+// GetIdxMessage returns the messages that causes the change at a given index
+func (ht *MemHT) GetIdxMessage(idx int) (msg Message, err error) {
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	b, ok := ht.messages[idx]
+	if !ok {
+		err = ErrNoSuchIdx
+		return
+	}
+	err = ByteDecoder(b, &msg)
+	return
+}
+
+// sortedKeys returns the hashes of all the entries in key order, assumes the read lock is held
+func (ht *MemHT) sortedKeys() (keys []string) {
+	keys = make([]string, 0, len(ht.entries))
+	for k := range ht.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// DumpIdx converts message and data of a DHT change request to a string for human consumption
+func (ht *MemHT) dumpIdx(idx int) (str string, err error) {
+	return dumpHashTableIdx(ht, idx)
+}
+
+// String converts the table into a human readable string
+func (ht *MemHT) String() (result string) {
+	idx, err := ht.GetIdx()
+	if err != nil {
+		return err.Error()
+	}
+	result += fmt.Sprintf("DHT changes: %d\n", idx)
+	for i := 1; i <= idx; i++ {
+		str, err := ht.dumpIdx(i)
+		if err != nil {
+			result += fmt.Sprintf("%d Error:%v\n", i, err)
+		} else {
+			result += fmt.Sprintf("%d\n%v\n", i, str)
+		}
+	}
+
+	result += fmt.Sprintf("DHT entries:\n")
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	for _, k := range ht.sortedKeys() {
+		e := ht.entries[k]
+		var links string
+		ht.iterateLinks(k, func(link string, tag string, records []linkEvent) {
+			v, _ := json.Marshal(records)
+			links += fmt.Sprintf("Linked to: %s with tag %s\n", link, tag)
+			links += string(v) + "\n"
+		})
+		status := statusValueToString(fmt.Sprintf("%d", e.status))
+		result += fmt.Sprintf("Hash--%s (status %s):\nValue: %s\nSources: %s\n%s\n", k, status, string(e.value), e.source, links)
+	}
+	return
+}
+
+// DumpIdxJSON converts message and data of a DHT change request to a JSON string representation.
+func (ht *MemHT) dumpIdxJSON(idx int) (str string, err error) {
+	return dumpHashTableIdxJSON(ht, idx)
+}
+
+// JSON converts the table into a JSON string representation.
+func (ht *MemHT) JSON() (result string, err error) {
+	var buffer, entries bytes.Buffer
+	idx, err := ht.GetIdx()
+	if err != nil {
+		return "", err
+	}
+	buffer.WriteString("{ \"dht_changes\": [")
+	for i := 1; i <= idx; i++ {
+		json, err := ht.dumpIdxJSON(i)
+		if err != nil {
+			return "", fmt.Errorf("DHT Change %d,  Error: %v", i, err)
+		}
+		buffer.WriteString(json)
+		if i < idx {
+			buffer.WriteString(",")
+		}
+	}
+	buffer.WriteString("], \"dht_entries\": [")
+	ht.lk.RLock()
+	for _, k := range ht.sortedKeys() {
+		e := ht.entries[k]
+		var links bytes.Buffer
+		ht.iterateLinks(k, func(link string, tag string, records []linkEvent) {
+			v, _ := json.Marshal(records)
+			links.WriteString(fmt.Sprintf("{ \"linkTo\": \"%s\",", link))
+			links.WriteString(fmt.Sprintf("\"tag\": \"%s\",", tag))
+			links.WriteString(fmt.Sprintf("\"value\": \"%s\" },", EscapeJSONValue(string(v))))
+		})
+		entries.WriteString(fmt.Sprintf("{ \"hash\": \"%s\",", k))
+		status := statusValueToString(fmt.Sprintf("%d", e.status))
+		entries.WriteString(fmt.Sprintf("\"status\": \"%s\",", status))
+		entries.WriteString(fmt.Sprintf("\"value\": \"%s\",", EscapeJSONValue(string(e.value))))
+		entries.WriteString(fmt.Sprintf("\"sources\": \"%s\"", e.source))
+		if links.Len() > 0 {
+			entries.WriteString(fmt.Sprintf(",\"links\": [%s]", strings.TrimSuffix(links.String(), ",")))
+		}
+		entries.WriteString("},")
+	}
+	ht.lk.RUnlock()
+	buffer.WriteString(strings.TrimSuffix(entries.String(), ","))
+	buffer.WriteString("]}")
+	return PrettyPrintJSON(buffer.Bytes())
+}
+
+// Iterate call fn on all the hashes in the table
+func (ht *MemHT) Iterate(fn HashTableIterateFn) {
+	ht.lk.RLock()
+	keys := ht.sortedKeys()
+	ht.lk.RUnlock()
+	for _, k := range keys {
+		hash, err := NewHash(k)
+		if err != nil || !fn(hash) {
+			break
+		}
+	}
+}
+
+// GetFingerprint returns the index that of the message that made a change or -1 if we don't have it
+func (ht *MemHT) GetFingerprint(f Hash) (index int, err error) {
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	index, ok := ht.fingerprints[f.String()]
+	if !ok {
+		index = -1
+	}
+	return
+}
+
+// GetPuts returns a list of puts after the given index
+func (ht *MemHT) GetPuts(since int) (puts []Put, err error) {
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	puts = make([]Put, 0)
+	if since < 1 {
+		since = 1
+	}
+	for i := since; i <= ht.idx; i++ {
+		b, ok := ht.messages[i]
+		if !ok {
+			continue
+		}
+		p := Put{Idx: i}
+		err = ByteDecoder(b, &p.M)
+		if err != nil {
+			return
+		}
+		puts = append(puts, p)
+	}
+	return
+}
+
+// GetGossiper loads returns last known index of the gossiper
+func (ht *MemHT) GetGossiper(id peer.ID) (idx int, err error) {
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	idx = ht.gossipers[id]
+	return
+}
+
+// GetGossipers returns all the gossipers with their last known index
+func (ht *MemHT) GetGossipers() (gossipers []GossiperData, err error) {
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	for id, idx := range ht.gossipers {
+		gossipers = append(gossipers, GossiperData{ID: id, PutIdx: idx})
+	}
+	sort.Slice(gossipers, func(i, j int) bool { return gossipers[i].ID < gossipers[j].ID })
+	return
+}
+
+// UpdateGossiper updates a gossiper's index if it's greater than the stored one
+func (ht *MemHT) UpdateGossiper(id peer.ID, newIdx int) (err error) {
+	ht.lk.Lock()
+	defer ht.lk.Unlock()
+	idx, ok := ht.gossipers[id]
+	if ok && newIdx < idx {
+		return
+	}
+	ht.gossipers[id] = newIdx
+	return
+}
+
+// DeleteGossiper removes a gossiper from the table
+func (ht *MemHT) DeleteGossiper(id peer.ID) (err error) {
+	ht.lk.Lock()
+	defer ht.lk.Unlock()
+	delete(ht.gossipers, id)
+	return
+}
+
+// GetReputation returns the reputation of a peer
+func (ht *MemHT) GetReputation(id peer.ID) (rep Reputation, err error) {
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	rep = ht.reputations[id]
+	return
+}
+
+// UpdateReputation adds the counts in delta to the reputation of a peer
+func (ht *MemHT) UpdateReputation(id peer.ID, delta Reputation) (err error) {
+	ht.lk.Lock()
+	defer ht.lk.Unlock()
+	ht.reputations[id] = ht.reputations[id].update(delta, time.Now())
+	return
+}
+
+// PutHeaderProof stores the proof of one of an agent's headers under the header it follows
+func (ht *MemHT) PutHeaderProof(agent peer.ID, proof ChainProof) (seen *ChainProof, err error) {
+	key := peer.IDB58Encode(agent) + ":" + proof.Header.HeaderLink.String()
+	ht.lk.Lock()
+	defer ht.lk.Unlock()
+	if b, ok := ht.headers[key]; ok {
+		var p ChainProof
+		err = ByteDecoder(b, &p)
+		if err == nil {
+			seen = &p
+		}
+		return
+	}
+	ht.headers[key], err = ByteEncoder(&proof)
+	if err == nil {
+		entry := proof.Header.EntryLink.String()
+		ht.headersOf[entry] = append(ht.headersOf[entry], key)
+	}
+	return
+}
+
+// GetList returns the peer list of the given type
+func (ht *MemHT) GetList(listType PeerListType) (result PeerList, err error) {
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	result.Type = listType
+	result.Records = make([]PeerRecord, 0)
+	for id, warrant := range ht.lists[listType] {
+		result.Records = append(result.Records, PeerRecord{ID: id, Warrant: warrant})
+	}
+	sort.Slice(result.Records, func(i, j int) bool { return result.Records[i].ID < result.Records[j].ID })
+	return
+}
+
+// AddToList adds the peers to a list
+func (ht *MemHT) AddToList(m *Message, list PeerList) (err error) {
+	ht.lk.Lock()
+	defer ht.lk.Unlock()
+	err = ht.incIdx(m)
+	if err != nil {
+		return
+	}
+	l := ht.lists[list.Type]
+	if l == nil {
+		l = make(map[peer.ID]string)
+		ht.lists[list.Type] = l
+	}
+	for _, r := range list.Records {
+		l[r.ID] = r.Warrant
+	}
+	return
+}
+
+// PutReceipt stores a receipt returned by a node for a change to a hash
+func (ht *MemHT) PutReceipt(key Hash, receipt Receipt) (err error) {
+	var f Hash
+	f, err = receipt.Msg.Fingerprint()
+	if err != nil {
+		return
+	}
+	var b []byte
+	b, err = ByteEncoder(&receipt)
+	if err != nil {
+		return
+	}
+	ht.lk.Lock()
+	defer ht.lk.Unlock()
+	k := key.String()
+	r := ht.receipts[k]
+	if r == nil {
+		r = make(map[string][]byte)
+		ht.receipts[k] = r
+	}
+	r[peer.IDB58Encode(receipt.Peer)+":"+f.String()] = b
+	return
+}
+
+// GetReceipts returns a list of receipts that were generated regarding a hash
+func (ht *MemHT) GetReceipts(key Hash) (receipts []Receipt, err error) {
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	receipts = make([]Receipt, 0)
+	r := ht.receipts[key.String()]
+	keys := make([]string, 0, len(r))
+	for k := range r {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var receipt Receipt
+		err = ByteDecoder(r[k], &receipt)
+		if err != nil {
+			return
+		}
+		receipts = append(receipts, receipt)
+	}
+	return
+}
+
+// releaseUsage removes the bytes of any entry already stored under key from
+// its source's usage, assumes the write lock is held
+func (ht *MemHT) releaseUsage(key string) {
+	e := ht.entries[key]
+	if e == nil {
+		return
+	}
+	src, err := peer.IDB58Decode(e.source)
+	if err == nil {
+		ht.usage[src] -= len(e.value)
+	}
+}
+
+// GetUsage returns the number of bytes of entry data held on behalf of a source
+func (ht *MemHT) GetUsage(src peer.ID) (bytes int, err error) {
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	bytes = ht.usage[src]
+	return
+}
+
+// Usage returns the number of bytes of entry data held on behalf of each source
+func (ht *MemHT) Usage() (usage map[peer.ID]int, err error) {
+	ht.lk.RLock()
+	defer ht.lk.RUnlock()
+	usage = make(map[peer.ID]int)
+	for id, n := range ht.usage {
+		usage[id] = n
+	}
+	return
+}
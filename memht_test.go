@@ -0,0 +1,46 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"testing"
+)
+
+func TestDHTWithMemHT(t *testing.T) {
+	d, s := setupTestService()
+	defer CleanupTestDir(d)
+	h := setupTestChain("test", 0, s)
+	h.Config.HashTableType = MemHTType
+	prepareTestChain(h)
+	defer h.Close()
+
+	Convey("the DHT should use the HashTable type from the config", t, func() {
+		_, ok := h.dht.ht.(*MemHT)
+		So(ok, ShouldBeTrue)
+	})
+
+	Convey("it should not write any DHT data to disk", t, func() {
+		So(FileExists(h.DBPath(), DHTStoreFileName), ShouldBeFalse)
+		So(FileExists(h.DBPath(), DHTBoltStoreFileName), ShouldBeFalse)
+	})
+
+	Convey("genesis entries should be retrievable from the memory DHT", t, func() {
+		_, et, _, status, err := h.dht.Get(h.agentHash, StatusLive, GetMaskAll)
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, StatusLive)
+		So(et, ShouldEqual, AgentEntryType)
+	})
+}
+
+func TestMemHTConfigFromEnv(t *testing.T) {
+	d, s := setupTestService()
+	defer CleanupTestDir(d)
+
+	Convey("the HashTable type should be settable from the environment", t, func() {
+		os.Setenv("HOLOCHAINCONFIG_HASHTABLETYPE", MemHTType)
+		defer os.Unsetenv("HOLOCHAINCONFIG_HASHTABLETYPE")
+		config, err := _makeConfig(s)
+		So(err, ShouldBeNil)
+		So(config.HashTableType, ShouldEqual, MemHTType)
+	})
+}
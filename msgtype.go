@@ -0,0 +1,126 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements the registry of the message types nodes send to each other
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+
+	protocol "github.com/libp2p/go-libp2p-protocol"
+)
+
+var ErrUnknownMsgType = errors.New("unknown message type")
+var ErrMsgTypeNotSupported = errors.New("message type not supported by the protocol version")
+var ErrMsgTypeRegistered = errors.New("message type already registered")
+
+// MsgTypeDef describes a message type.  Type is the message type's id on the
+// wire, and Version is the protocol version that introduced it, so it can't
+// be sent to peers that only speak older versions.
+type MsgTypeDef struct {
+	Type    MsgType
+	Name    string
+	Version string
+}
+
+var msgTypes = make(map[MsgType]*MsgTypeDef)
+
+// RegisterMsgType adds a message type to the registry
+func RegisterMsgType(def MsgTypeDef) (err error) {
+	if _, ok := msgTypes[def.Type]; ok {
+		err = ErrMsgTypeRegistered
+		return
+	}
+	if protocolVersionIdx(def.Version) < 0 {
+		err = fmt.Errorf("unknown protocol version %s for message type %s", def.Version, def.Name)
+		return
+	}
+	msgTypes[def.Type] = &def
+	return
+}
+
+// GetMsgTypeDef returns the definition of a registered message type
+func GetMsgTypeDef(msgType MsgType) (def *MsgTypeDef, ok bool) {
+	def, ok = msgTypes[msgType]
+	return
+}
+
+func (msgType MsgType) String() string {
+	def, ok := msgTypes[msgType]
+	if !ok {
+		return fmt.Sprintf("UNKNOWN_MSG_TYPE(%d)", int(msgType))
+	}
+	return def.Name
+}
+
+// protocolVersionIdx returns the index of a version in SupportedProtocolVersions or -1
+func protocolVersionIdx(version string) int {
+	for i, v := range SupportedProtocolVersions {
+		if v == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// SupportedIn returns true if the message type can be sent with the given protocol version
+func (def *MsgTypeDef) SupportedIn(version string) bool {
+	i := protocolVersionIdx(version)
+	return i >= 0 && i <= protocolVersionIdx(def.Version)
+}
+
+// speaks returns true if the sender of a message speaks the given protocol version
+// or a newer one.  Messages delivered locally speak the current version.
+func (m *Message) speaks(version string) bool {
+	v := m.version
+	if v == "" {
+		v = ProtocolVersion
+	}
+	i := protocolVersionIdx(v)
+	return i >= 0 && i <= protocolVersionIdx(version)
+}
+
+// checkMsgType checks that a message type is registered and that it exists
+// in the version of the protocol that a stream negotiated
+func (node *Node) checkMsgType(proto int, id protocol.ID, msgType MsgType) (err error) {
+	def, ok := GetMsgTypeDef(msgType)
+	if !ok {
+		err = ErrUnknownMsgType
+		return
+	}
+	version, ok := node.protocols[proto].Version(id)
+	if !ok || !def.SupportedIn(version) {
+		err = ErrMsgTypeNotSupported
+	}
+	return
+}
+
+func init() {
+	defs := []MsgTypeDef{
+		{ERROR_RESPONSE, "ERROR_RESPONSE", "0.0.0"},
+		{OK_RESPONSE, "OK_RESPONSE", "0.0.0"},
+		{PUT_REQUEST, "PUT_REQUEST", "0.0.0"},
+		{DEL_REQUEST, "DEL_REQUEST", "0.0.0"},
+		{MOD_REQUEST, "MOD_REQUEST", "0.0.0"},
+		{GET_REQUEST, "GET_REQUEST", "0.0.0"},
+		{LINK_REQUEST, "LINK_REQUEST", "0.0.0"},
+		{GETLINK_REQUEST, "GETLINK_REQUEST", "0.0.0"},
+		{DELETELINK_REQUEST, "DELETELINK_REQUEST", "0.0.0"},
+		{GOSSIP_REQUEST, "GOSSIP_REQUEST", "0.0.0"},
+		{VALIDATE_PUT_REQUEST, "VALIDATE_PUT_REQUEST", "0.0.0"},
+		{VALIDATE_LINK_REQUEST, "VALIDATE_LINK_REQUEST", "0.0.0"},
+		{VALIDATE_DEL_REQUEST, "VALIDATE_DEL_REQUEST", "0.0.0"},
+		{VALIDATE_MOD_REQUEST, "VALIDATE_MOD_REQUEST", "0.0.0"},
+		{APP_MESSAGE, "APP_MESSAGE", "0.0.0"},
+		{LISTADD_REQUEST, "LISTADD_REQUEST", "0.0.0"},
+		{FIND_NODE_REQUEST, "FIND_NODE_REQUEST", "0.0.0"},
+	}
+	for _, def := range defs {
+		if err := RegisterMsgType(def); err != nil {
+			panic(err)
+		}
+	}
+}
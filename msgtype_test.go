@@ -0,0 +1,132 @@
+package holochain
+
+import (
+	"context"
+	"testing"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMsgTypeRegistry(t *testing.T) {
+	Convey("message types should be registered with their names", t, func() {
+		So(PUT_REQUEST.String(), ShouldEqual, "PUT_REQUEST")
+		So(FIND_NODE_REQUEST.String(), ShouldEqual, "FIND_NODE_REQUEST")
+		def, ok := GetMsgTypeDef(GOSSIP_REQUEST)
+		So(ok, ShouldBeTrue)
+		So(def.Version, ShouldEqual, "0.0.0")
+	})
+
+	Convey("unknown message types should still have a name", t, func() {
+		_, ok := GetMsgTypeDef(MsgType(101))
+		So(ok, ShouldBeFalse)
+		So(MsgType(101).String(), ShouldEqual, "UNKNOWN_MSG_TYPE(101)")
+	})
+
+	Convey("it should not register a message type twice or for an unknown protocol version", t, func() {
+		So(RegisterMsgType(MsgTypeDef{PUT_REQUEST, "MY_REQUEST", ProtocolVersion}), ShouldEqual, ErrMsgTypeRegistered)
+		So(PUT_REQUEST.String(), ShouldEqual, "PUT_REQUEST")
+		err := RegisterMsgType(MsgTypeDef{MsgType(101), "MY_REQUEST", "9.9.9"})
+		So(err.Error(), ShouldEqual, "unknown protocol version 9.9.9 for message type MY_REQUEST")
+	})
+
+	Convey("message types should only be supported by protocol versions since the one that introduced them", t, func() {
+		def := &MsgTypeDef{MsgType(101), "MY_REQUEST", ProtocolVersion}
+		So(def.SupportedIn(ProtocolVersion), ShouldBeTrue)
+		So(def.SupportedIn("0.0.0"), ShouldBeFalse)
+		def, _ = GetMsgTypeDef(PUT_REQUEST)
+		So(def.SupportedIn(ProtocolVersion), ShouldBeTrue)
+		So(def.SupportedIn("0.0.0"), ShouldBeTrue)
+		So(def.SupportedIn("9.9.9"), ShouldBeFalse)
+	})
+
+	Convey("protocols should have IDs for all the supported versions", t, func() {
+		p := NewProtocol("action", "fakednahash", ActionReceiver)
+		So(p.IDs[len(p.IDs)-1], ShouldEqual, protocol.ID("/hc-action-fakednahash/0.0.0"))
+		v, ok := p.Version("/hc-action-fakednahash/0.0.0")
+		So(ok, ShouldBeTrue)
+		So(v, ShouldEqual, "0.0.0")
+		_, ok = p.Version("/hc-gossip-fakednahash/0.0.0")
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestMsgTypeNegotiation(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	node1, err := makeNode(1234, "node1")
+	if err != nil {
+		panic(err)
+	}
+	defer node1.Close()
+	node2, err := makeNode(1235, "node2")
+	if err != nil {
+		panic(err)
+	}
+	defer node2.Close()
+	node3, err := makeNode(1236, "node3")
+	if err != nil {
+		panic(err)
+	}
+	defer node3.Close()
+
+	// node2 is an older node that only speaks the first version of the protocol
+	old := protocol.ID("/hc-kademlia-fakednahash/0.0.0")
+	node2.protocols[KademliaProtocol] = &Protocol{ID: old, Receiver: KademliaReceiver, IDs: []protocol.ID{old}}
+
+	for _, n := range []*Node{node1, node2, node3} {
+		n.StartProtocol(h, KademliaProtocol)
+	}
+	node1.host.Peerstore().AddAddr(node2.HashAddr, node2.NetAddr, pstore.PermanentAddrTTL)
+	node1.host.Peerstore().AddAddr(node3.HashAddr, node3.NetAddr, pstore.PermanentAddrTTL)
+	node2.host.Peerstore().AddAddr(node1.HashAddr, node1.NetAddr, pstore.PermanentAddrTTL)
+
+	newType := MsgType(100)
+	err = RegisterMsgType(MsgTypeDef{newType, "NEW_REQUEST", ProtocolVersion})
+	if err != nil {
+		panic(err)
+	}
+	defer delete(msgTypes, newType)
+
+	Convey("nodes should negotiate the newest version they both speak", t, func() {
+		m := node1.NewMessage(newType, "fish")
+		r, err := node1.Send(context.Background(), KademliaProtocol, node3.HashAddr, m)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, ERROR_RESPONSE)
+		So(r.Body.(ErrorResponse).Message, ShouldEqual, "message type 100 not in holochain-kademlia protocol")
+
+		m = node2.NewMessage(FIND_NODE_REQUEST, "fish")
+		r, err = node2.Send(context.Background(), KademliaProtocol, node1.HashAddr, m)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, ERROR_RESPONSE)
+		So(r.Body.(ErrorResponse).Message, ShouldEqual, ErrDHTUnexpectedTypeInBody.Error())
+	})
+
+	Convey("it should not send message types that the negotiated version doesn't have", t, func() {
+		m := node1.NewMessage(newType, "fish")
+		_, err := node1.Send(context.Background(), KademliaProtocol, node2.HashAddr, m)
+		So(err, ShouldEqual, ErrMsgTypeNotSupported)
+
+		m = node1.NewMessage(MsgType(101), "fish")
+		_, err = node1.Send(context.Background(), KademliaProtocol, node3.HashAddr, m)
+		So(err, ShouldEqual, ErrUnknownMsgType)
+	})
+
+	Convey("it should respond with an error response to unknown message types", t, func() {
+		s, err := node1.host.NewStream(context.Background(), node3.HashAddr, node3.protocols[KademliaProtocol].ID)
+		So(err, ShouldBeNil)
+		defer s.Close()
+		data, err := node1.NewMessage(MsgType(101), "fish").Encode()
+		So(err, ShouldBeNil)
+		_, err = s.Write(data)
+		So(err, ShouldBeNil)
+
+		var r Message
+		So(r.Decode(s), ShouldBeNil)
+		So(r.Type, ShouldEqual, ERROR_RESPONSE)
+		So(r.Body.(ErrorResponse).Code, ShouldEqual, ErrUnknownMsgTypeCode)
+		So(r.Body.(ErrorResponse).DecodeResponseError(), ShouldEqual, ErrUnknownMsgType)
+	})
+}
@@ -9,18 +9,22 @@ package holochain
 import (
 	"context"
 	//	host "github.com/libp2p/go-libp2p-host"
+	"crypto/sha256"
 	"encoding/gob"
 	"errors"
 	"fmt"
 
 	. "github.com/holochain/holochain-proto/hash"
+	queue "github.com/holochain/holochain-proto/peerqueue"
 	goprocess "github.com/jbenet/goprocess"
 	goprocessctx "github.com/jbenet/goprocess/context"
 	ic "github.com/libp2p/go-libp2p-crypto"
+	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
 	nat "github.com/libp2p/go-libp2p-nat"
 	net "github.com/libp2p/go-libp2p-net"
 	peer "github.com/libp2p/go-libp2p-peer"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
+	pnet "github.com/libp2p/go-libp2p-pnet"
 	protocol "github.com/libp2p/go-libp2p-protocol"
 	swarm "github.com/libp2p/go-libp2p-swarm"
 	discovery "github.com/libp2p/go-libp2p/p2p/discovery"
@@ -43,69 +47,50 @@ type ReceiverFn func(h *Holochain, m *Message) (response interface{}, err error)
 
 type MsgType int8
 
-// @TODO don't have order dependant constants
-// https://github.com/holochain/holochain-proto/issues/713
+// message types have stable ids on the wire, so new ones must be added with
+// new ids and registered in msgtype.go, never inserted between existing ones
 const (
 	// common messages
 
-	ERROR_RESPONSE MsgType = iota
-	OK_RESPONSE
+	ERROR_RESPONSE MsgType = 0
+	OK_RESPONSE    MsgType = 1
 
 	// DHT messages
 
-	PUT_REQUEST
-	DEL_REQUEST
-	MOD_REQUEST
-	GET_REQUEST
-	LINK_REQUEST
-	GETLINK_REQUEST
-	DELETELINK_REQUEST
+	PUT_REQUEST        MsgType = 2
+	DEL_REQUEST        MsgType = 3
+	MOD_REQUEST        MsgType = 4
+	GET_REQUEST        MsgType = 5
+	LINK_REQUEST       MsgType = 6
+	GETLINK_REQUEST    MsgType = 7
+	DELETELINK_REQUEST MsgType = 8
 
 	// Gossip messages
 
-	GOSSIP_REQUEST
+	GOSSIP_REQUEST MsgType = 9
 
 	// Validate Messages
 
-	VALIDATE_PUT_REQUEST
-	VALIDATE_LINK_REQUEST
-	VALIDATE_DEL_REQUEST
-	VALIDATE_MOD_REQUEST
+	VALIDATE_PUT_REQUEST  MsgType = 10
+	VALIDATE_LINK_REQUEST MsgType = 11
+	VALIDATE_DEL_REQUEST  MsgType = 12
+	VALIDATE_MOD_REQUEST  MsgType = 13
 
 	// Application Messages
 
-	APP_MESSAGE
+	APP_MESSAGE MsgType = 14
 
 	// Peer messages
 
-	LISTADD_REQUEST
+	LISTADD_REQUEST MsgType = 15
 
 	// Kademlia messages
 
-	FIND_NODE_REQUEST
+	FIND_NODE_REQUEST MsgType = 16
 )
 
-func (msgType MsgType) String() string {
-	return []string{"ERROR_RESPONSE",
-		"OK_RESPONSE",
-		"PUT_REQUEST",
-		"DEL_REQUEST",
-		"MOD_REQUEST",
-		"GET_REQUEST",
-		"LINK_REQUEST",
-		"GETLINK_REQUEST",
-		"DELETELINK_REQUEST",
-		"GOSSIP_REQUEST",
-		"VALIDATE_PUT_REQUEST",
-		"VALIDATE_LINK_REQUEST",
-		"VALIDATE_DEL_REQUEST",
-		"VALIDATE_MOD_REQUEST",
-		"APP_MESSAGE",
-		"LISTADD_REQUEST",
-		"FIND_NODE_REQUEST"}[msgType]
-}
-
 var ErrBlockedListed = errors.New("node blockedlisted")
+var ErrMissingNetworkKey = errors.New("a private network requires HC_NETWORK_KEY to be set")
 
 // Message represents data that can be sent to node in the network
 type Message struct {
@@ -113,6 +98,10 @@ type Message struct {
 	Time time.Time
 	From peer.ID
 	Body interface{}
+
+	// the protocol version negotiated on the stream the message arrived on,
+	// empty for messages delivered locally
+	version string
 }
 
 type BytesSent struct {
@@ -128,6 +117,7 @@ const (
 	BootstrappingStopper
 	RefreshingStopper
 	HoldingStopper
+	BundleTimeoutStopper
 	_StopperCount
 )
 
@@ -138,9 +128,13 @@ type Node struct {
 	host         *rhost.RoutedHost
 	mdnsSvc      discovery.Service
 	blockedlist  map[peer.ID]bool
+	blockedLk    sync.RWMutex
+	limiter      *rateLimiter
+	reputation   queue.ReputationFn // orders the peers queried by their reputation
 	protocols    [_protocolCount]*Protocol
 	peerstore    pstore.Peerstore
 	routingTable *RoutingTable
+	metrics      pstore.Metrics // round trip times of the messages sent to peers
 	nat          *nat.NAT
 	log          *Logger
 
@@ -158,6 +152,7 @@ type Node struct {
 type Protocol struct {
 	ID       protocol.ID
 	Receiver ReceiverFn
+	IDs      []protocol.ID // the IDs of all the supported versions and codecs, most preferred first
 }
 
 const (
@@ -168,11 +163,96 @@ const (
 	_protocolCount
 )
 
+// ProtocolVersion is the current version of the holochain protocols.  Nodes
+// also speak the older versions in SupportedProtocolVersions (newest first),
+// and the version and codec used with a peer are negotiated when a stream is
+// opened.  Protocol IDs are /hc-<protocol>-<protoMux>/<version>[/<codec>]
+// with gob as the codec when there is none.
+const ProtocolVersion = "0.1.0"
+
+// CodecNegotiationVersion is the protocol version that introduced codecs other than gob
+const CodecNegotiationVersion = "0.1.0"
+
+var SupportedProtocolVersions = []string{ProtocolVersion, "0.0.0"}
+
+var protocolNames = [_protocolCount]string{"action", "validate", "gossip", "kademlia"}
+
+// protocolIdx returns the index of the protocol with the given name or -1
+func protocolIdx(name string) int {
+	for i, n := range protocolNames {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// NewProtocol creates a protocol with IDs for all the supported versions and codecs
+func NewProtocol(name string, protoMux string, receiver ReceiverFn) (p *Protocol) {
+	p = &Protocol{Receiver: receiver}
+	negotiates := protocolVersionIdx(CodecNegotiationVersion)
+	for i, v := range SupportedProtocolVersions {
+		id := "/hc-" + name + "-" + protoMux + "/" + v
+		for _, c := range WireCodecs {
+			if c == GobCodecName {
+				p.IDs = append(p.IDs, protocol.ID(id))
+			} else if i <= negotiates {
+				p.IDs = append(p.IDs, protocol.ID(id+"/"+c))
+			}
+		}
+	}
+	p.ID = p.IDs[0]
+	return
+}
+
+// parseID returns the version and codec of the protocol that an ID is for
+func (p *Protocol) parseID(id protocol.ID) (version string, codec string, ok bool) {
+	for _, pid := range p.IDs {
+		if pid == id {
+			parts := strings.Split(string(id), "/")
+			if len(parts) < 3 {
+				return
+			}
+			version = parts[2]
+			codec = GobCodecName
+			if len(parts) > 3 {
+				codec = parts[3]
+			}
+			ok = true
+			return
+		}
+	}
+	return
+}
+
+// Version returns the version of the protocol that an ID is for
+func (p *Protocol) Version(id protocol.ID) (version string, ok bool) {
+	version, _, ok = p.parseID(id)
+	return
+}
+
+// Codec returns the codec of the protocol that an ID is for
+func (p *Protocol) Codec(id protocol.ID) (codec Codec, err error) {
+	_, name, ok := p.parseID(id)
+	if !ok {
+		err = ErrUnknownCodec
+		return
+	}
+	codec, err = GetCodec(name)
+	return
+}
+
 const (
 	PeerTTL                       = time.Minute * 10
 	DefaultRoutingRefreshInterval = time.Minute
 	DefaultGossipInterval         = time.Second * 2
 	DefaultHoldingCheckInterval   = time.Second * 30
+	DefaultPruneGracePeriod       = time.Hour
+
+	// RoundTripFailurePenalty is the round trip time recorded for a message to
+	// a peer that fails, so that a peer that stops responding loses the place
+	// its earlier round trips earned it when peers are ordered by latency
+	RoundTripFailurePenalty = DefaultSendTimeout
 )
 
 // implement peer found function for mdns discovery
@@ -380,8 +460,23 @@ func (node *Node) discoverAndHandleNat(listenPort int) {
 	}
 }
 
-// NewNode creates a new node with given multiAddress listener string and identity
-func NewNode(listenAddr string, protoMux string, agent *LibP2PAgent, enableNATUPnP bool, log *Logger) (node *Node, err error) {
+// networkPSKLabel separates the private network key from any other use of the group's secret
+const networkPSKLabel = "holochain private network"
+
+// NetworkPSK derives the pre-shared key of a private network from the secret
+// shared by its members, so that each DNA gets its own network from one secret
+func NetworkPSK(key string, dnaHash Hash) []byte {
+	h := sha256.New()
+	h.Write([]byte(networkPSKLabel))
+	h.Write([]byte(key))
+	h.Write([]byte(dnaHash))
+	return h.Sum(nil)
+}
+
+// NewNode creates a new node with given multiAddress listener string and identity.
+// If networkPSK is set the node only completes connections with nodes that have
+// the same key, see NetworkPSK.
+func NewNode(listenAddr string, protoMux string, agent *LibP2PAgent, enableNATUPnP bool, networkPSK []byte, log *Logger) (node *Node, err error) {
 	var n Node
 	n.log = log
 	n.log.Logf("Creating new node with protoMux: %s\n", protoMux)
@@ -415,20 +510,11 @@ func NewNode(listenAddr string, protoMux string, agent *LibP2PAgent, enableNATUP
 	ps.AddPrivKey(nodeID, priv)
 	ps.AddPubKey(nodeID, priv.GetPublic())
 
-	validateProtocolString := "/hc-validate-" + protoMux + "/0.0.0"
-	gossipProtocolString := "/hc-gossip-" + protoMux + "/0.0.0"
-	actionProtocolString := "/hc-action-" + protoMux + "/0.0.0"
-	kademliaProtocolString := "/hc-kademlia-" + protoMux + "/0.0.0"
-
-	n.log.Logf("Validate protocol identifier: " + validateProtocolString)
-	n.log.Logf("Gossip protocol identifier: " + gossipProtocolString)
-	n.log.Logf("Action protocol identifier: " + actionProtocolString)
-	n.log.Logf("Kademlia protocol identifier: " + kademliaProtocolString)
-
-	n.protocols[ValidateProtocol] = &Protocol{protocol.ID(validateProtocolString), ValidateReceiver}
-	n.protocols[GossipProtocol] = &Protocol{protocol.ID(gossipProtocolString), GossipReceiver}
-	n.protocols[ActionProtocol] = &Protocol{protocol.ID(actionProtocolString), ActionReceiver}
-	n.protocols[KademliaProtocol] = &Protocol{protocol.ID(kademliaProtocolString), KademliaReceiver}
+	receivers := [_protocolCount]ReceiverFn{ActionReceiver, ValidateReceiver, GossipReceiver, KademliaReceiver}
+	for proto, name := range protocolNames {
+		n.protocols[proto] = NewProtocol(name, protoMux, receivers[proto])
+		n.log.Logf("%s protocol identifiers: %v", name, n.protocols[proto].IDs)
+	}
 
 	n.stoppers = make([]chan bool, _StopperCount)
 
@@ -436,7 +522,24 @@ func NewNode(listenAddr string, protoMux string, agent *LibP2PAgent, enableNATUP
 	n.ctx = ctx
 
 	// create a new swarm to be used by the service host
-	netw, err := swarm.NewNetwork(ctx, []ma.Multiaddr{n.NetAddr}, nodeID, ps, nil)
+	var netw *swarm.Network
+	if networkPSK != nil {
+		var psk [32]byte
+		if len(networkPSK) != len(psk) {
+			err = fmt.Errorf("network key must be %d bytes", len(psk))
+			return
+		}
+		copy(psk[:], networkPSK)
+		var protector ipnet.Protector
+		protector, err = pnet.NewV1ProtectorFromBytes(&psk)
+		if err != nil {
+			return
+		}
+		n.log.Logf("Joining private network with fingerprint: %x\n", protector.Fingerprint())
+		netw, err = swarm.NewNetworkWithProtector(ctx, []ma.Multiaddr{n.NetAddr}, nodeID, ps, protector, nil)
+	} else {
+		netw, err = swarm.NewNetwork(ctx, []ma.Multiaddr{n.NetAddr}, nodeID, ps, nil)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -449,8 +552,8 @@ func NewNode(listenAddr string, protoMux string, agent *LibP2PAgent, enableNATUP
 
 	n.host = rhost.Wrap(bh, &n)
 
-	m := pstore.NewMetrics()
-	n.routingTable = NewRoutingTable(KValue, nodeID, time.Minute, m)
+	n.metrics = pstore.NewMetrics()
+	n.routingTable = NewRoutingTable(KValue, nodeID, time.Minute, n.metrics)
 	n.peers = make(map[peer.ID]*peerTracker)
 
 	node = &n
@@ -466,8 +569,7 @@ func NewNode(listenAddr string, protoMux string, agent *LibP2PAgent, enableNATUP
 	return
 }
 
-// Encode codes a message to gob format
-// @TODO generalize for other message encoding formats
+// Encode codes a message to gob format, see Codec for the other formats
 func (m *Message) Encode() (data []byte, err error) {
 	data, err = ByteEncoder(m)
 	if err != nil {
@@ -476,8 +578,7 @@ func (m *Message) Encode() (data []byte, err error) {
 	return
 }
 
-// Decode converts a message from gob format
-// @TODO generalize for other message encoding formats
+// Decode converts a message from gob format, see Codec for the other formats
 func (m *Message) Decode(r io.Reader) (err error) {
 	dec := gob.NewDecoder(r)
 	err = dec.Decode(m)
@@ -510,7 +611,7 @@ func (m Message) String() string {
 }
 
 // respondWith writes a message either error or otherwise, to the stream
-func (node *Node) respondWith(s net.Stream, err error, body interface{}) {
+func (node *Node) respondWith(s net.Stream, codec Codec, err error, body interface{}) {
 	var m *Message
 	if err != nil {
 		errResp := NewErrorResponse(err)
@@ -520,7 +621,7 @@ func (node *Node) respondWith(s net.Stream, err error, body interface{}) {
 		m = node.NewMessage(OK_RESPONSE, body)
 	}
 
-	data, err := m.Encode()
+	data, err := codec.Encode(m)
 	if err != nil {
 		Infof("Response failed: unable to encode message: %v", m)
 	}
@@ -536,25 +637,53 @@ func (node *Node) respondWith(s net.Stream, err error, body interface{}) {
 }
 
 // StartProtocol initiates listening for a protocol on the node
+// Handlers are set for every supported version of the protocol so that the
+// libp2p identify service advertises them all to peers when they connect.
 func (node *Node) StartProtocol(h *Holochain, proto int) (err error) {
-	node.host.SetStreamHandler(node.protocols[proto].ID, func(s net.Stream) {
+	handler := func(s net.Stream) {
+		codec, err := node.protocols[proto].Codec(s.Protocol())
+		if err != nil {
+			node.log.Logf("closing stream for %s: %v", s.Protocol(), err)
+			s.Close()
+			return
+		}
+		remote := s.Conn().RemotePeer()
+		if node.limiter != nil && !node.IsBlocked(remote) {
+			retryAfter, block := node.limiter.allow(remote, proto, time.Now())
+			if retryAfter > 0 {
+				if block {
+					node.log.Logf("blocking %v for flooding the %s protocol", remote, protocolNames[proto])
+					node.Block(remote)
+				}
+				node.respondWith(s, codec, ErrRateLimited, RateLimitedResp{RetryAfter: retryAfter})
+				return
+			}
+		}
 		var m Message
-		err := m.Decode(s)
+		err = codec.Decode(s, &m)
+		m.version, _ = node.protocols[proto].Version(s.Protocol())
 		var response interface{}
 		if m.From == "" {
 			// @todo other sanity checks on From?
 			err = errors.New("message must have a source")
 		} else {
-			if node.IsBlocked(s.Conn().RemotePeer()) {
+			if node.IsBlocked(remote) {
 				err = ErrBlockedListed
 			}
 
+			if err == nil {
+				err = node.checkMsgType(proto, s.Protocol(), m.Type)
+			}
+
 			if err == nil {
 				response, err = node.protocols[proto].Receiver(h, &m)
 			}
 		}
-		node.respondWith(s, err, response)
-	})
+		node.respondWith(s, codec, err, response)
+	}
+	for _, id := range node.protocols[proto].IDs {
+		node.host.SetStreamHandler(id, handler)
+	}
 	return
 }
 
@@ -578,20 +707,36 @@ func (node *Node) Send(ctx context.Context, proto int, addr peer.ID, m *Message)
 		return
 	}
 
-	s, err := node.host.NewStream(ctx, addr, node.protocols[proto].ID)
+	// offer all the versions we speak and let the peer pick the newest it knows
+	s, err := node.host.NewStream(ctx, addr, node.protocols[proto].IDs...)
 	if err != nil {
+		node.recordFailedRoundTrip(addr)
 		return
 	}
 	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	err = node.checkMsgType(proto, s.Protocol(), m.Type)
+	if err != nil {
+		return
+	}
+	codec, err := node.protocols[proto].Codec(s.Protocol())
+	if err != nil {
+		return
+	}
 
 	// encode the message and send it
-	data, err := m.Encode()
+	data, err := codec.Encode(m)
 	if err != nil {
 		return
 	}
+	start := time.Now()
 
 	n, err := s.Write(data)
 	if err != nil {
+		node.recordFailedRoundTrip(addr)
 		return
 	}
 	if n != len(data) {
@@ -603,14 +748,28 @@ func (node *Node) Send(ctx context.Context, proto int, addr peer.ID, m *Message)
 	}
 
 	// decode the response
-	err = response.Decode(s)
+	err = codec.Decode(s, &response)
 	if err != nil {
 		node.log.Logf("failed to decode with err:%v ", err)
+		node.recordFailedRoundTrip(addr)
 		return
 	}
+	node.metrics.RecordLatency(addr, time.Since(start))
 	return
 }
 
+// recordFailedRoundTrip records RoundTripFailurePenalty as the round trip time
+// of a message to a peer that failed or timed out
+func (node *Node) recordFailedRoundTrip(addr peer.ID) {
+	node.metrics.RecordLatency(addr, RoundTripFailurePenalty)
+}
+
+// Latency returns the moving average of the round trip times of the messages
+// sent to a peer, or 0 if none have been sent
+func (node *Node) Latency(addr peer.ID) time.Duration {
+	return node.metrics.LatencyEWMA(addr)
+}
+
 // NewMessage creates a message from the node with a new current timestamp
 func (node *Node) NewMessage(t MsgType, body interface{}) (msg *Message) {
 	m := Message{Type: t, Time: time.Now().Round(0), Body: body, From: node.HashAddr}
@@ -620,20 +779,27 @@ func (node *Node) NewMessage(t MsgType, body interface{}) (msg *Message) {
 
 // IsBlockedListed checks to see if a node is on the blockedlist
 func (node *Node) IsBlocked(addr peer.ID) (ok bool) {
+	node.blockedLk.RLock()
+	defer node.blockedLk.RUnlock()
 	ok = node.blockedlist[addr]
 	return
 }
 
 // InitBlockedList sets up the blockedlist from a PeerList
 func (node *Node) InitBlockedList(list PeerList) {
-	node.blockedlist = make(map[peer.ID]bool)
+	blockedlist := make(map[peer.ID]bool)
 	for _, r := range list.Records {
-		node.Block(r.ID)
+		blockedlist[r.ID] = true
 	}
+	node.blockedLk.Lock()
+	node.blockedlist = blockedlist
+	node.blockedLk.Unlock()
 }
 
 // Block adds a peer to the blocklist
 func (node *Node) Block(addr peer.ID) {
+	node.blockedLk.Lock()
+	defer node.blockedLk.Unlock()
 	if node.blockedlist == nil {
 		node.blockedlist = make(map[peer.ID]bool)
 	}
@@ -642,9 +808,26 @@ func (node *Node) Block(addr peer.ID) {
 
 // Unblock removes a peer from the blocklist
 func (node *Node) Unblock(addr peer.ID) {
+	node.blockedLk.Lock()
 	if node.blockedlist != nil {
 		delete(node.blockedlist, addr)
 	}
+	node.blockedLk.Unlock()
+	if node.limiter != nil {
+		node.limiter.forget(addr)
+	}
+}
+
+// SetRateLimits limits how fast each peer can send messages on each protocol,
+// by protocol name, blocking peers that get blockAfter strikes for sending
+// over their limits
+func (node *Node) SetRateLimits(limits map[string]RateLimit, blockAfter int) (err error) {
+	var rl *rateLimiter
+	rl, err = newRateLimiter(limits, blockAfter)
+	if err == nil {
+		node.limiter = rl
+	}
+	return
 }
 
 type ErrorResponse struct {
@@ -662,6 +845,11 @@ const (
 	ErrLinkNotFoundCode
 	ErrEntryTypeMismatchCode
 	ErrBlockedListedCode
+	ErrEntryTooLargeCode
+	ErrQuotaExceededCode
+	ErrUnknownMsgTypeCode
+	ErrMsgTypeNotSupportedCode
+	ErrRateLimitedCode
 )
 
 // NewErrorResponse encodes standard errors for transmitting
@@ -681,6 +869,16 @@ func NewErrorResponse(err error) (errResp ErrorResponse) {
 		errResp.Code = ErrEntryTypeMismatchCode
 	case ErrBlockedListed:
 		errResp.Code = ErrBlockedListedCode
+	case ErrEntryTooLarge:
+		errResp.Code = ErrEntryTooLargeCode
+	case ErrQuotaExceeded:
+		errResp.Code = ErrQuotaExceededCode
+	case ErrUnknownMsgType:
+		errResp.Code = ErrUnknownMsgTypeCode
+	case ErrMsgTypeNotSupported:
+		errResp.Code = ErrMsgTypeNotSupportedCode
+	case ErrRateLimited:
+		errResp.Code = ErrRateLimitedCode
 	default:
 		errResp.Message = err.Error() //Code will be set to ErrUnknown by default cus it's 0
 	}
@@ -704,6 +902,16 @@ func (errResp ErrorResponse) DecodeResponseError() (err error) {
 		err = ErrEntryTypeMismatch
 	case ErrBlockedListedCode:
 		err = ErrBlockedListed
+	case ErrEntryTooLargeCode:
+		err = ErrEntryTooLarge
+	case ErrQuotaExceededCode:
+		err = ErrQuotaExceeded
+	case ErrUnknownMsgTypeCode:
+		err = ErrUnknownMsgType
+	case ErrMsgTypeNotSupportedCode:
+		err = ErrMsgTypeNotSupported
+	case ErrRateLimitedCode:
+		err = ErrRateLimited
 	default:
 		err = errors.New(errResp.Message)
 	}
@@ -178,7 +178,10 @@ func TestNodeSend(t *testing.T) {
 	defer node2.Close()
 	h2.node = node2
 	os.Remove(filepath.Join(h2.DBPath(), DHTStoreFileName))
-	h2.dht = NewDHT(h2)
+	h2.dht, err = NewDHT(h2)
+	if err != nil {
+		panic(err)
+	}
 
 	h.Activate()
 
@@ -248,6 +251,20 @@ func TestNodeSend(t *testing.T) {
 		So(fmt.Sprintf("%T", r.Body), ShouldEqual, "holochain.Gossip")
 	})
 
+	Convey("it should record a penalty round trip for messages that time out", t, func() {
+		latency := node2.Latency(node1.HashAddr)
+		So(latency, ShouldBeGreaterThan, 0)
+		So(latency, ShouldBeLessThan, RoundTripFailurePenalty/10)
+
+		slowResponses(node1, 500*time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		m := node2.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		_, err := node2.Send(ctx, GossipProtocol, node1.HashAddr, m)
+		So(err, ShouldNotBeNil)
+		So(node2.Latency(node1.HashAddr), ShouldBeGreaterThan, latency+RoundTripFailurePenalty/20)
+	})
+
 	Convey("it should respond with err on messages from nodes on the blockedlist", t, func() {
 		node1.Block(node2.HashAddr)
 		m := node2.NewMessage(GOSSIP_REQUEST, GossipReq{})
@@ -381,6 +398,12 @@ func TestErrorCoding(t *testing.T) {
 		So(er.DecodeResponseError(), ShouldEqual, ErrHashRejected)
 		er = NewErrorResponse(ErrLinkNotFound)
 		So(er.DecodeResponseError(), ShouldEqual, ErrLinkNotFound)
+		er = NewErrorResponse(ErrUnknownMsgType)
+		So(er.DecodeResponseError(), ShouldEqual, ErrUnknownMsgType)
+		er = NewErrorResponse(ErrMsgTypeNotSupported)
+		So(er.DecodeResponseError(), ShouldEqual, ErrMsgTypeNotSupported)
+		er = NewErrorResponse(ErrRateLimited)
+		So(er.DecodeResponseError(), ShouldEqual, ErrRateLimited)
 
 		er = NewErrorResponse(errors.New("Some Error"))
 		So(er.Code, ShouldEqual, ErrUnknownCode)
@@ -608,3 +631,68 @@ func TestNodeRoutingTableBootstrap(t *testing.T) {
 		So(rt.IsEmpty(), ShouldBeFalse)
 	})
 }
+
+func TestPrivateNetwork(t *testing.T) {
+	dnaHash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	otherDNAHash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat6x5HEhc1TVGs11tmfNSzkqh2")
+	psk := NetworkPSK("our secret", dnaHash)
+
+	Convey("the network key should be derived from the secret and the DNA", t, func() {
+		So(len(psk), ShouldEqual, 32)
+		So(NetworkPSK("our secret", dnaHash), ShouldResemble, psk)
+		So(NetworkPSK("their secret", dnaHash), ShouldNotResemble, psk)
+		So(NetworkPSK("our secret", otherDNAHash), ShouldNotResemble, psk)
+	})
+
+	Convey("a private network should require HC_NETWORK_KEY", t, func() {
+		config := Config{PrivateNetwork: true}
+		So(config.Setup(), ShouldEqual, ErrMissingNetworkKey)
+		os.Setenv("HC_NETWORK_KEY", "our secret")
+		defer os.Unsetenv("HC_NETWORK_KEY")
+		So(config.Setup(), ShouldBeNil)
+		So(config.networkKey, ShouldEqual, "our secret")
+	})
+
+	Convey("it should refuse network keys of the wrong size", t, func() {
+		_, err := makePrivateNode(1237, "node1", psk[:16])
+		So(err.Error(), ShouldEqual, "network key must be 32 bytes")
+	})
+
+	node1, err := makePrivateNode(1234, "node1", psk)
+	if err != nil {
+		panic(err)
+	}
+	defer node1.Close()
+	node2, err := makePrivateNode(1235, "node2", psk)
+	if err != nil {
+		panic(err)
+	}
+	defer node2.Close()
+	outsider, err := makePrivateNode(1236, "node3", NetworkPSK("their secret", dnaHash))
+	if err != nil {
+		panic(err)
+	}
+	defer outsider.Close()
+	public, err := makeNode(1237, "node4")
+	if err != nil {
+		panic(err)
+	}
+	defer public.Close()
+
+	ctx := context.Background()
+	Convey("members of the private network should connect to each other", t, func() {
+		err := node1.host.Connect(ctx, pstore.PeerInfo{ID: node2.HashAddr, Addrs: []ma.Multiaddr{node2.NetAddr}})
+		So(err, ShouldBeNil)
+	})
+
+	Convey("nodes without the network key should not be able to connect", t, func() {
+		err := node1.host.Connect(ctx, pstore.PeerInfo{ID: outsider.HashAddr, Addrs: []ma.Multiaddr{outsider.NetAddr}})
+		So(err, ShouldNotBeNil)
+		err = outsider.host.Connect(ctx, pstore.PeerInfo{ID: node2.HashAddr, Addrs: []ma.Multiaddr{node2.NetAddr}})
+		So(err, ShouldNotBeNil)
+		err = public.host.Connect(ctx, pstore.PeerInfo{ID: node1.HashAddr, Addrs: []ma.Multiaddr{node1.NetAddr}})
+		So(err, ShouldNotBeNil)
+		err = node1.host.Connect(ctx, pstore.PeerInfo{ID: public.HashAddr, Addrs: []ma.Multiaddr{public.NetAddr}})
+		So(err, ShouldNotBeNil)
+	})
+}
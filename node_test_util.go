@@ -9,6 +9,7 @@ import (
 	"math/rand"
 	"os"
 	"testing"
+	"time"
 )
 
 // -------------------------------------------------------------------------------------------
@@ -29,7 +30,26 @@ func makeNode(port int, id string) (*Node, error) {
 	listenaddr := fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", port)
 	_, key := makePeer(id)
 	agent := LibP2PAgent{identity: AgentIdentity(id), priv: key, pub: key.GetPublic()}
-	return NewNode(listenaddr, "fakednahash", &agent, false, &debugLog)
+	return NewNode(listenaddr, "fakednahash", &agent, false, nil, &debugLog)
+}
+
+// slowResponses makes a node take at least delay to handle the messages it
+// receives on any protocol, to simulate a slow peer
+func slowResponses(n *Node, delay time.Duration) {
+	for _, p := range n.protocols {
+		receiver := p.Receiver
+		p.Receiver = func(h *Holochain, m *Message) (response interface{}, err error) {
+			time.Sleep(delay)
+			return receiver(h, m)
+		}
+	}
+}
+
+func makePrivateNode(port int, id string, psk []byte) (*Node, error) {
+	listenaddr := fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", port)
+	_, key := makePeer(id)
+	agent := LibP2PAgent{identity: AgentIdentity(id), priv: key, pub: key.GetPublic()}
+	return NewNode(listenaddr, "fakednahash", &agent, false, psk, &debugLog)
 }
 
 func addTestPeers(h *Holochain, peers []peer.ID, start int, count int) []peer.ID {
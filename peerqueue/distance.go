@@ -55,6 +55,12 @@ type distancePQ struct {
 	// heap is a heap of peerDistance items
 	heap peerMetricHeap
 
+	// reputation, if set, weights the distances by the peers' reputations
+	reputation ReputationFn
+
+	// latency, if set, orders the peers by latency before distance
+	latency LatencyFn
+
 	sync.RWMutex
 }
 
@@ -69,6 +75,12 @@ func (pq *distancePQ) Enqueue(p peer.ID) {
 	defer pq.Unlock()
 
 	distance := HashXORDistance(HashFromPeerID(p), pq.from)
+	if pq.reputation != nil {
+		distance = reputationMetric(distance, pq.reputation(p))
+	}
+	if pq.latency != nil {
+		distance = latencyMetric(distance, pq.latency(p))
+	}
 
 	heap.Push(&pq.heap, &peerMetric{
 		peer:   p,
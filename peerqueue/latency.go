@@ -0,0 +1,40 @@
+package peerqueue
+
+import (
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"math"
+	"math/big"
+	"time"
+)
+
+// LatencyFn returns the measured round trip time to a peer, or 0 if it
+// hasn't been measured
+type LatencyFn func(peer.ID) time.Duration
+
+// latencyShift moves the latency above any XOR distance in the metric, so
+// that distance only breaks ties between peers with the same latency
+const latencyShift = 1024
+
+// latencyMetric combines a latency with an XOR distance, ordering first by
+// latency with unmeasured peers after all the measured ones
+func latencyMetric(distance *big.Int, latency time.Duration) *big.Int {
+	if latency <= 0 {
+		latency = math.MaxInt64
+	}
+	metric := big.NewInt(int64(latency))
+	metric.Lsh(metric, latencyShift)
+	return metric.Add(metric, distance)
+}
+
+// NewLatencyPQ returns a PeerQueue which maintains its peers sorted by their
+// latency, fastest first, with peers whose latency hasn't been measured
+// coming last.  Peers with the same latency are sorted by their XOR distance
+// to from.
+func NewLatencyPQ(from Hash, latency LatencyFn) PeerQueue {
+	return &distancePQ{
+		from:    from,
+		heap:    peerMetricHeap{},
+		latency: latency,
+	}
+}
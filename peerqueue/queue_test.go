@@ -67,6 +67,64 @@ func TestQueue(t *testing.T) {
 	}
 }
 
+func TestReputationQueue(t *testing.T) {
+	h1, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1")
+	h2, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	h3, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+	h4, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh4")
+
+	p2 := PeerIDFromHash(h2)
+	p3 := PeerIDFromHash(h3)
+	p4 := PeerIDFromHash(h4)
+
+	// with no reputations the order is by distance
+	pq := NewReputationPQ(h1, nil)
+	pq.Enqueue(p4)
+	pq.Enqueue(p2)
+	pq.Enqueue(p3)
+	if pq.Dequeue() != p2 || pq.Dequeue() != p3 || pq.Dequeue() != p4 {
+		t.Error("ordering failed")
+	}
+
+	// a misbehaving close peer should fall behind, and a peer that behaves
+	// well should move ahead of a closer one
+	scores := map[peer.ID]int{p2: -1000, p4: 1}
+	pq = NewReputationPQ(h1, func(p peer.ID) int { return scores[p] })
+	pq.Enqueue(p2)
+	pq.Enqueue(p3)
+	pq.Enqueue(p4)
+	if pq.Len() != 3 {
+		t.Error("expected 3 peers")
+	}
+	if pq.Dequeue() != p4 || pq.Dequeue() != p3 || pq.Dequeue() != p2 {
+		t.Error("reputation ordering failed")
+	}
+}
+
+func TestLatencyQueue(t *testing.T) {
+	h1, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1")
+	h2, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	h3, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+	h4, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh4")
+	h5, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh5")
+
+	p2 := PeerIDFromHash(h2)
+	p3 := PeerIDFromHash(h3)
+	p4 := PeerIDFromHash(h4)
+	p5 := PeerIDFromHash(h5)
+
+	// p5 is fastest, p2 and p4 tie so go by distance, and p3 hasn't been measured
+	latencies := map[peer.ID]time.Duration{p2: 20 * time.Millisecond, p4: 20 * time.Millisecond, p5: time.Millisecond}
+	pq := NewLatencyPQ(h1, func(p peer.ID) time.Duration { return latencies[p] })
+	pq.Enqueue(p3)
+	pq.Enqueue(p4)
+	pq.Enqueue(p2)
+	pq.Enqueue(p5)
+	if pq.Dequeue() != p5 || pq.Dequeue() != p2 || pq.Dequeue() != p4 || pq.Dequeue() != p3 {
+		t.Error("latency ordering failed")
+	}
+}
+
 func newPeerTime(t time.Time) peer.ID {
 	s := fmt.Sprintf("hmmm time: %v", t)
 	h, _ := mh.Sum([]byte(s), mh.SHA2_256, -1)
@@ -0,0 +1,47 @@
+package peerqueue
+
+import (
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"math/big"
+)
+
+// ReputationFn returns a peer's reputation score, 0 for a peer nothing is
+// known about, above zero for a peer that has behaved well and below zero
+// for a peer that has misbehaved
+type ReputationFn func(peer.ID) int
+
+// maxReputationShift bounds how far a bad reputation pushes a peer away.  It's
+// more bits than a hash has, so any peer with a score this bad comes after
+// all the peers with a score of zero or better.
+const maxReputationShift = 512
+
+// reputationMetric weights an XOR distance by a reputation score, each point
+// of score halving the distance and each point below zero doubling it
+func reputationMetric(distance *big.Int, score int) *big.Int {
+	switch {
+	case score > 0:
+		if score > maxReputationShift {
+			score = maxReputationShift
+		}
+		return distance.Rsh(distance, uint(score))
+	case score < 0:
+		if score < -maxReputationShift {
+			score = -maxReputationShift
+		}
+		return distance.Lsh(distance, uint(-score))
+	}
+	return distance
+}
+
+// NewReputationPQ returns a PeerQueue which maintains its peers sorted by
+// their XOR distance to from weighted by their reputation, so that peers
+// with a bad reputation are dequeued after the close peers that behave.
+// A nil reputation function orders the peers by distance alone.
+func NewReputationPQ(from Hash, reputation ReputationFn) PeerQueue {
+	return &distancePQ{
+		from:       from,
+		heap:       peerMetricHeap{},
+		reputation: reputation,
+	}
+}
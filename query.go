@@ -0,0 +1,451 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements the constraint matching, sorting and aggregation for local chain queries
+
+package holochain
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	QueryCompareEQ = "="
+	QueryCompareNE = "!="
+	QueryCompareLT = "<"
+	QueryCompareLE = "<="
+	QueryCompareGT = ">"
+	QueryCompareGE = ">="
+
+	QueryAggregateCount = "count"
+	QueryAggregateSum   = "sum"
+	QueryAggregateMin   = "min"
+	QueryAggregateMax   = "max"
+)
+
+// QueryCompare constrains a query to entries whose JSON field compares
+// numerically to Value with Op
+type QueryCompare struct {
+	Field string
+	Op    string
+	Value float64
+}
+
+// QueryAggregate asks a query for a single value computed from its results
+// instead of the results themselves.  Count counts the results, or those
+// that have Field if it's set, and Sum, Min and Max use the numeric values of Field.
+type QueryAggregate struct {
+	Fn    string
+	Field string
+}
+
+// queryContent loads and parses an entry's content only when a constraint needs it
+type queryContent struct {
+	def    *EntryDef
+	load   func() (Entry, error)
+	entry  Entry
+	fields map[string]interface{}
+}
+
+func (qc *queryContent) get() (entry Entry, err error) {
+	if qc.entry == nil {
+		qc.entry, err = qc.load()
+	}
+	entry = qc.entry
+	return
+}
+
+// str returns the content of a non-JSON entry
+func (qc *queryContent) str() (content string, err error) {
+	var entry Entry
+	entry, err = qc.get()
+	if err != nil {
+		return
+	}
+	content, _ = entry.Content().(string)
+	return
+}
+
+// jsonFields returns the fields of a JSON entry, or nil if the entry isn't JSON
+func (qc *queryContent) jsonFields() (fields map[string]interface{}, err error) {
+	if qc.def.DataFormat != DataFormatJSON {
+		return
+	}
+	if qc.fields == nil {
+		var content string
+		content, err = qc.str()
+		if err != nil {
+			return
+		}
+		qc.fields = make(map[string]interface{})
+		err = json.Unmarshal([]byte(content), &qc.fields)
+		if err != nil {
+			return
+		}
+	}
+	fields = qc.fields
+	return
+}
+
+// queryFilter is a QueryConstrain with its field maps and regular expressions
+// parsed the first time they are needed
+type queryFilter struct {
+	c           *QueryConstrain
+	re          *regexp.Regexp
+	equalsMap   map[string]interface{}
+	containsMap map[string]interface{}
+	reMap       map[string]*regexp.Regexp
+	and, or     []*queryFilter
+	not         *queryFilter
+}
+
+func newQueryFilter(c *QueryConstrain) (f *queryFilter, err error) {
+	f = &queryFilter{c: c}
+	for _, cmp := range c.Compare {
+		switch cmp.Op {
+		case QueryCompareEQ, QueryCompareNE, QueryCompareLT, QueryCompareLE, QueryCompareGT, QueryCompareGE:
+		default:
+			err = fmt.Errorf("unknown query comparison: %s", cmp.Op)
+			return
+		}
+	}
+	for i := range c.And {
+		var sub *queryFilter
+		sub, err = newQueryFilter(&c.And[i])
+		if err != nil {
+			return
+		}
+		f.and = append(f.and, sub)
+	}
+	for i := range c.Or {
+		var sub *queryFilter
+		sub, err = newQueryFilter(&c.Or[i])
+		if err != nil {
+			return
+		}
+		f.or = append(f.or, sub)
+	}
+	if c.Not != nil {
+		f.not, err = newQueryFilter(c.Not)
+	}
+	return
+}
+
+// match returns true if the entry passes all of the constraint's tests, all
+// of its And constraints, at least one of its Or constraints and not its Not constraint
+func (f *queryFilter) match(header *Header, qc *queryContent) (pass bool, err error) {
+	c := f.c
+	if len(c.EntryTypes) > 0 && !contains(c.EntryTypes, header.Type) {
+		return
+	}
+	if !c.Since.IsZero() && header.Time.Before(c.Since) {
+		return
+	}
+	if !c.Until.IsZero() && !header.Time.Before(c.Until) {
+		return
+	}
+	if c.Equals != "" || c.Contains != "" || c.Matches != "" {
+		pass, err = f.matchContent(qc)
+		if err != nil || !pass {
+			return
+		}
+	}
+	if len(c.Compare) > 0 {
+		pass, err = f.matchCompare(qc)
+		if err != nil || !pass {
+			return
+		}
+	}
+	for _, sub := range f.and {
+		pass, err = sub.match(header, qc)
+		if err != nil || !pass {
+			return
+		}
+	}
+	if len(f.or) > 0 {
+		for _, sub := range f.or {
+			pass, err = sub.match(header, qc)
+			if err != nil || pass {
+				break
+			}
+		}
+		if err != nil || !pass {
+			return
+		}
+	}
+	if f.not != nil {
+		pass, err = f.not.match(header, qc)
+		if err != nil || pass {
+			pass = false
+			return
+		}
+	}
+	pass = true
+	return
+}
+
+// matchContent applies the Equals, Contains and Matches constraints which for
+// JSON entries pass if any of their fields match and otherwise apply to the whole content
+func (f *queryFilter) matchContent(qc *queryContent) (pass bool, err error) {
+	c := f.c
+	var content string
+	var contentMap map[string]interface{}
+	if qc.def.DataFormat == DataFormatJSON {
+		contentMap, err = qc.jsonFields()
+	} else {
+		content, err = qc.str()
+	}
+	if err != nil {
+		return
+	}
+
+	if c.Equals != "" {
+		if contentMap != nil {
+			if f.equalsMap == nil {
+				f.equalsMap = make(map[string]interface{})
+				err = json.Unmarshal([]byte(c.Equals), &f.equalsMap)
+				if err != nil {
+					return
+				}
+			}
+			var found bool
+			for fieldName, fieldValue := range f.equalsMap {
+				if contentMap[fieldName] == fieldValue {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return
+			}
+		} else if content != c.Equals {
+			return
+		}
+	}
+	if c.Contains != "" {
+		if contentMap != nil {
+			if f.containsMap == nil {
+				f.containsMap = make(map[string]interface{})
+				err = json.Unmarshal([]byte(c.Contains), &f.containsMap)
+				if err != nil {
+					return
+				}
+			}
+			var found bool
+			for fieldName, fieldValue := range f.containsMap {
+				s, _ := contentMap[fieldName].(string)
+				v, _ := fieldValue.(string)
+				if strings.Index(s, v) >= 0 {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return
+			}
+		} else if strings.Index(content, c.Contains) < 0 {
+			return
+		}
+	}
+	if c.Matches != "" {
+		if contentMap != nil {
+			if f.reMap == nil {
+				reMapStr := make(map[string]interface{})
+				err = json.Unmarshal([]byte(c.Matches), &reMapStr)
+				if err != nil {
+					return
+				}
+				f.reMap = make(map[string]*regexp.Regexp)
+				for fieldName, fieldValue := range reMapStr {
+					v, _ := fieldValue.(string)
+					f.reMap[fieldName], err = regexp.Compile(v)
+					if err != nil {
+						return
+					}
+				}
+			}
+			var found bool
+			for fieldName, fieldRe := range f.reMap {
+				s, _ := contentMap[fieldName].(string)
+				if fieldRe.Match([]byte(s)) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return
+			}
+		} else {
+			if f.re == nil {
+				f.re, err = regexp.Compile(c.Matches)
+				if err != nil {
+					return
+				}
+			}
+			if !f.re.Match([]byte(content)) {
+				return
+			}
+		}
+	}
+	pass = true
+	return
+}
+
+// matchCompare passes if all the comparisons hold, entries that aren't JSON
+// or whose field isn't a number never pass
+func (f *queryFilter) matchCompare(qc *queryContent) (pass bool, err error) {
+	var fields map[string]interface{}
+	fields, err = qc.jsonFields()
+	if err != nil || fields == nil {
+		return
+	}
+	for _, cmp := range f.c.Compare {
+		v, ok := fields[cmp.Field].(float64)
+		if !ok {
+			return
+		}
+		switch cmp.Op {
+		case QueryCompareEQ:
+			ok = v == cmp.Value
+		case QueryCompareNE:
+			ok = v != cmp.Value
+		case QueryCompareLT:
+			ok = v < cmp.Value
+		case QueryCompareLE:
+			ok = v <= cmp.Value
+		case QueryCompareGT:
+			ok = v > cmp.Value
+		case QueryCompareGE:
+			ok = v >= cmp.Value
+		}
+		if !ok {
+			return
+		}
+	}
+	pass = true
+	return
+}
+
+// queryLess orders the values of a JSON field, numbers before strings and
+// entries without the field (or with other types) after both
+func queryLess(a, b interface{}, ascending bool) bool {
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case float64:
+			return 0
+		case string:
+			return 1
+		}
+		return 2
+	}
+	ra, rb := rank(a), rank(b)
+	if ra != rb || ra == 2 {
+		return ra < rb
+	}
+	if ra == 0 {
+		if ascending {
+			return a.(float64) < b.(float64)
+		}
+		return a.(float64) > b.(float64)
+	}
+	if ascending {
+		return a.(string) < b.(string)
+	}
+	return a.(string) > b.(string)
+}
+
+// sortQueryResults sorts the results by the values of a JSON field
+func sortQueryResults(results []QueryResult, values map[*Header]interface{}, ascending bool) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return queryLess(values[results[i].Header], values[results[j].Header], ascending)
+	})
+}
+
+// QueryAggregate runs a query and returns the aggregate value the options ask for
+// which is an int for counts and a float64, or nil if no results have the field, otherwise
+func (h *Holochain) QueryAggregate(options *QueryOptions) (value interface{}, err error) {
+	agg := options.Aggregate
+	switch agg.Fn {
+	case QueryAggregateCount:
+	case QueryAggregateSum, QueryAggregateMin, QueryAggregateMax:
+		if agg.Field == "" {
+			err = fmt.Errorf("query aggregate %s requires a field", agg.Fn)
+			return
+		}
+	default:
+		err = fmt.Errorf("unknown query aggregate: %s", agg.Fn)
+		return
+	}
+
+	// aggregate over all the matching entries, not just a page of them
+	q := *options
+	q.Constrain.Count = 0
+	q.Constrain.Page = 0
+	q.Return.Entries = true
+	var results []QueryResult
+	results, err = h.Query(&q)
+	if err != nil {
+		return
+	}
+	if agg.Fn == QueryAggregateCount && agg.Field == "" {
+		value = len(results)
+		return
+	}
+
+	var count int
+	var acc float64
+	defs := make(map[string]*EntryDef)
+	for _, r := range results {
+		def, ok := defs[r.Header.Type]
+		if !ok {
+			_, def, err = h.GetEntryDef(r.Header.Type)
+			if err != nil {
+				return
+			}
+			defs[r.Header.Type] = def
+		}
+		entry := r.Entry
+		qc := queryContent{def: def, load: func() (Entry, error) { return entry, nil }}
+		var fields map[string]interface{}
+		fields, err = qc.jsonFields()
+		if err != nil {
+			return
+		}
+		v, ok := fields[agg.Field]
+		if !ok {
+			continue
+		}
+		if agg.Fn == QueryAggregateCount {
+			count++
+			continue
+		}
+		n, ok := v.(float64)
+		if !ok {
+			continue
+		}
+		switch agg.Fn {
+		case QueryAggregateSum:
+			acc += n
+		case QueryAggregateMin:
+			if count == 0 || n < acc {
+				acc = n
+			}
+		case QueryAggregateMax:
+			if count == 0 || n > acc {
+				acc = n
+			}
+		}
+		count++
+	}
+	if agg.Fn == QueryAggregateCount {
+		value = count
+	} else if agg.Fn == QueryAggregateSum || count > 0 {
+		value = acc
+	}
+	return
+}
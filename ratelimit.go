@@ -0,0 +1,199 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements the limits on how fast peers can send messages to a node
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimit configures the token bucket that limits how many messages a peer
+// can send on a protocol.  The bucket holds Burst messages and refills at
+// Rate messages a second.  A zero Rate means there's no limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitedResp is sent as the payload of a rate limit error and tells the
+// peer how long to wait before sending again
+type RateLimitedResp struct {
+	RetryAfter time.Duration
+}
+
+// DefaultRateLimits are the limits for each protocol, by name, used when
+// the config doesn't set them
+var DefaultRateLimits = map[string]RateLimit{
+	"action":   {Rate: 200, Burst: 1000},
+	"validate": {Rate: 200, Burst: 1000},
+	"gossip":   {Rate: 50, Burst: 200},
+	"kademlia": {Rate: 200, Burst: 1000},
+}
+
+const (
+	// DefaultRateLimitBlockAfter is the number of strikes after which a peer is blocked
+	DefaultRateLimitBlockAfter = 500
+
+	// a peer gets a strike for every message over its limit, and loses one every RateLimitStrikeDecay
+	RateLimitStrikeDecay = time.Second
+
+	// RateLimitSweepInterval is how often the limiter drops the state of peers
+	// whose buckets have refilled and whose strikes have worn off
+	RateLimitSweepInterval = time.Minute
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token from the bucket, or returns how long until there will be one
+func (b *tokenBucket) take(limit RateLimit, now time.Time) (retryAfter time.Duration) {
+	if b.last.IsZero() {
+		b.tokens = float64(limit.Burst)
+	} else {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return
+	}
+	retryAfter = time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	if retryAfter <= 0 {
+		retryAfter = time.Nanosecond
+	}
+	return
+}
+
+// full returns true if the bucket has refilled to its burst, in which case it's
+// no different from a new bucket
+func (b *tokenBucket) full(limit RateLimit, now time.Time) bool {
+	return b.last.IsZero() || b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= float64(limit.Burst)
+}
+
+type strikes struct {
+	count float64
+	last  time.Time
+}
+
+// expired returns true if all the strikes have worn off
+func (s *strikes) expired(now time.Time) bool {
+	return s.count-float64(now.Sub(s.last))/float64(RateLimitStrikeDecay) <= 0
+}
+
+// rateLimiter keeps the token buckets of each peer for each protocol, and
+// counts the strikes of peers that keep sending over their limits
+type rateLimiter struct {
+	lk         sync.Mutex
+	limits     [_protocolCount]RateLimit
+	blockAfter int
+	buckets    map[peer.ID]*[_protocolCount]tokenBucket
+	strikes    map[peer.ID]*strikes
+	swept      time.Time // when the maps were last swept of idle peers
+}
+
+// newRateLimiter makes a limiter from the limits for each protocol by name.
+// Peers are never blocked if blockAfter isn't above zero.
+func newRateLimiter(limits map[string]RateLimit, blockAfter int) (rl *rateLimiter, err error) {
+	l := rateLimiter{
+		blockAfter: blockAfter,
+		buckets:    make(map[peer.ID]*[_protocolCount]tokenBucket),
+		strikes:    make(map[peer.ID]*strikes),
+	}
+	for name, limit := range limits {
+		proto := protocolIdx(name)
+		if proto < 0 {
+			err = fmt.Errorf("unknown protocol %s in rate limits", name)
+			return
+		}
+		if limit.Rate < 0 || (limit.Rate > 0 && limit.Burst < 1) {
+			err = fmt.Errorf("invalid rate limit for protocol %s", name)
+			return
+		}
+		l.limits[proto] = limit
+	}
+	rl = &l
+	return
+}
+
+// allow checks whether a peer can send another message on a protocol.  If it
+// can't it returns how long it should wait, and whether it has kept going
+// over its limits for long enough that it should be blocked.
+func (rl *rateLimiter) allow(id peer.ID, proto int, now time.Time) (retryAfter time.Duration, block bool) {
+	limit := rl.limits[proto]
+	if limit.Rate == 0 {
+		return
+	}
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	if now.Sub(rl.swept) >= RateLimitSweepInterval {
+		rl.sweep(now)
+	}
+	b := rl.buckets[id]
+	if b == nil {
+		b = &[_protocolCount]tokenBucket{}
+		rl.buckets[id] = b
+	}
+	retryAfter = b[proto].take(limit, now)
+	if retryAfter == 0 {
+		return
+	}
+	s := rl.strikes[id]
+	if s == nil {
+		s = &strikes{}
+		rl.strikes[id] = s
+	} else {
+		s.count = math.Max(0, s.count-float64(now.Sub(s.last))/float64(RateLimitStrikeDecay))
+	}
+	s.last = now
+	s.count++
+	block = rl.blockAfter > 0 && s.count >= float64(rl.blockAfter)
+	return
+}
+
+// sweep drops the state of peers whose buckets have all refilled and whose
+// strikes have worn off, so that the limiter doesn't keep state for every peer
+// that has ever sent a message.  Must be called with the limiter locked.
+func (rl *rateLimiter) sweep(now time.Time) {
+	rl.swept = now
+	for id, s := range rl.strikes {
+		if s.expired(now) {
+			delete(rl.strikes, id)
+		}
+	}
+	for id, b := range rl.buckets {
+		if _, striking := rl.strikes[id]; striking {
+			continue
+		}
+		idle := true
+		for proto, limit := range rl.limits {
+			if limit.Rate > 0 && !b[proto].full(limit, now) {
+				idle = false
+				break
+			}
+		}
+		if idle {
+			delete(rl.buckets, id)
+		}
+	}
+}
+
+// forget drops the limiter's state for a peer
+func (rl *rateLimiter) forget(id peer.ID) {
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	delete(rl.buckets, id)
+	delete(rl.strikes, id)
+}
@@ -0,0 +1,215 @@
+package holochain
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/holochain/holochain-proto/hash"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimiter(t *testing.T) {
+	pid, _ := makePeer("peer1")
+	other, _ := makePeer("peer2")
+	now := time.Unix(1, 0)
+
+	Convey("it should reject unknown protocols and bad limits", t, func() {
+		_, err := newRateLimiter(map[string]RateLimit{"bogus": {Rate: 1, Burst: 1}}, 0)
+		So(err.Error(), ShouldEqual, "unknown protocol bogus in rate limits")
+		_, err = newRateLimiter(map[string]RateLimit{"gossip": {Rate: 1, Burst: 0}}, 0)
+		So(err.Error(), ShouldEqual, "invalid rate limit for protocol gossip")
+		_, err = newRateLimiter(map[string]RateLimit{"gossip": {Rate: -1, Burst: 1}}, 0)
+		So(err.Error(), ShouldEqual, "invalid rate limit for protocol gossip")
+	})
+
+	Convey("it should allow bursts and then limit to the rate", t, func() {
+		rl, err := newRateLimiter(map[string]RateLimit{"gossip": {Rate: 2, Burst: 3}}, 0)
+		So(err, ShouldBeNil)
+		for i := 0; i < 3; i++ {
+			retryAfter, _ := rl.allow(pid, GossipProtocol, now)
+			So(retryAfter, ShouldEqual, 0)
+		}
+		retryAfter, block := rl.allow(pid, GossipProtocol, now)
+		So(retryAfter, ShouldEqual, 500*time.Millisecond)
+		So(block, ShouldBeFalse)
+
+		// other peers and protocols have their own limits
+		retryAfter, _ = rl.allow(other, GossipProtocol, now)
+		So(retryAfter, ShouldEqual, 0)
+		retryAfter, _ = rl.allow(pid, ActionProtocol, now)
+		So(retryAfter, ShouldEqual, 0)
+
+		// the bucket refills at the rate
+		retryAfter, _ = rl.allow(pid, GossipProtocol, now.Add(500*time.Millisecond))
+		So(retryAfter, ShouldEqual, 0)
+		retryAfter, _ = rl.allow(pid, GossipProtocol, now.Add(500*time.Millisecond))
+		So(retryAfter, ShouldEqual, 500*time.Millisecond)
+	})
+
+	Convey("it should block peers that keep going over their limits", t, func() {
+		rl, _ := newRateLimiter(map[string]RateLimit{"action": {Rate: 1, Burst: 1}}, 3)
+		rl.allow(pid, ActionProtocol, now)
+		_, block := rl.allow(pid, ActionProtocol, now)
+		So(block, ShouldBeFalse)
+		_, block = rl.allow(pid, ActionProtocol, now)
+		So(block, ShouldBeFalse)
+
+		// strikes wear off over time
+		later := now.Add(2 * RateLimitStrikeDecay)
+		retryAfter, _ := rl.allow(pid, ActionProtocol, later)
+		So(retryAfter, ShouldEqual, 0)
+		_, block = rl.allow(pid, ActionProtocol, later)
+		So(block, ShouldBeFalse)
+		_, block = rl.allow(pid, ActionProtocol, later)
+		So(block, ShouldBeFalse)
+		_, block = rl.allow(pid, ActionProtocol, later)
+		So(block, ShouldBeTrue)
+
+		rl.forget(pid)
+		retryAfter, _ = rl.allow(pid, ActionProtocol, later)
+		So(retryAfter, ShouldEqual, 0)
+	})
+
+	Convey("it should drop the state of peers that have refilled their buckets and lost their strikes", t, func() {
+		rl, _ := newRateLimiter(map[string]RateLimit{"action": {Rate: 1, Burst: 2}, "gossip": {Rate: 1, Burst: 1}}, 100)
+		rl.allow(pid, ActionProtocol, now)
+		for i := 0; i < 10; i++ {
+			rl.allow(other, GossipProtocol, now)
+		}
+		So(len(rl.buckets), ShouldEqual, 2)
+		So(len(rl.strikes), ShouldEqual, 1)
+
+		// the first peer's bucket has refilled but the other peer still has strikes
+		rl.sweep(now.Add(5 * time.Second))
+		_, ok := rl.buckets[pid]
+		So(ok, ShouldBeFalse)
+		_, ok = rl.buckets[other]
+		So(ok, ShouldBeTrue)
+		So(len(rl.strikes), ShouldEqual, 1)
+
+		rl.sweep(now.Add(20 * time.Second))
+		So(len(rl.buckets), ShouldEqual, 0)
+		So(len(rl.strikes), ShouldEqual, 0)
+
+		// and allow sweeps every RateLimitSweepInterval
+		rl.allow(pid, ActionProtocol, now.Add(20*time.Second))
+		rl.allow(other, ActionProtocol, now.Add(20*time.Second+RateLimitSweepInterval/2))
+		So(len(rl.buckets), ShouldEqual, 2)
+		rl.allow(other, ActionProtocol, now.Add(20*time.Second+RateLimitSweepInterval))
+		_, ok = rl.buckets[pid]
+		So(ok, ShouldBeFalse)
+		So(len(rl.buckets), ShouldEqual, 1)
+	})
+
+	Convey("it should never block if blockAfter isn't above zero", t, func() {
+		rl, _ := newRateLimiter(map[string]RateLimit{"action": {Rate: 1, Burst: 1}}, -1)
+		for i := 0; i < 100; i++ {
+			_, block := rl.allow(pid, ActionProtocol, now)
+			So(block, ShouldBeFalse)
+		}
+	})
+}
+
+func TestNodeRateLimiting(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	node1, err := makeNode(1234, "node1")
+	if err != nil {
+		panic(err)
+	}
+	h.node.Close()
+	h.node = node1
+
+	d2, _, h2 := PrepareTestChain("test2")
+	defer CleanupTestChain(h2, d2)
+	h2.node.Close()
+
+	node2, err := makeNode(1235, "node2")
+	if err != nil {
+		panic(err)
+	}
+	defer node2.Close()
+	h2.node = node2
+	os.Remove(filepath.Join(h2.DBPath(), DHTStoreFileName))
+	h2.dht, err = NewDHT(h2)
+	if err != nil {
+		panic(err)
+	}
+
+	h.Activate()
+	node2.host.Peerstore().AddAddr(node1.HashAddr, node1.NetAddr, pstore.PermanentAddrTTL)
+
+	err = node1.SetRateLimits(map[string]RateLimit{"gossip": {Rate: 1, Burst: 2}}, 3)
+	if err != nil {
+		panic(err)
+	}
+
+	send := func() Message {
+		m := node2.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		r, err := node2.Send(context.Background(), GossipProtocol, node1.HashAddr, m)
+		So(err, ShouldBeNil)
+		return r
+	}
+
+	Convey("it should respond with a retry after hint when a peer goes over its limit", t, func() {
+		So(send().Type, ShouldEqual, OK_RESPONSE)
+		So(send().Type, ShouldEqual, OK_RESPONSE)
+		r := send()
+		So(r.Type, ShouldEqual, ERROR_RESPONSE)
+		errResp := r.Body.(ErrorResponse)
+		So(errResp.DecodeResponseError(), ShouldEqual, ErrRateLimited)
+		retryAfter := errResp.Payload.(RateLimitedResp).RetryAfter
+		So(retryAfter, ShouldBeGreaterThan, 0)
+		So(retryAfter, ShouldBeLessThanOrEqualTo, time.Second)
+
+		// other protocols aren't limited
+		hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat6x5HEhc1TVGs11tmfNSzkqh2")
+		for i := 0; i < 3; i++ {
+			m := node2.NewMessage(GET_REQUEST, GetReq{H: hash, StatusMask: StatusLive})
+			r, err := node2.Send(context.Background(), ActionProtocol, node1.HashAddr, m)
+			So(err, ShouldBeNil)
+			if r.Type == ERROR_RESPONSE {
+				So(r.Body.(ErrorResponse).Code, ShouldNotEqual, ErrRateLimitedCode)
+			}
+		}
+	})
+
+	Convey("it should block a peer that keeps going over its limit", t, func() {
+		var codes []int
+		for i := 0; i < 10 && !node1.IsBlocked(node2.HashAddr); i++ {
+			codes = append(codes, send().Body.(ErrorResponse).Code)
+		}
+		So(node1.IsBlocked(node2.HashAddr), ShouldBeTrue)
+		for _, code := range codes {
+			So(code, ShouldEqual, ErrRateLimitedCode)
+		}
+		So(send().Body.(ErrorResponse).Code, ShouldEqual, ErrBlockedListedCode)
+	})
+
+	Convey("unblocking a peer should reset its limits", t, func() {
+		node1.Unblock(node2.HashAddr)
+		So(send().Type, ShouldEqual, OK_RESPONSE)
+	})
+
+	Convey("it should block a peer that goes over its limit on many streams at once", t, func() {
+		node1.Unblock(node2.HashAddr)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m := node2.NewMessage(GOSSIP_REQUEST, GossipReq{})
+				node2.Send(context.Background(), GossipProtocol, node1.HashAddr, m)
+				node1.IsBlocked(node2.HashAddr)
+			}()
+		}
+		wg.Wait()
+		So(node1.IsBlocked(node2.HashAddr), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements the tracking of how well peers behave, so that misbehaving peers can be avoided
+
+package holochain
+
+import (
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// Reputation holds the counts of the things a peer has done that we
+// judge it by.  It's stored in the hash table next to the gossipers.
+// The counts halve every ReputationHalfLife so that a peer isn't judged
+// forever by what it did long ago.
+type Reputation struct {
+	Timeouts     int       // requests to the peer that timed out
+	InvalidData  int       // responses from the peer that didn't check out
+	RejectedPuts int       // our puts that the peer refused to hold
+	Holds        int       // our puts that the peer agreed to hold
+	Decayed      time.Time // when the counts were last halved, zero if they never have been counted
+}
+
+const (
+	ReputationHoldCredit         = 1
+	ReputationMaxCredit          = 8 // a peer can't earn enough credit to hide misbehavior
+	ReputationTimeoutPenalty     = 2
+	ReputationRejectedPutPenalty = 4
+	ReputationInvalidDataPenalty = 8
+
+	ReputationHalfLife = 24 * time.Hour
+)
+
+// Score returns a single number for the reputation, 0 for a peer we know
+// nothing about, below zero for a peer that has misbehaved more than it
+// has been useful
+func (r Reputation) Score() int {
+	credit := r.Holds * ReputationHoldCredit
+	if credit > ReputationMaxCredit {
+		credit = ReputationMaxCredit
+	}
+	return credit - r.Timeouts*ReputationTimeoutPenalty -
+		r.RejectedPuts*ReputationRejectedPutPenalty -
+		r.InvalidData*ReputationInvalidDataPenalty
+}
+
+// decay returns the reputation with its counts halved for every half life
+// that has passed since they were last halved
+func (r Reputation) decay(now time.Time) Reputation {
+	if r.Decayed.IsZero() {
+		return r
+	}
+	halvings := now.Sub(r.Decayed) / ReputationHalfLife
+	if halvings <= 0 {
+		return r
+	}
+	if halvings > 62 {
+		halvings = 62
+	}
+	r.Timeouts >>= uint(halvings)
+	r.InvalidData >>= uint(halvings)
+	r.RejectedPuts >>= uint(halvings)
+	r.Holds >>= uint(halvings)
+	r.Decayed = r.Decayed.Add(halvings * ReputationHalfLife)
+	if r.Decayed.Before(now.Add(-ReputationHalfLife)) {
+		r.Decayed = now
+	}
+	return r
+}
+
+// update returns the reputation decayed to now with the counts in delta added to it
+func (r Reputation) update(delta Reputation, now time.Time) Reputation {
+	r = r.decay(now)
+	if r.Decayed.IsZero() {
+		r.Decayed = now
+	}
+	r.Timeouts += delta.Timeouts
+	r.InvalidData += delta.InvalidData
+	r.RejectedPuts += delta.RejectedPuts
+	r.Holds += delta.Holds
+	return r
+}
+
+// Misbehaving returns true if the peer should be avoided when there are others to choose from
+func (r Reputation) Misbehaving() bool {
+	return r.Score() < 0
+}
+
+// GetReputation returns the reputation of a peer as it stands now
+func (dht *DHT) GetReputation(id peer.ID) (rep Reputation, err error) {
+	rep, err = dht.ht.GetReputation(id)
+	if err == nil {
+		rep = rep.decay(time.Now())
+	}
+	return
+}
+
+// UpdateReputation adds the counts in delta to the reputation of a peer
+func (dht *DHT) UpdateReputation(id peer.ID, delta Reputation) (err error) {
+	if id == dht.h.nodeID {
+		return
+	}
+	dht.dlog.Logf("updating reputation of %v by %v", id, delta)
+	err = dht.ht.UpdateReputation(id, delta)
+	return
+}
+
+// updateReputation updates a peer's reputation logging rather than returning any error
+func (dht *DHT) updateReputation(id peer.ID, delta Reputation) {
+	if dht == nil {
+		return
+	}
+	if err := dht.UpdateReputation(id, delta); err != nil {
+		dht.dlog.Logf("failed to update reputation of %v: %v", id, err)
+	}
+}
+
+// reputationScore returns the score of a peer for ordering peers by
+// reputation, or 0 if it can't be found
+func (dht *DHT) reputationScore(id peer.ID) int {
+	if dht == nil {
+		return 0
+	}
+	rep, err := dht.GetReputation(id)
+	if err != nil {
+		return 0
+	}
+	return rep.Score()
+}
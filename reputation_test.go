@@ -0,0 +1,145 @@
+package holochain
+
+import (
+	"testing"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReputationScore(t *testing.T) {
+	Convey("an unknown peer should have a zero score", t, func() {
+		var r Reputation
+		So(r.Score(), ShouldEqual, 0)
+		So(r.Misbehaving(), ShouldBeFalse)
+	})
+
+	Convey("holds should add to the score up to the maximum credit", t, func() {
+		So(Reputation{Holds: 3}.Score(), ShouldEqual, 3*ReputationHoldCredit)
+		So(Reputation{Holds: 1000}.Score(), ShouldEqual, ReputationMaxCredit)
+	})
+
+	Convey("misbehavior should take from the score", t, func() {
+		So(Reputation{Timeouts: 1}.Score(), ShouldEqual, -ReputationTimeoutPenalty)
+		So(Reputation{RejectedPuts: 1}.Score(), ShouldEqual, -ReputationRejectedPutPenalty)
+		So(Reputation{InvalidData: 1}.Score(), ShouldEqual, -ReputationInvalidDataPenalty)
+		So(Reputation{Holds: 1000, InvalidData: 2}.Misbehaving(), ShouldBeTrue)
+		So(Reputation{Holds: 4, Timeouts: 1}.Misbehaving(), ShouldBeFalse)
+	})
+}
+
+// repCounts returns just the counts of a reputation
+func repCounts(r Reputation) Reputation {
+	r.Decayed = time.Time{}
+	return r
+}
+
+func TestReputationDecay(t *testing.T) {
+	now := time.Now()
+
+	Convey("counts should halve every half life", t, func() {
+		r := Reputation{}.update(Reputation{Timeouts: 5, InvalidData: 1, Holds: 8}, now)
+		So(r.Decayed, ShouldEqual, now)
+		So(r.decay(now.Add(ReputationHalfLife-time.Second)), ShouldResemble, r)
+
+		d := r.decay(now.Add(ReputationHalfLife + time.Second))
+		So(repCounts(d), ShouldResemble, Reputation{Timeouts: 2, Holds: 4})
+		So(d.Decayed, ShouldEqual, now.Add(ReputationHalfLife))
+
+		d = r.decay(now.Add(3 * ReputationHalfLife))
+		So(repCounts(d), ShouldResemble, Reputation{Holds: 1})
+		So(d.Misbehaving(), ShouldBeFalse)
+	})
+
+	Convey("a peer that timed out a few times long ago should not be misbehaving", t, func() {
+		r := Reputation{}.update(Reputation{Timeouts: 5}, now)
+		So(r.Misbehaving(), ShouldBeTrue)
+		r = r.decay(now.Add(100 * 365 * 24 * time.Hour))
+		So(repCounts(r), ShouldResemble, Reputation{})
+		So(r.Misbehaving(), ShouldBeFalse)
+	})
+
+	Convey("updates should decay the counts before adding to them", t, func() {
+		r := Reputation{}.update(Reputation{Timeouts: 4}, now)
+		r = r.update(Reputation{Timeouts: 1}, now.Add(2*ReputationHalfLife))
+		So(repCounts(r), ShouldResemble, Reputation{Timeouts: 2})
+	})
+
+	Convey("counts that have never been decayed should be left alone", t, func() {
+		r := Reputation{Timeouts: 3}
+		So(r.decay(now), ShouldResemble, r)
+	})
+}
+
+func TestDHTReputation(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+	dht := h.dht
+
+	fooAddr, _ := makePeer("peer_foo")
+	barAddr, _ := makePeer("peer_bar")
+
+	Convey("UpdateReputation should add to a peer's reputation", t, func() {
+		rep, err := dht.GetReputation(fooAddr)
+		So(err, ShouldBeNil)
+		So(rep, ShouldResemble, Reputation{})
+
+		So(dht.UpdateReputation(fooAddr, Reputation{Holds: 2}), ShouldBeNil)
+		So(dht.UpdateReputation(fooAddr, Reputation{Holds: 1, Timeouts: 1}), ShouldBeNil)
+		rep, err = dht.GetReputation(fooAddr)
+		So(err, ShouldBeNil)
+		So(repCounts(rep), ShouldResemble, Reputation{Holds: 3, Timeouts: 1})
+		So(dht.reputationScore(fooAddr), ShouldEqual, 3*ReputationHoldCredit-ReputationTimeoutPenalty)
+	})
+
+	Convey("UpdateReputation should ignore our own node", t, func() {
+		So(dht.UpdateReputation(h.nodeID, Reputation{InvalidData: 1}), ShouldBeNil)
+		rep, _ := dht.GetReputation(h.nodeID)
+		So(rep, ShouldResemble, Reputation{})
+	})
+
+	addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/1234")
+	if err != nil {
+		panic(err)
+	}
+	h.node.peerstore.AddAddrs(fooAddr, []ma.Multiaddr{addr}, PeerTTL)
+	h.node.peerstore.AddAddrs(barAddr, []ma.Multiaddr{addr}, PeerTTL)
+	dht.AddGossiper(fooAddr)
+	dht.AddGossiper(barAddr)
+
+	Convey("FindGossiper should avoid misbehaving gossipers", t, func() {
+		So(dht.UpdateReputation(barAddr, Reputation{InvalidData: 1}), ShouldBeNil)
+		for i := 0; i < 20; i++ {
+			g, err := dht.FindGossiper()
+			So(err, ShouldBeNil)
+			So(g, ShouldEqual, fooAddr)
+		}
+	})
+
+	Convey("FindGossiper should use misbehaving gossipers if there are no others", t, func() {
+		So(dht.UpdateReputation(fooAddr, Reputation{InvalidData: 1}), ShouldBeNil)
+		found := make(map[peer.ID]bool)
+		for i := 0; i < 50; i++ {
+			g, err := dht.FindGossiper()
+			So(err, ShouldBeNil)
+			found[g] = true
+		}
+		So(len(found), ShouldEqual, 2)
+	})
+
+	Convey("gossipers should be ordered with misbehaving ones after closer ones that behave", t, func() {
+		near, far := fooAddr, barAddr
+		if h.node.Distance(far).Cmp(h.node.Distance(near)) < 0 {
+			near, far = far, near
+		}
+		h.nucleus.dna.DHTConfig.RedundancyFactor = 2
+		defer func() { h.nucleus.dna.DHTConfig.RedundancyFactor = 0 }()
+
+		So(dht.UpdateReputation(near, Reputation{InvalidData: 100}), ShouldBeNil)
+		glist, err := dht._getGossipers()
+		So(err, ShouldBeNil)
+		So(glist, ShouldResemble, []peer.ID{far, near})
+	})
+}
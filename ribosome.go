@@ -46,6 +46,7 @@ const (
 
 	BundleCancelReasonUserCancel = "userCancel"
 	BundleCancelReasonTimeout    = "timeout"
+	BundleCancelReasonDiverged   = "diverged"
 
 	BundleCancelResponseOK     = ""
 	BundleCancelResponseCommit = "commit"
@@ -93,6 +94,7 @@ type Ribosome interface {
 	Call(fn *FunctionDef, params interface{}) (interface{}, error)
 	Run(code string) (result interface{}, err error)
 	RunAsyncSendResponse(response AppMsg, callback string, callbackID string) (result interface{}, err error)
+	RunSubscriptionCallback(change DHTChange, callback string, id int) (result interface{}, err error)
 	BundleCanceled(reason string) (response string, err error)
 }
 
@@ -41,6 +41,7 @@ const (
 	StoreFileName        string = "chain.db"    // Filename for local data store
 	DNAHashFileName      string = "dna.hash"    // Filename for storing the hash of the holochain
 	DHTStoreFileName     string = "dht.db"      // Filname for storing the dht
+	DHTBoltStoreFileName string = "dht.bolt"    // Filname for storing the dht with the bolt HashTable
 	BridgeDBFileName     string = "bridge.db"   // Filname for storing bridge keys
 
 	TestConfigFileName string = "_config.json"
@@ -176,6 +177,9 @@ func Init(root string, identity AgentIdentity, seed io.Reader) (service *Service
 	if MigrateEntryDef.validator == nil {
 		err = MigrateEntryDef.BuildJSONSchemaValidatorFromString(MigrateEntryDef.Schema)
 	}
+	if CheckpointEntryDef.validator == nil {
+		err = CheckpointEntryDef.BuildJSONSchemaValidatorFromString(CheckpointEntryDef.Schema)
+	}
 	if err != nil {
 		return
 	}
@@ -321,13 +325,26 @@ func (s *Service) IsConfigured(name string) (f string, err error) {
 	return
 }
 
+// LoadOptions override parts of a holochain's saved Config when loading it
+type LoadOptions struct {
+	ChainVerification string // if not empty, the verification to do when opening the chain
+	EncryptChain      bool   // encrypt a chain that was written in the clear if the Config turns on DataEncryption
+}
+
 // Load instantiates a Holochain instance from disk
 func (s *Service) Load(name string) (h *Holochain, err error) {
+	h, err = s.LoadWithOptions(name, LoadOptions{})
+	return
+}
+
+// LoadWithOptions instantiates a Holochain instance from disk like Load but
+// with the given options overriding its saved Config
+func (s *Service) LoadWithOptions(name string, options LoadOptions) (h *Holochain, err error) {
 	f, err := s.IsConfigured(name)
 	if err != nil {
 		return
 	}
-	h, err = s.load(name, f)
+	h, err = s.load(name, f, options)
 	return
 }
 
@@ -453,7 +470,7 @@ func (s *Service) loadDNA(path string, filename string, format string) (dnaP *DN
 }
 
 // load unmarshals a holochain structure for the named chain and format
-func (s *Service) load(name string, format string) (hP *Holochain, err error) {
+func (s *Service) load(name string, format string, options LoadOptions) (hP *Holochain, err error) {
 	var h Holochain
 	root := filepath.Join(s.Path, name)
 
@@ -471,6 +488,10 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 	if err = h.Config.Setup(); err != nil {
 		return
 	}
+	if options.ChainVerification != "" {
+		h.Config.ChainVerification = options.ChainVerification
+	}
+	h.encryptChain = options.EncryptChain
 
 	dna, err := s.loadDNA(filepath.Join(root, ChainDNADir), DNAFileName, format)
 	if err != nil {
@@ -504,15 +525,20 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 		return
 	}
 
-	h.chain, err = NewChainFromFile(h.hashSpec, filepath.Join(h.DBPath(), StoreFileName))
+	err = h.openChain()
 	if err != nil {
 		return
 	}
 
 	// if the chain has been started there should be a DNAHashFile which
 	// we can load to check against the actual hash of the DNA entry
-	if len(h.chain.Headers) > 0 {
-		h.dnaHash = h.chain.Headers[0].EntryLink.Clone()
+	if h.chain.Length() > 0 {
+		var dnaHeader *Header
+		dnaHeader, err = h.chain.header(0)
+		if err != nil {
+			return
+		}
+		h.dnaHash = dnaHeader.EntryLink.Clone()
 
 		var b []byte
 		b, err = ReadFile(h.rootPath, DNAHashFileName)
@@ -527,7 +553,12 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 	// @TODO compare value from file to actual hash
 
 	if h.chain.Length() > 0 {
-		h.agentHash = h.chain.Headers[1].EntryLink
+		agentHeader, e := h.chain.header(1)
+		if e != nil {
+			err = e
+			return
+		}
+		h.agentHash = agentHeader.EntryLink
 		_, topHeader := h.chain.TopType(AgentEntryType)
 		h.agentTopHash = topHeader.EntryLink
 	}
@@ -561,7 +592,7 @@ func gen(root string, initDB bool, makeH func(root string) (hP *Holochain, err e
 			return nil, err
 		}
 
-		h.chain, err = NewChainFromFile(h.hashSpec, filepath.Join(h.DBPath(), StoreFileName))
+		err = h.openChain()
 		if err != nil {
 			return nil, err
 		}
@@ -631,6 +662,24 @@ func _makeConfig(s *Service) (config Config, err error) {
 		Debugf("makeConfig: using environment variable to set enableNATUPnP to: %s", val)
 		config.EnableNATUPnP = val == "true"
 	}
+
+	val = os.Getenv("HOLOCHAINCONFIG_HASHTABLETYPE")
+	if val != "" {
+		Debugf("makeConfig: using environment variable to set hashTableType to: %s", val)
+		config.HashTableType = val
+	}
+
+	val = os.Getenv("HOLOCHAINCONFIG_DATAENCRYPTION")
+	if val != "" {
+		Debugf("makeConfig: using environment variable to set dataEncryption to: %s", val)
+		config.DataEncryption = val
+	}
+
+	val = os.Getenv("HOLOCHAINCONFIG_CHAINVERIFICATION")
+	if val != "" {
+		Debugf("makeConfig: using environment variable to set chainVerification to: %s", val)
+		config.ChainVerification = val
+	}
 	return
 }
 
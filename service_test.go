@@ -260,7 +260,7 @@ func TestMakeTestingApp(t *testing.T) {
 		f, err := s.IsConfigured(name)
 		So(f, ShouldEqual, "")
 		So(err.Error(), ShouldEqual, fmt.Sprintf("No DNA file in %s%s", filepath.Join(root, ChainDNADir), string(os.PathSeparator)))
-		_, err = s.load("test", "json")
+		_, err = s.load("test", "json", LoadOptions{})
 		So(err.Error(), ShouldEqual, "open "+filepath.Join(root, ConfigFileName+".json")+": no such file or directory")
 
 	})
@@ -276,7 +276,7 @@ func TestMakeTestingApp(t *testing.T) {
 		h, err = s.Load(name)
 		So(err, ShouldBeNil)
 
-		lh, err := s.load(name, "json")
+		lh, err := s.load(name, "json", LoadOptions{})
 		So(err, ShouldBeNil)
 		So(lh.nodeID, ShouldEqual, h.nodeID)
 		So(lh.nodeIDStr, ShouldEqual, h.nodeIDStr)
@@ -17,9 +17,16 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// WebSocketWriteTimeout is how long a write to a websocket connection may take
+// before it fails, so that a stalled client can't hold up its subscriptions
+const WebSocketWriteTimeout = 10 * time.Second
+
 type WebServer struct {
 	h      *holo.Holochain
 	port   string
@@ -68,6 +75,22 @@ func (ws *WebServer) Start() {
 			return
 		}
 
+		// subscription changes are written from the DHT change feed so writes
+		// to the connection must be serialized
+		var writeLk sync.Mutex
+		writeJSON := func(v interface{}) error {
+			writeLk.Lock()
+			defer writeLk.Unlock()
+			conn.SetWriteDeadline(time.Now().Add(WebSocketWriteTimeout))
+			return conn.WriteJSON(v)
+		}
+		subscriptions := make(map[int]bool)
+		defer func() {
+			for id := range subscriptions {
+				ws.h.DHT().Unsubscribe(id)
+			}
+		}()
+
 		for {
 			var v map[string]string
 			err := conn.ReadJSON(&v)
@@ -78,17 +101,49 @@ func (ws *WebServer) Start() {
 				ws.errs.Log(err)
 				return
 			}
-			zome := v["zome"]
-			function := v["fn"]
-			result, err := ws.call(zome, function, v["arg"])
-			switch t := result.(type) {
-			case string:
-				err = conn.WriteMessage(websocket.TextMessage, []byte(t))
-			case []byte:
-				err = conn.WriteMessage(websocket.TextMessage, t)
-				//err = conn.WriteJSON(t)
-			default:
-				err = fmt.Errorf("Unknown type from Call of %s:%s", zome, function)
+
+			if filter, ok := v["subscribe"]; ok {
+				var f holo.DHTChangeFilter
+				if filter != "" {
+					err = json.Unmarshal([]byte(filter), &f)
+				}
+				if err == nil {
+					id := ws.h.DHT().Subscribe(f, func(id int, change holo.DHTChange) {
+						err := writeJSON(map[string]interface{}{"subscription": id, "change": change})
+						if err != nil {
+							ws.errs.Log(err)
+						}
+					})
+					subscriptions[id] = true
+					err = writeJSON(map[string]interface{}{"subscription": id})
+				}
+			} else if idStr, ok := v["unsubscribe"]; ok {
+				var id int
+				id, err = strconv.Atoi(idStr)
+				if err == nil {
+					err = ws.h.DHT().Unsubscribe(id)
+					delete(subscriptions, id)
+				}
+				if err == nil {
+					err = writeJSON(map[string]interface{}{"unsubscribed": id})
+				}
+			} else {
+				zome := v["zome"]
+				function := v["fn"]
+				var result interface{}
+				result, err = ws.call(zome, function, v["arg"])
+				writeLk.Lock()
+				conn.SetWriteDeadline(time.Now().Add(WebSocketWriteTimeout))
+				switch t := result.(type) {
+				case string:
+					err = conn.WriteMessage(websocket.TextMessage, []byte(t))
+				case []byte:
+					err = conn.WriteMessage(websocket.TextMessage, t)
+					//err = conn.WriteJSON(t)
+				default:
+					err = fmt.Errorf("Unknown type from Call of %s:%s", zome, function)
+				}
+				writeLk.Unlock()
 			}
 
 			if err != nil {
@@ -147,6 +202,22 @@ func (ws *WebServer) Start() {
 		}
 	})
 
+	mux.HandleFunc("/_status", func(w http.ResponseWriter, r *http.Request) {
+		AddCors(w)
+		status := map[string]interface{}{
+			"DNAHash": ws.h.DNAHash().String(),
+			"NodeID":  ws.h.NodeIDStr(),
+			"Pruning": ws.h.PruneStats(),
+		}
+		b, err := json.Marshal(status)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+
 	mux.HandleFunc("/setup-bridge/", func(w http.ResponseWriter, r *http.Request) {
 		var err error
 		var errCode = 400
@@ -2,6 +2,7 @@ package ui
 
 import (
 	"bytes"
+	"encoding/json"
 	. "github.com/holochain/holochain-proto"
 	. "github.com/holochain/holochain-proto/hash"
 	. "github.com/smartystreets/goconvey/convey"
@@ -93,6 +94,22 @@ func TestWebServer(t *testing.T) {
 		So(string(b), ShouldEqual, "Error: myError\n")
 	})
 
+	Convey("it should return the node's status", t, func() {
+		resp, err := http.Get("http://0.0.0.0:31415/_status")
+		So(err, ShouldBeNil)
+		defer resp.Body.Close()
+		var status struct {
+			DNAHash string
+			NodeID  string
+			Pruning PruneStats
+		}
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		So(err, ShouldBeNil)
+		So(status.DNAHash, ShouldEqual, h.DNAHash().String())
+		So(status.NodeID, ShouldEqual, h.NodeIDStr())
+		So(status.Pruning.Pruned, ShouldEqual, 0)
+	})
+
 	fakeFromApp, _ := NewHash("QmVGtdTZdTFaLsaj2RwdVG8jcjNNcp1DE914DKZ2kHmXHx")
 	token, _ := h.AddBridgeAsCallee(fakeFromApp, "")
 
@@ -9,20 +9,28 @@ package holochain
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	. "github.com/holochain/holochain-proto/hash"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
 )
 
+var ErrPackageSignerNotSource = errors.New("validation package wasn't signed by the agent being validated")
+var ErrPackageProofMismatch = errors.New("validation package proof isn't of the header being validated")
+
 // Package holds app specified data needed for validation (wire package)
 type Package struct {
-	Chain []byte
+	Chain  []byte
+	Proofs []ChainProof
 }
 
 // ValidationPackage holds app specified data needed for validation. This version
 // holds the package with any chain data un-marshaled after validation for passing
 // into the app for app level validation
 type ValidationPackage struct {
-	Chain *Chain
+	Chain  *Chain
+	Proofs []ChainProof
 }
 
 const (
@@ -43,15 +51,46 @@ const (
 	PkgReqChainOptHeaders    = 0x01
 	PkgReqChainOptEntries    = 0x02
 	PkgReqChainOptFull       = 0x03
+	PkgReqChainOptProof      = 0x04 // send inclusion proofs instead of the chain
 	PkgReqChainOptNoneStr    = "0"
 	PkgReqChainOptHeadersStr = "1"
 	PkgReqChainOptEntriesStr = "2"
 	PkgReqChainOptFullStr    = "3"
+	PkgReqChainOptProofStr   = "4"
 )
 
+// CheckpointPackageVersion is the protocol version that introduced chain packages
+// that start at the latest checkpoint
+const CheckpointPackageVersion = "0.1.0"
+
 // PackagingReq holds a request from an app for data to be included in the validation response
 type PackagingReq map[string]interface{}
 
+// signer returns the ID of the agent that signed the package's proofs or the
+// checkpoint its chain starts from, or an empty ID if nothing in it is signed
+func (vp *ValidationPackage) signer() (ID peer.ID, err error) {
+	if len(vp.Proofs) > 0 {
+		ID, err = vp.Proofs[0].Agent()
+		return
+	}
+	if vp.Chain == nil || len(vp.Chain.Headers) == 0 || len(vp.Chain.Entries) == 0 || vp.Chain.Headers[0].Type != CheckpointEntryType {
+		return
+	}
+	var cp CheckpointEntry
+	j, _ := vp.Chain.Entries[0].Content().(string)
+	cp, err = CheckpointEntryFromJSON(j)
+	if err != nil {
+		return
+	}
+	var pubKey ic.PubKey
+	pubKey, err = DecodePubKey(cp.PubKey)
+	if err != nil {
+		return
+	}
+	ID, err = peer.IDFromPublicKey(pubKey)
+	return
+}
+
 // ValidateQuery holds the data from a validation query on the Source protocol
 type ValidateQuery struct {
 	H Hash
@@ -68,11 +107,27 @@ type ValidateResponse struct {
 // MakePackage converts a package request into a package, loading chain data as necessary
 // this is the package that gets sent over the wire.  Chain DNA is omitted in this package
 // because it can be added at the destination and the chain will still validate.
+// If the request asks for proofs the package holds proofs of the latest header
+// of each of the requested entry types, or of the latest header of the chain, instead.
 func MakePackage(h *Holochain, req PackagingReq) (pkg Package, err error) {
+	return makePackage(h, req, true)
+}
+
+// makePackage makes a package, starting the chain at the latest checkpoint only
+// if fromCheckpoint is set, as peers older than CheckpointPackageVersion don't
+// know that marshaling flag
+func makePackage(h *Holochain, req PackagingReq, fromCheckpoint bool) (pkg Package, err error) {
 	if f, ok := req[PkgReqChain]; ok {
 		var b bytes.Buffer
 		flags := f.(int64)
+		if (flags & PkgReqChainOptProof) != 0 {
+			pkg.Proofs, err = makePackageProofs(h, req)
+			return
+		}
 		var mflags int64
+		if fromCheckpoint {
+			mflags += ChainMarshalFlagsFromCheckpoint
+		}
 		if (flags & PkgReqChainOptHeaders) == 0 {
 			mflags += ChainMarshalFlagsNoHeaders
 		}
@@ -97,10 +152,55 @@ func MakePackage(h *Holochain, req PackagingReq) (pkg Package, err error) {
 	return
 }
 
+// makePackageProofs proves the headers a proof package request asks for
+func makePackageProofs(h *Holochain, req PackagingReq) (proofs []ChainProof, err error) {
+	var hashes []Hash
+	if t, ok := req[PkgReqEntryTypes]; ok {
+		for _, entryType := range t.([]string) {
+			if hash, _ := h.chain.TopType(entryType); hash != nil {
+				hashes = append(hashes, *hash)
+			}
+		}
+	} else {
+		var hash Hash
+		h.chain.lk.RLock()
+		hash, err = h.chain.topHash()
+		h.chain.lk.RUnlock()
+		if err != nil {
+			return
+		}
+		hashes = append(hashes, hash)
+	}
+	for _, hash := range hashes {
+		var proof *ChainProof
+		proof, err = h.chain.Prove(hash, h.agent.PrivKey())
+		if err != nil {
+			return
+		}
+		proofs = append(proofs, *proof)
+	}
+	return
+}
+
 // MakeValidationPackage converts a received Package into a ValidationPackage and validates
-// any chain data that was included
+// any chain data that was included.  All of the package's proofs must verify
+// and be for the same agent's chain.
 func MakeValidationPackage(h *Holochain, pkg *Package) (vpkg *ValidationPackage, err error) {
 	vp := ValidationPackage{}
+	if pkg != nil {
+		for i := range pkg.Proofs {
+			proof := &pkg.Proofs[i]
+			if proof.PubKey != pkg.Proofs[0].PubKey {
+				err = errors.New("package proofs are for different agents")
+				return
+			}
+			err = VerifyChainProof(h.hashSpec, proof)
+			if err != nil {
+				return
+			}
+		}
+		vp.Proofs = pkg.Proofs
+	}
 	if (pkg != nil) && (pkg.Chain != nil) {
 		buf := bytes.NewBuffer(pkg.Chain)
 		var flags int64
@@ -108,9 +208,14 @@ func MakeValidationPackage(h *Holochain, pkg *Package) (vpkg *ValidationPackage,
 		if err != nil {
 			return
 		}
-		if flags&ChainMarshalFlagsNoEntries == 0 {
+		if flags&(ChainMarshalFlagsNoEntries|ChainMarshalFlagsFromCheckpoint) == 0 {
 			// restore the chain's DNA data
-			vp.Chain.Entries[0].(*GobEntry).C = h.chain.Entries[0].(*GobEntry).C
+			var dna Entry
+			dna, err = h.chain.entry(0)
+			if err != nil {
+				return
+			}
+			vp.Chain.Entries[0].(*GobEntry).C = dna.(*GobEntry).C
 		}
 		if flags&ChainMarshalFlagsNoHeaders == 0 {
 			err = vp.Chain.Validate(flags&ChainMarshalFlagsNoEntries != 0)
@@ -123,6 +228,33 @@ func MakeValidationPackage(h *Holochain, pkg *Package) (vpkg *ValidationPackage,
 	return
 }
 
+// checkPackageProof checks that the first proof in the package of a validation
+// response, if there is one, proves the response's header and that the header is
+// of the entry that was asked for, so that the proof can't be of some other entry
+func checkPackageProof(hash Hash, resp *ValidateResponse) (err error) {
+	if len(resp.Package.Proofs) == 0 {
+		return
+	}
+	proof := &resp.Package.Proofs[0]
+	if !proof.Header.EntryLink.Equal(hash) {
+		err = ErrPackageProofMismatch
+		return
+	}
+	var proved, served []byte
+	proved, err = proof.Header.Marshal()
+	if err != nil {
+		return
+	}
+	served, err = resp.Header.Marshal()
+	if err != nil {
+		return
+	}
+	if !bytes.Equal(proved, served) {
+		err = ErrPackageProofMismatch
+	}
+	return
+}
+
 // ValidateReceiver handles messages on the Validate protocol
 func ValidateReceiver(h *Holochain, msg *Message) (response interface{}, err error) {
 	var a ValidatingAction
@@ -142,7 +274,7 @@ func ValidateReceiver(h *Holochain, msg *Message) (response interface{}, err err
 		h.dht.dlog.Logf("got validate %s request: %v", a.Name(), msg)
 		switch t := msg.Body.(type) {
 		case ValidateQuery:
-			response, err = h.GetValidationResponse(a, t.H)
+			response, err = h.getValidationResponse(a, t.H, msg.speaks(CheckpointPackageVersion))
 		default:
 			err = fmt.Errorf("expected ValidateQuery got %T", t)
 		}
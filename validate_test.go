@@ -165,6 +165,11 @@ func TestGetValidationResponse(t *testing.T) {
 		var b bytes.Buffer
 		h.chain.MarshalChain(&b, ChainMarshalFlagsOmitDNA, emptyStringList, emptyStringList)
 		So(fmt.Sprintf("%v", string(resp.Package.Chain)), ShouldEqual, fmt.Sprintf("%v", string(b.Bytes())))
+
+		// the entry's header is always proved even though the app didn't ask for proofs
+		So(len(resp.Package.Proofs), ShouldEqual, 1)
+		So(resp.Package.Proofs[0].Header.EntryLink.String(), ShouldEqual, hash.String())
+		So(checkPackageProof(hash, &resp), ShouldBeNil)
 	})
 
 	Convey("it should fail on the DNA (can't validate DNA as it's what determines what's valid)", t, func() {
@@ -187,6 +192,7 @@ func TestGetValidationResponse(t *testing.T) {
 		var b bytes.Buffer
 		h.chain.MarshalChain(&b, ChainMarshalFlagsOmitDNA, types, emptyStringList)
 		So(fmt.Sprintf("%v", string(resp.Package.Chain)), ShouldEqual, fmt.Sprintf("%v", string(b.Bytes())))
+		So(checkPackageProof(h.agentHash, &resp), ShouldBeNil)
 	})
 
 	Convey("key entry type should return empty package with pubkey as entry", t, func() {
@@ -205,7 +211,7 @@ func TestGetValidationResponse(t *testing.T) {
 		So(fmt.Sprintf("%v", resp.Package), ShouldEqual, fmt.Sprintf("%v", Package{}))
 	})
 
-	Convey("headers entry type should return empty package with the entry", t, func() {
+	Convey("headers entry type should return a package with just the proof of its header with the entry", t, func() {
 		hd := h.Chain().Top()
 		j, _ := hd.ToJSON()
 		entryStr := fmt.Sprintf(`[{"Header":%s,"Role":"someRole","Source":"%s"}]`, j, h.nodeID.Pretty())
@@ -217,7 +223,9 @@ func TestGetValidationResponse(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(resp.Type, ShouldEqual, HeadersEntryType)
 		So(fmt.Sprintf("%v", resp.Entry.Content()), ShouldEqual, entryStr)
-		So(fmt.Sprintf("%v", resp.Package), ShouldEqual, fmt.Sprintf("%v", Package{}))
+		So(resp.Package.Chain, ShouldBeNil)
+		So(len(resp.Package.Proofs), ShouldEqual, 1)
+		So(checkPackageProof(hash, &resp), ShouldBeNil)
 	})
 }
 
@@ -253,3 +261,57 @@ func TestMakeValidatePackage(t *testing.T) {
 
 	})
 }
+
+func TestValidateProofPackage(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+	commit(h, "evenNumbers", "2")
+	commit(h, "oddNumbers", "3")
+	commit(h, "evenNumbers", "4")
+
+	Convey("a proof package request should prove the top of the chain instead of sending it", t, func() {
+		pkg, err := MakePackage(h, PackagingReq{PkgReqChain: int64(PkgReqChainOptProof)})
+		So(err, ShouldBeNil)
+		So(pkg.Chain, ShouldBeNil)
+		So(len(pkg.Proofs), ShouldEqual, 1)
+		So(pkg.Proofs[0].Index, ShouldEqual, h.chain.Length()-1)
+
+		vpkg, err := MakeValidationPackage(h, &pkg)
+		So(err, ShouldBeNil)
+		So(len(vpkg.Proofs), ShouldEqual, 1)
+	})
+
+	Convey("a proof package request with entry types should prove the latest of each type", t, func() {
+		pkg, err := MakePackage(h, PackagingReq{PkgReqChain: int64(PkgReqChainOptProof), PkgReqEntryTypes: []string{"oddNumbers", AgentEntryType}})
+		So(err, ShouldBeNil)
+		So(len(pkg.Proofs), ShouldEqual, 2)
+		So(pkg.Proofs[0].Header.Type, ShouldEqual, "oddNumbers")
+		So(pkg.Proofs[1].Header.Type, ShouldEqual, AgentEntryType)
+		_, err = MakeValidationPackage(h, &pkg)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("a package with a proof that doesn't verify should be rejected", t, func() {
+		pkg, _ := MakePackage(h, PackagingReq{PkgReqChain: int64(PkgReqChainOptProof)})
+		pkg.Proofs[0].Length++
+		vpkg, err := MakeValidationPackage(h, &pkg)
+		So(err, ShouldEqual, ErrChainProofInvalid)
+		So(vpkg, ShouldBeNil)
+	})
+
+	Convey("a package's first proof should be of the header being validated", t, func() {
+		pkg, _ := MakePackage(h, PackagingReq{PkgReqChain: int64(PkgReqChainOptProof)})
+		top := h.chain.Top()
+		resp := ValidateResponse{Header: *top, Package: pkg}
+		So(checkPackageProof(top.EntryLink, &resp), ShouldBeNil)
+
+		prev := h.chain.Nth(1)
+		So(checkPackageProof(prev.EntryLink, &resp), ShouldEqual, ErrPackageProofMismatch)
+
+		resp.Header = *prev
+		So(checkPackageProof(top.EntryLink, &resp), ShouldEqual, ErrPackageProofMismatch)
+
+		resp.Package.Proofs = nil
+		So(checkPackageProof(prev.EntryLink, &resp), ShouldBeNil)
+	})
+}
@@ -7,6 +7,7 @@
 package holochain
 
 import (
+	"bytes"
 	"errors"
 	. "github.com/holochain/holochain-proto/hash"
 	ic "github.com/libp2p/go-libp2p-crypto"
@@ -15,6 +16,8 @@ import (
 
 const (
 	SelfRevocationType = iota
+	InvalidDataType
+	ForkType
 )
 
 // Warrant abstracts the notion of a multi-party cryptographically verifiable signed claim
@@ -62,6 +65,12 @@ func DecodeWarrant(warrantType int, data []byte) (w Warrant, err error) {
 	case SelfRevocationType:
 		w = &SelfRevocationWarrant{}
 		err = w.Decode(data)
+	case InvalidDataType:
+		w = &InvalidDataWarrant{}
+		err = w.Decode(data)
+	case ForkType:
+		w = &ForkWarrant{}
+		err = w.Decode(data)
 	default:
 		err = UnknownWarrantTypeErr
 	}
@@ -158,3 +167,320 @@ func (w *SelfRevocationWarrant) Decode(data []byte) (err error) {
 	err = w.Revocation.Unmarshal(string(data))
 	return
 }
+
+var ErrWarrantMissingProof = errors.New("invalid data warrant must hold a chain proof of the entry's header")
+var ErrWarrantDataValid = errors.New("warranted data passes validation")
+
+// InvalidDataWarrant warrants that its party committed an entry that doesn't
+// pass the DNA's validation.  It holds the validation response the author
+// served, so that any node can run the validation again.  The response's
+// package must start with the author's chain proof of the entry's header,
+// which GetValidationResponse always includes, because the header's
+// signature only covers the entry and not its type.
+type InvalidDataWarrant struct {
+	Data    ValidateResponse
+	Failure string // the validation failure of the node that made the warrant
+}
+
+// NewInvalidDataWarrant makes a warrant from a validation response that failed validation
+func NewInvalidDataWarrant(resp ValidateResponse, failure string) (w *InvalidDataWarrant, err error) {
+	wr := InvalidDataWarrant{Data: resp, Failure: failure}
+	_, err = wr.proof()
+	if err == nil {
+		w = &wr
+	}
+	return
+}
+
+// proof returns the chain proof of the warranted header after checking it
+// and the entry are the ones being warranted
+func (w *InvalidDataWarrant) proof() (proof *ChainProof, err error) {
+	if len(w.Data.Package.Proofs) == 0 {
+		err = ErrWarrantMissingProof
+		return
+	}
+	proof = &w.Data.Package.Proofs[0]
+	var proved, warranted []byte
+	proved, err = proof.Header.Marshal()
+	if err != nil {
+		return
+	}
+	warranted, err = w.Data.Header.Marshal()
+	if err != nil {
+		return
+	}
+	if !bytes.Equal(proved, warranted) {
+		err = ErrWarrantMissingProof
+		return
+	}
+	if w.Data.Type != w.Data.Header.Type {
+		err = errors.New("warranted entry type doesn't match its header")
+	}
+	return
+}
+
+func (w *InvalidDataWarrant) Type() int {
+	return InvalidDataType
+}
+
+func (w *InvalidDataWarrant) Parties() (parties []Hash, err error) {
+	var proof *ChainProof
+	proof, err = w.proof()
+	if err != nil {
+		return
+	}
+	var ID peer.ID
+	ID, err = proof.Agent()
+	if err != nil {
+		return
+	}
+	parties = append(parties, HashFromPeerID(ID))
+	return
+}
+
+func (w *InvalidDataWarrant) Verify(h *Holochain) (err error) {
+	var proof *ChainProof
+	proof, err = w.proof()
+	if err != nil {
+		return
+	}
+	// the author must have committed the header to its chain
+	err = VerifyChainProof(h.hashSpec, proof)
+	if err != nil {
+		return
+	}
+	// and the header must be of the entry
+	var hash Hash
+	hash, err = w.Data.Entry.Sum(h.hashSpec)
+	if err != nil {
+		return
+	}
+	if !hash.Equal(w.Data.Header.EntryLink) {
+		err = errors.New("warranted entry doesn't match its header")
+		return
+	}
+	var author peer.ID
+	author, err = proof.Agent()
+	if err != nil {
+		return
+	}
+
+	// run the validation again to see for ourselves that it fails
+	a := NewPutAction(w.Data.Type, &w.Data.Entry, &w.Data.Header)
+	_, err = h.ValidateAction(a, w.Data.Type, &w.Data.Package, []peer.ID{author})
+	if err == nil {
+		err = ErrWarrantDataValid
+	} else if IsValidationFailedErr(err) {
+		err = nil
+	}
+	return
+}
+
+func (w *InvalidDataWarrant) Property(key string) (value interface{}, err error) {
+	switch key {
+	case "failure":
+		value = w.Failure
+	case "entryType":
+		value = w.Data.Type
+	case "entryHash":
+		value = w.Data.Header.EntryLink
+	default:
+		err = WarrantPropertyNotFoundErr
+	}
+	return
+}
+
+func (w *InvalidDataWarrant) Encode() (data []byte, err error) {
+	data, err = ByteEncoder(w)
+	return
+}
+
+func (w *InvalidDataWarrant) Decode(data []byte) (err error) {
+	err = ByteDecoder(data, w)
+	return
+}
+
+// warrantInvalidData asks the network to block the author of an entry that
+// failed validation, if the author's validation response can prove it did
+func (dht *DHT) warrantInvalidData(author peer.ID, resp ValidateResponse, failure error) {
+	if author == dht.h.nodeID || !IsValidationFailedErr(failure) {
+		return
+	}
+	w, err := NewInvalidDataWarrant(resp, failure.Error())
+	if err != nil {
+		dht.dlog.Logf("can't warrant %v for invalid data: %v", author, err)
+		return
+	}
+	data, err := w.Encode()
+	if err == nil {
+		err = dht.Change(resp.Header.EntryLink, LISTADD_REQUEST,
+			ListAddReq{
+				ListType:    BlockedList,
+				Peers:       []string{peer.IDB58Encode(author)},
+				WarrantType: InvalidDataType,
+				Warrant:     data,
+			})
+	}
+	if err != nil {
+		dht.dlog.Logf("failed to warrant %v for invalid data: %v", author, err)
+	}
+}
+
+// ForkWarrant warrants that its party forked its source chain, by committing
+// two different headers that follow the same header.  A header's signature
+// only covers its entry, so each header is held in the agent's chain proof
+// of it, whose signed root commits to the whole header.
+type ForkWarrant struct {
+	Proofs [2]ChainProof
+}
+
+// NewForkWarrant makes a warrant from the proofs of two headers of a forked chain
+func NewForkWarrant(proof1 ChainProof, proof2 ChainProof, hashSpec HashSpec) (w *ForkWarrant, err error) {
+	wr := ForkWarrant{Proofs: [2]ChainProof{proof1, proof2}}
+	err = wr.check(hashSpec)
+	if err == nil {
+		w = &wr
+	}
+	return
+}
+
+// check confirms the warrant's proofs are of different headers of the same
+// agent that both follow the same header
+func (w *ForkWarrant) check(hashSpec HashSpec) (err error) {
+	p1, p2 := &w.Proofs[0], &w.Proofs[1]
+	if p1.PubKey != p2.PubKey {
+		err = errors.New("fork warrant proofs are for different agents")
+		return
+	}
+	if !p1.Header.HeaderLink.Equal(p2.Header.HeaderLink) {
+		err = errors.New("fork warrant headers don't follow the same header")
+		return
+	}
+	var hash1, hash2 Hash
+	hash1, _, err = p1.Header.Sum(hashSpec)
+	if err != nil {
+		return
+	}
+	hash2, _, err = p2.Header.Sum(hashSpec)
+	if err != nil {
+		return
+	}
+	if hash1.Equal(hash2) {
+		err = errors.New("fork warrant proofs are of the same header")
+	}
+	return
+}
+
+func (w *ForkWarrant) Type() int {
+	return ForkType
+}
+
+func (w *ForkWarrant) Parties() (parties []Hash, err error) {
+	var ID peer.ID
+	ID, err = w.Proofs[0].Agent()
+	if err != nil {
+		return
+	}
+	parties = append(parties, HashFromPeerID(ID))
+	return
+}
+
+func (w *ForkWarrant) Verify(h *Holochain) (err error) {
+	err = w.check(h.hashSpec)
+	if err != nil {
+		return
+	}
+	// the agent must have committed both headers
+	for i := range w.Proofs {
+		err = VerifyChainProof(h.hashSpec, &w.Proofs[i])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (w *ForkWarrant) Property(key string) (value interface{}, err error) {
+	switch key {
+	case "headerLink":
+		value = w.Proofs[0].Header.HeaderLink
+	case "entryLinks":
+		value = []Hash{w.Proofs[0].Header.EntryLink, w.Proofs[1].Header.EntryLink}
+	default:
+		err = WarrantPropertyNotFoundErr
+	}
+	return
+}
+
+func (w *ForkWarrant) Encode() (data []byte, err error) {
+	data, err = ByteEncoder(w)
+	return
+}
+
+func (w *ForkWarrant) Decode(data []byte) (err error) {
+	err = ByteDecoder(data, w)
+	return
+}
+
+// checkForks remembers the header of the entry in a validation response, by
+// the proof of it that starts the response's package, and warrants the agent
+// whose chain it's from when it's a different header than one already seen
+// following the same header.  It should only be called with responses that
+// validated, as those are the entries that are held, and so the header proofs
+// are forgotten along with them.
+func (dht *DHT) checkForks(resp *ValidateResponse) {
+	if dht == nil || len(resp.Package.Proofs) == 0 {
+		return
+	}
+	proof := &resp.Package.Proofs[0]
+	if VerifyChainProof(dht.h.hashSpec, proof) != nil {
+		return
+	}
+	agent, err := proof.Agent()
+	if err != nil || agent == dht.h.nodeID {
+		return
+	}
+	var seen *ChainProof
+	seen, err = dht.PutHeaderProof(agent, *proof)
+	if err != nil {
+		dht.dlog.Logf("failed to remember header of %v: %v", agent, err)
+		return
+	}
+	if seen == nil {
+		return
+	}
+	w, err := NewForkWarrant(*seen, *proof, dht.h.hashSpec)
+	if err != nil {
+		// the header we've already seen
+		return
+	}
+	dht.warrantFork(agent, w)
+}
+
+// warrantFork tells the network that an agent forked its source chain,
+// unless we already know about it
+func (dht *DHT) warrantFork(agent peer.ID, w *ForkWarrant) {
+	list, err := dht.GetList(ForkedList)
+	if err == nil {
+		for _, r := range list.Records {
+			if r.ID == agent {
+				return
+			}
+		}
+		dht.dlog.Logf("agent %v forked its chain", agent)
+		var data []byte
+		data, err = w.Encode()
+		if err == nil {
+			err = dht.Change(HashFromPeerID(agent), LISTADD_REQUEST,
+				ListAddReq{
+					ListType:    ForkedList,
+					Peers:       []string{peer.IDB58Encode(agent)},
+					WarrantType: ForkType,
+					Warrant:     data,
+				})
+		}
+	}
+	if err != nil {
+		dht.dlog.Logf("failed to warrant %v for forking its chain: %v", agent, err)
+	}
+}
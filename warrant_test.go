@@ -2,6 +2,10 @@ package holochain
 
 import (
 	"fmt"
+	"time"
+
+	. "github.com/holochain/holochain-proto/hash"
+	ic "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 	. "github.com/smartystreets/goconvey/convey"
 
@@ -88,3 +92,289 @@ func TestSelfRevocationWarrant(t *testing.T) {
 
 	})
 }
+
+// invalidDataResponse commits an entry to a chain without validating it and
+// returns the validation response for it, which holds a proof of its header
+func invalidDataResponse(h *Holochain, entryType string, content string) (resp ValidateResponse) {
+	now := time.Unix(1, 1) // pick a constant time so the test will always work
+	_, hd, err := h.NewEntry(now, entryType, &GobEntry{C: content})
+	if err != nil {
+		panic(err)
+	}
+	resp, err = h.GetValidationResponse(NewPutAction(entryType, nil, nil), hd.EntryLink)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+func TestInvalidDataWarrant(t *testing.T) {
+	mt := setupMultiNodeTesting(2)
+	defer mt.cleanupMultiNodeTesting()
+	author := mt.nodes[0]
+	h := mt.nodes[1]
+
+	resp := invalidDataResponse(author, "oddNumbers", "2")
+
+	Convey("it should need a proof of the header", t, func() {
+		noProof := resp
+		noProof.Package.Proofs = nil
+		_, err := NewInvalidDataWarrant(noProof, "")
+		So(err, ShouldEqual, ErrWarrantMissingProof)
+	})
+
+	w, err := NewInvalidDataWarrant(resp, "Validation Failed: 2 is not odd")
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should have a type", t, func() {
+		So(w.Type(), ShouldEqual, InvalidDataType)
+	})
+
+	Convey("it should have the author as its party", t, func() {
+		parties, err := w.Parties()
+		So(err, ShouldBeNil)
+		So(len(parties), ShouldEqual, 1)
+		So(parties[0].String(), ShouldEqual, author.nodeIDStr)
+	})
+
+	Convey("it should have properties", t, func() {
+		failure, err := w.Property("failure")
+		So(err, ShouldBeNil)
+		So(failure, ShouldEqual, "Validation Failed: 2 is not odd")
+		entryType, _ := w.Property("entryType")
+		So(entryType, ShouldEqual, "oddNumbers")
+		entryHash, _ := w.Property("entryHash")
+		So(entryHash.(Hash).String(), ShouldEqual, resp.Header.EntryLink.String())
+		_, err = w.Property("foo")
+		So(err, ShouldEqual, WarrantPropertyNotFoundErr)
+	})
+
+	Convey("any node should be able to verify it by validating the entry", t, func() {
+		So(w.Verify(h), ShouldBeNil)
+		So(w.Verify(author), ShouldBeNil)
+	})
+
+	Convey("it should fail to verify for valid data", t, func() {
+		valid, err := NewInvalidDataWarrant(invalidDataResponse(author, "oddNumbers", "3"), "")
+		So(err, ShouldBeNil)
+		So(valid.Verify(h), ShouldEqual, ErrWarrantDataValid)
+	})
+
+	Convey("it should fail to verify if the data was tampered with", t, func() {
+		// a different entry type than the author committed
+		tampered := *w
+		tampered.Data.Type = "evenNumbers"
+		So(tampered.Verify(h).Error(), ShouldEqual, "warranted entry type doesn't match its header")
+
+		tampered = *w
+		tampered.Data.Header.Type = "evenNumbers"
+		So(tampered.Verify(h), ShouldEqual, ErrWarrantMissingProof)
+
+		// the header and the proof changed together
+		tampered = InvalidDataWarrant{}
+		data, _ := w.Encode()
+		tampered.Decode(data)
+		tampered.Data.Type = "evenNumbers"
+		tampered.Data.Header.Type = "evenNumbers"
+		tampered.Data.Package.Proofs[0].Header.Type = "evenNumbers"
+		So(tampered.Verify(h), ShouldEqual, ErrChainProofInvalid)
+
+		// an entry that isn't the one in the header
+		tampered = InvalidDataWarrant{}
+		tampered.Decode(data)
+		tampered.Data.Entry.C = "4"
+		So(tampered.Verify(h).Error(), ShouldEqual, "warranted entry doesn't match its header")
+	})
+
+	Convey("it should encode and decode warrants", t, func() {
+		encoded, err := w.Encode()
+		So(err, ShouldBeNil)
+		w2, err := DecodeWarrant(InvalidDataType, encoded)
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", w2), ShouldEqual, fmt.Sprintf("%v", w))
+		So(w2.Verify(h), ShouldBeNil)
+	})
+
+	Convey("a list add should only accept the warrant's parties", t, func() {
+		other, _ := makePeer("peer1")
+		data, _ := w.Encode()
+		m := h.node.NewMessage(LISTADD_REQUEST, ListAddReq{
+			ListType:    BlockedList,
+			Peers:       []string{peer.IDB58Encode(other)},
+			WarrantType: InvalidDataType,
+			Warrant:     data,
+		})
+		_, err := ActionReceiver(h, m)
+		So(err.Error(), ShouldEqual, fmt.Sprintf("%s: %v is not a party to the warrant", prefix, other.Pretty()))
+		So(h.node.IsBlocked(other), ShouldBeFalse)
+	})
+
+	Convey("a node that rejects invalid data should warrant its author onto the blocked list", t, func() {
+		h.dht.warrantInvalidData(author.nodeID, resp, ValidationFailed("2 is not odd"))
+		So(h.node.IsBlocked(author.nodeID), ShouldBeTrue)
+		list, err := h.dht.GetList(BlockedList)
+		So(err, ShouldBeNil)
+		So(len(list.Records), ShouldEqual, 1)
+		So(list.Records[0].ID, ShouldEqual, author.nodeID)
+	})
+}
+
+// forkedChainProofs returns the proofs of two different headers that both
+// follow the same header, as made by an agent that forked its chain
+func forkedChainProofs(hashSpec HashSpec, key ic.PrivKey) (p1 *ChainProof, p2 *ChainProof) {
+	now := time.Unix(1, 1) // pick a constant time so the test will always work
+	c1 := NewChain(hashSpec)
+	c2 := NewChain(hashSpec)
+	c1.AddEntry(now, "oddNumbers", &GobEntry{C: "1"}, key)
+	c1.AddEntry(now, "oddNumbers", &GobEntry{C: "3"}, key)
+	c2.AddEntry(now, "oddNumbers", &GobEntry{C: "1"}, key)
+	c2.AddEntry(now, "oddNumbers", &GobEntry{C: "5"}, key)
+	var err error
+	p1, err = c1.Prove(c1.Hashes[1], key)
+	if err != nil {
+		panic(err)
+	}
+	p2, err = c2.Prove(c2.Hashes[1], key)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// provedResponse returns a validation response for the header a proof is of
+func provedResponse(proof *ChainProof) *ValidateResponse {
+	return &ValidateResponse{Type: proof.Header.Type, Header: proof.Header, Package: Package{Proofs: []ChainProof{*proof}}}
+}
+
+func TestForkWarrant(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	forker, _ := NewAgent(LibP2P, "Joe", MakeTestSeed("Joe"))
+	forkerID, _ := peer.IDFromPrivateKey(forker.PrivKey())
+	p1, p2 := forkedChainProofs(h.hashSpec, forker.PrivKey())
+
+	Convey("it should need proofs of different headers that follow the same header", t, func() {
+		_, err := NewForkWarrant(*p1, *p1, h.hashSpec)
+		So(err.Error(), ShouldEqual, "fork warrant proofs are of the same header")
+
+		other, _ := NewAgent(LibP2P, "Jane", MakeTestSeed("Jane"))
+		o1, _ := forkedChainProofs(h.hashSpec, other.PrivKey())
+		_, err = NewForkWarrant(*p1, *o1, h.hashSpec)
+		So(err.Error(), ShouldEqual, "fork warrant proofs are for different agents")
+
+		c := NewChain(h.hashSpec)
+		c.AddEntry(time.Unix(1, 1), "oddNumbers", &GobEntry{C: "7"}, forker.PrivKey())
+		genesis, _ := c.Prove(c.Hashes[0], forker.PrivKey())
+		_, err = NewForkWarrant(*p1, *genesis, h.hashSpec)
+		So(err.Error(), ShouldEqual, "fork warrant headers don't follow the same header")
+	})
+
+	w, err := NewForkWarrant(*p1, *p2, h.hashSpec)
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should have a type", t, func() {
+		So(w.Type(), ShouldEqual, ForkType)
+	})
+
+	Convey("it should have the forking agent as its party", t, func() {
+		parties, err := w.Parties()
+		So(err, ShouldBeNil)
+		So(len(parties), ShouldEqual, 1)
+		So(parties[0].String(), ShouldEqual, peer.IDB58Encode(forkerID))
+	})
+
+	Convey("it should have properties", t, func() {
+		headerLink, err := w.Property("headerLink")
+		So(err, ShouldBeNil)
+		So(headerLink.(Hash).String(), ShouldEqual, p1.Header.HeaderLink.String())
+		entryLinks, err := w.Property("entryLinks")
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", entryLinks), ShouldEqual, fmt.Sprintf("%v", []Hash{p1.Header.EntryLink, p2.Header.EntryLink}))
+		_, err = w.Property("foo")
+		So(err, ShouldEqual, WarrantPropertyNotFoundErr)
+	})
+
+	Convey("it should verify", t, func() {
+		So(w.Verify(h), ShouldBeNil)
+	})
+
+	Convey("it should fail to verify if a header was tampered with", t, func() {
+		tampered := ForkWarrant{}
+		data, _ := w.Encode()
+		tampered.Decode(data)
+		tampered.Proofs[1].Header.EntryLink = tampered.Proofs[0].Header.EntryLink
+		tampered.Proofs[1].Header.Type = "evenNumbers"
+		So(tampered.Verify(h), ShouldEqual, ErrChainProofInvalid)
+	})
+
+	Convey("it should encode and decode warrants", t, func() {
+		encoded, err := w.Encode()
+		So(err, ShouldBeNil)
+		w2, err := DecodeWarrant(ForkType, encoded)
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", w2), ShouldEqual, fmt.Sprintf("%v", w))
+		So(w2.Verify(h), ShouldBeNil)
+	})
+
+	Convey("the forked list should only accept fork warrants", t, func() {
+		oldPrivKey := h.agent.PrivKey()
+		revocation, _ := NewSelfRevocation(oldPrivKey, forker.PrivKey(), []byte("extra data"))
+		rw, _ := NewSelfRevocationWarrant(revocation)
+		data, _ := rw.Encode()
+		m := h.node.NewMessage(LISTADD_REQUEST, ListAddReq{
+			ListType:    ForkedList,
+			Peers:       []string{peer.IDB58Encode(forkerID)},
+			WarrantType: SelfRevocationType,
+			Warrant:     data,
+		})
+		_, err := ActionReceiver(h, m)
+		So(err.Error(), ShouldEqual, prefix+": forked list requires a fork warrant")
+	})
+
+	Convey("a node should detect a fork from the proofs it's shown and mark the agent as forked", t, func() {
+		h.Config.EnableWorldModel = true
+		h.world = NewWorld(h.nodeID, h.dht, &h.Config.Loggers.World)
+		defer func() { h.Config.EnableWorldModel = false }()
+
+		h.dht.checkForks(provedResponse(p1))
+		So(h.world.IsForked(forkerID), ShouldBeFalse)
+		list, err := h.dht.GetList(ForkedList)
+		So(err, ShouldBeNil)
+		So(len(list.Records), ShouldEqual, 0)
+
+		// the same header again isn't a fork
+		h.dht.checkForks(provedResponse(p1))
+		So(h.world.IsForked(forkerID), ShouldBeFalse)
+
+		h.dht.checkForks(provedResponse(p2))
+		So(h.world.IsForked(forkerID), ShouldBeTrue)
+		list, err = h.dht.GetList(ForkedList)
+		So(err, ShouldBeNil)
+		So(len(list.Records), ShouldEqual, 1)
+		So(list.Records[0].ID, ShouldEqual, forkerID)
+
+		// forks aren't blocked, just marked
+		So(h.node.IsBlocked(forkerID), ShouldBeFalse)
+
+		// and it's only warranted once
+		idx, _ := h.dht.GetIdx()
+		h.dht.checkForks(provedResponse(p2))
+		idx2, _ := h.dht.GetIdx()
+		So(idx2, ShouldEqual, idx)
+	})
+
+	Convey("a node should ignore proofs that don't verify", t, func() {
+		other, _ := NewAgent(LibP2P, "Jane", MakeTestSeed("Jane"))
+		otherID, _ := peer.IDFromPrivateKey(other.PrivKey())
+		o1, o2 := forkedChainProofs(h.hashSpec, other.PrivKey())
+		o2.Header.Type = "evenNumbers"
+		h.dht.checkForks(provedResponse(o1))
+		h.dht.checkForks(provedResponse(o2))
+		So(h.world.IsForked(otherID), ShouldBeFalse)
+	})
+}
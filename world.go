@@ -12,6 +12,7 @@ import (
 	peer "github.com/libp2p/go-libp2p-peer"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
 	"sync"
+	"time"
 )
 
 // NodeRecord stores the necessary information about other nodes in the world model
@@ -21,11 +22,29 @@ type NodeRecord struct {
 	IsHolding map[Hash]bool
 }
 
+// handoff tracks a hash that this node is no longer responsible for
+// while it's being handed off to the nodes that now are
+type handoff struct {
+	holders   []peer.ID
+	confirmed time.Time // when all the holders had returned hold receipts, zero until then
+}
+
+// PruneStats holds the counters of the pruning of DHT data
+type PruneStats struct {
+	Pending    int       // number of hashes waiting to be handed off
+	Pruned     int       // total number of hashes forgotten
+	Failed     int       // total number of failed attempts to forget a hash
+	LastPruned time.Time // when a hash was last forgotten
+}
+
 // World holds the data of a nodes' world model
 type World struct {
 	me          peer.ID
 	nodes       map[peer.ID]*NodeRecord
 	responsible map[Hash][]peer.ID
+	handoffs    map[Hash]*handoff
+	forked      map[peer.ID]bool
+	pruneStats  PruneStats
 	ht          HashTable
 	log         *Logger
 
@@ -39,6 +58,8 @@ func NewWorld(me peer.ID, ht HashTable, logger *Logger) *World {
 	world := World{me: me}
 	world.nodes = make(map[peer.ID]*NodeRecord)
 	world.responsible = make(map[Hash][]peer.ID)
+	world.handoffs = make(map[Hash]*handoff)
+	world.forked = make(map[peer.ID]bool)
 	world.ht = ht
 	world.log = logger
 	return &world
@@ -110,6 +131,21 @@ func (world *World) AddNode(pi pstore.PeerInfo, pubKey ic.PubKey) (err error) {
 	return
 }
 
+// SetForked marks an agent as having forked its source chain
+func (world *World) SetForked(ID peer.ID) {
+	world.lk.Lock()
+	defer world.lk.Unlock()
+	world.forked[ID] = true
+}
+
+// IsForked returns whether an agent is known to have forked its source chain
+func (world *World) IsForked(ID peer.ID) (forked bool) {
+	world.lk.RLock()
+	defer world.lk.RUnlock()
+	forked = world.forked[ID]
+	return
+}
+
 // NodesByHash returns a sorted list of peers, including "me" by distance from a hash
 func (world *World) nodesByHash(hash Hash) (nodes []peer.ID, err error) {
 	nodes, err = world.allNodes()
@@ -141,6 +177,7 @@ func (world *World) UpdateResponsible(hash Hash, redundancy int) (responsible bo
 	var nodes []peer.ID
 	if redundancy == 0 {
 		world.responsible[hash] = nil
+		delete(world.handoffs, hash)
 		responsible = true
 	} else if redundancy > 1 {
 		nodes, err = world.nodesByHash(hash)
@@ -169,10 +206,19 @@ func (world *World) UpdateResponsible(hash Hash, redundancy int) (responsible bo
 			}
 			nodes = append(nodes[:i], nodes[i+1:max]...)
 			world.responsible[hash] = nodes
+			delete(world.handoffs, hash)
 			world.log.Logf("Responsible for %v: %v", hash, nodes)
 
 		} else {
 			delete(world.responsible, hash)
+			// keep track of who should now be holding the hash
+			// so that we can hand it off to them
+			h := world.handoffs[hash]
+			if h == nil {
+				h = &handoff{}
+				world.handoffs[hash] = h
+			}
+			h.holders = nodes[:redundancy]
 		}
 	} else {
 		panic("resiliency=1 not implemented")
@@ -194,6 +240,80 @@ func (world *World) Responsible() (entries []Hash, err error) {
 	return
 }
 
+// Handoff returns the nodes that should be holding a hash that this node is no longer
+// responsible for, those of them we have no stored hold receipt of the hash from, and
+// whether it's been longer than the grace period since all of them returned one
+func (world *World) Handoff(hash Hash, gracePeriod time.Duration) (holders []peer.ID, unconfirmed []peer.ID, expired bool, err error) {
+	world.lk.Lock()
+	defer world.lk.Unlock()
+	h := world.handoffs[hash]
+	if h == nil {
+		return
+	}
+	var receipts []Receipt
+	receipts, err = world.ht.GetReceipts(hash)
+	if err != nil {
+		return
+	}
+	held := make(map[peer.ID]bool)
+	for _, r := range receipts {
+		if r.Code == ReceiptOK && r.Msg.Type == PUT_REQUEST {
+			held[r.Peer] = true
+		}
+	}
+	holders = h.holders
+	for _, node := range holders {
+		if !held[node] {
+			unconfirmed = append(unconfirmed, node)
+		}
+	}
+	if len(unconfirmed) > 0 {
+		h.confirmed = time.Time{}
+		return
+	}
+	if h.confirmed.IsZero() {
+		h.confirmed = time.Now()
+	}
+	expired = time.Since(h.confirmed) >= gracePeriod
+	return
+}
+
+// Forget removes a hash that has been handed off from the HashTable
+func (world *World) Forget(hash Hash) (err error) {
+	err = world.ht.Forget(hash)
+	world.lk.Lock()
+	defer world.lk.Unlock()
+	if err != nil {
+		world.pruneStats.Failed++
+		return
+	}
+	delete(world.handoffs, hash)
+	for _, record := range world.nodes {
+		delete(record.IsHolding, hash)
+	}
+	world.pruneStats.Pruned++
+	world.pruneStats.LastPruned = time.Now()
+	return
+}
+
+// PruneStats returns the current counters of the pruning of DHT data
+func (world *World) PruneStats() (stats PruneStats) {
+	world.lk.RLock()
+	defer world.lk.RUnlock()
+	stats = world.pruneStats
+	stats.Pending = len(world.handoffs)
+	return
+}
+
+// PruneStats returns the counters of the pruning of DHT data, which are all
+// zero if the world model isn't enabled
+func (h *Holochain) PruneStats() (stats PruneStats) {
+	if h.world != nil {
+		stats = h.world.PruneStats()
+	}
+	return
+}
+
 // Overlap returns a list of all the nodes that overlap for a given hash
 func (h *Holochain) Overlap(hash Hash) (overlap []peer.ID, err error) {
 	h.world.lk.RLock()
@@ -222,17 +342,32 @@ func HoldingTask(h *Holochain) {
 		return
 	}
 	hashes := myHashes(h)
+	if h.Config.EnablePruning {
+		defer func() {
+			stats := h.world.PruneStats()
+			h.world.log.Logf("HoldingTask: pruning pending:%d pruned:%d failed:%d last:%v\n",
+				stats.Pending, stats.Pruned, stats.Failed, stats.LastPruned)
+		}()
+	}
 	for _, hash := range hashes {
 		if hash.String() == h.dnaHash.String() {
 			continue
 		}
 
-		// TODO forget the hashes we are no longer responsible for
-		// https://waffle.io/Holochain/holochain-proto/cards/5af33e3b361c27001d5348c6
 		// TODO this really shouldn't be called in the holding task
 		//     but instead should be called with the Node list or hash list changes.
-		h.world.UpdateResponsible(hash, h.RedundancyFactor())
+		responsible, err := h.world.UpdateResponsible(hash, h.RedundancyFactor())
+		if err != nil {
+			h.world.log.Logf("HoldingTask: error updating responsibility for %v: %v\n", hash, err)
+			continue
+		}
 		h.world.log.Logf("HoldingTask: updated %v\n", hash)
+		if !responsible {
+			if h.Config.EnablePruning {
+				handOff(h, hash)
+			}
+			continue
+		}
 		overlap, err := h.Overlap(hash)
 		if err == nil {
 			h.world.log.Logf("HoldingTask: sending put requests to %d nodes\n", len(overlap))
@@ -248,7 +383,7 @@ func HoldingTask(h *Holochain) {
 				*/
 				h.world.log.Logf("HoldingTask: PUT_REQUEST sent to %v\n", node)
 				msg := h.node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash})
-				h.dht.sendChange(node, msg)
+				h.dht.sendChange(node, hash, msg)
 			}
 		}
 	}
@@ -258,3 +393,38 @@ func HoldingTask(h *Holochain) {
 		}
 	*/
 }
+
+// handOff sends the hash we are no longer responsible for to the nodes now responsible
+// for it that haven't returned a hold receipt of it yet, and once the grace period has
+// passed since they all have it forgets the hash
+func handOff(h *Holochain, hash Hash) {
+	holders, unconfirmed, expired, err := h.world.Handoff(hash, h.Config.pruneGracePeriod)
+	if err != nil {
+		h.world.log.Logf("HandOff: error getting receipts of %v: %v\n", hash, err)
+		return
+	}
+	if len(holders) == 0 {
+		return
+	}
+	for _, node := range unconfirmed {
+		// to protect against crashes from background routines after close
+		if h.node == nil {
+			return
+		}
+		h.world.log.Logf("HandOff: PUT_REQUEST sent to %v\n", node)
+		msg := h.node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash})
+		// a held change's receipt gets stored, confirming the hand off next time round
+		holding, err := h.dht.sendChange(node, hash, msg)
+		if err == nil && holding {
+			h.world.SetNodeHolding(node, hash)
+		}
+	}
+	if expired {
+		err := h.world.Forget(hash)
+		if err != nil {
+			h.world.log.Logf("HandOff: error forgetting %v: %v\n", hash, err)
+		} else {
+			h.world.log.Logf("HandOff: forgot %v held by %v\n", hash, holders)
+		}
+	}
+}
@@ -140,6 +140,89 @@ func TestWorldUpdateResponsible(t *testing.T) {
 	})
 }
 
+func TestWorldHandoff(t *testing.T) {
+	p1, _ := peer.IDB58Decode("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	p2, _ := peer.IDB58Decode("QmY9Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	p3, _ := peer.IDB58Decode("QmY9Mzg9F69e5P9AoQPYbt655HEhc1TVGs11tmfNSzkqh2")
+	ht, _ := NewMemHT("")
+	defer ht.Close()
+	world := NewWorld(p1, ht, nil)
+	var addr ma.Multiaddr
+	testAddNodeToWorld(world, p2, addr)
+	testAddNodeToWorld(world, p3, addr)
+	hash := HashFromPeerID(p2)
+	err := ht.Put(nil, "someType", hash, p2, []byte("some value"), StatusLive)
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("there should be nothing to hand off for hashes we are responsible for", t, func() {
+		responsible, err := world.UpdateResponsible(hash, 0)
+		So(err, ShouldBeNil)
+		So(responsible, ShouldBeTrue)
+		holders, _, _, err := world.Handoff(hash, 0)
+		So(err, ShouldBeNil)
+		So(holders, ShouldBeNil)
+		So(world.PruneStats().Pending, ShouldEqual, 0)
+	})
+
+	Convey("hashes we are no longer responsible for should be handed off to the closest nodes", t, func() {
+		responsible, err := world.UpdateResponsible(hash, 2)
+		So(err, ShouldBeNil)
+		So(responsible, ShouldBeFalse)
+		holders, unconfirmed, expired, err := world.Handoff(hash, 0)
+		So(err, ShouldBeNil)
+		So(len(holders), ShouldEqual, 2)
+		So(holders, ShouldContain, p2)
+		So(holders, ShouldContain, p3)
+		So(unconfirmed, ShouldResemble, holders)
+		So(expired, ShouldBeFalse)
+		So(world.PruneStats().Pending, ShouldEqual, 1)
+	})
+
+	Convey("the grace period should start once all the holders have returned hold receipts", t, func() {
+		putReceipt := func(p peer.ID, code int, t MsgType) {
+			err := ht.PutReceipt(hash, Receipt{Peer: p, Code: code, Msg: Message{Type: t, From: p, Body: HoldReq{EntryHash: hash}}, Time: time.Now()})
+			if err != nil {
+				panic(err)
+			}
+		}
+		putReceipt(p2, ReceiptOK, PUT_REQUEST)
+		putReceipt(p3, ReceiptRejected, PUT_REQUEST)
+		putReceipt(p3, ReceiptOK, LINK_REQUEST)
+		_, unconfirmed, expired, err := world.Handoff(hash, 0)
+		So(err, ShouldBeNil)
+		So(unconfirmed, ShouldResemble, []peer.ID{p3})
+		So(expired, ShouldBeFalse)
+
+		putReceipt(p3, ReceiptOK, PUT_REQUEST)
+		_, unconfirmed, expired, err = world.Handoff(hash, time.Hour)
+		So(err, ShouldBeNil)
+		So(unconfirmed, ShouldBeNil)
+		So(expired, ShouldBeFalse)
+		_, _, expired, err = world.Handoff(hash, 0)
+		So(err, ShouldBeNil)
+		So(expired, ShouldBeTrue)
+	})
+
+	Convey("forgetting should remove the hash and update the stats", t, func() {
+		world.SetNodeHolding(p2, hash)
+		err := world.Forget(hash)
+		So(err, ShouldBeNil)
+		So(ht.Exists(hash, StatusAny), ShouldEqual, ErrHashNotFound)
+		holding, _ := world.IsHolding(p2, hash)
+		So(holding, ShouldBeFalse)
+		stats := world.PruneStats()
+		So(stats.Pending, ShouldEqual, 0)
+		So(stats.Pruned, ShouldEqual, 1)
+		So(stats.Failed, ShouldEqual, 0)
+
+		err = world.Forget(hash)
+		So(err, ShouldEqual, ErrHashNotFound)
+		So(world.PruneStats().Failed, ShouldEqual, 1)
+	})
+}
+
 func TestWorldOverlap(t *testing.T) {
 	nodesCount := 20
 	mt := setupMultiNodeTesting(nodesCount)
@@ -160,11 +243,12 @@ func TestWorldOverlap(t *testing.T) {
 	Convey("when redundancy is 0 overlap is 100%", t, func() {
 		for i := 0; i < nodesCount; i++ {
 			chain := nodes[i].Chain()
-			for _, hd := range chain.Headers {
+			chain.Walk(func(key *Hash, hd *Header, entry Entry) error {
 				responsible, err := h.world.UpdateResponsible(hd.EntryLink, 0)
 				So(err, ShouldBeNil)
 				So(responsible, ShouldBeTrue)
-			}
+				return nil
+			})
 		}
 
 		entries, err := h.world.Responsible()
@@ -183,9 +267,10 @@ func TestWorldOverlap(t *testing.T) {
 		for i := 0; i < nodesCount; i++ {
 			nodes[i].nucleus.dna.DHTConfig.RedundancyFactor = r
 			chain := nodes[i].Chain()
-			for _, hd := range chain.Headers {
+			chain.Walk(func(key *Hash, hd *Header, entry Entry) error {
 				h.world.UpdateResponsible(hd.EntryLink, r)
-			}
+				return nil
+			})
 		}
 
 		entries, err := h.world.Responsible()
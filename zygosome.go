@@ -210,8 +210,15 @@ func buildZyValidateAction(action Action, def *EntryDef, pkg *ValidationPackage,
 	srcs := mkZySources(sources)
 
 	var pkgObj string
-	if pkg == nil || pkg.Chain == nil {
+	if pkg == nil || (pkg.Chain == nil && len(pkg.Proofs) == 0) {
 		pkgObj = "(hash)"
+	} else if pkg.Chain == nil {
+		var j string
+		j, err = chainProofsJSON(pkg.Proofs)
+		if err != nil {
+			return
+		}
+		pkgObj = fmt.Sprintf(`(unjson (raw "%s"))`, sanitizeZyString(j))
 	} else {
 		var j []byte
 		j, err = json.Marshal(pkg.Chain)
@@ -404,6 +411,7 @@ const (
 		`(def HC_PkgReq_ChainOpt_Headers "` + PkgReqChainOptHeadersStr + "\")" +
 		`(def HC_PkgReq_ChainOpt_Entries "` + PkgReqChainOptEntriesStr + "\")" +
 		`(def HC_PkgReq_ChainOpt_Full "` + PkgReqChainOptFullStr + "\")" +
+		`(def HC_PkgReq_ChainOpt_Proof "` + PkgReqChainOptProofStr + "\")" +
 
 		`(def HC_Migrate_Close "` + MigrateEntryTypeClose + `")` +
 		`(def HC_Migrate_Open "` + MigrateEntryTypeOpen + `")`
@@ -656,6 +664,75 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 			return &result, nil
 		})
 
+	z.env.AddFunction("getReceipts",
+		func(env *zygo.Zlisp, name string, zyargs []zygo.Sexp) (zygo.Sexp, error) {
+			a := &APIFnGetReceipts{}
+			args := a.Args()
+			err := zyProcessArgs(&z, args, zyargs)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			a.hash = args[0].value.(Hash)
+			r, err := a.Call(h)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			receipts := make([]map[string]interface{}, 0)
+			for _, receipt := range r.([]Receipt) {
+				receipts = append(receipts, map[string]interface{}{
+					"Peer":      peer.IDB58Encode(receipt.Peer),
+					"Code":      receipt.Code,
+					"Signature": receipt.Signature.B58String(),
+					"Time":      receipt.Time.Format(time.RFC3339),
+				})
+			}
+			j, err := json.Marshal(receipts)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpStr{S: string(j)}, nil
+		})
+
+	z.env.AddFunction("subscribe",
+		func(env *zygo.Zlisp, name string, zyargs []zygo.Sexp) (zygo.Sexp, error) {
+			a := &APIFnSubscribe{zome: z.zome.Name}
+			args := a.Args()
+			err := zyProcessArgs(&z, args, zyargs)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			j, err := json.Marshal(args[0].value)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			err = json.Unmarshal(j, &a.filter)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			a.callback = args[1].value.(string)
+			r, err := a.Call(h)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpInt{Val: int64(r.(int))}, nil
+		})
+
+	z.env.AddFunction("unsubscribe",
+		func(env *zygo.Zlisp, name string, zyargs []zygo.Sexp) (zygo.Sexp, error) {
+			a := &APIFnUnsubscribe{}
+			args := a.Args()
+			err := zyProcessArgs(&z, args, zyargs)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			a.id = int(args[0].value.(int64))
+			_, err = a.Call(h)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return zygo.SexpNull, nil
+		})
+
 	z.env.AddFunction("getBridges",
 		func(env *zygo.Zlisp, name string, zyargs []zygo.Sexp) (zygo.Sexp, error) {
 			a := &APIFnGetBridges{}
@@ -895,6 +972,22 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 			return &result, nil
 		})
 
+	z.env.AddFunction("checkpoint",
+		func(env *zygo.Zlisp, name string, zyargs []zygo.Sexp) (zygo.Sexp, error) {
+			fn := &APIFnCheckpoint{}
+			args := fn.Args()
+			err := zyProcessArgs(&z, args, zyargs)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			r, err := fn.Call(h)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			var result = zygo.SexpStr{S: r.(Hash).String()}
+			return &result, nil
+		})
+
 	z.env.AddFunction("query",
 		func(env *zygo.Zlisp, name string, zyargs []zygo.Sexp) (zygo.Sexp, error) {
 			a := &APIFnQuery{}
@@ -923,6 +1016,15 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 			if err != nil {
 				return zygo.SexpNull, err
 			}
+			if a.options != nil && a.options.Aggregate.Fn != "" {
+				switch v := r.(type) {
+				case int:
+					return &zygo.SexpInt{Val: int64(v)}, nil
+				case float64:
+					return &zygo.SexpFloat{Val: v}, nil
+				}
+				return zygo.SexpNull, nil
+			}
 			qr := r.([]QueryResult)
 
 			defs := make(map[string]*EntryDef)
@@ -1234,6 +1336,7 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 			tag := args[1].value.(string)
 
 			options := GetLinksOptions{Load: false, StatusMask: StatusLive}
+			var paged bool
 			if len(zyargs) == 3 {
 				opts := args[2].value.(map[string]interface{})
 				load, ok := opts["Load"]
@@ -1254,17 +1357,56 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 					}
 					options.StatusMask = int(maskval)
 				}
+				page, ok := opts["Page"]
+				if ok {
+					pageval, ok := page.(float64)
+					if !ok {
+						return zygo.SexpNull,
+							fmt.Errorf("expecting int Page attribute in object, got %T", page)
+					}
+					options.Page = int(pageval)
+					paged = true
+				}
+				count, ok := opts["Count"]
+				if ok {
+					countval, ok := count.(float64)
+					if !ok {
+						return zygo.SexpNull,
+							fmt.Errorf("expecting int Count attribute in object, got %T", count)
+					}
+					options.Count = int(countval)
+					paged = true
+				}
+				cursor, ok := opts["Cursor"]
+				if ok {
+					cursorval, ok := cursor.(string)
+					if !ok {
+						return zygo.SexpNull,
+							fmt.Errorf("expecting string Cursor attribute in object, got %T", cursor)
+					}
+					options.Cursor = cursorval
+					paged = true
+				}
+				err = options.setQueryOptions(opts)
+				if err != nil {
+					return zygo.SexpNull, err
+				}
 			}
 
 			var r interface{}
-			fn.action = *NewGetLinksAction(&LinkQuery{Base: base, T: tag, StatusMask: options.StatusMask}, &options)
+			fn.action = *NewGetLinksAction(newLinkQuery(base, tag, &options), &options)
 			r, err = fn.Call(h)
 			var resultValue zygo.Sexp
 			if err == nil {
 				response := r.(*LinkQueryResp)
 				resultValue = zygo.SexpNull
 				var j []byte
-				j, err = json.Marshal(response.Links)
+				if paged {
+					// when asking for a page of links return the cursor for the next page too
+					j, err = json.Marshal(response)
+				} else {
+					j, err = json.Marshal(response.Links)
+				}
 				if err == nil {
 					resultValue = &zygo.SexpStr{S: string(j)}
 				}
@@ -1370,6 +1512,19 @@ func addExtras(z *ZygoRibosome) {
 		})
 }
 
+// RunSubscriptionCallback calls the zome function subscribed to changes to the DHT with a change
+func (z *ZygoRibosome) RunSubscriptionCallback(change DHTChange, callback string, id int) (result interface{}, err error) {
+	var j []byte
+	j, err = json.Marshal(change)
+	if err != nil {
+		return
+	}
+	code := fmt.Sprintf(`(%s (unjson (raw "%s")) %d)`, callback, sanitizeZyString(string(j)), id)
+	z.h.Debugf("Calling %s\n", code)
+	result, err = z.Run(code)
+	return
+}
+
 func (z *ZygoRibosome) RunAsyncSendResponse(response AppMsg, callback string, callbackID string) (result interface{}, err error) {
 	code := fmt.Sprintf(`(%s (unjson (raw "%s")) "%s")`, callback, sanitizeZyString(response.Body), sanitizeZyString(callbackID))
 	z.h.Debugf("Calling %s\n", code)
@@ -207,7 +207,7 @@ func TestNewZygoRibosome(t *testing.T) {
 			// a string calling function
 			_, err := z.Run(`(call "jsSampleZome" "addOdd" "321")`)
 			So(err, ShouldBeNil)
-			So(h.chain.Entries[len(h.chain.Hashes)-1].Content(), ShouldEqual, "321")
+			So(topEntry(h).Content(), ShouldEqual, "321")
 			z := v.(*ZygoRibosome)
 			hashStr := z.lastResult.(*zygo.SexpStr).S
 			hash, _ := NewHash(hashStr)
@@ -217,7 +217,7 @@ func TestNewZygoRibosome(t *testing.T) {
 			// a json calling function
 			_, err = z.Run(`(call "jsSampleZome" "addProfile" (hash firstName: "Jane" lastName: "Jetson"))`)
 			So(err, ShouldBeNil)
-			So(h.chain.Entries[len(h.chain.Hashes)-1].Content(), ShouldEqual, `{"firstName":"Jane","lastName":"Jetson"}`)
+			So(topEntry(h).Content(), ShouldEqual, `{"firstName":"Jane","lastName":"Jetson"}`)
 			hashJSONStr := z.lastResult.(*zygo.SexpStr).S
 			json.Unmarshal([]byte(hashJSONStr), &hashStr)
 			hash, _ = NewHash(hashStr)
@@ -259,6 +259,14 @@ func TestNewZygoRibosome(t *testing.T) {
 			entry, _, _ := h.chain.GetEntry(migrationEntryHash)
 			So(entry.Content(), ShouldEqual, "{\"Type\":\"close\",\"DNAHash\":\""+dnaHash.String()+"\",\"Key\":\""+key.String()+"\",\"Data\":\""+data+"\"}")
 		})
+		Convey("checkpoint", func() {
+			_, err := z.Run(`(checkpoint)`)
+			So(err, ShouldBeNil)
+			checkpointHash, _ := NewHash(z.lastResult.(*zygo.SexpStr).S)
+			_, entryType, err := h.chain.GetEntry(checkpointHash)
+			So(err, ShouldBeNil)
+			So(entryType, ShouldEqual, CheckpointEntryType)
+		})
 	})
 }
 
@@ -309,6 +317,17 @@ func TestZygoQuery(t *testing.T) {
 			_, err := z.Run(`(debug (str (query (hash Constrain: (hash EntryTypes: ["%agent"])))))`)
 			So(err, ShouldBeNil)
 		}, `["{\"Identity\":\"Herbert \\u003ch@bert.com\\u003e\",\"Revocation\":\"\",\"PublicKey\":\"4XTTM8sJEQD5zMLT1gtu2ogshwg5AdUPNhJRbLvs77gsVtQQi\"}"]`)
+
+		commit(h, "profile", `{"firstName":"Zerbina","lastName":"Pinhead","age":4321}`)
+		commit(h, "profile", `{"firstName":"Pebbles","lastName":"Flintstone","age":1234}`)
+		ShouldLog(h.nucleus.alog, func() {
+			_, err := z.Run(`(debug (str (query (hash Constrain: (hash EntryTypes: ["profile"] Not: (hash Compare: [(hash Field: "age" Op: "<" Value: 2000)])) Order: (hash Field: "age")))))`)
+			So(err, ShouldBeNil)
+		}, `["{\"firstName\":\"Zerbina\",\"lastName\":\"Pinhead\",\"age\":4321}" "{\"firstName\":\"Zippy\",\"lastName\":\"Pinhead\"}"]`)
+		ShouldLog(h.nucleus.alog, func() {
+			_, err := z.Run(`(debug (str (query (hash Constrain: (hash EntryTypes: ["profile"]) Aggregate: (hash Fn: "sum" Field: "age")))))`)
+			So(err, ShouldBeNil)
+		}, `5555`)
 	})
 }
 func TestZygoGenesis(t *testing.T) {
@@ -647,6 +666,17 @@ func TestZygoDHT(t *testing.T) {
 		So(r.(*zygo.SexpStr).S, ShouldEqual, fmt.Sprintf(`[{"H":"QmYeinX5vhuA91D3v24YbgyLofw9QAxY6PoATrBHnRwbtt","E":"{\"firstName\":\"Zippy\",\"lastName\":\"Pinhead\"}","EntryType":"profile","T":"","Source":"%s"}]`, h.nodeIDStr))
 	})
 
+	Convey("getLinks function with Count option should return a page of Links and the cursor", t, func() {
+		v, err := NewZygoRibosome(h, &Zome{RibosomeType: ZygoRibosomeType, Code: fmt.Sprintf(`(getLinks "%s" "4stars" (hash Count:1))`, hash.String())})
+		So(err, ShouldBeNil)
+		z := v.(*ZygoRibosome)
+		sh := z.lastResult.(*zygo.SexpHash)
+
+		r, err := sh.HashGet(z.env, z.env.MakeSymbol("result"))
+		So(err, ShouldBeNil)
+		So(r.(*zygo.SexpStr).S, ShouldEqual, fmt.Sprintf(`{"Links":[{"H":"QmYeinX5vhuA91D3v24YbgyLofw9QAxY6PoATrBHnRwbtt","E":"","EntryType":"","T":"","Source":"%s"}],"Next":""}`, h.nodeIDStr))
+	})
+
 	Convey("commit with del link should delete link", t, func() {
 		v, err := NewZygoRibosome(h, &Zome{RibosomeType: ZygoRibosomeType, Code: fmt.Sprintf(`(commit "rating" (hash Links:[(hash LinkAction:HC_LinkAction_Del Base:"%s" Link:"%s" Tag:"4stars")]))`, hash.String(), profileHash.String())})
 		So(err, ShouldBeNil)
@@ -658,7 +688,9 @@ func TestZygoDHT(t *testing.T) {
 		links, _ := h.dht.GetLinks(hash, "4stars", StatusLive)
 		So(fmt.Sprintf("%v", links), ShouldEqual, "[]")
 		links, _ = h.dht.GetLinks(hash, "4stars", StatusDeleted)
-		So(fmt.Sprintf("%v", links), ShouldEqual, fmt.Sprintf("[{QmYeinX5vhuA91D3v24YbgyLofw9QAxY6PoATrBHnRwbtt    %s}]", h.nodeIDStr))
+		So(len(links), ShouldEqual, 1)
+		So(links[0].H, ShouldEqual, "QmYeinX5vhuA91D3v24YbgyLofw9QAxY6PoATrBHnRwbtt")
+		So(links[0].Source, ShouldEqual, h.nodeIDStr)
 	})
 
 	Convey("getLinks function with StatusMask option should return deleted Links", t, func() {
@@ -805,7 +837,7 @@ func TestZygoDHT(t *testing.T) {
 		So(found, ShouldBeTrue)
 
 		// the old peerID should now be in the blockedlist
-		peerList, err := h.dht.getList(BlockedList)
+		peerList, err := h.dht.GetList(BlockedList)
 		So(err, ShouldBeNil)
 		So(len(peerList.Records), ShouldEqual, 1)
 		So(peerList.Records[0].ID, ShouldEqual, oldPeer)